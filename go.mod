@@ -7,6 +7,11 @@ require (
 	github.com/evanw/esbuild v0.19.5
 	github.com/pgaskin/innosoftfusiongo-ical v0.0.16
 	github.com/pmezard/go-difflib v1.0.0
+	github.com/yuin/goldmark v1.7.8
+	golang.org/x/crypto v0.14.0
+	golang.org/x/net v0.17.0
+	golang.org/x/text v0.13.0
+	rsc.io/qr v0.2.0
 )
 
 require (
@@ -16,6 +21,5 @@ require (
 	github.com/tidwall/gjson v1.16.0 // indirect
 	github.com/tidwall/match v1.1.1 // indirect
 	github.com/tidwall/pretty v1.2.0 // indirect
-	golang.org/x/sys v0.1.0 // indirect
-	golang.org/x/text v0.3.8 // indirect
+	golang.org/x/sys v0.13.0 // indirect
 )
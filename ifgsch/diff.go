@@ -0,0 +1,138 @@
+package ifgsch
+
+import (
+	"cmp"
+	"slices"
+
+	"github.com/pgaskin/innosoftfusiongo-ical/fusiongo"
+)
+
+// ChangeKind is the type of a [Change].
+type ChangeKind string
+
+const (
+	ChangeAdded       ChangeKind = "added"
+	ChangeRemoved     ChangeKind = "removed"
+	ChangeTime        ChangeKind = "time"
+	ChangeCancelled   ChangeKind = "cancelled"
+	ChangeUncancelled ChangeKind = "uncancelled"
+)
+
+// Change is a difference between the events of two schedules.
+type Change struct {
+	Kind     ChangeKind
+	Activity string
+	Location string
+	Date     fusiongo.Date
+	Time     fusiongo.TimeRange // the new time, or the old one if removed
+	OldTime  fusiongo.TimeRange // only set for ChangeTime
+}
+
+// Diff compares the events of two schedules, only considering the dates
+// covered by both of them.
+func Diff(a, b *Schedule) []Change {
+	type Key struct {
+		Activity string
+		Location string
+		Date     fusiongo.Date
+	}
+	type Event struct {
+		Time      fusiongo.TimeRange
+		Cancelled bool
+	}
+
+	start, end := a.Start, a.End
+	if start.Less(b.Start) {
+		start = b.Start
+	}
+	if b.End.Less(end) {
+		end = b.End
+	}
+
+	events := func(s *Schedule) map[Key][]Event {
+		m := map[Key][]Event{}
+		for _, activity := range s.Activities {
+			for _, location := range activity.Locations {
+				for _, instance := range location.Instances {
+					Expand(s, instance, func(t fusiongo.DateTimeRange, cancelled, _ bool) {
+						if !t.Date.Less(start) && !end.Less(t.Date) {
+							k := Key{activity.Name, location.Name, t.Date}
+							m[k] = append(m[k], Event{t.TimeRange, cancelled})
+						}
+					})
+				}
+			}
+		}
+		for _, es := range m {
+			slices.SortStableFunc(es, func(a, b Event) int {
+				return a.Time.Compare(b.Time)
+			})
+		}
+		return m
+	}
+	ea, eb := events(a), events(b)
+
+	keys := make([]Key, 0, len(ea)+len(eb))
+	for k := range ea {
+		keys = append(keys, k)
+	}
+	for k := range eb {
+		if _, ok := ea[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	slices.SortFunc(keys, func(a, b Key) int {
+		if a.Date != b.Date {
+			return a.Date.Compare(b.Date)
+		}
+		if a.Activity != b.Activity {
+			return cmp.Compare(a.Activity, b.Activity)
+		}
+		return cmp.Compare(a.Location, b.Location)
+	})
+
+	var cs []Change
+	for _, k := range keys {
+		change := func(kind ChangeKind, t fusiongo.TimeRange) *Change {
+			cs = append(cs, Change{
+				Kind:     kind,
+				Activity: k.Activity,
+				Location: k.Location,
+				Date:     k.Date,
+				Time:     t,
+			})
+			return last(cs)
+		}
+		xa, xb := slices.Clone(ea[k]), slices.Clone(eb[k])
+
+		// events at the same time
+		xa = slices.DeleteFunc(xa, func(x Event) bool {
+			if i := slices.IndexFunc(xb, func(y Event) bool { return x.Time == y.Time }); i != -1 {
+				switch y := xb[i]; {
+				case !x.Cancelled && y.Cancelled:
+					change(ChangeCancelled, y.Time)
+				case x.Cancelled && !y.Cancelled:
+					change(ChangeUncancelled, y.Time)
+				}
+				xb = slices.Delete(xb, i, i+1)
+				return true
+			}
+			return false
+		})
+
+		// events at a different time
+		for len(xa) != 0 && len(xb) != 0 {
+			change(ChangeTime, xb[0].Time).OldTime = xa[0].Time
+			xa, xb = xa[1:], xb[1:]
+		}
+
+		// remaining events
+		for _, x := range xa {
+			change(ChangeRemoved, x.Time)
+		}
+		for _, x := range xb {
+			change(ChangeAdded, x.Time)
+		}
+	}
+	return cs
+}
@@ -51,6 +51,8 @@ func dumpSchedule(b *bytes.Buffer, s *Schedule) {
 						fmt.Fprintf(b, "LAST_WEEKDAY\n")
 					case x.Cancelled:
 						fmt.Fprintf(b, "CANCELLED\n")
+					case x.Excluded && x.Cutoff:
+						fmt.Fprintf(b, "EXCLUDED CUTOFF\n")
 					case x.Excluded:
 						fmt.Fprintf(b, "EXCLUDED\n")
 					case x.Time != (fusiongo.TimeRange{}):
@@ -4,19 +4,25 @@ package ifgsch
 import (
 	"cmp"
 	"context"
+	"crypto/sha1"
 	_ "embed"
 	"encoding/base64"
 	"fmt"
+	"hash/fnv"
 	"html/template"
 	"io"
 	"log/slog"
+	"math"
+	"net/url"
 	"slices"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/pgaskin/innosoftfusiongo-ical/fusiongo"
+	"github.com/pgaskin/innosoftfusiongo-schedule/internal/lru"
 	"github.com/pgaskin/innosoftfusiongo-schedule/m3color"
 )
 
@@ -27,15 +33,20 @@ type Schedule struct {
 	End           fusiongo.Date
 	Activities    []Activity
 	Notifications []Notification
+
+	MoreActivities int // number of activities removed due to [Options.MaxActivities]
 }
 
 type Activity struct {
-	Name      string
-	Locations []Location // will never be empty
+	Name       string
+	Category   string     // most common category name, may be empty
+	CategoryID string     // most common Fusion Go category ID, only set if [PrepareOptions.CategoryIDs] is set
+	Locations  []Location // will never be empty
 }
 
 type Location struct {
 	Name      string
+	Virtual   bool       // online rather than at a physical location
 	Instances []Instance // will never be empty
 }
 
@@ -54,23 +65,337 @@ type Exception struct {
 	Cancelled     bool
 	Excluded      bool
 	Time          fusiongo.TimeRange
+
+	Cutoff bool // if Excluded, the date is the first day of the schedule and before it was updated, so it may have just been left out of the data
 }
 
 type Notification struct {
+	ID   string // deterministic, derived from Sent and Text
 	Text string
-	Sent fusiongo.DateTime
+	Sent fusiongo.DateTime // zero if missing or invalid
 }
 
 type Options struct {
-	Color        string // hex
-	Icon         []byte // ico
-	Title        string
-	Description  string
-	Footer       []template.HTML
-	UpcomingDays int
-	Canonical    string
+	Color           string // hex
+	Icon            []byte // ico
+	Title           string
+	Description     string
+	Footer          []template.HTML
+	UpcomingDays    int
+	UpcomingMax     int // maximum number of events to show per upcoming day (0 for no limit)
+	UpcomingLayout  UpcomingLayout
+	Path            string // absolute URL path the schedule is served at, for linking to day pages
+	Canonical       string
+	Categories      bool            // show activity categories
+	TimeSeparator   string          // between the start and end of time ranges (default " - ")
+	Direction       string          // text direction (ltr/rtl)
+	Language        string          // BCP 47 language tag of the page content (default en)
+	Palette         template.CSS    // if set, used instead of generating the palette from Color
+	Live            bool            // highlight events happening now or next today (as of Now in Timezone, so the render must be refreshed to keep it current)
+	ExceptionDetail ExceptionDetail // how much detail to show for exceptions
+	Subscribe       string          // iCalendar feed URL to show calendar subscription links for (resolved against Canonical if relative)
+	Legend          bool            // explain the exception markers below the schedule
+	DateFormat      string          // Go time layout for short dates (default "Jan 2")
+	DateTimeFormat  string          // Go time layout for the updated/modified times (default "2006-01-02 15:04:05 MST")
+	QRCode          bool            // show a QR code linking to Canonical when printed
+	Compact         bool            // group locations with a single instance on a single weekday into one row group
+	Source          Source          // facility the schedule data is from
+	Markdown        bool            // render notifications as Markdown (sanitized)
+	EmptyCells      bool            // show a muted dash in grid cells without an instance
+	Weekly          bool            // show a grid of the occurrences for each week instead of the merged weekly instances
+	UpcomingSkip    bool            // don't show upcoming days without any events
+	UpcomingNav     bool            // show links above the upcoming days to jump to each day
+	NoNotifications bool            // don't show notifications
+	NotifyBanner    bool            // show the notifications in a single collapsed banner with the number of them and the most recent one
+	Alternates      []Alternate     // other formats of the schedule to advertise with link[rel=alternate]
+	Align           Align           // horizontal alignment of the page content
+	MaxWidth        string          // CSS length to limit the width of the page content to (the grid scrolls if wider)
+	RelativeTime    bool            // use a small inline script to show the updated/modified times relative to now
+	RangeFormat     RangeFormat     // how to show the date range in the grid header
+	UpcomingGroupBy UpcomingGroupBy // how to arrange the events in each upcoming day
+	DayCounts       DayCounts       // show the number of events in each upcoming day
+	EventColors     EventColors     // color upcoming event cards by activity or category
+	ZeroDuration    ZeroDuration    // how to show instances which start and end at the same time
+	Durations       Durations       // show the duration of instances as a badge
+	MaxActivities   int             // if nonzero, only show this many activities (see [LimitActivities])
+	MaxActivitiesBy ActivityLimit   // which activities to show for MaxActivities
+	Version         string          // included in the generator meta tag if set
+	NotificationMax int             // if nonzero, truncate notification text to this many characters
+	Seconds         bool            // show non-zero seconds in times instead of truncating them to the minute
+	CalendarLinks   bool            // show links to add each upcoming event to Google Calendar
+	Subtotals       bool            // show the average weekly number and duration of the events in the schedule range for each location in the grid (not the weekly grid)
+	StickyHeader    bool            // keep the grid weekday header and location column visible while scrolling (the grid is limited to the viewport height)
+
+	ActivityIcons map[string]template.HTML // inline SVG icons shown beside activity names, by activity name
+	CategoryIcons map[string]template.HTML // inline SVG icons shown beside activity names, by category name (if no activity icon)
+
+	Timezone *time.Location   // if set, upcoming days start from the current date in this timezone rather than the date of the last update
+	Now      func() time.Time // current time for Timezone and Live (default time.Now)
+}
+
+// Source is the facility which publishes the schedule data.
+type Source struct {
+	Name string
+	URL  string // optional
+}
+
+// Alternate is another format of the schedule.
+type Alternate struct {
+	Type  string // media type
+	Title string // optional
+	URL   string // resolved against Canonical if relative
+}
+
+// UpcomingLayout controls how upcoming days are laid out.
+type UpcomingLayout string
+
+const (
+	UpcomingLayoutScroll UpcomingLayout = ""      // a horizontally scrolling row of days
+	UpcomingLayoutStack  UpcomingLayout = "stack" // days stacked vertically
+)
+
+// UpcomingGroupBy controls how the events in upcoming days are arranged.
+type UpcomingGroupBy string
+
+const (
+	UpcomingGroupByTime     UpcomingGroupBy = ""         // events sorted by time
+	UpcomingGroupByActivity UpcomingGroupBy = "activity" // events grouped by activity, ordered by the first event of each
+)
+
+// DayCounts controls which events are counted for upcoming days.
+type DayCounts string
+
+const (
+	DayCountsNone      DayCounts = ""          // don't show counts
+	DayCountsAll       DayCounts = "all"       // all events, including cancelled ones
+	DayCountsScheduled DayCounts = "scheduled" // only events which aren't cancelled
+)
+
+// Align controls the horizontal alignment of the page content.
+type Align string
+
+const (
+	AlignCenter Align = ""      // centered
+	AlignStart  Align = "start" // left for ltr, right for rtl
+	AlignEnd    Align = "end"   // right for ltr, left for rtl
+)
+
+// EventColors controls how upcoming event cards are colored.
+type EventColors string
+
+const (
+	EventColorsNone     EventColors = ""         // the primary palette for all events
+	EventColorsActivity EventColors = "activity" // an accent palette for each activity
+	EventColorsCategory EventColors = "category" // an accent palette for each category
+)
+
+// accentColors is the number of accent palettes for [EventColors].
+const accentColors = 6
+
+// accent gets the accent palette index for an upcoming event in s, or -1 if
+// it isn't colored. Activities and categories are assigned palettes in the
+// order they appear in s, falling back to a hash of the name if not found.
+func accent(o *Options, s Schedule, e upcomingEvent) int {
+	var name string
+	switch o.EventColors {
+	case EventColorsActivity:
+		for i, a := range s.Activities {
+			if a.Name == e.Activity {
+				return i % accentColors
+			}
+		}
+		name = e.Activity
+	case EventColorsCategory:
+		if e.Category == "" {
+			return -1
+		}
+		var cs []string
+		for _, a := range s.Activities {
+			if a.Category != "" && !slices.Contains(cs, a.Category) {
+				if a.Category == e.Category {
+					return len(cs) % accentColors
+				}
+				cs = append(cs, a.Category)
+			}
+		}
+		name = e.Category
+	default:
+		return -1
+	}
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return int(h.Sum32() % accentColors)
+}
+
+// ZeroDuration controls how instances which start and end at the same time
+// are shown.
+type ZeroDuration string
+
+const (
+	ZeroDurationKeep  ZeroDuration = ""      // show the start and end time as usual
+	ZeroDurationPoint ZeroDuration = "point" // only show the start time
+	ZeroDurationHide  ZeroDuration = "hide"  // don't show them at all
+)
+
+// Durations controls whether instance durations are shown.
+type Durations string
+
+const (
+	DurationsNone    Durations = ""        // only show the time range
+	DurationsBeside  Durations = "beside"  // show the duration after the time range
+	DurationsReplace Durations = "replace" // show the duration instead of the time range in the grid (upcoming events still show both)
+)
+
+// duration formats the duration of t in minutes (e.g., "90 min") if it should
+// be shown, assuming it ends on the next day if it ends before it starts.
+func duration(d Durations, t fusiongo.TimeRange) string {
+	if d == DurationsNone || t.Start == t.End {
+		return ""
+	}
+	return strconv.Itoa(minutes(t)) + " min"
+}
+
+// formatTime formats t as HH:MM, or HH:MM:SS if seconds is true and it has
+// non-zero seconds.
+func formatTime(seconds bool, t fusiongo.Time) string {
+	if seconds {
+		return t.StringCompact()
+	}
+	return fmt.Sprintf("%02d:%02d", t.Hour, t.Minute)
+}
+
+// pointTime checks whether only the start of t should be shown.
+func pointTime(z ZeroDuration, t fusiongo.TimeRange) bool {
+	return z == ZeroDurationPoint && t.Start == t.End
+}
+
+// withoutZeroDuration returns a copy of s without instances which start and end
+// at the same time.
+func withoutZeroDuration(s *Schedule) *Schedule {
+	s1 := *s
+	s1.Activities = nil
+	for _, a := range s.Activities {
+		var ls []Location
+		for _, l := range a.Locations {
+			l.Instances = slices.DeleteFunc(slices.Clone(l.Instances), func(x Instance) bool {
+				return x.Time.Start == x.Time.End
+			})
+			if len(l.Instances) != 0 {
+				ls = append(ls, l)
+			}
+		}
+		if len(ls) != 0 {
+			a.Locations = ls
+			s1.Activities = append(s1.Activities, a)
+		}
+	}
+	return &s1
+}
+
+// RangeFormat controls how the date range of the grid is shown.
+type RangeFormat string
+
+const (
+	RangeFormatDates  RangeFormat = ""        // the start and end dates
+	RangeFormatWeekOf RangeFormat = "week-of" // "Week of" the start date if the range is within a single week, otherwise the start and end dates
+)
+
+// weekOf checks whether the range from start to end should be shown as the
+// week of start.
+func weekOf(f RangeFormat, start, end fusiongo.Date) bool {
+	return f == RangeFormatWeekOf && !start.AddDays(6).Less(end)
+}
+
+// formatRange formats the range from start to end as plain text.
+func formatRange(o *Options, start, end fusiongo.Date) string {
+	if weekOf(o.RangeFormat, start, end) {
+		return "Week of " + formatShortDate(o.DateFormat, start)
+	}
+	sep := o.TimeSeparator
+	if sep == "" {
+		sep = " - "
+	}
+	return formatShortDate(o.DateFormat, start) + sep + formatShortDate(o.DateFormat, end)
+}
+
+// ExceptionDetail controls how instance exceptions are shown in the grid.
+type ExceptionDetail string
+
+const (
+	ExceptionDetailFull      ExceptionDetail = ""          // list each exception
+	ExceptionDetailSummary   ExceptionDetail = "summary"   // show the number of exceptions, with the details in a tooltip
+	ExceptionDetailCollapsed ExceptionDetail = "collapsed" // show the number of exceptions, with the details in a tooltip and an expandable list (expanded when printing)
+	ExceptionDetailNone      ExceptionDetail = "none"      // don't show exceptions
+)
+
+// PrepareOptions configures [Prepare].
+type PrepareOptions struct {
+	CategoryAliases     map[string]string // rename categories after filtering
+	VirtualLocations    []string          // names of online locations (after filtering)
+	MergePriority       []MergePenalty    // order to compare merge candidates by (default exclusion, exception, duration)
+	SplitThreshold      time.Duration     // if nonzero, events starting or ending further than this from the rest of their merged instance get their own instance
+	SnapTimes           time.Duration     // if nonzero, round event start and end times to the nearest multiple of this before merging them (e.g., 5 minutes)
+	ExcludeDates        []fusiongo.Date   // remove all events on these dates before filtering, and don't mark weekly instances as not occurring on them
+	ActivitySort        ActivitySort
+	DedupeNotifications bool // only keep the most recent notification with the same text
+	HideCancelled       bool // treat cancelled events as if they were never scheduled
+	CategoryIDs         bool // set Activity.CategoryID
+	FirstDayExclusion   FirstDayExclusion
+	EmptyActivities     EmptyActivity // how to handle activities with empty names after trimming
+	UntitledActivity    string        // name for EmptyActivityRename (default Untitled)
+
+	Logger *slog.Logger // for debug messages about filtering and merging (default slog.Default())
 }
 
+// EmptyActivity controls how activities with names which are empty after
+// trimming whitespace are handled. This is done before filtering.
+type EmptyActivity string
+
+const (
+	EmptyActivityRename EmptyActivity = ""     // use PrepareOptions.UntitledActivity as the name
+	EmptyActivityDrop   EmptyActivity = "drop" // remove the activities
+)
+
+// ActivityLimit controls which activities are kept when limiting the number of
+// activities. The activity sort order is not changed.
+type ActivityLimit string
+
+const (
+	ActivityLimitName  ActivityLimit = ""      // alphabetically first
+	ActivityLimitCount ActivityLimit = "count" // most non-cancelled occurrences, then alphabetically first
+)
+
+// FirstDayExclusion controls how exclusions on the first day of the schedule
+// are handled if it is before the schedule was updated, since events which
+// already happened that day are sometimes left out of the data.
+type FirstDayExclusion string
+
+const (
+	FirstDayExclusionIgnore  FirstDayExclusion = ""        // don't add the exclusion
+	FirstDayExclusionShow    FirstDayExclusion = "show"    // add the exclusion with Cutoff set
+	FirstDayExclusionExclude FirstDayExclusion = "exclude" // add the exclusion like any other
+)
+
+// MergePenalty is something to minimize when merging occurrences at different
+// times into a single weekly instance.
+type MergePenalty string
+
+const (
+	MergePenaltyExclusion MergePenalty = "exclusion" // weekdays in the schedule range the instance doesn't occur on
+	MergePenaltyException MergePenalty = "exception" // occurrences with a different start or end time than the instance
+	MergePenaltyDuration  MergePenalty = "duration"  // duration of the merged occurrences (i.e., prefer merging shorter ones into longer ones)
+)
+
+var defaultMergePriority = []MergePenalty{MergePenaltyExclusion, MergePenaltyException, MergePenaltyDuration}
+
+// ActivitySort controls the order of activities and locations.
+type ActivitySort string
+
+const (
+	ActivitySortName ActivitySort = ""     // alphabetical
+	ActivitySortTime ActivitySort = "time" // by earliest instance start time, then alphabetical
+)
+
 //go:generate go run ./fonts.go
 var (
 	//go:embed asap.woff2
@@ -79,18 +404,49 @@ var (
 	symbols []byte
 )
 
-var colorCSS sync.Map
+// colorCSS and accentCSS cache the generated palettes by color. They are
+// bounded since colors may come from requests (e.g., for previews).
+var (
+	colorCSS  = lru.Cache[string, string]{Size: 64}
+	accentCSS = lru.Cache[string, string]{Size: 64}
+)
+
+// md3PaletteCSS generates the MD3 palette CSS custom properties for the hex
+// color c. The result is cached.
+func md3PaletteCSS(c string) (string, error) {
+	c = strings.ToLower(c)
+	if v, ok := colorCSS.Get(c); ok {
+		return v, nil
+	}
+	v, err := m3color.PaletteCSS(c)
+	if err != nil {
+		return "", fmt.Errorf("generate md3 palette css for color %s: %w", c, err)
+	}
+	colorCSS.Add(c, v)
+	return v, nil
+}
+
 var tmpl = template.Must(template.New("").
 	Funcs(template.FuncMap{
 		"Weekday": func(i int) time.Weekday {
 			return time.Weekday(i)
 		},
-		"FormatShortDate": func(d fusiongo.Date) string {
-			return d.Month.String()[:3] + " " + strconv.Itoa(d.Day)
+		"FormatShortDate": formatShortDate,
+		"WeekOf":          weekOf,
+		"PointTime":       pointTime,
+		"Duration":        duration,
+		"Minutes":         minutes,
+		"MarkdownHTML":    MarkdownHTML,
+		"ActivityIcon": func(o *Options, activity, category string) template.HTML {
+			if v, ok := o.ActivityIcons[activity]; ok {
+				return v
+			}
+			return o.CategoryIcons[category]
 		},
-		"FormatTime": func(d fusiongo.Time) string {
-			return d.StringCompact()
+		"Date": func(year int, month time.Month, day int) fusiongo.Date {
+			return fusiongo.Date{Year: year, Month: month, Day: day}
 		},
+		"FormatTime": formatTime,
 		"Range": func(n int) []int {
 			s := make([]int, n)
 			for i := range s {
@@ -98,54 +454,50 @@ var tmpl = template.Must(template.New("").
 			}
 			return s
 		},
-		"LocationWeekdayInstances": func(l Location) int {
-			var n [7]int
-			for _, x := range l.Instances {
-				for d, b := range x.Days {
-					if b {
-						n[d]++
-					}
-				}
-			}
-			var m int
-			for _, x := range n {
-				if x > m {
-					m = x
-				}
+		"LocationGroups":    locationGroups,
+		"Weeks":             weeks,
+		"RelativeTimeJS":    relativeTimeJS,
+		"PrintJS":           printJS,
+		"WeekdayExceptions": weekdayExceptions,
+		"Truncate":          truncate,
+		"GoogleCalendarURL": googleCalendarURL,
+		"LocationSubtotal":  locationSubtotal,
+		"HasCutoff":         hasCutoff,
+		"ExceptionSummary": func(es []exceptionRun) string {
+			if len(es) == 1 {
+				return "1 exception"
 			}
-			return m
+			return strconv.Itoa(len(es)) + " exceptions"
 		},
-		"LocationWeekdayInstance": func(l Location, w time.Weekday, i int) *Instance {
-			var c int
-			for xi, x := range l.Instances {
-				if x.Days[w] {
-					if c == i {
-						// quick sanity check to prevent bugs from being silently swallowed
-						for _, c := range x.Exceptions {
-							if !x.Days[c.Date.Weekday()] {
-								panic("wtf: instance has exceptions on weekdays the instance isn't on")
-							}
-						}
-						return &l.Instances[xi]
-					}
-					c++
+		"ExceptionTitle": func(es []exceptionRun, layout, sep string, seconds bool) string {
+			var b strings.Builder
+			for i, e := range es {
+				if i != 0 {
+					b.WriteByte('\n')
 				}
+				b.WriteString(e.Format(layout, sep, seconds))
 			}
-			return nil
+			return b.String()
 		},
 		"MD3": func(c string) (template.CSS, error) {
+			v, err := md3PaletteCSS(c)
+			return template.CSS(v), err
+		},
+		"MD3Accents": func(c string) (template.CSS, error) {
 			c = strings.ToLower(c)
-			v, ok := colorCSS.Load(c)
+			v, ok := accentCSS.Get(c)
 			if !ok {
-				if x, err := m3color.PaletteCSS(c); err != nil {
-					return "", fmt.Errorf("generate md3 palette css for color %s: %w", c, err)
+				if x, err := m3color.AccentCSS(c, accentColors); err != nil {
+					return "", fmt.Errorf("generate md3 accent css for color %s: %w", c, err)
 				} else {
 					v = x
 				}
-				colorCSS.Store(c, v)
+				accentCSS.Add(c, v)
 			}
-			return template.CSS(v.(string)), nil
+			return template.CSS(v), nil
 		},
+		"Accent":       accent,
+		"AccentColors": func() int { return accentColors },
 		"AsapFontURL": func() template.CSS {
 			return template.CSS("url('data:font/woff2;base64," + base64.StdEncoding.EncodeToString(asap) + "') format('woff2-variations')")
 		},
@@ -155,72 +507,56 @@ var tmpl = template.Must(template.New("").
 		"DataURL": func(mimetype string, data []byte) template.URL {
 			return template.URL("data:" + mimetype + ";base64," + base64.StdEncoding.EncodeToString(data))
 		},
-		"Upcoming": func(a Schedule, n int) any {
-			type DayEvent struct {
-				Activity  string
-				Time      fusiongo.TimeRange
-				Location  string
-				Cancelled bool
-				Exception bool
+		"Live": func(o *Options, a Schedule) any {
+			now := liveTime(o)
+			if now == nil {
+				return nil
 			}
-			type Day struct {
-				Date   fusiongo.Date
-				Events []DayEvent
+			return struct {
+				Weekday time.Weekday
+				Status  map[*Instance]LiveStatus
+			}{
+				Weekday: now.Date.Weekday(),
+				Status:  liveStatus(&a, *now),
 			}
-			var days []Day
-			for d := fusiongo.GoDateTime(a.Updated).Date; len(days) < n && !a.End.Less(d); d = d.AddDays(1) {
-				days = append(days, Day{
-					Date: d,
-				})
-			}
-			for _, activity := range a.Activities {
-				for _, location := range activity.Locations {
-					for _, instance := range location.Instances {
-						Expand(&a, instance, func(t fusiongo.DateTimeRange, cancelled, exception bool) {
-							for i := range days {
-								if days[i].Date == t.Date {
-									days[i].Events = append(days[i].Events, DayEvent{
-										Activity:  activity.Name,
-										Location:  location.Name,
-										Time:      t.TimeRange,
-										Cancelled: cancelled,
-										Exception: exception,
-									})
-									break
-								}
-							}
-						})
-					}
-				}
-			}
-			for _, day := range days {
-				slices.SortStableFunc(day.Events, func(a, b DayEvent) int {
-					return a.Time.Compare(b.Time)
-				})
-			}
-			return days
 		},
+		"SubscribeLinks": subscribeLinks,
+		"ResolveURL":     resolveURL,
+		"QRCode":         qrCodeSVG,
+		"Today":          today,
+		"Upcoming": func(o *Options, a Schedule, start fusiongo.Date, n, max int) []upcomingDay {
+			return upcoming(&a, start, n, max, liveTime(o))
+		},
+		"NonEmptyDays":   nonEmptyDays,
+		"DayCount":       dayCount,
+		"UpcomingGroups": upcomingGroups,
 	}).
 	Parse(unindent(false, `
 		<!DOCTYPE html>
-		<html lang="en">
+		<html lang="{{with $.Language}}{{.}}{{else}}en{{end}}"{{with $.Direction}} dir="{{.}}"{{end}}>
 		<head>
 			<meta charset="utf-8">
 			<meta name="viewport" content="width=760,user-scalable=yes">
-			<meta name="generator" content="ifgsch">
+			<meta name="generator" content="ifgsch{{with $.Version}} {{.}}{{end}}">
 			<meta name="color-scheme" content="light dark">
 			{{- with $.Description }}
 			<meta name="description" content="{{.}}">
 			{{- end }}
-			<title>{{with $.Title}}{{.}}{{else}}Schedule{{end}}</title>
+			<title>{{with $.Title}}{{.}}{{else}}Schedule{{end}}{{with $.Day}} - {{FormatShortDate $.DateFormat .}}{{end}}</title>
 			{{- with $.Icon }}
 			<link href="{{ DataURL "image/x-icon" . }}" rel="shortcut icon" type="image/x-icon">
 			{{- end }}
 			{{- with $.Canonical }}
-			<link rel="canonical" href="{{.}}">
+			<link rel="canonical" href="{{.}}{{with $.Day}}/{{.}}{{end}}">
+			{{- end }}
+			{{- range $.Alternates }}
+			<link rel="alternate" type="{{.Type}}" href="{{ResolveURL $.Canonical .URL}}" {{- with .Title}} title="{{.}}" {{- end}}>
 			{{- end }}
 			<style>
-				{{MD3 $.Color}}
+				{{with $.Palette}}{{.}}{{else}}{{MD3 $.Color}}{{end}}
+				{{- if $.EventColors }}
+				{{MD3Accents $.Color}}
+				{{- end }}
 				@font-face {
 					font-family: 'Asap SemiCondensed';
 					font-style: normal;
@@ -279,6 +615,20 @@ var tmpl = template.Must(template.New("").
 					max-width: 100% !important;
 					box-sizing: border-box !important;
 				}
+				{{- if eq $.Align "start" }}
+				main.wrapper {
+					justify-content: flex-start;
+				}
+				{{- else if eq $.Align "end" }}
+				main.wrapper {
+					justify-content: flex-end;
+				}
+				{{- end }}
+				{{- with $.MaxWidth }}
+				main.wrapper > .shrink {
+					max-width: min(100%, {{.}});
+				}
+				{{- end }}
 				h1.title {
 					color: var(--md-ref-palette-primary10);
 					margin: .5em 0;
@@ -299,7 +649,7 @@ var tmpl = template.Must(template.New("").
 				section.schedule table td {
 					padding: .5em;
 					vertical-align: top;
-					text-align: left;
+					text-align: start;
 					font-weight: 400;
 				}
 				section.schedule table tr.week {
@@ -318,10 +668,31 @@ var tmpl = template.Must(template.New("").
 				section.schedule table tr.activity > th {
 					font-weight: 500;
 				}
+				section.schedule table tr.activity > th > span.icon > svg {
+					display: inline-block;
+					width: 1.25em;
+					height: 1.25em;
+					vertical-align: -.25em;
+					margin-inline-end: .25em;
+					fill: currentColor;
+				}
 				section.schedule table tr.location > th.location {
 					background: var(--md-ref-palette-primary40);
 					color: var(--md-ref-palette-primary100);
 				}
+				section.schedule table tr.location > th.location > span.virtual,
+				section.schedule table tr.location > td.instance > div.location > span.virtual,
+				section.upcoming > div.inner > section.day > div.events > div.event > div.location > span.virtual,
+				section.upcoming > div.inner > section.day > div.events > div.event > div.occurrence > div.location > span.virtual {
+					display: inline-block;
+					border-radius: .25em;
+					box-shadow: inset 0 0 0 1px currentColor;
+					font-size: 0.75em;
+					font-weight: 500;
+					line-height: 1;
+					padding: .15em .35em;
+					vertical-align: .1em;
+				}
 				section.schedule table tr.location > td.instance {
 					text-align: center;
 					white-space: nowrap;
@@ -329,11 +700,161 @@ var tmpl = template.Must(template.New("").
 				section.schedule table tr.location > td.instance:nth-of-type(even) {
 					background: var(--md-ref-palette-primary92);
 				}
-				section.schedule table tr.location > td.instance > div.exception {
+				section.schedule table tr.location > td.instance > div.location {
+					font-weight: 500;
+				}
+				section.schedule table tr.location > td.instance > div.exception,
+				section.schedule table tr.location > td.instance > details.exception {
 					color: var(--md-ref-palette-primary40);
 					font-size: 0.75em;
 					margin-top: .2em;
 				}
+				section.schedule table tr.location > td.instance > details.exception > summary {
+					cursor: pointer;
+				}
+				section.schedule table tr.location > td.instance > details.exception > div.exception {
+					margin-top: .2em;
+				}
+				section.schedule table tr.location > td.instance.empty > span.placeholder {
+					color: var(--md-ref-palette-neutral-variant70);
+				}
+				section.schedule table tr.location.subtotal > * {
+					color: var(--md-ref-palette-neutral-variant40);
+					font-size: .875em;
+					font-weight: 400;
+					text-align: end;
+				}
+				section.schedule table tr.week > th.weekday > span.date {
+					display: block;
+					font-size: .85em;
+					font-weight: 400;
+				}
+				section.schedule table tr.location > td.instance.cancelled {
+					color: var(--md-ref-palette-error40);
+				}
+				section.schedule table tr.location > td.instance.cancelled > div.time {
+					text-decoration: line-through;
+				}
+				div.time > time.duration {
+					display: inline-block;
+					padding: 0 .35em;
+					border-radius: .35em;
+					background: var(--md-ref-palette-primary90);
+					color: var(--md-ref-palette-primary10);
+					font-size: .85em;
+					white-space: nowrap;
+				}
+				div.time > time.duration:not(:first-child) {
+					margin-inline-start: .35em;
+				}
+				{{- if $.StickyHeader }}
+				@media screen {
+					section.schedule {
+						overflow: auto;
+						max-height: 100vh;
+						max-height: 100dvh;
+					}
+					section.schedule table tr.week > th {
+						position: sticky;
+						top: 0;
+						z-index: 2;
+						background: inherit;
+					}
+					section.schedule table tr.week > th.range {
+						inset-inline-start: 0;
+						z-index: 3;
+					}
+					section.schedule table tr.location > th.location {
+						position: sticky;
+						inset-inline-start: 0;
+						z-index: 1;
+					}
+				}
+				{{- end }}
+				nav.weeks,
+				section.upcoming > nav.days {
+					display: flex;
+					flex-wrap: wrap;
+					justify-content: center;
+					gap: .35em;
+				}
+				section.upcoming > nav.days {
+					margin-bottom: .75em;
+				}
+				nav.weeks > a,
+				section.upcoming > nav.days > a {
+					background: var(--md-ref-palette-primary90);
+					color: var(--md-ref-palette-primary10);
+					border-radius: 1em;
+					padding: .15em .6em;
+					font-size: .9em;
+					text-decoration: none;
+					white-space: nowrap;
+				}
+				nav.weeks > a:hover,
+				section.upcoming > nav.days > a:hover {
+					background: var(--md-ref-palette-primary80);
+				}
+				section.schedule table tr.location > td.instance.now,
+				section.schedule table tr.location > td.instance.next {
+					background: var(--md-ref-palette-tertiary90);
+					color: var(--md-ref-palette-tertiary10);
+				}
+				section.schedule table tr.location > td.instance > div.live,
+				section.upcoming > div.inner > section.day > div.events > div.event > div.live,
+				section.upcoming > div.inner > section.day > div.events > div.event > div.occurrence > div.live {
+					display: inline-block;
+					background: var(--md-ref-palette-tertiary40);
+					color: var(--md-ref-palette-tertiary100);
+					border-radius: .25em;
+					font-size: 0.75em;
+					font-weight: 600;
+					line-height: 1;
+					padding: .15em .35em;
+					text-transform: uppercase;
+				}
+				section.schedule table tr.location > td.instance.next > div.live,
+				section.upcoming > div.inner > section.day > div.events > div.event.next > div.live,
+				section.upcoming > div.inner > section.day > div.events > div.event > div.occurrence.next > div.live {
+					background: transparent;
+					color: var(--md-ref-palette-tertiary40);
+					box-shadow: inset 0 0 0 1px currentColor;
+				}
+				section.schedule table tr.location > td.instance > div.live {
+					margin-bottom: .2em;
+				}
+				section.schedule.empty {
+					background: var(--md-ref-palette-primary95);
+					color: var(--md-ref-palette-primary20);
+					padding: .25em .5em;
+					text-align: center;
+				}
+				section.schedule.empty > p {
+					margin: .25em 0;
+				}
+				section.legend,
+				section.more-activities {
+					color: var(--md-ref-palette-neutral-variant30);
+					font-size: .875em;
+					padding: 0 .5em;
+				}
+				section.more-activities > p {
+					margin: 0;
+				}
+				section.legend > dl {
+					display: grid;
+					grid-template-columns: max-content 1fr;
+					gap: .125em 1em;
+					margin: 0;
+				}
+				section.legend > dl > dt {
+					color: var(--md-ref-palette-primary40);
+					font-size: .857em;
+					white-space: nowrap;
+				}
+				section.legend > dl > dd {
+					margin: 0;
+				}
 				section.notification {
 					background: var(--md-ref-palette-tertiary90);
 					color: var(--md-ref-palette-tertiary10);
@@ -345,10 +866,38 @@ var tmpl = template.Must(template.New("").
 				}
 				section.notification > div.date {
 					color: var(--md-ref-palette-tertiary40);
-					text-align: right;
+					text-align: end;
 					font-size: 0.75em;
 					margin: .25em 0;
 				}
+				details.notifications {
+					background: var(--md-ref-palette-tertiary90);
+					color: var(--md-ref-palette-tertiary10);
+					padding: .25em .5em;
+					border-radius: 8px;
+				}
+				details.notifications > summary {
+					cursor: pointer;
+					margin: .25em 0;
+					overflow: hidden;
+					text-overflow: ellipsis;
+					white-space: nowrap;
+				}
+				details.notifications > summary > span.count {
+					font-weight: 500;
+				}
+				details.notifications > summary > span.latest {
+					margin-inline-start: .5em;
+					color: var(--md-ref-palette-tertiary40);
+				}
+				details.notifications[open] > summary > span.latest {
+					display: none;
+				}
+				details.notifications > section.notification {
+					padding: 0;
+					border-radius: 0;
+					border-top: 1px solid var(--md-ref-palette-tertiary80);
+				}
 				section.upcoming > div.inner {
 					display: flex;
 					flex-direction: row;
@@ -392,50 +941,132 @@ var tmpl = template.Must(template.New("").
 					font-size: inherit;
 					font-weight: 600;
 				}
+				section.upcoming > div.inner > section.day > h2.date > span.count {
+					font-weight: normal;
+					opacity: 0.8;
+				}
+				section.upcoming > div.inner > section.day > h2.date > span.count::before {
+					content: "· ";
+				}
 				section.upcoming > div.inner > section.day > div.events {
 					flex: 1;
 					overflow: hidden auto;
 				}
+				section.upcoming.stack > div.inner {
+					flex-direction: column;
+					overflow: visible;
+					min-height: 0;
+					max-height: none;
+					padding-bottom: 0;
+					margin-bottom: 0;
+				}
+				section.upcoming.stack > div.inner > section.day {
+					flex: 0 0 auto;
+					min-width: 0;
+					max-width: none;
+				}
+				section.upcoming.stack > div.inner > section.day > div.events {
+					display: grid;
+					grid-template-columns: repeat(auto-fill, minmax(12em, 1fr));
+					overflow: visible;
+				}
 				section.upcoming > div.inner > section.day > div.events > div.event {
 					padding: .25em;
 				}
-				section.upcoming > div.inner > section.day > div.events > div.event.cancelled {
+				{{- if $.EventColors }}
+				{{- range $i := Range AccentColors }}
+				section.upcoming > div.inner > section.day > div.events > div.event.accent{{$i}} {
+					background: var(--md-ref-palette-accent{{$i}}-90);
+					color: var(--md-ref-palette-accent{{$i}}-10);
+					border-inline-start: .25em solid var(--md-ref-palette-accent{{$i}}-40);
+					border-radius: .25em;
+					margin: .25em;
+				}
+				{{- end }}
+				{{- end }}
+				section.upcoming > div.inner > section.day > div.events > .more {
+					display: block;
+					padding: .5em;
+					color: var(--md-ref-palette-primary40);
+					font-weight: 500;
+				}
+				nav.back {
+					margin: 0 0 .5em;
+				}
+				nav.back > a {
+					color: var(--md-ref-palette-primary40);
+				}
+				section.upcoming > div.inner > section.day > div.events > div.event.cancelled,
+				section.upcoming > div.inner > section.day > div.events > div.event > div.occurrence.cancelled {
 					color: var(--md-ref-palette-error20);
 					opacity: 0.5;
 				}
-				section.upcoming > div.inner > section.day > div.events > div.event > * {
+				section.upcoming > div.inner > section.day > div.events > div.event > *,
+				section.upcoming > div.inner > section.day > div.events > div.event > div.occurrence > * {
 					margin: .25em;
 				}
+				section.upcoming > div.inner > section.day > div.events > div.event > div.occurrence {
+					margin: .5em 0 0;
+				}
+				section.upcoming > div.inner > section.day > div.events > div.event > div.occurrence.cancelled > div.time {
+					text-decoration: line-through;
+				}
 				section.upcoming > div.inner > section.day > div.events > div.event > div.activity {
 					font-weight: 600;
 				}
+				section.upcoming > div.inner > section.day > div.events > div.event > div.facility,
+				section.upcoming > div.inner > section.day > div.events > div.event > div.occurrence > div.facility {
+					font-size: .875em;
+					font-weight: 500;
+				}
+				section.upcoming > div.inner > section.day > div.events > div.event > div.facility > a,
+				section.upcoming > div.inner > section.day > div.events > div.event > div.occurrence > div.facility > a {
+					color: inherit;
+				}
+				section.upcoming > div.inner > section.day > div.events > div.event > a.calendar,
+				section.upcoming > div.inner > section.day > div.events > div.event > div.occurrence > a.calendar {
+					display: block;
+					font-size: .875em;
+					color: var(--md-ref-palette-primary40);
+				}
 				section.upcoming > div.inner > section.day > div.events > div.event.cancelled > div.activity {
 					text-decoration: line-through;
 				}
 				section.upcoming > div.inner > section.day > div.events > div.event > div.location::before,
-				section.upcoming > div.inner > section.day > div.events > div.event > div.time::before {
+				section.upcoming > div.inner > section.day > div.events > div.event > div.time::before,
+				section.upcoming > div.inner > section.day > div.events > div.event > div.occurrence > div.location::before,
+				section.upcoming > div.inner > section.day > div.events > div.event > div.occurrence > div.time::before {
 					font-family: 'Material Symbols Subset';
 					text-rendering: optimizeLegibility;
 					-webkit-font-smoothing: antialiased;
 					-moz-osx-font-smoothing: grayscale;
 					display: inline-block;
 					vertical-align: top;
-					margin-right: .25em;
+					margin-inline-end: .25em;
 					line-height: 1;
 				}
-				section.upcoming > div.inner > section.day > div.events > div.event > div.location::before {
+				section.upcoming > div.inner > section.day > div.events > div.event > div.location::before,
+				section.upcoming > div.inner > section.day > div.events > div.event > div.occurrence > div.location::before {
 					content: '\E55F';
 				}
-				section.upcoming > div.inner > section.day > div.events > div.event > div.time::before {
+				section.upcoming > div.inner > section.day > div.events > div.event > div.time::before,
+				section.upcoming > div.inner > section.day > div.events > div.event > div.occurrence > div.time::before {
 					content: '\E192';
 				}
+				section.upcoming > div.inner > section.day > div.events > div.event.has-icon {
+					position: relative;
+					padding-inline-start: 2em;
+				}
+				section.upcoming > div.inner > section.day > div.events > div.event > div.icon {
+					margin: 0;
+				}
 				section.upcoming > div.inner > section.day > div.events > div.event > div.icon > svg {
 					display: block;
 					position: absolute;
-					top: 0;
-					left: -.35em;
-					bottom: 0;
-					height: 100%;
+					top: .5em;
+					inset-inline-start: .35em;
+					width: 1.5em;
+					height: 1.5em;
 					fill: currentColor;
 				}
 				footer.info {
@@ -448,6 +1079,20 @@ var tmpl = template.Must(template.New("").
 				footer.info > p {
 					margin: .25em 0;
 				}
+				footer.info > div.qr {
+					display: none;
+				}
+				section.subscribe {
+					color: var(--md-ref-palette-neutral-variant30);
+					font-size: .875em;
+					padding: 0 .5em;
+				}
+				section.subscribe > p {
+					margin: .25em 0;
+				}
+				section.subscribe a {
+					color: inherit;
+				}
 				@media screen and (prefers-color-scheme: dark) {
 					html {
 						background: var(--md-ref-palette-neutral0);
@@ -474,10 +1119,60 @@ var tmpl = template.Must(template.New("").
 						background: var(--md-ref-palette-primary25);
 						color: var(--md-ref-palette-primary90);
 					}
+					div.time > time.duration {
+						background: var(--md-ref-palette-primary30);
+						color: var(--md-ref-palette-primary90);
+					}
 					section.schedule table tr.location > td.instance:nth-of-type(even) {
 						background: var(--md-ref-palette-primary10);
 					}
-					section.schedule table tr.location > td.instance > div.exception {
+					section.schedule table tr.location > td.instance > div.exception,
+					section.schedule table tr.location > td.instance > details.exception {
+						color: var(--md-ref-palette-primary60);
+					}
+					section.schedule table tr.location > td.instance.empty > span.placeholder {
+						color: var(--md-ref-palette-neutral-variant40);
+					}
+					section.schedule table tr.location.subtotal > * {
+						color: var(--md-ref-palette-neutral-variant70);
+					}
+					section.schedule table tr.location > td.instance.cancelled {
+						color: var(--md-ref-palette-error80);
+					}
+					nav.weeks > a,
+					section.upcoming > nav.days > a {
+						background: var(--md-ref-palette-primary30);
+						color: var(--md-ref-palette-primary90);
+					}
+					nav.weeks > a:hover,
+					section.upcoming > nav.days > a:hover {
+						background: var(--md-ref-palette-primary40);
+					}
+					section.schedule table tr.location > td.instance.now,
+					section.schedule table tr.location > td.instance.next {
+						background: var(--md-ref-palette-tertiary20);
+						color: var(--md-ref-palette-tertiary90);
+					}
+					section.schedule table tr.location > td.instance > div.live,
+					section.upcoming > div.inner > section.day > div.events > div.event > div.live,
+					section.upcoming > div.inner > section.day > div.events > div.event > div.occurrence > div.live {
+						background: var(--md-ref-palette-tertiary80);
+						color: var(--md-ref-palette-tertiary20);
+					}
+					section.schedule table tr.location > td.instance.next > div.live,
+					section.upcoming > div.inner > section.day > div.events > div.event.next > div.live,
+					section.upcoming > div.inner > section.day > div.events > div.event > div.occurrence.next > div.live {
+						color: var(--md-ref-palette-tertiary80);
+					}
+					section.schedule.empty {
+						background: var(--md-ref-palette-primary12);
+						color: var(--md-ref-palette-primary90);
+					}
+					section.legend,
+					section.more-activities {
+						color: var(--md-ref-palette-neutral-variant70);
+					}
+					section.legend > dl > dt {
 						color: var(--md-ref-palette-primary60);
 					}
 					section.notification {
@@ -487,6 +1182,16 @@ var tmpl = template.Must(template.New("").
 					section.notification > div.date {
 						color: var(--md-ref-palette-tertiary60);
 					}
+					details.notifications {
+						background: var(--md-ref-palette-tertiary10);
+						color: var(--md-ref-palette-tertiary90);
+					}
+					details.notifications > summary > span.latest {
+						color: var(--md-ref-palette-tertiary60);
+					}
+					details.notifications > section.notification {
+						border-top-color: var(--md-ref-palette-tertiary30);
+					}
 					section.upcoming > div.inner > section.day {
 						background: var(--md-ref-palette-primary17);
 						color: var(--md-ref-palette-primary90);
@@ -495,13 +1200,30 @@ var tmpl = template.Must(template.New("").
 						background: var(--md-ref-palette-primary12);
 						color: var(--md-ref-palette-primary90);
 					}
-					section.upcoming > div.inner > section.day > div.events > div.event.cancelled {
+					{{- if $.EventColors }}
+					{{- range $i := Range AccentColors }}
+					section.upcoming > div.inner > section.day > div.events > div.event.accent{{$i}} {
+						background: var(--md-ref-palette-accent{{$i}}-30);
+						color: var(--md-ref-palette-accent{{$i}}-90);
+						border-color: var(--md-ref-palette-accent{{$i}}-80);
+					}
+					{{- end }}
+					{{- end }}
+					section.upcoming > div.inner > section.day > div.events > div.event.cancelled,
+					section.upcoming > div.inner > section.day > div.events > div.event > div.occurrence.cancelled {
 						color: var(--md-ref-palette-error80);
 					}
+					section.upcoming > div.inner > section.day > div.events > .more,
+					nav.back > a {
+						color: var(--md-ref-palette-primary80);
+					}
 					footer.info {
 						color: var(--md-ref-palette-neutral-variant70);
 						background: var(--md-ref-palette-neutral-variant10);
 					}
+					section.subscribe {
+						color: var(--md-ref-palette-neutral-variant70);
+					}
 				}
 				@media print {
 					@page {
@@ -518,21 +1240,109 @@ var tmpl = template.Must(template.New("").
 					section.schedule {
 						overflow: hidden;
 					}
-					section.upcoming {
+					section.schedule table tr.location > td.instance > details.exception::details-content,
+					details.notifications::details-content {
+						content-visibility: visible;
+						display: block;
+					}
+					details.notifications > summary > span.latest {
+						display: none;
+					}
+					section.schedule table tr.location > td.instance > details.exception > summary {
+						list-style: none;
+					}
+					section.upcoming,
+					section.subscribe {
 						display: none;
 					}
+					footer.info {
+						display: flow-root;
+					}
+					footer.info > div.qr {
+						display: block;
+						float: inline-end;
+						width: 2.5cm;
+						margin-inline-start: .5em;
+					}
+					footer.info > div.qr > svg {
+						display: block;
+					}
 				}
 			</style>
 		</head>
 		<body>
+			{{- $live := and $.Live (Live $.Options $.Schedule) }}
 			<main class="wrapper">
 				<div class="shrink">
 					<h1 class="title">{{with $.Title}}{{.}}{{else}}Schedule{{end}}</h1>
+					{{- if $.Day }}
+					{{- with $.Path }}
+					<nav class="back"><a href="{{.}}">Full schedule</a></nav>
+					{{- end }}
+					{{- else if $.Combined }}
+					{{- else if not $.Activities }}
+					<section class="schedule empty">
+						<p>No scheduled events for <time datetime="{{$.Start}}">{{FormatShortDate $.DateFormat $.Start}}</time>{{$.TimeSeparator}}<time datetime="{{$.End}}">{{FormatShortDate $.DateFormat $.End}}</time>.</p>
+					</section>
+					{{- else if $.Weekly }}
+					{{- $weeks := Weeks $.Schedule }}
+					<nav class="weeks">
+						{{- range $w := $weeks }}
+						<a href="#week-{{$w.Start}}">{{FormatShortDate $.DateFormat $w.Start}}</a>
+						{{- end }}
+					</nav>
+					{{- range $w := $weeks }}
+					<section class="schedule week" id="week-{{$w.Start}}">
+						<table>
+							<thead>
+								<tr class="week">
+									<th scope="row" class="range">{{if WeekOf $.RangeFormat $w.Start $w.End}}Week of <time datetime="{{$w.Start}}">{{FormatShortDate $.DateFormat $w.Start}}</time>{{else}}<time datetime="{{$w.Start}}">{{FormatShortDate $.DateFormat $w.Start}}</time>{{$.TimeSeparator}}<time datetime="{{$w.End}}">{{FormatShortDate $.DateFormat $w.End}}</time>{{end}}</th>
+									{{- range $d := $w.Dates }}
+									<th scope="col" class="weekday">{{$d.Weekday}} <span class="date"><time datetime="{{$d}}">{{FormatShortDate $.DateFormat $d}}</time></span></th>
+									{{- end }}
+								</tr>
+							</thead>
+							<tbody>
+								{{- range $a := $w.Activities }}
+								<tr class="activity">
+									<th scope="colgroup" class="activity" colspan="8">
+										{{- with ActivityIcon $.Options $a.Name $a.Category }}<span class="icon">{{.}}</span>{{ end -}}
+										{{$a.Name}}
+										{{- if and $.Categories $a.Category }} <span class="category">{{$a.Category}}</span>{{ end -}}
+									</th>
+								</tr>
+								{{- range $l := $a.Locations }}
+								{{- range $i, $row := $l.Rows }}
+								<tr class="location">
+									{{- if not $i }}
+									<th scope="rowgroup" class="location {{- if $l.Virtual }} virtual {{- end }}" rowspan="{{len $l.Rows}}">{{$l.Name}}{{if $l.Virtual}} <span class="virtual">Online</span>{{end}}</th>
+									{{- end }}
+									{{- range $x := $row }}
+									{{- if $x }}
+									<td class="instance {{- if $x.Cancelled }} cancelled {{- end }}">
+										<div class="time">{{if or (ne $.Durations "replace") (not (Duration $.Durations $x.Time))}}<time datetime="{{$x.Time.Start}}">{{FormatTime $.Seconds $x.Time.Start}}</time>{{if not (PointTime $.ZeroDuration $x.Time)}}{{$.TimeSeparator}}<time datetime="{{$x.Time.End}}">{{FormatTime $.Seconds $x.Time.End}}</time>{{end}}{{end}}{{with Duration $.Durations $x.Time}}<time class="duration" datetime="PT{{Minutes $x.Time}}M">{{.}}</time>{{end}}</div>
+										{{- if $x.Cancelled }}
+										<div class="exception">cancelled</div>
+										{{- end }}
+									</td>
+									{{- else }}
+									<td class="instance empty">{{if $.EmptyCells}}<span class="placeholder" aria-hidden="true">—</span>{{end}}</td>
+									{{- end }}
+									{{- end }}
+								</tr>
+								{{- end }}
+								{{- end }}
+								{{- end }}
+							</tbody>
+						</table>
+					</section>
+					{{- end }}
+					{{- else }}
 					<section class="schedule">
 						<table>
 							<thead>
 								<tr class="week">
-									<th scope="row" class="range"><time datetime="{{$.Start}}">{{FormatShortDate $.Start}}</time> - <time datetime="{{$.End}}">{{FormatShortDate $.End}}</time></th>
+									<th scope="row" class="range">{{if WeekOf $.RangeFormat $.Start $.End}}Week of <time datetime="{{$.Start}}">{{FormatShortDate $.DateFormat $.Start}}</time>{{else}}<time datetime="{{$.Start}}">{{FormatShortDate $.DateFormat $.Start}}</time>{{$.TimeSeparator}}<time datetime="{{$.End}}">{{FormatShortDate $.DateFormat $.End}}</time>{{end}}</th>
 									{{- range $w := Range 7 }}
 									<th scope="col" class="weekday">{{Weekday $w}}</th>
 									{{- end }}
@@ -541,22 +1351,51 @@ var tmpl = template.Must(template.New("").
 							<tbody>
 								{{- range $a := $.Activities }}
 								<tr class="activity">
-									<th scope="colgroup" class="activity" colspan="8">{{$a.Name}}</th>
+									<th scope="colgroup" class="activity" colspan="8">
+										{{- with ActivityIcon $.Options $a.Name $a.Category }}<span class="icon">{{.}}</span>{{ end -}}
+										{{$a.Name}}
+										{{- if and $.Categories $a.Category }} <span class="category">{{$a.Category}}</span>{{ end -}}
+									</th>
 								</tr>
-								{{- range $c := $a.Locations}}
-								{{- range $i := Range (LocationWeekdayInstances $c) }}
+								{{- range $c := LocationGroups $a $.Compact }}
+								{{- range $i, $row := $c.Rows }}
 								<tr class="location">
 									{{- if not $i }}
-									<th scope="rowgroup" class="location" rowspan="{{LocationWeekdayInstances $c}}">{{$c.Name}}</th>
+									{{- if $c.Other }}
+									<th scope="rowgroup" class="location other" rowspan="{{len $c.Rows}}">Other times</th>
+									{{- else }}
+									<th scope="rowgroup" class="location {{- if $c.Virtual }} virtual {{- end }}" rowspan="{{len $c.Rows}}">{{$c.Name}}{{if $c.Virtual}} <span class="virtual">Online</span>{{end}}</th>
+									{{- end }}
 									{{- end }}
 									{{- range $w := Range 7 }}
-									{{- with $x := LocationWeekdayInstance $c (Weekday $w) $i }}
-									<td class="instance">
-										<div class="time"><time datetime="{{$x.Time.Start}}">{{FormatTime $x.Time.Start}}</time> - <time datetime="{{$x.Time.End}}">{{FormatTime $x.Time.End}}</time></div>
-										{{- range $e := $x.Exceptions }}
-										{{- if eq $e.Date.Weekday (Weekday $w) }}
-										<div class="exception">
-											<time datetime="{{$e.Date}}">{{FormatShortDate $e.Date}}</time>
+									{{- with $x := index $row $w }}
+									<td class="instance {{- if and $live (eq (Weekday $w) $live.Weekday) }}{{with index $live.Status $x.Instance}} {{.}}{{end}}{{end}}">
+										{{- if and $live (eq (Weekday $w) $live.Weekday) }}{{with index $live.Status $x.Instance}}
+										<div class="live">{{.Label}}</div>
+										{{- end }}{{end}}
+										{{- if $c.Other }}
+										<div class="location {{- if $x.Location.Virtual }} virtual {{- end }}">{{$x.Location.Name}}{{if $x.Location.Virtual}} <span class="virtual">Online</span>{{end}}</div>
+										{{- end }}
+										<div class="time">{{if or (ne $.Durations "replace") (not (Duration $.Durations $x.Time))}}<time datetime="{{$x.Time.Start}}">{{FormatTime $.Seconds $x.Time.Start}}</time>{{if not (PointTime $.ZeroDuration $x.Time)}}{{$.TimeSeparator}}<time datetime="{{$x.Time.End}}">{{FormatTime $.Seconds $x.Time.End}}</time>{{end}}{{end}}{{with Duration $.Durations $x.Time}}<time class="duration" datetime="PT{{Minutes $x.Time}}M">{{.}}</time>{{end}}</div>
+										{{- if eq $.ExceptionDetail "none" }}
+										{{- else if eq $.ExceptionDetail "summary" }}
+										{{- with $es := WeekdayExceptions $x.Instance (Weekday $w) }}
+										<div class="exception" title="{{ExceptionTitle $es $.DateFormat $.TimeSeparator $.Seconds}}">{{ExceptionSummary $es}}</div>
+										{{- end }}
+										{{- else }}
+										{{- $es := WeekdayExceptions $x.Instance (Weekday $w) }}
+										{{- $collapsed := and $es (eq $.ExceptionDetail "collapsed") }}
+										{{- if $collapsed }}
+										<details class="exception" title="{{ExceptionTitle $es $.DateFormat $.TimeSeparator $.Seconds}}">
+										<summary>{{ExceptionSummary $es}}</summary>
+										{{- end }}
+										{{- range $e := $es }}
+										{{- $label := $e.Label $.DateFormat $.Seconds }}
+										<div class="exception" role="note" title="{{$label}}" aria-label="{{$label}}">
+											<time datetime="{{$e.Date}}">{{FormatShortDate $.DateFormat $e.Date}}</time>
+											{{- with $e.Until -}}
+											–<time datetime="{{.}}">{{FormatShortDate $.DateFormat .}}</time>
+											{{- end -}}
 											{{- if $e.OnlyOnWeekday -}}
 											{{- " only" -}}
 											{{- else if $e.LastOnWeekday -}}
@@ -564,55 +1403,192 @@ var tmpl = template.Must(template.New("").
 											{{- else if $e.Cancelled -}}
 											{{- " cancelled" -}}
 											{{- else if $e.Excluded -}}
-											{{- " excluded" -}}
+											{{- if $e.Cutoff }}{{" excluded?"}}{{else}}{{" excluded"}}{{end -}}
 											{{- else if $e.Time -}}
-											{{- " " -}}<time datetime="{{$e.Time.Start}}">{{FormatTime $e.Time.Start}}</time>-<time datetime="{{$e.Time.End}}">{{FormatTime $e.Time.End}}</time>
+											{{- " " -}}<time datetime="{{$e.Time.Start}}">{{FormatTime $.Seconds $e.Time.Start}}</time>{{$.TimeSeparator}}<time datetime="{{$e.Time.End}}">{{FormatTime $.Seconds $e.Time.End}}</time>
 											{{- else -}}
 											{{- " ?!?" -}}
 											{{- end -}}
 										</div>
 										{{- end }}
+										{{- if $collapsed }}
+										</details>
+										{{- end }}
 										{{- end }}
 									</td>
 									{{- else }}
-									<td class="instance empty"></td>
+									<td class="instance empty">{{if $.EmptyCells}}<span class="placeholder" aria-hidden="true">—</span>{{end}}</td>
 									{{- end }}
 									{{- end }}
 								</tr>
 								{{- end }}
+								{{- if $.Subtotals }}
+								{{- with $t := LocationSubtotal $.Schedule $c }}
+								<tr class="location subtotal">
+									<th scope="row" class="subtotal">Weekly</th>
+									<td class="subtotal" colspan="7">{{$t}}</td>
+								</tr>
+								{{- end }}
+								{{- end }}
 								{{- end }}
 								{{- end }}
 							</tbody>
 						</table>
 					</section>
+					{{- if and $.Legend (ne $.ExceptionDetail "none") }}
+					<section class="legend">
+						<dl class="nogrow">
+							{{- $d1 := FormatShortDate $.DateFormat (Date 2023 10 2) }}
+							{{- $d2 := FormatShortDate $.DateFormat (Date 2023 10 16) }}
+							<dt>{{$d1}} only</dt>
+							<dd>only on that date, not weekly</dd>
+							<dt>{{$d1}} last</dt>
+							<dd>weekly until that date</dd>
+							<dt>{{$d1}} cancelled</dt>
+							<dd>cancelled on that date</dd>
+							<dt>{{$d1}}–{{$d2}} cancelled</dt>
+							<dd>cancelled every week between those dates</dd>
+							<dt>{{$d1}} excluded</dt>
+							<dd>not scheduled on that date</dd>
+							{{- if HasCutoff $.Schedule }}
+							<dt>{{$d1}} excluded?</dt>
+							<dd>not in the schedule data, but possibly only because it was before the schedule was updated</dd>
+							{{- end }}
+							<dt>{{$d1}} <time>9:00</time>{{$.TimeSeparator}}<time>10:00</time></dt>
+							<dd>at a different time on that date</dd>
+						</dl>
+					</section>
+					{{- end }}
+					{{- end }}
+					{{- if and $.MoreActivities (not (or $.Day $.Combined)) }}
+					<section class="more-activities">
+						<p class="nogrow">{{if eq $.MoreActivities 1}}1 more activity{{else}}{{$.MoreActivities}} more activities{{end}} not shown.</p>
+					</section>
+					{{- end }}
+					{{- if not (or $.Day $.NoNotifications) }}
+					{{- $banner := and $.NotifyBanner $.Notifications }}
+					{{- if $banner }}
+					<details class="notifications">
+						<summary class="nogrow"><span class="count">{{if eq (len $.Notifications) 1}}1 notification{{else}}{{len $.Notifications}} notifications{{end}}</span><span class="latest">{{Truncate (index $.Notifications 0).Text 200}}</span></summary>
+					{{- end }}
 					{{- range $n := $.Notifications }}
-					<section class="notification">
-						<p class="text nogrow">{{$n.Text}}</p>
+					<section class="notification {{- if $banner}} nogrow{{end}}" id="notification-{{$n.ID}}">
+						<p class="text nogrow">{{- $text := Truncate $n.Text $.NotificationMax }}{{if $.Markdown}}{{MarkdownHTML $text}}{{else}}{{$text}}{{end}}</p>
+						{{- if ne $n.Sent.Date.Year 0 }}
 						<div class="date nogrow"><time datetime="{{$n.Sent.Date.String}}T{{$n.Sent.Time.String}}">{{$n.Sent.Date}} {{$n.Sent.Time}}</time></div>
+						{{- end }}
 					</section>
 					{{- end }}
-					{{- with $.UpcomingDays }}
-					<section class="upcoming">
+					{{- if $banner }}
+					</details>
+					{{- end }}
+					{{- end }}
+					{{- $days := false }}
+					{{- if $.Combined }}
+					{{- $days = $.Combined }}
+					{{- else if $.Day }}
+					{{- $days = Upcoming $.Options $.Schedule $.Day 1 0 }}
+					{{- else if $.UpcomingDays }}
+					{{- $days = Upcoming $.Options $.Schedule (Today $.Options $.Schedule) $.UpcomingDays $.UpcomingMax }}
+					{{- if $.UpcomingSkip }}
+					{{- $days = NonEmptyDays $days }}
+					{{- end }}
+					{{- end }}
+					{{- with $days }}
+					<section class="upcoming {{- if eq $.UpcomingLayout "stack" }} stack {{- end }}">
+						{{- if and $.UpcomingNav (gt (len .) 1) }}
+						<nav class="days nogrow">
+							{{- range $d := . }}
+							<a href="#day-{{$d.Date}}" title="{{FormatShortDate $.DateFormat $d.Date}}">{{printf "%.3s" $d.Date.Weekday}}</a>
+							{{- end }}
+						</nav>
+						{{- end }}
 						<div class="inner nogrow">
-							{{- range $d := Upcoming $.Schedule . }}
-							<section class="day">
+							{{- range $d := . }}
+							<section class="day" id="day-{{$d.Date}}">
 								<h2 class="date">
 									<time datetime="{{$d.Date}}">
 										<span class="weekday">{{printf "%.3s" $d.Date.Weekday}}</span>
-										<span class="date">{{printf "%.3s %d" $d.Date.Month $d.Date.Day}}</span>
+										<span class="date">{{FormatShortDate $.DateFormat $d.Date}}</span>
 									</time>
+									{{- with DayCount $.DayCounts $d }}
+									<span class="count">{{.}}</span>
+									{{- end }}
 								</h2>
 								<div class="events">
+									{{- if eq $.UpcomingGroupBy "activity" }}
+									{{- range $g := UpcomingGroups $d.Events }}
+									{{- $icon := ActivityIcon $.Options $g.Activity $g.Category }}
+									{{- $accent := Accent $.Options $.Schedule (index $g.Events 0) }}
+									<div class="event group {{- if $icon }} has-icon {{- end -}} {{- if ge $accent 0 }} accent{{$accent}} {{- end -}}">
+										{{- with $icon }}
+										<div class="icon">{{.}}</div>
+										{{- end }}
+										<div class="activity">{{$g.Activity}}</div>
+										{{- range $e := $g.Events }}
+										<div class="occurrence {{- if $e.Cancelled }} cancelled {{- end -}} {{- if and $live $e.Status }} {{$e.Status}} {{- end -}}" itemscope itemtype="https://schema.org/Event">
+											<meta itemprop="name" content="{{$g.Activity}}">
+											{{- if and $live $e.Status }}
+											<div class="live">{{$e.Status.Label}}</div>
+											{{- end }}
+											{{- with $e.Facility }}
+											<div class="facility">{{if $e.FacilityPath}}<a href="{{$e.FacilityPath}}">{{.}}</a>{{else}}{{.}}{{end}}</div>
+											{{- end }}
+											{{- if $e.Virtual }}
+											<div class="location virtual" itemprop="location" itemscope itemtype="https://schema.org/VirtualLocation"><span itemprop="name">{{$e.Location}}</span> <span class="virtual">Online</span></div>
+											<meta itemprop="eventAttendanceMode" content="https://schema.org/OnlineEventAttendanceMode">
+											{{- else }}
+											<div class="location" itemprop="location">{{$e.Location}}</div>
+											{{- end }}
+											<div class="time"><time itemprop="startDate" datetime="{{$d.Date}}T{{$e.Time.Start}}">{{FormatTime $.Seconds $e.Time.Start}}</time>{{if not (PointTime $.ZeroDuration $e.Time)}}{{$.TimeSeparator}}<time itemprop="endDate" datetime="{{$d.Date}}T{{$e.Time.End}}">{{FormatTime $.Seconds $e.Time.End}}</time>{{end}}{{with Duration $.Durations $e.Time}}<time class="duration" datetime="PT{{Minutes $e.Time}}M">{{.}}</time>{{end}}</div>
+											{{- if $e.Cancelled }}
+											<meta itemprop="eventStatus" content="https://schema.org/EventCancelled">
+											{{- end }}
+											{{- if and $.CalendarLinks (not $e.Cancelled) }}
+											<a class="calendar" href="{{GoogleCalendarURL $.Options $d.Date $e}}" target="_blank" rel="noopener">Add to calendar</a>
+											{{- end }}
+										</div>
+										{{- end }}
+									</div>
+									{{- end }}
+									{{- else }}
 									{{- range $e := .Events }}
-									<div class="event {{- if $e.Cancelled }} cancelled {{- end -}}" itemscope itemtype="https://schema.org/Event">
+									{{- $icon := ActivityIcon $.Options $e.Activity $e.Category }}
+									{{- $accent := Accent $.Options $.Schedule $e }}
+									<div class="event {{- if $e.Cancelled }} cancelled {{- end -}} {{- if and $live $e.Status }} {{$e.Status}} {{- end -}} {{- if $icon }} has-icon {{- end -}} {{- if ge $accent 0 }} accent{{$accent}} {{- end -}}" itemscope itemtype="https://schema.org/Event">
+										{{- with $icon }}
+										<div class="icon">{{.}}</div>
+										{{- end }}
+										{{- if and $live $e.Status }}
+										<div class="live">{{$e.Status.Label}}</div>
+										{{- end }}
 										<div class="activity" itemprop="name">{{$e.Activity}}</div>
+										{{- with $e.Facility }}
+										<div class="facility">{{if $e.FacilityPath}}<a href="{{$e.FacilityPath}}">{{.}}</a>{{else}}{{.}}{{end}}</div>
+										{{- end }}
+										{{- if $e.Virtual }}
+										<div class="location virtual" itemprop="location" itemscope itemtype="https://schema.org/VirtualLocation"><span itemprop="name">{{$e.Location}}</span> <span class="virtual">Online</span></div>
+										<meta itemprop="eventAttendanceMode" content="https://schema.org/OnlineEventAttendanceMode">
+										{{- else }}
 										<div class="location" itemprop="location">{{$e.Location}}</div>
-										<div class="time"><time itemprop="startDate" datetime="{{$d.Date}}T{{$e.Time.Start}}">{{$e.Time.Start.StringCompact}}</time> - <time itemprop="endDate" datetime="{{$d.Date}}T{{$e.Time.End}}">{{$e.Time.End.StringCompact}}</time></div>
+										{{- end }}
+										<div class="time"><time itemprop="startDate" datetime="{{$d.Date}}T{{$e.Time.Start}}">{{FormatTime $.Seconds $e.Time.Start}}</time>{{if not (PointTime $.ZeroDuration $e.Time)}}{{$.TimeSeparator}}<time itemprop="endDate" datetime="{{$d.Date}}T{{$e.Time.End}}">{{FormatTime $.Seconds $e.Time.End}}</time>{{end}}{{with Duration $.Durations $e.Time}}<time class="duration" datetime="PT{{Minutes $e.Time}}M">{{.}}</time>{{end}}</div>
 										{{- if $e.Cancelled }}
 										<meta itemprop="eventStatus" content="https://schema.org/EventCancelled">
 										{{- end }}<!-- TODO: show recurrence exception icon? -->
+										{{- if and $.CalendarLinks (not $e.Cancelled) }}
+										<a class="calendar" href="{{GoogleCalendarURL $.Options $d.Date $e}}" target="_blank" rel="noopener">Add to calendar</a>
+										{{- end }}
 									</div>
 									{{- end }}
+									{{- end }}
+									{{- if $d.More }}
+									{{- if $.Path }}
+									<a class="more" href="{{$.Path}}/{{$d.Date}}">+{{$d.More}} more</a>
+									{{- else }}
+									<div class="more">+{{$d.More}} more</div>
+									{{- end }}
+									{{- end }}
 								</div>
 							</section>
 							{{- end }}
@@ -620,14 +1596,35 @@ var tmpl = template.Must(template.New("").
 					</section>
 					{{- end }}
 					<footer class="info">
-						<p class="nogrow">Updated <time datetime="{{$.Updated.UTC.Format "2006-01-02T15:04:05Z"}}">{{$.Updated.Local.Format "2006-01-02 15:04:05 MST"}}</time>.</p>
-						<p class="nogrow">Modified <time datetime="{{$.Modified.UTC.Format "2006-01-02T15:04:05Z"}}">{{$.Modified.Local.Format "2006-01-02 15:04:05 MST"}}</time>.</p>
+						{{- if and $.QRCode $.Canonical }}
+						<div class="qr">{{QRCode $.Canonical}}</div>
+						{{- end }}
+						<p class="nogrow">Updated <time datetime="{{$.Updated.UTC.Format "2006-01-02T15:04:05Z"}}" {{- if $.RelativeTime }} data-relative {{- end }}>{{$.Updated.Local.Format $.DateTimeFormat}}</time>.</p>
+						<p class="nogrow">Modified <time datetime="{{$.Modified.UTC.Format "2006-01-02T15:04:05Z"}}" {{- if $.RelativeTime }} data-relative {{- end }}>{{$.Modified.Local.Format $.DateTimeFormat}}</time>.</p>
+						{{- with $.Source.Name }}
+						<p class="nogrow source">Data from {{if $.Source.URL}}<a href="{{$.Source.URL}}">{{.}}</a>{{else}}{{.}}{{end}}.</p>
+						{{- end }}
 						{{- range $.Footer }}
 						<p class="nogrow">{{.}}</p>
 						{{- end }}
 					</footer>
+					{{- with SubscribeLinks $.Subscribe $.Canonical $.Title }}
+					<section class="subscribe">
+						<p class="nogrow">Subscribe:
+							{{- range $i, $l := . }}
+							{{- if $i }} &middot;{{ end }} <a href="{{$l.URL}}" rel="nofollow">{{$l.Name}}</a>
+							{{- end }}
+						</p>
+					</section>
+					{{- end }}
 				</div>
 			</main>
+			{{- if $.RelativeTime }}
+			<script>{{RelativeTimeJS}}</script>
+			{{- end }}
+			{{- if or (eq $.ExceptionDetail "collapsed") (and $.NotifyBanner $.Notifications (not (or $.Day $.NoNotifications))) }}
+			<script>{{PrintJS}}</script>
+			{{- end }}
 		</body>
 		</html>
 	`)),
@@ -635,16 +1632,145 @@ var tmpl = template.Must(template.New("").
 
 // Render renders a schedule with the provided options.
 func Render(w io.Writer, o *Options, s *Schedule) error {
+	return render(w, o, s, nil, nil)
+}
+
+// RenderDay renders all events for a single day of a schedule with the
+// provided options.
+func RenderDay(w io.Writer, o *Options, s *Schedule, d fusiongo.Date) error {
+	if s != nil && (d.Less(s.Start) || s.End.Less(d)) {
+		return fmt.Errorf("date %s not in schedule", d)
+	}
+	return render(w, o, s, &d, nil)
+}
+
+// CombinedSource is a schedule included in a combined upcoming view.
+type CombinedSource struct {
+	Name     string // shown for each event
+	Path     string // optional link for Name
+	Schedule *Schedule
+}
+
+// RenderCombined renders the upcoming events from multiple schedules with the
+// provided options, starting from the latest updated date of the schedules
+// and continuing for o.UpcomingDays days (at least one).
+func RenderCombined(w io.Writer, o *Options, srcs []CombinedSource) error {
+	if o == nil {
+		return fmt.Errorf("no options provided")
+	}
+	var s Schedule
+	for _, src := range srcs {
+		if src.Schedule.Updated.After(s.Updated) {
+			s.Updated = src.Schedule.Updated
+		}
+		if src.Schedule.Modified.After(s.Modified) {
+			s.Modified = src.Schedule.Modified
+		}
+	}
+	n := max(o.UpcomingDays, 1)
+	s.Start = today(o, &s)
+	s.End = s.Start.AddDays(n - 1)
+
+	days := make([]upcomingDay, n)
+	for i := range days {
+		days[i].Date = s.Start.AddDays(i)
+	}
+	for _, src := range srcs {
+		for _, d := range upcoming(src.Schedule, s.Start, n, 0, liveTime(o)) {
+			for i := range days {
+				if days[i].Date == d.Date {
+					for _, e := range d.Events {
+						if o.ZeroDuration == ZeroDurationHide && e.Time.Start == e.Time.End {
+							continue
+						}
+						e.Facility, e.FacilityPath = src.Name, src.Path
+						days[i].Events = append(days[i].Events, e)
+					}
+				}
+			}
+		}
+	}
+	for i, day := range days {
+		slices.SortStableFunc(day.Events, func(a, b upcomingEvent) int {
+			return a.Time.Compare(b.Time)
+		})
+		days[i].limit(o.UpcomingMax)
+	}
+
+	if o.UpcomingSkip {
+		days = nonEmptyDays(days)
+	}
+
+	o1 := *o
+	o1.Path = "" // no day pages
+	return render(w, &o1, &s, nil, days)
+}
+
+// today gets the date to start upcoming days from.
+func today(o *Options, s *Schedule) fusiongo.Date {
+	if o.Timezone == nil {
+		return fusiongo.GoDateTime(s.Updated).Date
+	}
+	return fusiongo.GoDateTime(currentTime(o).In(o.Timezone)).Date
+}
+
+// liveTime gets the time to show the live status of events as of, in Timezone
+// if it is set, or nil if Live isn't set.
+func liveTime(o *Options) *fusiongo.DateTime {
+	if !o.Live {
+		return nil
+	}
+	t := currentTime(o)
+	if o.Timezone != nil {
+		t = t.In(o.Timezone)
+	}
+	d := fusiongo.GoDateTime(t)
+	return &d
+}
+
+// currentTime gets the current time.
+func currentTime(o *Options) time.Time {
+	if o.Now != nil {
+		return o.Now()
+	}
+	return time.Now()
+}
+
+func render(w io.Writer, o *Options, s *Schedule, d *fusiongo.Date, combined []upcomingDay) error {
 	if o == nil {
 		return fmt.Errorf("no options provided")
 	}
 	if s == nil {
 		return fmt.Errorf("no schedule provided")
 	}
+	if o.ZeroDuration == ZeroDurationHide {
+		s = withoutZeroDuration(s)
+	}
+	if combined == nil {
+		var err error
+		if s, err = LimitActivities(o, s); err != nil {
+			return err
+		}
+	}
+	if o.TimeSeparator == "" || o.DateFormat == "" || o.DateTimeFormat == "" {
+		o1 := *o
+		if o1.TimeSeparator == "" {
+			o1.TimeSeparator = " - "
+		}
+		if o1.DateFormat == "" {
+			o1.DateFormat = "Jan 2"
+		}
+		if o1.DateTimeFormat == "" {
+			o1.DateTimeFormat = "2006-01-02 15:04:05 MST"
+		}
+		o = &o1
+	}
 	return tmpl.Execute(w, struct {
 		*Options
 		*Schedule
-	}{o, s})
+		Day      *fusiongo.Date
+		Combined []upcomingDay
+	}{o, s, d, combined})
 }
 
 // Filter filters and transforms schedule activities.
@@ -671,8 +1797,33 @@ func (fs Filters) Filter(ai *fusiongo.ActivityInstance) bool {
 	return true
 }
 
+// AllFilter is a group of filters applied sequentially, keeping activities
+// which match all of them. It is equivalent to [Filters].
+type AllFilter []Filter
+
+func (fs AllFilter) Filter(ai *fusiongo.ActivityInstance) bool {
+	return Filters(fs).Filter(ai)
+}
+
+// AnyFilter is a group of filters, keeping activities which match any of them.
+// Each filter is applied to a copy of the activity, and only the transformations
+// of the first matching one are kept.
+type AnyFilter []Filter
+
+func (fs AnyFilter) Filter(ai *fusiongo.ActivityInstance) bool {
+	for _, f := range fs {
+		x := *ai
+		x.Category = slices.Clone(x.Category)
+		if f.Filter(&x) {
+			*ai = x
+			return true
+		}
+	}
+	return false
+}
+
 // FetchAndPrepare fetches data and calls Prepare.
-func FetchAndPrepare(ctx context.Context, schoolID int, filter Filter) (*Schedule, error) {
+func FetchAndPrepare(ctx context.Context, schoolID int, filter Filter, opt *PrepareOptions) (*Schedule, error) {
 
 	// fetch the app schedule
 	schedule, err := fusiongo.FetchSchedule(ctx, schoolID)
@@ -686,17 +1837,42 @@ func FetchAndPrepare(ctx context.Context, schoolID int, filter Filter) (*Schedul
 		return nil, fmt.Errorf("get fusion data: %w", err)
 	}
 
-	return Prepare(schedule, notifications, filter)
+	return Prepare(schedule, notifications, filter, opt)
 }
 
-// Prepare computes schedule data from the provided Innosoft Fusion Go data.
-func Prepare(schedule *fusiongo.Schedule, notifications *fusiongo.Notifications, filter Filter) (*Schedule, error) {
-	s, _, err := prepare(schedule, notifications, filter)
+// Prepare computes schedule data from the provided Innosoft Fusion Go data. If
+// opt is nil, the default options are used.
+func Prepare(schedule *fusiongo.Schedule, notifications *fusiongo.Notifications, filter Filter, opt *PrepareOptions) (*Schedule, error) {
+	s, _, err := prepare(schedule, notifications, filter, opt)
 	return s, err
 }
 
-func prepare(schedule *fusiongo.Schedule, notifications *fusiongo.Notifications, filter Filter) (*Schedule, *fusiongo.Schedule, error) {
+func prepare(schedule *fusiongo.Schedule, notifications *fusiongo.Notifications, filter Filter, opt *PrepareOptions) (*Schedule, *fusiongo.Schedule, error) {
 	var ss Schedule
+	if opt == nil {
+		opt = new(PrepareOptions)
+	}
+	logger := opt.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	mergePriority := opt.MergePriority
+	if mergePriority == nil {
+		mergePriority = defaultMergePriority
+	}
+	if opt.SnapTimes < 0 || opt.SnapTimes > time.Hour {
+		return nil, nil, fmt.Errorf("time snapping interval %s must be between zero and one hour", opt.SnapTimes)
+	}
+	for i, p := range mergePriority {
+		switch p {
+		case MergePenaltyExclusion, MergePenaltyException, MergePenaltyDuration:
+		default:
+			return nil, nil, fmt.Errorf("unknown merge penalty %q", p)
+		}
+		if slices.Contains(mergePriority[:i], p) {
+			return nil, nil, fmt.Errorf("duplicate merge penalty %q", p)
+		}
+	}
 
 	// set the times
 	ss.Updated = time.Now()
@@ -719,6 +1895,10 @@ func prepare(schedule *fusiongo.Schedule, notifications *fusiongo.Notifications,
 			ss.End = fa.Time.Date
 		}
 	}
+	if len(schedule.Activities) == 0 {
+		ss.Start = fusiongo.GoDateTime(ss.Updated).Date
+		ss.End = ss.Start.AddDays(6)
+	}
 
 	// copy the schedule so we can modify it
 	{
@@ -731,9 +1911,91 @@ func prepare(schedule *fusiongo.Schedule, notifications *fusiongo.Notifications,
 	}
 
 	// trim activity names
-	for fai, fa := range schedule.Activities {
-		fa.Activity = strings.TrimSpace(fa.Activity)
-		schedule.Activities[fai] = fa
+	{
+		untitled := opt.UntitledActivity
+		if untitled == "" {
+			untitled = "Untitled"
+		}
+		n := 0
+		for _, fa := range schedule.Activities {
+			if fa.Activity = strings.TrimSpace(fa.Activity); fa.Activity == "" {
+				if opt.EmptyActivities == EmptyActivityDrop {
+					continue
+				}
+				fa.Activity = untitled
+			}
+			schedule.Activities[n] = fa
+			n++
+		}
+		if d := len(schedule.Activities) - n; d != 0 {
+			logger.Debug("removed activity instances with empty names", "count", d)
+		}
+		schedule.Activities = schedule.Activities[:n]
+	}
+
+	// remove excluded dates
+	if len(opt.ExcludeDates) != 0 {
+		n := 0
+		for _, fa := range schedule.Activities {
+			if slices.Contains(opt.ExcludeDates, fa.Time.Date) {
+				continue
+			}
+			schedule.Activities[n] = fa
+			n++
+		}
+		if d := len(schedule.Activities) - n; d != 0 {
+			logger.Debug("removed activity instances on excluded dates", "count", d)
+		}
+		schedule.Activities = schedule.Activities[:n]
+	}
+
+	// snap times to the interval
+	if opt.SnapTimes > 0 {
+		for fai, fa := range schedule.Activities {
+			fa.Time.TimeRange.Start = snapTime(fa.Time.TimeRange.Start, opt.SnapTimes)
+			fa.Time.TimeRange.End = snapTime(fa.Time.TimeRange.End, opt.SnapTimes)
+			schedule.Activities[fai] = fa
+		}
+	}
+
+	// remove exact duplicate activity instances
+	{
+		type instanceKey struct {
+			Time        fusiongo.DateTimeRange
+			Activity    string
+			ActivityID  string
+			Location    string
+			Description string
+			IsCancelled bool
+			Category    string
+		}
+		seen := map[instanceKey]struct{}{}
+		n := 0
+		for _, fa := range schedule.Activities {
+			var category strings.Builder
+			for _, c := range fa.Category {
+				category.WriteString(c.ID + "\x00" + c.Name + "\x00")
+			}
+			k := instanceKey{
+				Time:        fa.Time,
+				Activity:    fa.Activity,
+				ActivityID:  fa.ActivityID,
+				Location:    fa.Location,
+				Description: fa.Description,
+				IsCancelled: fa.IsCancelled,
+				Category:    category.String(),
+			}
+			if _, ok := seen[k]; ok {
+				continue
+			}
+			seen[k] = struct{}{}
+			schedule.Activities[n] = fa
+			n++
+		}
+		if d := len(schedule.Activities) - n; d != 0 {
+			logger.Debug("removed duplicate activity instances", "count", d)
+		}
+		schedule.Activities = schedule.Activities[:n]
 	}
 
 	// convert fake cancellations to real ones
@@ -756,7 +2018,7 @@ func prepare(schedule *fusiongo.Schedule, notifications *fusiongo.Notifications,
 		if !fa.IsCancelled {
 			continue
 		}
-		slog.Debug("convert fake cancellation", slog.Group("activity", "time", fa.Time, "activity", fa.Activity, "location", fa.Location))
+		logger.Debug("convert fake cancellation", slog.Group("activity", "time", fa.Time, "activity", fa.Activity, "location", fa.Location))
 
 		// fix up the activity ID and location from a matching activity if possible
 		var possibleMatches []fusiongo.ActivityInstance
@@ -779,19 +2041,19 @@ func prepare(schedule *fusiongo.Schedule, notifications *fusiongo.Notifications,
 			if x := mostCommonBy(possibleMatches, func(fa1 fusiongo.ActivityInstance) string {
 				return fa1.ActivityID
 			}); fa.ActivityID != x {
-				slog.Debug("... update cancellation activityID", slog.Group("activity", slog.Group("id", "new", x, "old", fa.ActivityID)))
+				logger.Debug("... update cancellation activityID", slog.Group("activity", slog.Group("id", "new", x, "old", fa.ActivityID)))
 				fa.ActivityID = x
 			}
 			if x := mostCommonBy(possibleMatches, func(fa1 fusiongo.ActivityInstance) string {
 				return fa1.Description
 			}); fa.Description != x {
-				slog.Debug("... update cancellation description", slog.Group("activity", slog.Group("description", "new", x, "old", fa.Description)))
+				logger.Debug("... update cancellation description", slog.Group("activity", slog.Group("description", "new", x, "old", fa.Description)))
 				fa.Description = x
 			}
 			if x := mostCommonBy(possibleMatches, func(fa1 fusiongo.ActivityInstance) string {
 				return fa1.Location
 			}); fa.Location != x {
-				slog.Debug("... update cancellation location", slog.Group("activity", slog.Group("location", "new", x, "old", fa.Location)))
+				logger.Debug("... update cancellation location", slog.Group("activity", slog.Group("location", "new", x, "old", fa.Location)))
 				fa.Location = x
 			}
 		}
@@ -809,7 +2071,18 @@ func prepare(schedule *fusiongo.Schedule, notifications *fusiongo.Notifications,
 				n++
 			}
 		}
-		schedule.Activities = schedule.Activities[:n]
+		schedule.Activities = schedule.Activities[:n]
+	}
+
+	// rename categories
+	if len(opt.CategoryAliases) != 0 {
+		for _, fa := range schedule.Activities {
+			for i, c := range fa.Category {
+				if x, ok := opt.CategoryAliases[c.Name]; ok {
+					fa.Category[i].Name = x
+				}
+			}
+		}
 	}
 
 	// create recurrence groups for each activity/location/weekday by finding the time range for the base case
@@ -985,27 +2258,32 @@ func prepare(schedule *fusiongo.Schedule, notifications *fusiongo.Notifications,
 					break
 				}
 				if epoch == 0 {
-					slog.Debug("merging", "partition", fmt.Sprintf("%s - %s [%.2s]", pk.Activity, pk.Location, pk.Weekday))
+					logger.Debug("merging", "partition", fmt.Sprintf("%s - %s [%.2s]", pk.Activity, pk.Location, pk.Weekday))
 				}
 
 				// rank the candidates
 				slices.SortStableFunc(cs, func(c1, c2 Candidate) int {
-					if c1.Penalty.Exclusion != c2.Penalty.Exclusion {
-						return cmp.Compare(c1.Penalty.Exclusion, c2.Penalty.Exclusion)
-					}
-					if c1.Penalty.Exception != c2.Penalty.Exception {
-						return cmp.Compare(c1.Penalty.Exception, c2.Penalty.Exception)
-					}
-					if c1.Penalty.Duration != c2.Penalty.Duration {
-						return cmp.Compare(c1.Penalty.Duration, c2.Penalty.Duration)
+					for _, p := range mergePriority {
+						var r int
+						switch p {
+						case MergePenaltyExclusion:
+							r = cmp.Compare(c1.Penalty.Exclusion, c2.Penalty.Exclusion)
+						case MergePenaltyException:
+							r = cmp.Compare(c1.Penalty.Exception, c2.Penalty.Exception)
+						case MergePenaltyDuration:
+							r = cmp.Compare(c1.Penalty.Duration, c2.Penalty.Duration)
+						}
+						if r != 0 {
+							return r
+						}
 					}
 					return c1.Into.Compare(c2.Into) // otherwise, prefer ones with an earlier time range
 				})
 
 				// debug
-				if slog.Default().Enabled(context.Background(), slog.LevelDebug) {
+				if logger.Enabled(context.Background(), slog.LevelDebug) {
 					for i, c := range cs {
-						slog.Debug("merge candidate",
+						logger.Debug("merge candidate",
 							"partition", fmt.Sprintf("%s - %s [%.2s]", pk.Activity, pk.Location, pk.Weekday),
 							"epoch", epoch,
 							"candidate", fmt.Sprintf("[%d %d %s] %s <- %s", c.Penalty.Exception, c.Penalty.Exclusion, c.Penalty.Duration, c.Into, c.From),
@@ -1037,7 +2315,7 @@ func prepare(schedule *fusiongo.Schedule, notifications *fusiongo.Notifications,
 				}
 				for _, fai := range ga {
 					if fa := schedule.Activities[fai]; fa.Time.TimeRange != timeRange {
-						slog.Debug("move into", "base", timeRange, slog.Group("activity", "time", fa.Time, "activity", fa.Activity, "location", fa.Location))
+						logger.Debug("move into", "base", timeRange, slog.Group("activity", "time", fa.Time, "activity", fa.Activity, "location", fa.Location))
 					}
 					baseActivityTimeRange[fai] = timeRange
 				}
@@ -1077,12 +2355,24 @@ func prepare(schedule *fusiongo.Schedule, notifications *fusiongo.Notifications,
 					continue gkNext
 				}
 
-				slog.Debug("splitting", "partition", fmt.Sprintf("%s - %s [%.2s]", pk.Activity, pk.Location, pk.Weekday))
+				logger.Debug("splitting", "partition", fmt.Sprintf("%s - %s [%.2s]", pk.Activity, pk.Location, pk.Weekday))
 				for _, fai := range pgs[pk][gk] {
 					baseActivityTimeRange[fai] = schedule.Activities[fai].Time.TimeRange
 				}
 			}
 		}
+
+		// split outliers which start or end too far from the rest of their group into their own instances
+		// note: groups can contain these since candidates only need to overlap, and merges can chain
+		if opt.SplitThreshold > 0 {
+			for fai, fa := range schedule.Activities {
+				base := baseActivityTimeRange[fai]
+				if timeDistance(fa.Time.TimeRange.Start, base.Start) > opt.SplitThreshold || timeDistance(fa.Time.TimeRange.End, base.End) > opt.SplitThreshold {
+					logger.Debug("split outlier", "base", base, slog.Group("activity", "time", fa.Time, "activity", fa.Activity, "location", fa.Location))
+					baseActivityTimeRange[fai] = fa.Time.TimeRange
+				}
+			}
+		}
 	}
 
 	// build the schedule
@@ -1093,10 +2383,31 @@ func prepare(schedule *fusiongo.Schedule, notifications *fusiongo.Notifications,
 		ss.Activities = append(ss.Activities, Activity{Name: activity})
 		ssActivity := last(ss.Activities)
 
+		var categories []string
+		for _, fa := range schedule.Activities {
+			if fa.Activity == activity {
+				categories = append(categories, fa.CategoryNames()...)
+			}
+		}
+		ssActivity.Category = mostCommon(categories)
+
+		if opt.CategoryIDs {
+			var categoryIDs []string
+			for _, fa := range schedule.Activities {
+				if fa.Activity == activity {
+					categoryIDs = append(categoryIDs, fa.CategoryIDs()...)
+				}
+			}
+			ssActivity.CategoryID = mostCommon(categoryIDs)
+		}
+
 		for _, location := range mapFilterSortUniq(schedule.Activities, func(fai int, fa fusiongo.ActivityInstance) (string, bool) {
 			return fa.Location, fa.Activity == activity
 		}) {
-			ssActivity.Locations = append(ssActivity.Locations, Location{Name: location})
+			ssActivity.Locations = append(ssActivity.Locations, Location{
+				Name:    location,
+				Virtual: slices.Contains(opt.VirtualLocations, location),
+			})
 			ssLocation := last(ssActivity.Locations)
 
 			for _, baseTimeRange := range mapFilterSortUniqFunc(schedule.Activities, func(fai int, fa fusiongo.ActivityInstance) (fusiongo.TimeRange, bool) {
@@ -1129,6 +2440,9 @@ func prepare(schedule *fusiongo.Schedule, notifications *fusiongo.Notifications,
 
 				for d := ss.Start; !ss.End.Less(d); d = d.AddDays(1) {
 					if ssInstance.Days[d.Weekday()] {
+						if slices.Contains(opt.ExcludeDates, d) {
+							continue // we don't know whether it occurs
+						}
 						var exists bool
 						for fai, fa := range schedule.Activities {
 							if fa.Time.Date == d && fa.Activity == activity && fa.Location == location && baseActivityTimeRange[fai] == baseTimeRange {
@@ -1157,14 +2471,15 @@ func prepare(schedule *fusiongo.Schedule, notifications *fusiongo.Notifications,
 							}
 						} else {
 							if !exists {
-								if d == ss.Start && ss.Start.Less(fusiongo.GoDateTime(schedule.Updated).Date) {
+								if cutoff := d == ss.Start && ss.Start.Less(fusiongo.GoDateTime(schedule.Updated).Date); cutoff && opt.FirstDayExclusion == FirstDayExclusionIgnore {
 									// probably just cut off since it's on the first covered day, and is before the schedule update date
-									slog.Debug("ignore exclusion on date == first schedule day != update day", slog.Group("schedule", "start", ss.Start, "updated", ss.Updated), slog.Group("activity", "time", baseTimeRange.WithDate(d), "activity", activity, "location", location))
+									logger.Debug("ignore exclusion on date == first schedule day != update day", slog.Group("schedule", "start", ss.Start, "updated", ss.Updated), slog.Group("activity", "time", baseTimeRange.WithDate(d), "activity", activity, "location", location))
 								} else {
 									if last[d.Weekday()] == (fusiongo.Date{}) || !last[d.Weekday()].Less(d) {
 										ssInstance.Exceptions = append(ssInstance.Exceptions, Exception{
 											Date:     d,
 											Excluded: true,
+											Cutoff:   cutoff && opt.FirstDayExclusion == FirstDayExclusionShow,
 										})
 									}
 								}
@@ -1183,11 +2498,39 @@ func prepare(schedule *fusiongo.Schedule, notifications *fusiongo.Notifications,
 		}
 	}
 
+	// remove cancelled events
+	if opt.HideCancelled {
+		hideCancelled(&ss)
+	}
+
+	// sort the schedule
+	switch opt.ActivitySort {
+	case ActivitySortName:
+		// already sorted
+	case ActivitySortTime:
+		for _, a := range ss.Activities {
+			slices.SortStableFunc(a.Locations, func(a, b Location) int {
+				return a.earliest().Compare(b.earliest())
+			})
+		}
+		slices.SortStableFunc(ss.Activities, func(a, b Activity) int {
+			return a.earliest().Compare(b.earliest())
+		})
+	default:
+		return nil, nil, fmt.Errorf("unknown activity sort %q", opt.ActivitySort)
+	}
+
 	// add the notifications
 	if notifications != nil {
 		ss.Notifications = make([]Notification, len(notifications.Notifications))
 		for i, n := range notifications.Notifications {
+			if !validDateTime(n.Sent) {
+				// treat it as the oldest so it consistently sorts last
+				logger.Debug("notification has invalid sent date", "sent", n.Sent, "text", n.Text)
+				n.Sent = fusiongo.DateTime{}
+			}
 			ss.Notifications[i] = Notification{
+				ID:   notificationID(n.Sent, n.Text),
 				Text: n.Text,
 				Sent: n.Sent,
 			}
@@ -1196,12 +2539,720 @@ func prepare(schedule *fusiongo.Schedule, notifications *fusiongo.Notifications,
 			return a.Sent.Compare(b.Sent)
 		})
 		slices.Reverse(ss.Notifications)
+		if opt.DedupeNotifications {
+			seen := map[string]bool{}
+			ss.Notifications = slices.DeleteFunc(ss.Notifications, func(n Notification) bool {
+				k := strings.TrimSpace(n.Text)
+				if seen[k] {
+					return true
+				}
+				seen[k] = true
+				return false
+			})
+		}
 	}
 
 	// done
 	return &ss, schedule, nil
 }
 
+// LimitActivities returns a copy of s with only o.MaxActivities activities,
+// setting MoreActivities to the number removed. If s is within the limit, it is
+// returned as-is. It is applied when rendering rather than preparing so which
+// activities are shown doesn't affect the prepared schedule (e.g., for change
+// detection).
+func LimitActivities(o *Options, s *Schedule) (*Schedule, error) {
+	n := o.MaxActivities
+	if n <= 0 || len(s.Activities) <= n {
+		return s, nil
+	}
+	keep, err := limitActivities(s, n, o.MaxActivitiesBy)
+	if err != nil {
+		return nil, err
+	}
+	s1 := *s
+	s1.MoreActivities = len(s.Activities) - n
+	s1.Activities = slices.DeleteFunc(slices.Clone(s.Activities), func(a Activity) bool {
+		return !keep[a.Name]
+	})
+	return &s1, nil
+}
+
+// limitActivities chooses n activity names to keep from s.
+func limitActivities(s *Schedule, n int, by ActivityLimit) (map[string]bool, error) {
+	names := make([]string, len(s.Activities))
+	for i, a := range s.Activities {
+		names[i] = a.Name
+	}
+	slices.Sort(names)
+	switch by {
+	case ActivityLimitName:
+	case ActivityLimitCount:
+		count := map[string]int{}
+		for _, a := range s.Activities {
+			for _, l := range a.Locations {
+				for _, i := range l.Instances {
+					Expand(s, i, func(_ fusiongo.DateTimeRange, cancelled, _ bool) {
+						if !cancelled {
+							count[a.Name]++
+						}
+					})
+				}
+			}
+		}
+		slices.SortStableFunc(names, func(a, b string) int {
+			return count[b] - count[a]
+		})
+	default:
+		return nil, fmt.Errorf("unknown activity limit %q", by)
+	}
+	keep := make(map[string]bool, n)
+	for _, name := range names[:n] {
+		keep[name] = true
+	}
+	return keep, nil
+}
+
+// timeDistance returns the absolute difference between a and b.
+func timeDistance(a, b fusiongo.Time) time.Duration {
+	d := time.Duration(a.Hour-b.Hour)*time.Hour + time.Duration(a.Minute-b.Minute)*time.Minute + time.Duration(a.Second-b.Second)*time.Second
+	if d < 0 {
+		d = -d
+	}
+	return d
+}
+
+// snapTime rounds t to the nearest multiple of d since midnight, rounding down
+// instead if it would be rounded to midnight of the next day.
+func snapTime(t fusiongo.Time, d time.Duration) fusiongo.Time {
+	v := time.Duration(t.Hour)*time.Hour + time.Duration(t.Minute)*time.Minute + time.Duration(t.Second)*time.Second
+	if v = v.Round(d); v >= 24*time.Hour {
+		v -= d
+	}
+	return fusiongo.Time{
+		Hour:   int(v / time.Hour),
+		Minute: int(v % time.Hour / time.Minute),
+		Second: int(v % time.Minute / time.Second),
+	}
+}
+
+// hideCancelled converts cancellations into exclusions, then removes weekdays
+// (along with their exceptions) on which instances no longer occur, and any
+// resulting empty instances, locations, and activities.
+func hideCancelled(s *Schedule) {
+	for ai := range s.Activities {
+		a := &s.Activities[ai]
+		for li := range a.Locations {
+			l := &a.Locations[li]
+			for xi := range l.Instances {
+				x := &l.Instances[xi]
+				for ei := range x.Exceptions {
+					if e := &x.Exceptions[ei]; e.Cancelled {
+						e.Cancelled, e.Excluded = false, true
+					}
+				}
+				var n [7]int
+				Expand(s, *x, func(t fusiongo.DateTimeRange, _, _ bool) {
+					n[t.Date.Weekday()]++
+				})
+				for wd := range x.Days {
+					if n[wd] == 0 {
+						x.Days[wd] = false
+					}
+				}
+				x.Exceptions = slices.DeleteFunc(x.Exceptions, func(e Exception) bool {
+					return !x.Days[e.Date.Weekday()]
+				})
+			}
+			l.Instances = slices.DeleteFunc(l.Instances, func(x Instance) bool {
+				return x.Days == [7]bool{}
+			})
+		}
+		a.Locations = slices.DeleteFunc(a.Locations, func(l Location) bool {
+			return len(l.Instances) == 0
+		})
+	}
+	s.Activities = slices.DeleteFunc(s.Activities, func(a Activity) bool {
+		return len(a.Locations) == 0
+	})
+}
+
+// notificationID generates a stable ID for a notification.
+func notificationID(sent fusiongo.DateTime, text string) string {
+	h := sha1.Sum([]byte(text))
+	return fmt.Sprintf("%04d%02d%02dT%02d%02d%02d-%x", sent.Year, sent.Month, sent.Day, sent.Hour, sent.Minute, sent.Second, h[:6])
+}
+
+// earliest returns the earliest start time of the activity's instances.
+func (a Activity) earliest() (t fusiongo.Time) {
+	for i, l := range a.Locations {
+		if x := l.earliest(); i == 0 || x.Less(t) {
+			t = x
+		}
+	}
+	return
+}
+
+// earliest returns the earliest start time of the location's instances.
+func (l Location) earliest() (t fusiongo.Time) {
+	for i, x := range l.Instances {
+		if i == 0 || x.Time.Start.Less(t) {
+			t = x.Time.Start
+		}
+	}
+	return
+}
+
+// subscribeLink is a link to subscribe to an iCalendar feed.
+type subscribeLink struct {
+	Name string
+	URL  template.URL
+}
+
+// subscribeLinks gets links to subscribe to the iCalendar feed at ics in
+// various calendar apps. If ics is relative, it is resolved against canonical.
+// If it cannot be resolved to an absolute http(s) URL, only a direct link is
+// returned.
+func subscribeLinks(ics, canonical, title string) []subscribeLink {
+	if ics == "" {
+		return nil
+	}
+	u, err := url.Parse(ics)
+	if err != nil {
+		return nil
+	}
+	if base, err := url.Parse(canonical); err == nil && canonical != "" {
+		u = base.ResolveReference(u)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return []subscribeLink{{"iCalendar", template.URL(u.String())}}
+	}
+	webcal := *u
+	webcal.Scheme = "webcal"
+	if title == "" {
+		title = "Schedule"
+	}
+	return []subscribeLink{
+		{"Google Calendar", template.URL("https://calendar.google.com/calendar/render?cid=" + url.QueryEscape(webcal.String()))},
+		{"Apple Calendar", template.URL(webcal.String())},
+		{"Outlook", template.URL("https://outlook.live.com/calendar/0/addfromweb?url=" + url.QueryEscape(u.String()) + "&name=" + url.QueryEscape(title))},
+		{"iCalendar", template.URL(u.String())},
+	}
+}
+
+// resolveURL resolves ref against base if both are valid, returning ref as-is
+// otherwise.
+func resolveURL(base, ref string) string {
+	b, err := url.Parse(base)
+	if err != nil || base == "" {
+		return ref
+	}
+	u, err := url.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	return b.ResolveReference(u).String()
+}
+
+// googleCalendarURL returns a Google Calendar template URL to add an upcoming
+// event on d. If the timezone is set, the times are in it, otherwise they are
+// in the user's timezone.
+func googleCalendarURL(o *Options, d fusiongo.Date, e upcomingEvent) string {
+	var (
+		start = d.WithTime(e.Time.Start)
+		end   = d.WithTime(e.Time.End)
+	)
+	if e.Time.End.Less(e.Time.Start) {
+		end = end.AddDays(1)
+	}
+	const layout = "20060102T150405"
+	q := url.Values{}
+	q.Set("action", "TEMPLATE")
+	q.Set("text", e.Activity)
+	q.Set("dates", start.In(time.UTC).Format(layout)+"/"+end.In(time.UTC).Format(layout))
+	if o.Timezone != nil && o.Timezone != time.Local {
+		q.Set("ctz", o.Timezone.String())
+	}
+	if e.Facility != "" {
+		q.Set("location", e.Location+", "+e.Facility)
+	} else {
+		q.Set("location", e.Location)
+	}
+	if o.Canonical != "" {
+		q.Set("details", o.Canonical)
+	}
+	return "https://calendar.google.com/calendar/render?" + q.Encode()
+}
+
+// truncate truncates s to at most n characters (or does nothing if n is zero),
+// replacing the last one with an ellipsis if anything was removed.
+func truncate(s string, n int) string {
+	if n <= 0 || utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	r := []rune(s)[:n-1]
+	return strings.TrimRightFunc(string(r), unicode.IsSpace) + "…"
+}
+
+// exceptionRun is an exception for display purposes.
+type exceptionRun struct {
+	Exception
+	Until *fusiongo.Date // if set, the exception is a cancellation repeated weekly until this date
+}
+
+// String formats the exception like it is displayed in the grid.
+func (e exceptionRun) String() string {
+	return e.Format("", " - ", true)
+}
+
+// Format formats the exception like it is displayed in the grid, using the
+// provided layout for dates and separator for time ranges.
+func (e exceptionRun) Format(layout, sep string, seconds bool) string {
+	s := formatShortDate(layout, e.Date)
+	if e.Until != nil {
+		s += "–" + formatShortDate(layout, *e.Until)
+	}
+	switch {
+	case e.OnlyOnWeekday:
+		return s + " only"
+	case e.LastOnWeekday:
+		return s + " last"
+	case e.Cancelled:
+		return s + " cancelled"
+	case e.Excluded:
+		if e.Cutoff {
+			return s + " excluded?"
+		}
+		return s + " excluded"
+	case e.Time != (fusiongo.TimeRange{}):
+		return s + " " + formatTime(seconds, e.Time.Start) + sep + formatTime(seconds, e.Time.End)
+	}
+	return s + " ?!?"
+}
+
+// Label describes the exception in full, like the legend, using the provided
+// layout for dates.
+func (e exceptionRun) Label(layout string, seconds bool) string {
+	d := formatShortDate(layout, e.Date)
+	switch {
+	case e.OnlyOnWeekday:
+		return "Only on " + d + ", not weekly"
+	case e.LastOnWeekday:
+		return "Weekly until " + d + " (last occurrence)"
+	case e.Cancelled:
+		if e.Until != nil {
+			return "Cancelled every week from " + d + " to " + formatShortDate(layout, *e.Until)
+		}
+		return "Cancelled on " + d
+	case e.Excluded:
+		if e.Cutoff {
+			return "Possibly not scheduled on " + d + " (may only be missing because it was before the schedule was updated)"
+		}
+		return "Not scheduled on " + d
+	case e.Time != (fusiongo.TimeRange{}):
+		return "At " + formatTime(seconds, e.Time.Start) + " to " + formatTime(seconds, e.Time.End) + " on " + d
+	}
+	return e.Format(layout, " - ", seconds)
+}
+
+// validDateTime checks if d is a real date and time.
+func validDateTime(d fusiongo.DateTime) bool {
+	return d.Date.Year > 0 && fusiongo.GoDateTime(d.In(time.UTC)) == d
+}
+
+// hasCutoff checks if any exceptions in s have Cutoff set.
+func hasCutoff(s *Schedule) bool {
+	for _, a := range s.Activities {
+		for _, l := range a.Locations {
+			for _, i := range l.Instances {
+				for _, e := range i.Exceptions {
+					if e.Cutoff {
+						return true
+					}
+				}
+			}
+		}
+	}
+	return false
+}
+
+// formatShortDate formats a date using the provided layout, or like "Jan 2"
+// if it is empty.
+func formatShortDate(layout string, d fusiongo.Date) string {
+	if layout == "" {
+		layout = "Jan 2"
+	}
+	return time.Date(d.Year, d.Month, d.Day, 0, 0, 0, 0, time.UTC).Format(layout)
+}
+
+// minCancellationRun is the minimum number of consecutive weekly cancellations
+// to display as a single exception.
+const minCancellationRun = 3
+
+// weekdayExceptions gets the exceptions for the specified weekday of i,
+// collapsing runs of weekly cancellations.
+func weekdayExceptions(i *Instance, w time.Weekday) []exceptionRun {
+	var es []exceptionRun
+	for n := 0; n < len(i.Exceptions); n++ {
+		e := i.Exceptions[n]
+		if e.Date.Weekday() != w {
+			continue
+		}
+		if e.Cancelled {
+			var (
+				end = e.Date
+				cnt = 1
+				nxt = n
+			)
+			for j := n + 1; j < len(i.Exceptions); j++ {
+				if x := i.Exceptions[j]; x.Date.Weekday() == w {
+					if !x.Cancelled || x.Date != end.AddDays(7) {
+						break
+					}
+					end, cnt, nxt = x.Date, cnt+1, j
+				}
+			}
+			if cnt >= minCancellationRun {
+				es = append(es, exceptionRun{Exception: e, Until: &end})
+				n = nxt
+				continue
+			}
+		}
+		es = append(es, exceptionRun{Exception: e})
+	}
+	return es
+}
+
+// upcomingDay contains the events for a day.
+type upcomingDay struct {
+	Date      fusiongo.Date
+	Events    []upcomingEvent
+	More      int // number of events not shown
+	Total     int // number of events, including ones not shown
+	Cancelled int // number of cancelled events, including ones not shown
+}
+
+// limit counts the events, then only keeps the first max if it is positive.
+func (d *upcomingDay) limit(max int) {
+	d.Total, d.Cancelled = len(d.Events), 0
+	for _, e := range d.Events {
+		if e.Cancelled {
+			d.Cancelled++
+		}
+	}
+	if max > 0 && len(d.Events) > max {
+		d.Events, d.More = d.Events[:max], len(d.Events)-max
+	}
+}
+
+// dayCount formats the number of events in d for c, returning an empty string
+// if counts aren't shown.
+func dayCount(c DayCounts, d upcomingDay) string {
+	var n int
+	switch c {
+	case DayCountsAll:
+		n = d.Total
+	case DayCountsScheduled:
+		n = d.Total - d.Cancelled
+	default:
+		return ""
+	}
+	if n == 1 {
+		return "1 event"
+	}
+	return strconv.Itoa(n) + " events"
+}
+
+// upcomingGroup is the events for an activity in an upcoming day.
+type upcomingGroup struct {
+	Activity string
+	Category string
+	Events   []upcomingEvent
+}
+
+// upcomingGroups groups events by activity, keeping the order of the first
+// event for each activity.
+func upcomingGroups(events []upcomingEvent) []upcomingGroup {
+	var (
+		gs  []upcomingGroup
+		idx = map[string]int{}
+	)
+	for _, e := range events {
+		i, ok := idx[e.Activity]
+		if !ok {
+			i = len(gs)
+			idx[e.Activity] = i
+			gs = append(gs, upcomingGroup{Activity: e.Activity, Category: e.Category})
+		}
+		gs[i].Events = append(gs[i].Events, e)
+	}
+	return gs
+}
+
+// nonEmptyDays removes days without any events.
+func nonEmptyDays(days []upcomingDay) []upcomingDay {
+	return slices.DeleteFunc(days, func(d upcomingDay) bool {
+		return len(d.Events) == 0
+	})
+}
+
+// upcomingEvent is a single event.
+type upcomingEvent struct {
+	Activity     string
+	Category     string
+	Facility     string // only set for combined schedules
+	FacilityPath string
+	Time         fusiongo.TimeRange
+	Location     string
+	Virtual      bool
+	Cancelled    bool
+	Exception    bool
+	Status       LiveStatus
+}
+
+// upcoming gets the events for up to n days of a, starting from start, with
+// at most max events per day if max is positive. If now is not nil, the live
+// status is set for the events on its date.
+func upcoming(a *Schedule, start fusiongo.Date, n, max int, now *fusiongo.DateTime) []upcomingDay {
+	var days []upcomingDay
+	for d := start; len(days) < n && !a.End.Less(d); d = d.AddDays(1) {
+		days = append(days, upcomingDay{
+			Date: d,
+		})
+	}
+	var live map[*Instance]LiveStatus
+	if now != nil {
+		live = liveStatus(a, *now)
+	}
+	for _, activity := range a.Activities {
+		for _, location := range activity.Locations {
+			for xi, instance := range location.Instances {
+				Expand(a, instance, func(t fusiongo.DateTimeRange, cancelled, exception bool) {
+					for i := range days {
+						if days[i].Date == t.Date {
+							var status LiveStatus
+							if now != nil && t.Date == now.Date && !cancelled {
+								status = live[&location.Instances[xi]]
+							}
+							days[i].Events = append(days[i].Events, upcomingEvent{
+								Activity:  activity.Name,
+								Category:  activity.Category,
+								Location:  location.Name,
+								Virtual:   location.Virtual,
+								Time:      t.TimeRange,
+								Cancelled: cancelled,
+								Exception: exception,
+								Status:    status,
+							})
+							break
+						}
+					}
+				})
+			}
+		}
+	}
+	for i, day := range days {
+		slices.SortStableFunc(day.Events, func(a, b upcomingEvent) int {
+			return a.Time.Compare(b.Time)
+		})
+		days[i].limit(max)
+	}
+	return days
+}
+
+// locationGroup is a group of rows in the schedule grid.
+type locationGroup struct {
+	Location
+	Other bool              // the locations with a single instance on a single weekday, grouped together
+	Rows  [][7]*instanceRow // by weekday
+}
+
+// subtotal is the number and total duration of events over a number of days.
+type subtotal struct {
+	Events  int
+	Minutes int
+	Days    int
+}
+
+// String formats the average weekly number and duration of the events.
+func (t subtotal) String() string {
+	weeks := float64(max(t.Days, 1)) / 7
+	var b strings.Builder
+	if events := math.Round(float64(t.Events)/weeks*10) / 10; events == 1 {
+		b.WriteString("1 event, ")
+	} else {
+		b.WriteString(strconv.FormatFloat(events, 'f', -1, 64) + " events, ")
+	}
+	avg := int(math.Round(float64(t.Minutes) / weeks))
+	if h, m := avg/60, avg%60; h == 0 {
+		b.WriteString(strconv.Itoa(m) + " min")
+	} else if m == 0 {
+		b.WriteString(strconv.Itoa(h) + " h")
+	} else {
+		b.WriteString(strconv.Itoa(h) + " h " + strconv.Itoa(m) + " min")
+	}
+	return b.String()
+}
+
+// locationSubtotal gets the subtotal of the non-cancelled events in the
+// schedule range for the instances in g, or nil if there aren't any.
+func locationSubtotal(s *Schedule, g locationGroup) *subtotal {
+	var (
+		t    = subtotal{Days: 1}
+		seen = map[*Instance]bool{}
+	)
+	for _, row := range g.Rows {
+		for _, x := range row {
+			if x == nil || seen[x.Instance] {
+				continue
+			}
+			seen[x.Instance] = true
+			Expand(s, *x.Instance, func(dt fusiongo.DateTimeRange, cancelled, _ bool) {
+				if !cancelled {
+					t.Events++
+					t.Minutes += minutes(dt.TimeRange)
+				}
+			})
+		}
+	}
+	if t.Events == 0 {
+		return nil
+	}
+	for d := s.Start; d.Less(s.End); d = d.AddDays(1) {
+		t.Days++
+	}
+	return &t
+}
+
+type instanceRow struct {
+	*Instance
+	Location *Location
+}
+
+// locationGroups gets the grid rows for each of the activity's locations. If
+// compact is true and there are multiple locations with a single instance on
+// a single weekday, they are grouped together at the end.
+func locationGroups(a Activity, compact bool) []locationGroup {
+	var (
+		gs    []locationGroup
+		other = locationGroup{Other: true}
+		nsgl  int
+	)
+	single := func(l Location) bool {
+		if len(l.Instances) != 1 {
+			return false
+		}
+		var n int
+		for _, b := range l.Instances[0].Days {
+			if b {
+				n++
+			}
+		}
+		return n == 1
+	}
+	if compact {
+		for _, l := range a.Locations {
+			if single(l) {
+				nsgl++
+			}
+		}
+	}
+	for li := range a.Locations {
+		l := &a.Locations[li]
+		g := &locationGroup{Location: *l}
+		if nsgl > 1 && single(*l) {
+			g = &other
+		}
+		var n [7]int
+		for xi := range l.Instances {
+			x := &l.Instances[xi]
+			// quick sanity check to prevent bugs from being silently swallowed
+			for _, c := range x.Exceptions {
+				if !x.Days[c.Date.Weekday()] {
+					panic("wtf: instance has exceptions on weekdays the instance isn't on")
+				}
+			}
+			for d, b := range x.Days {
+				if b {
+					var i int
+					if g.Other {
+						for i < len(g.Rows) && g.Rows[i][d] != nil {
+							i++
+						}
+					} else {
+						i = n[d]
+						n[d]++
+					}
+					for len(g.Rows) <= i {
+						g.Rows = append(g.Rows, [7]*instanceRow{})
+					}
+					g.Rows[i][d] = &instanceRow{x, l}
+				}
+			}
+		}
+		if !g.Other {
+			gs = append(gs, *g)
+		}
+	}
+	if len(other.Rows) != 0 {
+		gs = append(gs, other)
+	}
+	return gs
+}
+
+// LiveStatus is the status of an event happening today.
+type LiveStatus string
+
+const (
+	LiveNow  LiveStatus = "now"  // in progress
+	LiveNext LiveStatus = "next" // the next upcoming event for the activity
+)
+
+// Label returns a short human-readable label for the status.
+func (l LiveStatus) Label() string {
+	switch l {
+	case LiveNow:
+		return "Now"
+	case LiveNext:
+		return "Next"
+	}
+	return string(l)
+}
+
+// liveStatus gets the status of the non-cancelled occurrences of each instance
+// on the date of now. For each activity, if none of its events are in
+// progress, the next one to start is marked.
+func liveStatus(s *Schedule, now fusiongo.DateTime) map[*Instance]LiveStatus {
+	m := map[*Instance]LiveStatus{}
+	for ai := range s.Activities {
+		var (
+			next  *Instance
+			nextT fusiongo.Time
+			found bool
+		)
+		for li := range s.Activities[ai].Locations {
+			for xi := range s.Activities[ai].Locations[li].Instances {
+				x := &s.Activities[ai].Locations[li].Instances[xi]
+				Expand(s, *x, func(t fusiongo.DateTimeRange, cancelled, _ bool) {
+					if t.Date != now.Date || cancelled {
+						return
+					}
+					switch {
+					case !now.Time.Less(t.TimeRange.Start) && (now.Time.Less(t.TimeRange.End) || t.TimeRange.End.Less(t.TimeRange.Start)):
+						m[x], found = LiveNow, true
+					case now.Time.Less(t.TimeRange.Start) && (next == nil || t.TimeRange.Start.Less(nextT)):
+						next, nextT = x, t.TimeRange.Start
+					}
+				})
+			}
+		}
+		if !found && next != nil {
+			m[next] = LiveNext
+		}
+	}
+	return m
+}
+
 // Expand calls fn for all events in i.
 func Expand(s *Schedule, i Instance, fn func(t fusiongo.DateTimeRange, cancelled, exception bool)) {
 date:
@@ -1242,6 +3293,50 @@ date:
 	}
 }
 
+// Occurrence is a single event in a schedule.
+type Occurrence struct {
+	Time      fusiongo.DateTimeRange
+	Activity  string
+	Category  string
+	Location  string
+	Virtual   bool // online location
+	Cancelled bool
+	Exception bool // the instance has an exception on the date
+}
+
+// Occurrences returns all events in s, sorted by time, then by activity and
+// location.
+func Occurrences(s *Schedule) []Occurrence {
+	var os []Occurrence
+	for _, a := range s.Activities {
+		for _, l := range a.Locations {
+			for _, i := range l.Instances {
+				Expand(s, i, func(t fusiongo.DateTimeRange, cancelled, exception bool) {
+					os = append(os, Occurrence{
+						Time:      t,
+						Activity:  a.Name,
+						Category:  a.Category,
+						Location:  l.Name,
+						Virtual:   l.Virtual,
+						Cancelled: cancelled,
+						Exception: exception,
+					})
+				})
+			}
+		}
+	}
+	slices.SortStableFunc(os, func(a, b Occurrence) int {
+		if a.Time != b.Time {
+			return a.Time.Compare(b.Time)
+		}
+		if a.Activity != b.Activity {
+			return cmp.Compare(a.Activity, b.Activity)
+		}
+		return cmp.Compare(a.Location, b.Location)
+	})
+	return os
+}
+
 // last returns a pointer to the last element of xs. Note that the pointer may
 // become stale if the slice is appended to.
 func last[T any](xs []T) *T {
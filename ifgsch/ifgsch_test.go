@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"cmp"
 	"context"
+	"encoding/xml"
 	"flag"
 	"fmt"
 	"io"
@@ -37,7 +38,7 @@ func Test(t *testing.T) {
 		t.Run("PrepareAndRender", func(t *testing.T) {
 			var schedule *Schedule
 			for i := 0; i < 15; i++ {
-				s, err := FetchAndPrepare(context.Background(), 110, FilterFunc(swim))
+				s, err := FetchAndPrepare(context.Background(), 110, FilterFunc(swim), nil)
 				if err != nil {
 					t.Fatalf("prepare: %v", err)
 				}
@@ -65,7 +66,7 @@ func Test(t *testing.T) {
 				panic(err)
 			}
 
-			ss, fs, err := prepare(fs, fn, nil)
+			ss, fs, err := prepare(fs, fn, nil, nil)
 			if err != nil {
 				t.Fatalf("prepare: %v", err)
 			}
@@ -89,9 +90,108 @@ func Test(t *testing.T) {
 			}
 		})
 
+		t.Run("Occurrences", func(t *testing.T) {
+			fs, err := fusiongo.FetchSchedule(context.Background(), 110)
+			if err != nil {
+				panic(err)
+			}
+
+			fn, err := fusiongo.FetchNotifications(context.Background(), 110)
+			if err != nil {
+				panic(err)
+			}
+
+			ss, fs, err := prepare(fs, fn, nil, nil)
+			if err != nil {
+				t.Fatalf("prepare: %v", err)
+			}
+
+			os := Occurrences(ss)
+			if !slices.IsSortedFunc(os, func(a, b Occurrence) int {
+				return a.Time.Compare(b.Time)
+			}) {
+				t.Errorf("occurrences not sorted by time")
+			}
+
+			var dl []dumpListItem
+			for _, o := range os {
+				dl = append(dl, dumpListItem{
+					Time:      o.Time,
+					Activity:  o.Activity,
+					Location:  o.Location,
+					Cancelled: o.Cancelled,
+				})
+			}
+			if fl, sl := dumpListFusion(fs), dumpList(dl); fl != sl {
+				t.Errorf("occurrences don't match fusion events")
+			}
+		})
+
+		t.Run("RenderSVG", func(t *testing.T) {
+			s, err := FetchAndPrepare(context.Background(), 110, nil, nil)
+			if err != nil {
+				t.Fatalf("prepare: %v", err)
+			}
+			var buf bytes.Buffer
+			if err := RenderSVG(&buf, &Options{Title: "A & B", EmptyCells: true, Compact: true}, s); err != nil {
+				t.Fatalf("render: %v", err)
+			}
+			d := xml.NewDecoder(&buf)
+			for {
+				if _, err := d.Token(); err == io.EOF {
+					break
+				} else if err != nil {
+					t.Fatalf("invalid svg: %v", err)
+				}
+			}
+		})
+
+		t.Run("CategoryIDs", func(t *testing.T) {
+			s, err := FetchAndPrepare(context.Background(), 110, nil, &PrepareOptions{CategoryIDs: true})
+			if err != nil {
+				t.Fatalf("prepare: %v", err)
+			}
+			for _, a := range s.Activities {
+				if a.Category != "" && a.CategoryID == "" {
+					t.Errorf("activity %q has category %q but no category id", a.Name, a.Category)
+				}
+			}
+			s, err = FetchAndPrepare(context.Background(), 110, nil, nil)
+			if err != nil {
+				t.Fatalf("prepare: %v", err)
+			}
+			for _, a := range s.Activities {
+				if a.CategoryID != "" {
+					t.Errorf("activity %q has category id %q without CategoryIDs set", a.Name, a.CategoryID)
+				}
+			}
+		})
+
+		t.Run("Empty", func(t *testing.T) {
+			s, err := FetchAndPrepare(context.Background(), 110, FilterFunc(func(*fusiongo.ActivityInstance) bool {
+				return false
+			}), nil)
+			if err != nil {
+				t.Fatalf("prepare: %v", err)
+			}
+			if len(s.Activities) != 0 {
+				t.Errorf("expected no activities, got %d", len(s.Activities))
+			}
+			if s.Start == (fusiongo.Date{}) || s.End.Less(s.Start) {
+				t.Errorf("invalid schedule range %s - %s", s.Start, s.End)
+			}
+			var b bytes.Buffer
+			if err := Render(&b, &Options{}, s); err != nil {
+				t.Fatalf("render: %v", err)
+			}
+			if !bytes.Contains(b.Bytes(), []byte("No scheduled events")) {
+				t.Errorf("expected empty schedule message")
+			}
+		})
+
 		if d == "20231015" {
 			t.Run("Check", func(t *testing.T) {
-				s, err := FetchAndPrepare(context.Background(), 110, FilterFunc(swim))
+				s, err := FetchAndPrepare(context.Background(), 110, FilterFunc(swim), nil)
 				if err != nil {
 					t.Fatalf("prepare: %v", err)
 				}
@@ -250,7 +350,7 @@ func Test(t *testing.T) {
 				a, err := FetchAndPrepare(context.Background(), 110, FilterFunc(func(ai *fusiongo.ActivityInstance) bool {
 					// this one has many possibilities for merges, some of which are ambiguous, and some of which are suboptimal
 					return ai.Activity == "Open Rec Badminton" && ai.Location == "Gym 2B"
-				}))
+				}), nil)
 				if err != nil {
 					t.Fatalf("prepare: %v", err)
 				}
@@ -323,7 +423,7 @@ func Test(t *testing.T) {
 
 		if d == "20240129" {
 			t.Run("MiscFakeCancellations", func(t *testing.T) {
-				s, err := FetchAndPrepare(context.Background(), 110, FilterFunc(swim))
+				s, err := FetchAndPrepare(context.Background(), 110, FilterFunc(swim), nil)
 				if err != nil {
 					t.Fatalf("prepare: %v", err)
 				}
@@ -358,7 +458,7 @@ func Test(t *testing.T) {
 }
 
 func TestMergeSynthetic(t *testing.T) {
-	test := func(name string, updated fusiongo.DateTime, in []fusiongo.DateTimeRange, exp ...Instance) {
+	testOpt := func(name string, opt *PrepareOptions, updated fusiongo.DateTime, in []fusiongo.DateTimeRange, exp ...Instance) {
 		t.Run(name, func(t *testing.T) {
 			schedule := &fusiongo.Schedule{
 				Updated: updated.In(time.Local),
@@ -377,7 +477,7 @@ func TestMergeSynthetic(t *testing.T) {
 					}},
 				})
 			}
-			s, err := Prepare(schedule, &fusiongo.Notifications{}, nil)
+			s, err := Prepare(schedule, &fusiongo.Notifications{}, nil, opt)
 			if err != nil {
 				t.Fatalf("prepare: %v", err)
 			}
@@ -399,6 +499,9 @@ func TestMergeSynthetic(t *testing.T) {
 			}
 		})
 	}
+	test := func(name string, updated fusiongo.DateTime, in []fusiongo.DateTimeRange, exp ...Instance) {
+		testOpt(name, nil, updated, in, exp...)
+	}
 	test(
 		"",
 		fgDateTime(2023, 1, 1, 0, 0, 0),
@@ -495,9 +598,1446 @@ func TestMergeSynthetic(t *testing.T) {
 			},
 		},
 	)
+	testOpt(
+		"PriorityDefault",
+		nil,
+		fgDateTime(2023, 1, 1, 0, 0, 0),
+		[]fusiongo.DateTimeRange{
+			fgDateTimeRange(2023, 1, 3, 10, 30, 11, 30),  // Tu
+			fgDateTimeRange(2023, 1, 10, 10, 30, 11, 30), // Tu
+			fgDateTimeRange(2023, 1, 17, 10, 30, 11, 45), // Tu
+			fgDateTimeRange(2023, 1, 17, 10, 15, 11, 30), // Tu
+			fgDateTimeRange(2023, 1, 24, 10, 15, 11, 30), // Tu
+			fgDateTimeRange(2023, 1, 31, 10, 15, 11, 30), // Tu
+		},
+		Instance{
+			Time: fgTimeRange(10, 15, 11, 30),
+			Days: days(time.Tuesday),
+			Exceptions: []Exception{
+				{Date: fgDate(2023, 1, 3), Time: fgTimeRange(10, 30, 11, 30)},
+				{Date: fgDate(2023, 1, 10), Time: fgTimeRange(10, 30, 11, 30)},
+			},
+		},
+		Instance{
+			Time: fgTimeRange(10, 30, 11, 45),
+			Days: days(time.Tuesday),
+			Exceptions: []Exception{
+				{Date: fgDate(2023, 1, 17), OnlyOnWeekday: true},
+			},
+		},
+	)
+	testOpt(
+		"PriorityException",
+		&PrepareOptions{MergePriority: []MergePenalty{MergePenaltyException, MergePenaltyExclusion, MergePenaltyDuration}},
+		fgDateTime(2023, 1, 1, 0, 0, 0),
+		[]fusiongo.DateTimeRange{
+			fgDateTimeRange(2023, 1, 3, 10, 30, 11, 30),  // Tu
+			fgDateTimeRange(2023, 1, 10, 10, 30, 11, 30), // Tu
+			fgDateTimeRange(2023, 1, 17, 10, 30, 11, 45), // Tu
+			fgDateTimeRange(2023, 1, 17, 10, 15, 11, 30), // Tu
+			fgDateTimeRange(2023, 1, 24, 10, 15, 11, 30), // Tu
+			fgDateTimeRange(2023, 1, 31, 10, 15, 11, 30), // Tu
+		},
+		Instance{
+			Time: fgTimeRange(10, 15, 11, 30),
+			Days: days(time.Tuesday),
+			Exceptions: []Exception{
+				{Date: fgDate(2023, 1, 3), Excluded: true},
+				{Date: fgDate(2023, 1, 10), Excluded: true},
+			},
+		},
+		Instance{
+			Time: fgTimeRange(10, 30, 11, 30),
+			Days: days(time.Tuesday),
+			Exceptions: []Exception{
+				{Date: fgDate(2023, 1, 17), Time: fgTimeRange(10, 30, 11, 45)},
+				{Date: fgDate(2023, 1, 17), LastOnWeekday: true},
+			},
+		},
+	)
+	testOpt(
+		"SplitThresholdDisabled",
+		nil,
+		fgDateTime(2023, 1, 1, 0, 0, 0),
+		[]fusiongo.DateTimeRange{
+			fgDateTimeRange(2023, 1, 3, 10, 30, 11, 30),  // Tu
+			fgDateTimeRange(2023, 1, 5, 10, 30, 11, 30),  // Th
+			fgDateTimeRange(2023, 1, 10, 10, 30, 11, 30), // Tu
+			fgDateTimeRange(2023, 1, 12, 8, 0, 16, 0),    // Th
+			fgDateTimeRange(2023, 1, 17, 10, 30, 11, 30), // Tu
+			fgDateTimeRange(2023, 1, 19, 10, 30, 11, 30), // Th
+			fgDateTimeRange(2023, 1, 24, 10, 30, 11, 30), // Tu
+			fgDateTimeRange(2023, 1, 26, 10, 45, 11, 30), // Th
+		},
+		Instance{
+			Time: fgTimeRange(10, 30, 11, 30),
+			Days: days(time.Tuesday, time.Thursday),
+			Exceptions: []Exception{
+				{Date: fgDate(2023, 1, 12), Time: fgTimeRange(8, 0, 16, 0)},
+				{Date: fgDate(2023, 1, 26), Time: fgTimeRange(10, 45, 11, 30)},
+			},
+		},
+	)
+	testOpt(
+		"SplitThreshold",
+		&PrepareOptions{SplitThreshold: time.Hour},
+		fgDateTime(2023, 1, 1, 0, 0, 0),
+		[]fusiongo.DateTimeRange{
+			fgDateTimeRange(2023, 1, 3, 10, 30, 11, 30),  // Tu
+			fgDateTimeRange(2023, 1, 5, 10, 30, 11, 30),  // Th
+			fgDateTimeRange(2023, 1, 10, 10, 30, 11, 30), // Tu
+			fgDateTimeRange(2023, 1, 12, 8, 0, 16, 0),    // Th
+			fgDateTimeRange(2023, 1, 17, 10, 30, 11, 30), // Tu
+			fgDateTimeRange(2023, 1, 19, 10, 30, 11, 30), // Th
+			fgDateTimeRange(2023, 1, 24, 10, 30, 11, 30), // Tu
+			fgDateTimeRange(2023, 1, 26, 10, 45, 11, 30), // Th
+		},
+		Instance{
+			Time: fgTimeRange(8, 0, 16, 0),
+			Days: days(time.Thursday),
+			Exceptions: []Exception{
+				{Date: fgDate(2023, 1, 12), OnlyOnWeekday: true},
+			},
+		},
+		Instance{
+			Time: fgTimeRange(10, 30, 11, 30),
+			Days: days(time.Tuesday, time.Thursday),
+			Exceptions: []Exception{
+				{Date: fgDate(2023, 1, 12), Excluded: true},
+				{Date: fgDate(2023, 1, 26), Time: fgTimeRange(10, 45, 11, 30)},
+			},
+		},
+	)
 	// TODO: more test cases for specific situations
 }
 
+func TestFirstDayExclusion(t *testing.T) {
+	instance := func(d fusiongo.DateTimeRange, activity string) fusiongo.ActivityInstance {
+		return fusiongo.ActivityInstance{
+			Time:     d,
+			Activity: activity,
+			Location: "Test",
+		}
+	}
+	schedule := &fusiongo.Schedule{
+		Updated: fgDateTime(2023, 1, 3, 12, 0, 0).In(time.Local), // after the first day
+		Activities: []fusiongo.ActivityInstance{
+			instance(fgDateTimeRange(2023, 1, 2, 20, 0, 21, 0), "Other"),   // Mo; sets the schedule start
+			instance(fgDateTimeRange(2023, 1, 9, 10, 30, 11, 30), "Test"),  // Mo
+			instance(fgDateTimeRange(2023, 1, 16, 10, 30, 11, 30), "Test"), // Mo
+		},
+	}
+	for _, tc := range []struct {
+		Mode FirstDayExclusion
+		Exp  []Exception
+	}{
+		{FirstDayExclusionIgnore, nil},
+		{FirstDayExclusionShow, []Exception{{Date: fgDate(2023, 1, 2), Excluded: true, Cutoff: true}}},
+		{FirstDayExclusionExclude, []Exception{{Date: fgDate(2023, 1, 2), Excluded: true}}},
+	} {
+		s, err := Prepare(schedule, &fusiongo.Notifications{}, nil, &PrepareOptions{FirstDayExclusion: tc.Mode})
+		if err != nil {
+			t.Fatalf("%q: prepare: %v", tc.Mode, err)
+		}
+		if s.Start != fgDate(2023, 1, 2) {
+			t.Fatalf("%q: expected schedule to start on 2023-01-02, got %s", tc.Mode, s.Start)
+		}
+		var act []Exception
+		for _, a := range s.Activities {
+			if a.Name == "Test" {
+				act = a.Locations[0].Instances[0].Exceptions
+			}
+		}
+		if fmt.Sprint(act) != fmt.Sprint(tc.Exp) {
+			t.Errorf("%q: expected exceptions %v, got %v", tc.Mode, tc.Exp, act)
+		}
+		if err := Render(io.Discard, &Options{Legend: true}, s); err != nil {
+			t.Fatalf("%q: render: %v", tc.Mode, err)
+		}
+	}
+}
+
+func TestFilterGroups(t *testing.T) {
+	activity := func(names ...string) Filter {
+		return FilterFunc(func(ai *fusiongo.ActivityInstance) bool {
+			return slices.Contains(names, ai.Activity)
+		})
+	}
+	rename := func(name string) Filter {
+		return FilterFunc(func(ai *fusiongo.ActivityInstance) bool {
+			ai.Activity = name
+			ai.Category[0].Name = name
+			return true
+		})
+	}
+	f := AnyFilter{
+		AllFilter{rename("X"), activity("A")}, // rejects everything, but after renaming
+		AllFilter{activity("A", "B"), AnyFilter{activity("B"), AllFilter{activity("A"), rename("C")}}},
+		AllFilter{},
+	}
+	for _, tc := range []struct {
+		Activity string
+		Keep     bool
+		Result   string
+	}{
+		{"A", true, "C"},
+		{"B", true, "B"},
+		{"D", true, "D"},
+	} {
+		ai := &fusiongo.ActivityInstance{Activity: tc.Activity, Category: []fusiongo.ActivityCategory{{Name: tc.Activity}}}
+		if act := f.Filter(ai); act != tc.Keep {
+			t.Errorf("%q: expected keep %t, got %t", tc.Activity, tc.Keep, act)
+		} else if ai.Activity != tc.Result || ai.Category[0].Name != tc.Result {
+			t.Errorf("%q: expected result %q, got %q (category %q)", tc.Activity, tc.Result, ai.Activity, ai.Category[0].Name)
+		}
+	}
+	if (AnyFilter{}).Filter(&fusiongo.ActivityInstance{}) {
+		t.Errorf("expected empty AnyFilter to reject everything")
+	}
+	if !(AllFilter{}).Filter(&fusiongo.ActivityInstance{}) {
+		t.Errorf("expected empty AllFilter to keep everything")
+	}
+}
+
+func TestPrepareEmpty(t *testing.T) {
+	s, err := Prepare(&fusiongo.Schedule{Updated: time.Now()}, &fusiongo.Notifications{}, nil, nil)
+	if err != nil {
+		t.Fatalf("prepare: %v", err)
+	}
+	if s.Start == (fusiongo.Date{}) || s.End.Less(s.Start) {
+		t.Errorf("invalid schedule range %s - %s", s.Start, s.End)
+	}
+	if err := Render(io.Discard, &Options{UpcomingDays: 7}, s); err != nil {
+		t.Fatalf("render: %v", err)
+	}
+}
+
+func TestPrepareDuplicateInstances(t *testing.T) {
+	instance := func(d fusiongo.DateTimeRange, activity, location string) fusiongo.ActivityInstance {
+		return fusiongo.ActivityInstance{
+			Time:       d,
+			Activity:   activity,
+			ActivityID: "00000000-0000-0000-0000-000000000000",
+			Location:   location,
+			Category: []fusiongo.ActivityCategory{{
+				ID:   "1",
+				Name: "Test",
+			}},
+		}
+	}
+	base := []fusiongo.ActivityInstance{
+		instance(fgDateTimeRange(2023, 1, 2, 10, 30, 11, 30), "A", "X"),
+		instance(fgDateTimeRange(2023, 1, 9, 10, 30, 11, 30), "A", "X"),
+		instance(fgDateTimeRange(2023, 1, 16, 10, 30, 11, 30), "A", "X"),
+		instance(fgDateTimeRange(2023, 1, 3, 8, 0, 9, 0), "A", "Y"),
+		instance(fgDateTimeRange(2023, 1, 10, 8, 0, 9, 0), "B", "Y"),
+	}
+	dup := slices.Clone(base)
+	dup = append(dup, base[0], base[3], base[3])
+	dup = append(dup, instance(fgDateTimeRange(2023, 1, 16, 10, 30, 11, 30), " A ", "X")) // duplicate after trimming
+	dup = slices.Insert(dup, 1, base[4])
+
+	updated := fgDateTime(2023, 1, 1, 0, 0, 0).In(time.Local)
+	exp, err := Prepare(&fusiongo.Schedule{Updated: updated, Activities: base}, &fusiongo.Notifications{}, nil, nil)
+	if err != nil {
+		t.Fatalf("prepare: %v", err)
+	}
+	act, err := Prepare(&fusiongo.Schedule{Updated: updated, Activities: dup}, &fusiongo.Notifications{}, nil, nil)
+	if err != nil {
+		t.Fatalf("prepare: %v", err)
+	}
+	act.Updated = exp.Updated
+	if d, ok := diff("exp", exp, "act", act); ok {
+		t.Fatal("incorrect\n" + d)
+	}
+}
+
+func TestLimitActivities(t *testing.T) {
+	instance := func(day int, activity string) fusiongo.ActivityInstance {
+		return fusiongo.ActivityInstance{
+			Time:     fgDateTimeRange(2023, 1, day, 10, 0, 11, 0),
+			Activity: activity,
+			Location: "X",
+		}
+	}
+	var ais []fusiongo.ActivityInstance
+	for i, name := range []string{"A", "B", "C", "D"} {
+		for d := 0; d <= i; d++ {
+			ais = append(ais, instance(2+d, name))
+		}
+	}
+	updated := fgDateTime(2023, 1, 1, 0, 0, 0).In(time.Local)
+	for _, tc := range []struct {
+		Max        int
+		By         ActivityLimit
+		Activities []string
+	}{
+		{0, ActivityLimitName, []string{"A", "B", "C", "D"}},
+		{4, ActivityLimitCount, []string{"A", "B", "C", "D"}},
+		{2, ActivityLimitName, []string{"A", "B"}},
+		{2, ActivityLimitCount, []string{"C", "D"}},
+	} {
+		p, err := Prepare(&fusiongo.Schedule{Updated: updated, Activities: ais}, &fusiongo.Notifications{}, nil, nil)
+		if err != nil {
+			t.Fatalf("prepare: %v", err)
+		}
+		s, err := LimitActivities(&Options{MaxActivities: tc.Max, MaxActivitiesBy: tc.By}, p)
+		if err != nil {
+			t.Fatalf("limit: %v", err)
+		}
+		if len(p.Activities) != 4 || p.MoreActivities != 0 {
+			t.Errorf("%d %q: expected the prepared schedule to be unchanged", tc.Max, tc.By)
+		}
+		var act []string
+		for _, a := range s.Activities {
+			act = append(act, a.Name)
+		}
+		if !slices.Equal(act, tc.Activities) {
+			t.Errorf("%d %q: expected activities %q, got %q", tc.Max, tc.By, tc.Activities, act)
+		}
+		if exp := 4 - len(tc.Activities); s.MoreActivities != exp {
+			t.Errorf("%d %q: expected %d more activities, got %d", tc.Max, tc.By, exp, s.MoreActivities)
+		}
+	}
+}
+
+func TestPrepareEmptyActivities(t *testing.T) {
+	ais := []fusiongo.ActivityInstance{
+		{Time: fgDateTimeRange(2023, 1, 2, 10, 0, 11, 0), Activity: "Swim", Location: "X"},
+		{Time: fgDateTimeRange(2023, 1, 2, 12, 0, 13, 0), Activity: " \t ", Location: "X"},
+		{Time: fgDateTimeRange(2023, 1, 3, 12, 0, 13, 0), Activity: "", Location: "X"},
+	}
+	updated := fgDateTime(2023, 1, 1, 0, 0, 0).In(time.Local)
+	for _, tc := range []struct {
+		Empty      EmptyActivity
+		Untitled   string
+		Activities []string
+	}{
+		{EmptyActivityRename, "", []string{"Swim", "Untitled"}},
+		{EmptyActivityRename, "Unnamed", []string{"Swim", "Unnamed"}},
+		{EmptyActivityDrop, "", []string{"Swim"}},
+	} {
+		s, err := Prepare(&fusiongo.Schedule{Updated: updated, Activities: ais}, &fusiongo.Notifications{}, nil, &PrepareOptions{EmptyActivities: tc.Empty, UntitledActivity: tc.Untitled})
+		if err != nil {
+			t.Fatalf("prepare: %v", err)
+		}
+		var act []string
+		for _, a := range s.Activities {
+			act = append(act, a.Name)
+		}
+		if !slices.Equal(act, tc.Activities) {
+			t.Errorf("%q %q: expected activities %q, got %q", tc.Empty, tc.Untitled, tc.Activities, act)
+		}
+	}
+}
+
+func TestPrepareExcludeDates(t *testing.T) {
+	ais := []fusiongo.ActivityInstance{
+		{Time: fgDateTimeRange(2023, 1, 2, 10, 0, 11, 0), Activity: "A", Location: "X"},
+		{Time: fgDateTimeRange(2023, 1, 9, 10, 0, 11, 0), Activity: "A", Location: "X"},
+		{Time: fgDateTimeRange(2023, 1, 16, 10, 7, 10, 53), Activity: "A", Location: "X"},
+		{Time: fgDateTimeRange(2023, 1, 16, 12, 0, 13, 0), Activity: "B", Location: "X"},
+		{Time: fgDateTimeRange(2023, 1, 23, 10, 0, 11, 0), Activity: "A", Location: "X"},
+		{Time: fgDateTimeRange(2023, 1, 3, 10, 0, 11, 0), Activity: "A", Location: "X"},
+		{Time: fgDateTimeRange(2023, 1, 10, 10, 0, 11, 0), Activity: "A", Location: "X"},
+		{Time: fgDateTimeRange(2023, 1, 24, 10, 0, 11, 0), Activity: "A", Location: "X"},
+	}
+	updated := fgDateTime(2023, 1, 1, 0, 0, 0).In(time.Local)
+	for _, tc := range []struct {
+		Exclude    []fusiongo.Date
+		Activities []string
+		Exceptions int
+	}{
+		{nil, []string{"A", "B"}, 2}, // the different time on the 16th, and the exclusion on the 17th
+		{[]fusiongo.Date{fgDate(2023, 1, 16), fgDate(2023, 1, 17)}, []string{"A"}, 0},
+	} {
+		s, err := Prepare(&fusiongo.Schedule{Updated: updated, Activities: ais}, &fusiongo.Notifications{}, nil, &PrepareOptions{ExcludeDates: tc.Exclude})
+		if err != nil {
+			t.Fatalf("prepare: %v", err)
+		}
+		var act []string
+		for _, a := range s.Activities {
+			act = append(act, a.Name)
+		}
+		if !slices.Equal(act, tc.Activities) {
+			t.Errorf("%s: expected activities %q, got %q", tc.Exclude, tc.Activities, act)
+		}
+		var exceptions int
+		for _, i := range s.Activities[0].Locations[0].Instances {
+			exceptions += len(i.Exceptions)
+		}
+		if exceptions != tc.Exceptions {
+			t.Errorf("%s: expected %d exceptions, got %d", tc.Exclude, tc.Exceptions, exceptions)
+		}
+	}
+}
+
+func TestExceptionDetail(t *testing.T) {
+	ais := []fusiongo.ActivityInstance{
+		{Time: fgDateTimeRange(2023, 1, 2, 10, 0, 11, 0), Activity: "A", Location: "X"},
+		{Time: fgDateTimeRange(2023, 1, 9, 10, 0, 11, 0), Activity: "A", Location: "X"},
+		{Time: fgDateTimeRange(2023, 1, 23, 10, 0, 11, 0), Activity: "A", Location: "X"},
+	}
+	updated := fgDateTime(2023, 1, 1, 0, 0, 0).In(time.Local)
+	s, err := Prepare(&fusiongo.Schedule{Updated: updated, Activities: ais}, &fusiongo.Notifications{}, nil, &PrepareOptions{})
+	if err != nil {
+		t.Fatalf("prepare: %v", err)
+	}
+	for _, tc := range []struct {
+		Detail  ExceptionDetail
+		List    bool
+		Details bool
+	}{
+		{ExceptionDetailFull, true, false},
+		{ExceptionDetailSummary, false, false},
+		{ExceptionDetailCollapsed, true, true},
+		{ExceptionDetailNone, false, false},
+	} {
+		var buf bytes.Buffer
+		if err := Render(&buf, &Options{ExceptionDetail: tc.Detail}, s); err != nil {
+			t.Fatalf("%q: render: %v", tc.Detail, err)
+		}
+		if x := strings.Contains(buf.String(), `<div class="exception" role="note"`); x != tc.List {
+			t.Errorf("%q: expected exception list = %t, got %t", tc.Detail, tc.List, x)
+		}
+		if x := strings.Contains(buf.String(), `<details class="exception"`); x != tc.Details {
+			t.Errorf("%q: expected collapsed exceptions = %t, got %t", tc.Detail, tc.Details, x)
+		}
+		if x := strings.Contains(buf.String(), printScript); x != tc.Details {
+			t.Errorf("%q: expected print script = %t, got %t", tc.Detail, tc.Details, x)
+		}
+	}
+}
+
+func TestTimeSeparator(t *testing.T) {
+	s := &Schedule{
+		Start: fgDate(2023, 10, 15),
+		End:   fgDate(2023, 10, 21),
+		Activities: []Activity{
+			{Name: "A", Locations: []Location{{Name: "X", Instances: []Instance{
+				{Time: fgTimeRange(10, 0, 11, 0), Days: days(time.Monday), Exceptions: []Exception{
+					{Date: fgDate(2023, 10, 16), Time: fgTimeRange(12, 0, 13, 0)},
+				}},
+			}}}},
+		},
+	}
+	for _, tc := range []struct {
+		Detail ExceptionDetail
+		Exp    []string
+	}{
+		{ExceptionDetailFull, []string{
+			`<time datetime="10:00:00">10:00</time> to <time datetime="11:00:00">11:00</time>`,
+			`<time datetime="12:00:00">12:00</time> to <time datetime="13:00:00">13:00</time>`,
+			`<time>9:00</time> to <time>10:00</time>`,
+		}},
+		{ExceptionDetailSummary, []string{
+			`title="Oct 16 12:00 to 13:00"`,
+		}},
+	} {
+		var buf bytes.Buffer
+		if err := Render(&buf, &Options{TimeSeparator: " to ", ExceptionDetail: tc.Detail, Legend: true}, s); err != nil {
+			t.Fatalf("%q: render: %v", tc.Detail, err)
+		}
+		for _, exp := range tc.Exp {
+			if !strings.Contains(buf.String(), exp) {
+				t.Errorf("%q: expected html to contain %q", tc.Detail, exp)
+			}
+		}
+		if strings.Contains(buf.String(), `</time>-<time`) {
+			t.Errorf("%q: expected every time range to use the separator", tc.Detail)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := RenderText(&buf, &Options{TimeSeparator: " to "}, s); err != nil {
+		t.Fatalf("render text: %v", err)
+	}
+	for _, exp := range []string{"10:00 to 11:00", "Mon Oct 16 12:00 to 13:00"} {
+		if !strings.Contains(buf.String(), exp) {
+			t.Errorf("expected text to contain %q, got:\n%s", exp, buf.String())
+		}
+	}
+}
+
+func TestPrepareSnapTimes(t *testing.T) {
+	ais := []fusiongo.ActivityInstance{
+		{Time: fgDateTimeRange(2023, 1, 2, 10, 0, 11, 0), Activity: "A", Location: "X"},
+		{Time: fgDateTimeRange(2023, 1, 9, 10, 2, 10, 58), Activity: "A", Location: "X"},
+		{Time: fgDateTimeRange(2023, 1, 16, 9, 59, 11, 1), Activity: "A", Location: "X"},
+		{Time: fgDateTimeRange(2023, 1, 23, 10, 0, 11, 0), Activity: "A", Location: "X"},
+	}
+	updated := fgDateTime(2023, 1, 1, 0, 0, 0).In(time.Local)
+	for _, tc := range []struct {
+		Snap       time.Duration
+		Exceptions int
+	}{
+		{0, 2},
+		{time.Minute * 5, 0},
+	} {
+		s, err := Prepare(&fusiongo.Schedule{Updated: updated, Activities: ais}, &fusiongo.Notifications{}, nil, &PrepareOptions{SnapTimes: tc.Snap})
+		if err != nil {
+			t.Fatalf("prepare: %v", err)
+		}
+		var exceptions int
+		for _, i := range s.Activities[0].Locations[0].Instances {
+			exceptions += len(i.Exceptions)
+		}
+		if exceptions != tc.Exceptions {
+			t.Errorf("%s: expected %d exceptions, got %d", tc.Snap, tc.Exceptions, exceptions)
+		}
+	}
+	for _, tc := range []struct {
+		Time fusiongo.Time
+		Snap time.Duration
+		Exp  fusiongo.Time
+	}{
+		{fusiongo.Time{Hour: 6, Minute: 32}, time.Minute * 5, fusiongo.Time{Hour: 6, Minute: 30}},
+		{fusiongo.Time{Hour: 6, Minute: 32, Second: 30}, time.Minute * 5, fusiongo.Time{Hour: 6, Minute: 35}},
+		{fusiongo.Time{Hour: 6, Minute: 50}, time.Minute * 15, fusiongo.Time{Hour: 6, Minute: 45}},
+		{fusiongo.Time{Hour: 6, Minute: 53}, time.Minute * 15, fusiongo.Time{Hour: 7}},
+		{fusiongo.Time{Hour: 23, Minute: 58}, time.Minute * 5, fusiongo.Time{Hour: 23, Minute: 55}},
+	} {
+		if act := snapTime(tc.Time, tc.Snap); act != tc.Exp {
+			t.Errorf("snap %s to %s: expected %s, got %s", tc.Time, tc.Snap, tc.Exp, act)
+		}
+	}
+	if _, err := Prepare(&fusiongo.Schedule{Updated: updated}, &fusiongo.Notifications{}, nil, &PrepareOptions{SnapTimes: -time.Minute}); err == nil {
+		t.Errorf("expected error for negative snap interval")
+	}
+}
+
+func TestPrepareDedupeNotifications(t *testing.T) {
+	ns := &fusiongo.Notifications{
+		Notifications: []fusiongo.Notification{
+			{Text: "Pool closed", Sent: fgDateTime(2023, 10, 1, 9, 0, 0)},
+			{Text: "Gym closed", Sent: fgDateTime(2023, 10, 2, 9, 0, 0)},
+			{Text: "Pool closed ", Sent: fgDateTime(2023, 10, 3, 9, 0, 0)},
+			{Text: "Pool closed", Sent: fgDateTime(2023, 10, 2, 12, 0, 0)},
+		},
+	}
+	for _, tc := range []struct {
+		Dedupe bool
+		Exp    []string
+	}{
+		{false, []string{"2023-10-03 09:00:00", "2023-10-02 12:00:00", "2023-10-02 09:00:00", "2023-10-01 09:00:00"}},
+		{true, []string{"2023-10-03 09:00:00", "2023-10-02 09:00:00"}},
+	} {
+		s, err := Prepare(&fusiongo.Schedule{Updated: time.Now()}, ns, nil, &PrepareOptions{DedupeNotifications: tc.Dedupe})
+		if err != nil {
+			t.Fatalf("prepare: %v", err)
+		}
+		var act []string
+		for _, n := range s.Notifications {
+			act = append(act, n.Sent.Date.String()+" "+n.Sent.Time.String())
+		}
+		if !slices.Equal(act, tc.Exp) {
+			t.Errorf("dedupe=%t: expected %q, got %q", tc.Dedupe, tc.Exp, act)
+		}
+	}
+}
+
+func TestPrepareInvalidNotificationDates(t *testing.T) {
+	ns := &fusiongo.Notifications{
+		Notifications: []fusiongo.Notification{
+			{Text: "A", Sent: fusiongo.DateTime{}},
+			{Text: "B", Sent: fgDateTime(2023, 10, 1, 9, 0, 0)},
+			{Text: "C", Sent: fusiongo.DateTime{Date: fusiongo.Date{Year: 2023, Month: 13, Day: 1}}},
+			{Text: "D", Sent: fgDateTime(2023, 10, 2, 9, 0, 0)},
+			{Text: "E", Sent: fgDateTime(2023, 2, 30, 9, 0, 0)},
+		},
+	}
+	s, err := Prepare(&fusiongo.Schedule{Updated: time.Now()}, ns, nil, nil)
+	if err != nil {
+		t.Fatalf("prepare: %v", err)
+	}
+	var act []string
+	for _, n := range s.Notifications {
+		act = append(act, n.Text)
+		if n.Text != "B" && n.Text != "D" && n.Sent != (fusiongo.DateTime{}) {
+			t.Errorf("%s: expected invalid sent date to be cleared, got %s", n.Text, n.Sent)
+		}
+	}
+	if exp := []string{"D", "B", "E", "C", "A"}; !slices.Equal(act, exp) {
+		t.Errorf("expected order %q, got %q", exp, act)
+	}
+
+	var buf bytes.Buffer
+	if err := Render(&buf, &Options{}, s); err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	if n := strings.Count(buf.String(), `<div class="date nogrow">`); n != 2 {
+		t.Errorf("expected 2 notification dates, got %d", n)
+	}
+	if strings.Contains(buf.String(), `<details class="notifications">`) {
+		t.Errorf("expected no notification banner by default")
+	}
+	if strings.Contains(buf.String(), printScript) {
+		t.Errorf("expected no print script by default")
+	}
+	buf.Reset()
+	if err := Render(&buf, &Options{NotifyBanner: true}, s); err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	if x := `<summary class="nogrow"><span class="count">5 notifications</span><span class="latest">D</span></summary>`; !strings.Contains(buf.String(), x) {
+		t.Errorf("expected notification banner %q", x)
+	}
+	if n := strings.Count(buf.String(), `<section class="notification nogrow"`); n != 5 {
+		t.Errorf("expected 5 notifications in the banner, got %d", n)
+	}
+	if !strings.Contains(buf.String(), printScript) {
+		t.Errorf("expected print script with notification banner")
+	}
+	buf.Reset()
+	if err := Render(&buf, &Options{NotifyBanner: true}, &Schedule{Start: s.Start, End: s.End}); err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	if strings.Contains(buf.String(), `<details class="notifications">`) {
+		t.Errorf("expected no notification banner without notifications")
+	}
+	if strings.Contains(buf.String(), printScript) {
+		t.Errorf("expected no print script without notifications")
+	}
+	buf.Reset()
+	if err := RenderText(&buf, &Options{}, s); err != nil {
+		t.Fatalf("render text: %v", err)
+	}
+	if n := strings.Count(buf.String(), "(no date)"); n != 3 {
+		t.Errorf("expected 3 notifications without dates, got %d:\n%s", n, buf.String())
+	}
+}
+
+func TestHideCancelled(t *testing.T) {
+	s := &Schedule{
+		Start: fgDate(2023, 10, 15), // Sunday
+		End:   fgDate(2023, 10, 28),
+		Activities: []Activity{
+			{Name: "A", Locations: []Location{{Name: "X", Instances: []Instance{
+				{Time: fgTimeRange(8, 0, 9, 0), Days: days(time.Monday, time.Wednesday), Exceptions: []Exception{
+					{Date: fgDate(2023, 10, 16), Cancelled: true},
+					{Date: fgDate(2023, 10, 18), Cancelled: true},
+					{Date: fgDate(2023, 10, 25), Cancelled: true},
+				}},
+				{Time: fgTimeRange(10, 0, 11, 0), Days: days(time.Friday), Exceptions: []Exception{
+					{Date: fgDate(2023, 10, 20), Cancelled: true},
+					{Date: fgDate(2023, 10, 27), Cancelled: true},
+				}},
+			}}}},
+			{Name: "B", Locations: []Location{{Name: "X", Instances: []Instance{
+				{Time: fgTimeRange(10, 0, 11, 0), Days: days(time.Tuesday), Exceptions: []Exception{
+					{Date: fgDate(2023, 10, 17), OnlyOnWeekday: true},
+					{Date: fgDate(2023, 10, 17), Cancelled: true},
+				}},
+			}}}},
+		},
+	}
+	hideCancelled(s)
+	exp := []Activity{
+		{Name: "A", Locations: []Location{{Name: "X", Instances: []Instance{
+			{Time: fgTimeRange(8, 0, 9, 0), Days: days(time.Monday), Exceptions: []Exception{
+				{Date: fgDate(2023, 10, 16), Excluded: true},
+			}},
+		}}}},
+	}
+	if act := fmt.Sprint(s.Activities); act != fmt.Sprint(exp) {
+		t.Errorf("expected:\n\t%s\ngot:\n\t%s", fmt.Sprint(exp), act)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	a := &Schedule{
+		Start: fgDate(2023, 10, 1),
+		End:   fgDate(2023, 10, 21),
+		Activities: []Activity{{
+			Name: "Swim",
+			Locations: []Location{{
+				Name: "Pool",
+				Instances: []Instance{{
+					Time: fgTimeRange(10, 0, 11, 0),
+					Days: days(time.Monday, time.Wednesday),
+				}},
+			}},
+		}},
+	}
+	b := &Schedule{
+		Start: fgDate(2023, 10, 8),
+		End:   fgDate(2023, 10, 28),
+		Activities: []Activity{{
+			Name: "Swim",
+			Locations: []Location{{
+				Name: "Pool",
+				Instances: []Instance{{
+					Time: fgTimeRange(10, 0, 11, 0),
+					Days: days(time.Monday, time.Wednesday, time.Friday),
+					Exceptions: []Exception{
+						{Date: fgDate(2023, 10, 9), Cancelled: true},
+						{Date: fgDate(2023, 10, 11), Time: fgTimeRange(12, 0, 13, 0)},
+						{Date: fgDate(2023, 10, 16), Excluded: true},
+					},
+				}},
+			}},
+		}},
+	}
+	var act []string
+	for _, c := range Diff(a, b) {
+		x := fmt.Sprintf("%s %s %s", c.Date, c.Time, c.Kind)
+		if c.Kind == ChangeTime {
+			x += " " + c.OldTime.String()
+		}
+		act = append(act, x)
+	}
+	exp := []string{
+		"2023-10-09 " + fgTimeRange(10, 0, 11, 0).String() + " cancelled",
+		"2023-10-11 " + fgTimeRange(12, 0, 13, 0).String() + " time " + fgTimeRange(10, 0, 11, 0).String(),
+		"2023-10-13 " + fgTimeRange(10, 0, 11, 0).String() + " added",
+		"2023-10-16 " + fgTimeRange(10, 0, 11, 0).String() + " removed",
+		"2023-10-20 " + fgTimeRange(10, 0, 11, 0).String() + " added",
+	}
+	if !slices.Equal(act, exp) {
+		t.Errorf("incorrect diff:\n\texp: %q\n\tact: %q", exp, act)
+	}
+	if cs := Diff(b, b); len(cs) != 0 {
+		t.Errorf("expected no changes when comparing with self, got %d", len(cs))
+	}
+}
+
+func TestNotificationID(t *testing.T) {
+	a := notificationID(fgDateTime(2023, 10, 15, 19, 51, 5), "Pool closed")
+	if exp := "20231015T195105-"; !strings.HasPrefix(a, exp) {
+		t.Errorf("expected id to start with %q, got %q", exp, a)
+	}
+	if b := notificationID(fgDateTime(2023, 10, 15, 19, 51, 5), "Pool closed"); a != b {
+		t.Errorf("id not deterministic: %q != %q", a, b)
+	}
+	if b := notificationID(fgDateTime(2023, 10, 15, 19, 51, 5), "Pool open"); a == b {
+		t.Errorf("id not unique for different text: %q", a)
+	}
+	if b := notificationID(fgDateTime(2023, 10, 16, 19, 51, 5), "Pool closed"); a == b {
+		t.Errorf("id not unique for different date: %q", a)
+	}
+}
+
+func TestWeekdayExceptions(t *testing.T) {
+	i := &Instance{
+		Time: fgTimeRange(10, 0, 11, 0),
+		Days: days(time.Monday, time.Friday),
+		Exceptions: []Exception{
+			{Date: fgDate(2023, 10, 2), Cancelled: true},
+			{Date: fgDate(2023, 10, 9), Cancelled: true},
+			{Date: fgDate(2023, 10, 13), Cancelled: true},
+			{Date: fgDate(2023, 10, 16), Cancelled: true},
+			{Date: fgDate(2023, 10, 20), Cancelled: true},
+			{Date: fgDate(2023, 10, 23), Time: fgTimeRange(10, 0, 12, 0)},
+			{Date: fgDate(2023, 10, 30), Cancelled: true},
+			{Date: fgDate(2023, 11, 6), Cancelled: true},
+			{Date: fgDate(2023, 11, 20), Cancelled: true},
+		},
+	}
+	dump := func(es []exceptionRun) string {
+		var b strings.Builder
+		for _, e := range es {
+			b.WriteString(e.Date.String())
+			if e.Until != nil {
+				b.WriteString(".." + e.Until.String())
+			}
+			b.WriteString(" ")
+		}
+		return b.String()
+	}
+	if act, exp := dump(weekdayExceptions(i, time.Monday)), "2023-10-02..2023-10-16 2023-10-23 2023-10-30 2023-11-06 2023-11-20 "; act != exp {
+		t.Errorf("monday: expected %q, got %q", exp, act)
+	}
+	if act, exp := dump(weekdayExceptions(i, time.Friday)), "2023-10-13 2023-10-20 "; act != exp {
+		t.Errorf("friday: expected %q, got %q", exp, act)
+	}
+	for j, exp := range []string{"Oct 2–Oct 16 cancelled", "Oct 23 10:00 - 12:00"} {
+		if act := weekdayExceptions(i, time.Monday)[j].String(); act != exp {
+			t.Errorf("monday %d: expected string %q, got %q", j, exp, act)
+		}
+	}
+	for j, exp := range []string{"Cancelled every week from Oct 2 to Oct 16", "At 10:00 to 12:00 on Oct 23"} {
+		if act := weekdayExceptions(i, time.Monday)[j].Label("", false); act != exp {
+			t.Errorf("monday %d: expected label %q, got %q", j, exp, act)
+		}
+	}
+}
+
+func TestLiveStatus(t *testing.T) {
+	s := &Schedule{
+		Start: fgDate(2023, 10, 15),
+		End:   fgDate(2023, 10, 21),
+		Activities: []Activity{
+			{Name: "A", Locations: []Location{{Name: "X", Instances: []Instance{
+				{Time: fgTimeRange(8, 0, 9, 0), Days: days(time.Monday)},
+				{Time: fgTimeRange(10, 0, 11, 0), Days: days(time.Monday)},
+				{Time: fgTimeRange(12, 0, 13, 0), Days: days(time.Monday)},
+			}}}},
+			{Name: "B", Locations: []Location{{Name: "X", Instances: []Instance{
+				{Time: fgTimeRange(10, 0, 11, 0), Days: days(time.Monday), Exceptions: []Exception{
+					{Date: fgDate(2023, 10, 16), Cancelled: true},
+				}},
+				{Time: fgTimeRange(14, 0, 15, 0), Days: days(time.Monday)},
+				{Time: fgTimeRange(13, 0, 14, 0), Days: days(time.Monday)},
+			}}}},
+			{Name: "C", Locations: []Location{{Name: "X", Instances: []Instance{
+				{Time: fgTimeRange(11, 0, 12, 0), Days: days(time.Tuesday)},
+			}}}},
+		},
+	}
+	m := liveStatus(s, fgDateTime(2023, 10, 16, 10, 30, 0)) // Monday
+	for _, tc := range []struct {
+		A, X int
+		S    LiveStatus
+	}{
+		{0, 0, ""},
+		{0, 1, LiveNow},
+		{0, 2, ""},
+		{1, 0, ""},
+		{1, 1, ""},
+		{1, 2, LiveNext},
+		{2, 0, ""},
+	} {
+		if act := m[&s.Activities[tc.A].Locations[0].Instances[tc.X]]; act != tc.S {
+			t.Errorf("activity %s instance %d: expected %q, got %q", s.Activities[tc.A].Name, tc.X, tc.S, act)
+		}
+	}
+}
+
+func TestToday(t *testing.T) {
+	var (
+		tz  = time.FixedZone("EDT", -4*60*60)
+		now = time.Date(2023, 10, 17, 2, 30, 0, 0, time.UTC) // Oct 16 22:30 EDT
+		s   = &Schedule{Updated: time.Date(2023, 10, 15, 12, 0, 0, 0, time.UTC)}
+	)
+	for _, tc := range []struct {
+		Name     string
+		Timezone *time.Location
+		Expected fusiongo.Date
+	}{
+		{"Updated", nil, fgDate(2023, 10, 15)},
+		{"UTC", time.UTC, fgDate(2023, 10, 17)},
+		{"Timezone", tz, fgDate(2023, 10, 16)},
+	} {
+		o := &Options{Timezone: tc.Timezone, Now: func() time.Time { return now }}
+		if act := today(o, s); act != tc.Expected {
+			t.Errorf("%s: expected %s, got %s", tc.Name, tc.Expected, act)
+		}
+	}
+}
+
+func TestLiveTime(t *testing.T) {
+	var (
+		tz  = time.FixedZone("EDT", -4*60*60)
+		now = time.Date(2023, 10, 17, 14, 30, 0, 0, time.UTC) // Oct 17 10:30 EDT
+		s   = &Schedule{
+			Updated: time.Date(2023, 10, 16, 12, 0, 0, 0, tz),
+			Start:   fgDate(2023, 10, 15),
+			End:     fgDate(2023, 10, 21),
+			Activities: []Activity{
+				{Name: "A", Locations: []Location{{Name: "X", Instances: []Instance{
+					{Time: fgTimeRange(10, 0, 11, 0), Days: days(time.Monday, time.Tuesday)},
+				}}}},
+			},
+		}
+	)
+	if d := liveTime(&Options{Now: func() time.Time { return now }}); d != nil {
+		t.Errorf("expected no live time if live isn't set, got %s", d)
+	}
+	o := &Options{Live: true, Timezone: tz, Now: func() time.Time { return now }, UpcomingDays: 2}
+	if d := liveTime(o); d == nil || *d != fgDateTime(2023, 10, 17, 10, 30, 0) {
+		t.Errorf("expected the live time to be the current time in the timezone, got %v", d)
+	}
+	ds := upcoming(s, fgDate(2023, 10, 16), 2, 0, liveTime(o))
+	if act := [2]LiveStatus{ds[0].Events[0].Status, ds[1].Events[0].Status}; act != [2]LiveStatus{"", LiveNow} {
+		t.Errorf("expected the live status to be for the current date instead of the updated one, got %q", act)
+	}
+	var buf bytes.Buffer
+	if err := Render(&buf, o, s); err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	if act := strings.Count(buf.String(), `<div class="live">Now</div>`); act != 2 {
+		t.Errorf("expected the instance on the current weekday to be live in the grid and upcoming day, got %d", act)
+	}
+	o.Now = func() time.Time { return now.Add(time.Hour) }
+	buf.Reset()
+	if err := Render(&buf, o, s); err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	if strings.Contains(buf.String(), `class="live"`) {
+		t.Errorf("expected no live events after they ended")
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	for _, tc := range []struct {
+		in  string
+		n   int
+		exp string
+	}{
+		{"Pool closed today", 0, "Pool closed today"},
+		{"Pool closed today", 17, "Pool closed today"},
+		{"Pool closed today", 16, "Pool closed tod…"},
+		{"Pool closed today", 12, "Pool closed…"},
+		{"Pool closed today", 13, "Pool closed…"},
+		{"Café fermé", 5, "Café…"},
+	} {
+		if act := truncate(tc.in, tc.n); act != tc.exp {
+			t.Errorf("truncate(%q, %d): expected %q, got %q", tc.in, tc.n, tc.exp, act)
+		}
+	}
+}
+
+func TestGoogleCalendarURL(t *testing.T) {
+	loc, err := time.LoadLocation("America/Toronto")
+	if err != nil {
+		t.Skipf("load timezone: %v", err)
+	}
+	for _, tc := range []struct {
+		Options Options
+		Event   upcomingEvent
+		URL     string
+	}{
+		{Options{}, upcomingEvent{Activity: "Lane Swim", Location: "Pool", Time: fgTimeRange(7, 30, 9, 0)}, "https://calendar.google.com/calendar/render?action=TEMPLATE&dates=20231016T073000%2F20231016T090000&location=Pool&text=Lane+Swim"},
+		{Options{Timezone: loc}, upcomingEvent{Activity: "A & B", Location: "Gym", Facility: "ARC", Time: fgTimeRange(23, 0, 1, 0)}, "https://calendar.google.com/calendar/render?action=TEMPLATE&ctz=America%2FToronto&dates=20231016T230000%2F20231017T010000&location=Gym%2C+ARC&text=A+%26+B"},
+	} {
+		if act := googleCalendarURL(&tc.Options, fgDate(2023, 10, 16), tc.Event); act != tc.URL {
+			t.Errorf("expected %q, got %q", tc.URL, act)
+		}
+	}
+}
+
+func TestFormatRange(t *testing.T) {
+	for _, tc := range []struct {
+		Format     RangeFormat
+		Start, End fusiongo.Date
+		Expected   string
+	}{
+		{RangeFormatDates, fgDate(2023, 10, 15), fgDate(2023, 10, 21), "Oct 15 - Oct 21"},
+		{RangeFormatWeekOf, fgDate(2023, 10, 15), fgDate(2023, 10, 21), "Week of Oct 15"},
+		{RangeFormatWeekOf, fgDate(2023, 10, 17), fgDate(2023, 10, 19), "Week of Oct 17"},
+		{RangeFormatWeekOf, fgDate(2023, 10, 15), fgDate(2023, 10, 22), "Oct 15 - Oct 22"},
+	} {
+		if act := formatRange(&Options{RangeFormat: tc.Format}, tc.Start, tc.End); act != tc.Expected {
+			t.Errorf("%q %s %s: expected %q, got %q", tc.Format, tc.Start, tc.End, tc.Expected, act)
+		}
+	}
+}
+
+func TestUpcomingGroups(t *testing.T) {
+	var (
+		a1 = upcomingEvent{Activity: "A", Time: fgTimeRange(8, 0, 9, 0)}
+		b1 = upcomingEvent{Activity: "B", Time: fgTimeRange(9, 0, 10, 0)}
+		a2 = upcomingEvent{Activity: "A", Time: fgTimeRange(10, 0, 11, 0), Cancelled: true}
+		c1 = upcomingEvent{Activity: "C", Category: "X", Time: fgTimeRange(11, 0, 12, 0)}
+		b2 = upcomingEvent{Activity: "B", Time: fgTimeRange(12, 0, 13, 0)}
+	)
+	gs := upcomingGroups([]upcomingEvent{a1, b1, a2, c1, b2})
+	exp := []upcomingGroup{
+		{Activity: "A", Events: []upcomingEvent{a1, a2}},
+		{Activity: "B", Events: []upcomingEvent{b1, b2}},
+		{Activity: "C", Category: "X", Events: []upcomingEvent{c1}},
+	}
+	if !slices.EqualFunc(gs, exp, func(a, b upcomingGroup) bool {
+		return a.Activity == b.Activity && a.Category == b.Category && slices.Equal(a.Events, b.Events)
+	}) {
+		t.Errorf("expected %v, got %v", exp, gs)
+	}
+}
+
+func TestAccent(t *testing.T) {
+	s := Schedule{Activities: []Activity{
+		{Name: "A", Category: "X"},
+		{Name: "B", Category: "Y"},
+		{Name: "C", Category: "X"},
+		{Name: "D"},
+	}}
+	for _, tc := range []struct {
+		Colors   EventColors
+		Event    upcomingEvent
+		Expected int
+	}{
+		{EventColorsNone, upcomingEvent{Activity: "B", Category: "Y"}, -1},
+		{EventColorsActivity, upcomingEvent{Activity: "A", Category: "X"}, 0},
+		{EventColorsActivity, upcomingEvent{Activity: "C", Category: "X"}, 2},
+		{EventColorsActivity, upcomingEvent{Activity: "D"}, 3},
+		{EventColorsCategory, upcomingEvent{Activity: "C", Category: "X"}, 0},
+		{EventColorsCategory, upcomingEvent{Activity: "B", Category: "Y"}, 1},
+		{EventColorsCategory, upcomingEvent{Activity: "D"}, -1},
+	} {
+		if act := accent(&Options{EventColors: tc.Colors}, s, tc.Event); act != tc.Expected {
+			t.Errorf("%q %s: expected %d, got %d", tc.Colors, tc.Event.Activity, tc.Expected, act)
+		}
+	}
+	if a, b := accent(&Options{EventColors: EventColorsActivity}, Schedule{}, upcomingEvent{Activity: "E"}), accent(&Options{EventColors: EventColorsActivity}, Schedule{}, upcomingEvent{Activity: "E"}); a != b || a < 0 || a >= accentColors {
+		t.Errorf("expected a consistent accent for unknown activities, got %d and %d", a, b)
+	}
+}
+
+func TestZeroDuration(t *testing.T) {
+	s := &Schedule{
+		Start: fgDate(2023, 10, 16),
+		End:   fgDate(2023, 10, 22),
+		Activities: []Activity{
+			{Name: "A", Locations: []Location{{Name: "X", Instances: []Instance{
+				{Time: fgTimeRange(7, 30, 7, 30), Days: days(time.Monday)},
+				{Time: fgTimeRange(9, 0, 10, 0), Days: days(time.Tuesday)},
+			}}}},
+			{Name: "B", Locations: []Location{{Name: "Y", Instances: []Instance{
+				{Time: fgTimeRange(12, 0, 12, 0), Days: days(time.Wednesday)},
+			}}}},
+		},
+	}
+	for _, tc := range []struct {
+		Mode       ZeroDuration
+		Contains   []string
+		NotContain []string
+	}{
+		{ZeroDurationKeep, []string{"07:30 - 07:30", "09:00 - 10:00", "\nB\n"}, nil},
+		{ZeroDurationPoint, []string{"07:30\n", "09:00 - 10:00", "\nB\n"}, []string{"07:30 - 07:30"}},
+		{ZeroDurationHide, []string{"09:00 - 10:00"}, []string{"07:30", "\nB\n", "12:00"}},
+	} {
+		var buf bytes.Buffer
+		if err := RenderText(&buf, &Options{ZeroDuration: tc.Mode}, s); err != nil {
+			t.Fatalf("%q: render text: %v", tc.Mode, err)
+		}
+		for _, x := range tc.Contains {
+			if !strings.Contains(buf.String(), x) {
+				t.Errorf("%q: expected output to contain %q:\n%s", tc.Mode, x, buf.String())
+			}
+		}
+		for _, x := range tc.NotContain {
+			if strings.Contains(buf.String(), x) {
+				t.Errorf("%q: expected output to not contain %q:\n%s", tc.Mode, x, buf.String())
+			}
+		}
+		buf.Reset()
+		if err := Render(&buf, &Options{ZeroDuration: tc.Mode}, s); err != nil {
+			t.Fatalf("%q: render: %v", tc.Mode, err)
+		}
+		if act, exp := strings.Contains(buf.String(), `datetime="07:30:00"`), tc.Mode != ZeroDurationHide; act != exp {
+			t.Errorf("%q: expected zero-duration instance in html %t, got %t", tc.Mode, exp, act)
+		}
+	}
+	if len(s.Activities) != 2 || len(s.Activities[0].Locations[0].Instances) != 2 {
+		t.Errorf("schedule was modified")
+	}
+}
+
+func TestDayCounts(t *testing.T) {
+	s := &Schedule{
+		Start: fgDate(2023, 10, 16),
+		End:   fgDate(2023, 10, 22),
+		Activities: []Activity{
+			{Name: "A", Locations: []Location{{Name: "X", Instances: []Instance{
+				{Time: fgTimeRange(7, 0, 8, 0), Days: days(time.Monday)},
+				{Time: fgTimeRange(9, 0, 10, 0), Days: days(time.Monday), Exceptions: []Exception{{Date: fgDate(2023, 10, 16), Cancelled: true}}},
+				{Time: fgTimeRange(11, 0, 12, 0), Days: days(time.Monday, time.Tuesday)},
+			}}}},
+		},
+	}
+	ds := upcoming(s, fgDate(2023, 10, 16), 3, 1, nil)
+	for _, tc := range []struct {
+		Counts DayCounts
+		Exp    []string
+	}{
+		{DayCountsNone, []string{"", "", ""}},
+		{DayCountsAll, []string{"3 events", "1 event", "0 events"}},
+		{DayCountsScheduled, []string{"2 events", "1 event", "0 events"}},
+	} {
+		var act []string
+		for _, d := range ds {
+			act = append(act, dayCount(tc.Counts, d))
+		}
+		if !slices.Equal(act, tc.Exp) {
+			t.Errorf("%q: expected counts %q, got %q", tc.Counts, tc.Exp, act)
+		}
+	}
+	if n := len(ds[0].Events); n != 1 {
+		t.Errorf("expected the counts to include events not shown, but %d were shown", n)
+	}
+
+	s.Updated = time.Date(2023, 10, 16, 6, 0, 0, 0, time.Local)
+	var buf bytes.Buffer
+	if err := Render(&buf, &Options{UpcomingDays: 2, DayCounts: DayCountsScheduled}, s); err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	if !strings.Contains(buf.String(), `<span class="count">1 event</span>`) {
+		t.Errorf("expected day count in upcoming card header")
+	}
+}
+
+func TestDurations(t *testing.T) {
+	for _, tc := range []struct {
+		Durations Durations
+		Time      fusiongo.TimeRange
+		Exp       string
+	}{
+		{DurationsNone, fgTimeRange(7, 0, 8, 30), ""},
+		{DurationsBeside, fgTimeRange(7, 0, 8, 30), "90 min"},
+		{DurationsReplace, fgTimeRange(7, 0, 8, 30), "90 min"},
+		{DurationsBeside, fgTimeRange(23, 0, 1, 15), "135 min"}, // wraps to the next day
+		{DurationsBeside, fgTimeRange(7, 0, 7, 0), ""},
+	} {
+		if act := duration(tc.Durations, tc.Time); act != tc.Exp {
+			t.Errorf("%q %s: expected %q, got %q", tc.Durations, tc.Time, tc.Exp, act)
+		}
+	}
+
+	s := &Schedule{
+		Start:   fgDate(2023, 10, 16),
+		End:     fgDate(2023, 10, 22),
+		Updated: time.Date(2023, 10, 16, 6, 0, 0, 0, time.Local),
+		Activities: []Activity{
+			{Name: "A", Locations: []Location{{Name: "X", Instances: []Instance{
+				{Time: fgTimeRange(22, 30, 0, 0), Days: days(time.Monday)},
+			}}}},
+		},
+	}
+	for _, tc := range []struct {
+		Durations Durations
+		Time      bool
+	}{
+		{DurationsBeside, true},
+		{DurationsReplace, false},
+	} {
+		var buf bytes.Buffer
+		if err := Render(&buf, &Options{Durations: tc.Durations, UpcomingDays: 1}, s); err != nil {
+			t.Fatalf("render: %v", err)
+		}
+		if !strings.Contains(buf.String(), `<time class="duration" datetime="PT90M">90 min</time>`) {
+			t.Errorf("%q: expected duration badge", tc.Durations)
+		}
+		if act := strings.Contains(buf.String(), `<time datetime="22:30:00">`); act != tc.Time {
+			t.Errorf("%q: expected time range shown = %t, got %t", tc.Durations, tc.Time, act)
+		}
+		if !strings.Contains(buf.String(), `<time itemprop="startDate" datetime="2023-10-16T22:30:00">22:30</time>`) {
+			t.Errorf("%q: expected upcoming events to keep the start time", tc.Durations)
+		}
+	}
+}
+
+func TestSeconds(t *testing.T) {
+	s := &Schedule{
+		Start: fgDate(2023, 10, 16),
+		End:   fgDate(2023, 10, 22),
+		Activities: []Activity{
+			{Name: "A", Locations: []Location{{Name: "X", Instances: []Instance{
+				{Time: fusiongo.TimeRange{Start: fusiongo.Time{Hour: 7, Minute: 30, Second: 15}, End: fusiongo.Time{Hour: 8}}, Days: days(time.Monday)},
+			}}}},
+		},
+	}
+	for _, tc := range []struct {
+		Seconds bool
+		Text    string
+		HTML    string
+	}{
+		{false, " 07:30 - 08:00\n", `<time datetime="07:30:15">07:30</time>`},
+		{true, " 07:30:15 - 08:00\n", `<time datetime="07:30:15">07:30:15</time>`},
+	} {
+		var buf bytes.Buffer
+		if err := RenderText(&buf, &Options{Seconds: tc.Seconds}, s); err != nil {
+			t.Fatalf("%t: render text: %v", tc.Seconds, err)
+		}
+		if !strings.Contains(buf.String(), tc.Text) {
+			t.Errorf("%t: expected text output to contain %q:\n%s", tc.Seconds, tc.Text, buf.String())
+		}
+		buf.Reset()
+		if err := Render(&buf, &Options{Seconds: tc.Seconds}, s); err != nil {
+			t.Fatalf("%t: render: %v", tc.Seconds, err)
+		}
+		if !strings.Contains(buf.String(), tc.HTML) {
+			t.Errorf("%t: expected html output to contain %q", tc.Seconds, tc.HTML)
+		}
+	}
+}
+
+func TestWeeks(t *testing.T) {
+	s := &Schedule{
+		Start: fgDate(2023, 10, 17), // Tuesday
+		End:   fgDate(2023, 10, 28), // Saturday
+		Activities: []Activity{
+			{Name: "A", Locations: []Location{{Name: "X", Instances: []Instance{
+				{Time: fgTimeRange(12, 0, 13, 0), Days: days(time.Tuesday)},
+				{Time: fgTimeRange(8, 0, 9, 0), Days: days(time.Tuesday), Exceptions: []Exception{
+					{Date: fgDate(2023, 10, 24), Cancelled: true},
+				}},
+			}}}},
+			{Name: "B", Locations: []Location{{Name: "X", Instances: []Instance{
+				{Time: fgTimeRange(8, 0, 9, 0), Days: days(time.Friday), Exceptions: []Exception{
+					{Date: fgDate(2023, 10, 27), OnlyOnWeekday: true},
+				}},
+			}}}},
+		},
+	}
+	ws := weeks(s)
+	if len(ws) != 2 {
+		t.Fatalf("expected 2 weeks, got %d", len(ws))
+	}
+	if ws[0].Start != fgDate(2023, 10, 15) || ws[0].End != fgDate(2023, 10, 21) || ws[1].Start != fgDate(2023, 10, 22) {
+		t.Errorf("incorrect week ranges")
+	}
+	if len(ws[0].Activities) != 1 || len(ws[1].Activities) != 2 {
+		t.Fatalf("incorrect activities")
+	}
+	for _, w := range ws {
+		rows := w.Activities[0].Locations[0].Rows
+		if len(rows) != 2 {
+			t.Fatalf("week %s: expected 2 rows, got %d", w.Start, len(rows))
+		}
+		if x := rows[0][time.Tuesday]; x == nil || x.Time != fgTimeRange(8, 0, 9, 0) || x.Cancelled != (w.Start == fgDate(2023, 10, 22)) {
+			t.Errorf("week %s: incorrect first tuesday occurrence %v", w.Start, x)
+		}
+		if x := rows[1][time.Tuesday]; x == nil || x.Time != fgTimeRange(12, 0, 13, 0) {
+			t.Errorf("week %s: incorrect second tuesday occurrence %v", w.Start, x)
+		}
+	}
+}
+
+func TestComputeStats(t *testing.T) {
+	s := &Schedule{
+		Start: fgDate(2023, 10, 15), // Sunday
+		End:   fgDate(2023, 10, 28),
+		Activities: []Activity{
+			{Name: "A", Locations: []Location{{Name: "X", Instances: []Instance{
+				{Time: fgTimeRange(8, 0, 9, 30), Days: days(time.Monday, time.Wednesday), Exceptions: []Exception{
+					{Date: fgDate(2023, 10, 16), Cancelled: true},
+					{Date: fgDate(2023, 10, 25), Excluded: true},
+				}},
+			}}}},
+			{Name: "B", Locations: []Location{
+				{Name: "X", Instances: []Instance{
+					{Time: fgTimeRange(10, 0, 11, 0), Days: days(time.Wednesday)},
+				}},
+				{Name: "Y", Instances: []Instance{
+					{Time: fgTimeRange(10, 0, 10, 45), Days: days(time.Friday)},
+				}},
+			}},
+		},
+	}
+	st := ComputeStats(s)
+	if act, exp := [4]int{st.Weekly, st.Occurrences, st.Cancelled, st.Excluded}, [4]int{4, 6, 1, 1}; act != exp {
+		t.Errorf("expected weekly/occurrences/cancelled/excluded %v, got %v", exp, act)
+	}
+	if act, exp := st.Weekdays, [7]int{0, 1, 0, 3, 0, 2, 0}; act != exp {
+		t.Errorf("expected weekdays %v, got %v", exp, act)
+	}
+	if st.Busiest != time.Wednesday {
+		t.Errorf("expected busiest weekday to be wednesday, got %s", st.Busiest)
+	}
+	if act, exp := st.Activities, []ActivityStats{{"A", "", 2, 1}, {"B", "", 4, 0}}; !slices.Equal(act, exp) {
+		t.Errorf("expected activities %v, got %v", exp, act)
+	}
+	if act, exp := st.Locations, []LocationStats{{"X", 4, 90*2 + 60*2}, {"Y", 2, 45 * 2}}; !slices.Equal(act, exp) {
+		t.Errorf("expected locations %v, got %v", exp, act)
+	}
+}
+
+func TestLocationSubtotal(t *testing.T) {
+	s := &Schedule{
+		Start: fgDate(2023, 10, 16), // Monday
+		End:   fgDate(2023, 10, 29),
+		Activities: []Activity{
+			{Name: "A", Locations: []Location{{Name: "X", Instances: []Instance{
+				{Time: fgTimeRange(8, 0, 9, 15), Days: days(time.Monday, time.Wednesday), Exceptions: []Exception{
+					{Date: fgDate(2023, 10, 18), Cancelled: true},
+					{Date: fgDate(2023, 10, 25), Excluded: true},
+				}},
+				{Time: fgTimeRange(23, 0, 1, 0), Days: days(time.Friday)},
+			}}}},
+			{Name: "B", Locations: []Location{{Name: "Y", Instances: []Instance{
+				{Time: fgTimeRange(8, 0, 9, 0), Days: days(time.Monday), Exceptions: []Exception{
+					{Date: fgDate(2023, 10, 16), Cancelled: true},
+					{Date: fgDate(2023, 10, 23), Cancelled: true},
+				}},
+			}}}},
+		},
+	}
+	if act, exp := locationSubtotal(s, locationGroups(s.Activities[0], false)[0]), (subtotal{4, 75*2 + 120*2, 14}); act == nil || *act != exp {
+		t.Errorf("expected %#v, got %#v", exp, act)
+	} else if act, exp := act.String(), "2 events, 3 h 15 min"; act != exp {
+		t.Errorf("expected %q, got %q", exp, act)
+	}
+	if act := locationSubtotal(s, locationGroups(s.Activities[1], false)[0]); act != nil {
+		t.Errorf("expected no subtotal for location without events, got %v", act)
+	}
+	for _, tc := range []struct {
+		Subtotal subtotal
+		String   string
+	}{
+		{subtotal{4, 390, 7}, "4 events, 6 h 30 min"},
+		{subtotal{4, 390, 3}, "9.3 events, 15 h 10 min"},
+		{subtotal{3, 180, 21}, "1 event, 1 h"},
+		{subtotal{5, 300, 14}, "2.5 events, 2 h 30 min"},
+		{subtotal{1, 45, 28}, "0.3 events, 11 min"},
+	} {
+		if act := tc.Subtotal.String(); act != tc.String {
+			t.Errorf("%#v: expected %q, got %q", tc.Subtotal, tc.String, act)
+		}
+	}
+}
+
+func TestPrepareActivitySort(t *testing.T) {
+	ais := []fusiongo.ActivityInstance{
+		{Time: fgDateTimeRange(2023, 1, 2, 18, 0, 19, 0), Activity: "A", Location: "X"},
+		{Time: fgDateTimeRange(2023, 1, 3, 9, 0, 10, 0), Activity: "B", Location: "Y"},
+		{Time: fgDateTimeRange(2023, 1, 4, 12, 0, 13, 0), Activity: "B", Location: "X"},
+		{Time: fgDateTimeRange(2023, 1, 5, 7, 0, 8, 0), Activity: "C", Location: "Z"},
+		{Time: fgDateTimeRange(2023, 1, 6, 20, 0, 21, 0), Activity: "C", Location: "Y"},
+	}
+	updated := fgDateTime(2023, 1, 1, 0, 0, 0).In(time.Local)
+	for _, tc := range []struct {
+		Sort       ActivitySort
+		Activities []string
+		Locations  []string // of B
+	}{
+		{ActivitySortName, []string{"A", "B", "C"}, []string{"X", "Y"}},
+		{ActivitySortTime, []string{"C", "B", "A"}, []string{"Y", "X"}},
+		{"invalid", nil, nil},
+	} {
+		s, err := Prepare(&fusiongo.Schedule{Updated: updated, Activities: ais}, &fusiongo.Notifications{}, nil, &PrepareOptions{ActivitySort: tc.Sort})
+		if tc.Activities == nil {
+			if err == nil {
+				t.Errorf("%q: expected error", tc.Sort)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%q: prepare: %v", tc.Sort, err)
+		}
+		var act, loc []string
+		for _, a := range s.Activities {
+			act = append(act, a.Name)
+			if a.Name == "B" {
+				for _, l := range a.Locations {
+					loc = append(loc, l.Name)
+				}
+			}
+		}
+		if !slices.Equal(act, tc.Activities) {
+			t.Errorf("%q: expected activities %q, got %q", tc.Sort, tc.Activities, act)
+		}
+		if !slices.Equal(loc, tc.Locations) {
+			t.Errorf("%q: expected locations %q, got %q", tc.Sort, tc.Locations, loc)
+		}
+	}
+}
+
+func TestLocationGroups(t *testing.T) {
+	loc := func(name string, ds ...[7]bool) Location {
+		l := Location{Name: name}
+		for i, d := range ds {
+			l.Instances = append(l.Instances, Instance{Time: fgTimeRange(8+i, 0, 9+i, 0), Days: d})
+		}
+		return l
+	}
+	a := Activity{Name: "A", Locations: []Location{
+		loc("W", days(time.Monday), days(time.Monday)),
+		loc("X", days(time.Monday)),
+		loc("Y", days(time.Monday)),
+		loc("Z", days(time.Tuesday)),
+		loc("V", days(time.Monday, time.Tuesday)),
+	}}
+	for _, tc := range []struct {
+		Name     string
+		Activity Activity
+		Compact  bool
+		Groups   []string // "*" for the other group
+		Rows     []int
+	}{
+		{"default", a, false, []string{"W", "X", "Y", "Z", "V"}, []int{2, 1, 1, 1, 1}},
+		{"compact", a, true, []string{"W", "V", "*"}, []int{2, 1, 2}},
+		{"compact single", Activity{Name: "A", Locations: a.Locations[:2]}, true, []string{"W", "X"}, []int{2, 1}},
+	} {
+		var groups []string
+		var rows []int
+		for _, g := range locationGroups(tc.Activity, tc.Compact) {
+			if g.Other {
+				groups = append(groups, "*")
+			} else {
+				groups = append(groups, g.Name)
+			}
+			rows = append(rows, len(g.Rows))
+		}
+		if !slices.Equal(groups, tc.Groups) || !slices.Equal(rows, tc.Rows) {
+			t.Errorf("%s: expected groups %q with rows %d, got %q with %d", tc.Name, tc.Groups, tc.Rows, groups, rows)
+		}
+
+		var buf bytes.Buffer
+		if err := Render(&buf, &Options{Compact: tc.Compact}, &Schedule{
+			Start:      fgDate(2023, 1, 1),
+			End:        fgDate(2023, 1, 7),
+			Activities: []Activity{tc.Activity},
+		}); err != nil {
+			t.Fatalf("%s: render: %v", tc.Name, err)
+		}
+		if act, exp := strings.Contains(buf.String(), ">Other times</th>"), slices.Contains(tc.Groups, "*"); act != exp {
+			t.Errorf("%s: expected other times group = %t, got %t", tc.Name, exp, act)
+		}
+	}
+}
+
+func TestQRCodeSVG(t *testing.T) {
+	a, err := qrCodeSVG("https://example.com/a")
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	if !strings.HasPrefix(string(a), "<svg ") {
+		t.Errorf("expected an svg, got %q", a)
+	}
+	for i := 0; i < qrSVG.Size*2; i++ {
+		if _, err := qrCodeSVG("https://example.com/" + strconv.Itoa(i)); err != nil {
+			t.Fatalf("generate: %v", err)
+		}
+	}
+	if n := qrSVG.Len(); n != qrSVG.Size {
+		t.Errorf("expected the cache to be limited to %d codes, got %d", qrSVG.Size, n)
+	}
+	if b, _ := qrCodeSVG("https://example.com/a"); b != a {
+		t.Errorf("expected the same code after it was evicted")
+	}
+}
+
+func TestSanitizeHTML(t *testing.T) {
+	for _, tc := range []struct {
+		In, Out string
+	}{
+		{`plain text`, `plain text`},
+		{`a < b & c`, `a &lt; b &amp; c`},
+		{`<b>bold</b> and <i>italic</i>`, `<b>bold</b> and <i>italic</i>`},
+		{`<a href="https://example.com/?a=1&amp;b=2" onclick="alert(1)">link</a>`, `<a href="https://example.com/?a=1&amp;b=2">link</a>`},
+		{`<a href="javascript:alert(1)">link</a>`, `<a>link</a>`},
+		{`<span style="color:var(--md-ref-palette-error50)">warning</span>`, `<span style="color:var(--md-ref-palette-error50)">warning</span>`},
+		{`<span style="color: red; background: url(x)">x</span>`, `<span style="color:red">x</span>`},
+		{`<span style="color: expression(alert(1))">x</span>`, `<span>x</span>`},
+		{`<script>alert(1)</script>text`, `text`},
+		{`<div><img src=x onerror=alert(1)>text</div>`, `text`},
+		{`<b>unclosed <i>nested`, `<b>unclosed <i>nested</i></b>`},
+		{`<b><i>misnested</b></i>`, `<b><i>misnested</i></b>`},
+		{`line<br>break<br/>`, `line<br>break<br>`},
+	} {
+		if act := string(SanitizeHTML(tc.In)); act != tc.Out {
+			t.Errorf("sanitize %q: expected %q, got %q", tc.In, tc.Out, act)
+		}
+	}
+}
+
+func TestMarkdownHTML(t *testing.T) {
+	for _, tc := range []struct {
+		In, Out string
+	}{
+		{`plain text`, `plain text`},
+		{`**bold** and _italic_`, `<strong>bold</strong> and <em>italic</em>`},
+		{`see [the website](https://example.com/)`, `see <a href="https://example.com/">the website</a>`},
+		{`[link](javascript:alert(1))`, `<a href="">link</a>`},
+		{"<script>alert(1)</script>\n\ntext", `text`},
+		{"first\n\nsecond", `first<br>second`},
+		{"- one\n- two", `one<br>two`},
+	} {
+		if act := string(MarkdownHTML(tc.In)); act != tc.Out {
+			t.Errorf("markdown %q: expected %q, got %q", tc.In, tc.Out, act)
+		}
+	}
+}
+
 func fgDate(year int, month time.Month, day int) fusiongo.Date {
 	return fusiongo.Date{
 		Year:  year,
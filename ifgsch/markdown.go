@@ -0,0 +1,29 @@
+package ifgsch
+
+import (
+	"bytes"
+	"html/template"
+	"regexp"
+	"strings"
+
+	"github.com/yuin/goldmark"
+)
+
+// markdownBlockEnd matches the end of block elements which are replaced with
+// line breaks since the output is inline.
+var markdownBlockEnd = regexp.MustCompile(`</(?:p|li|h[1-6]|blockquote|pre)>\s*`)
+
+// MarkdownHTML renders s as Markdown, then sanitizes it with [SanitizeHTML].
+// Raw HTML is omitted, and since the result is used inline, block elements
+// are replaced with line breaks.
+func MarkdownHTML(s string) template.HTML {
+	var b bytes.Buffer
+	if err := goldmark.Convert([]byte(s), &b); err != nil {
+		return template.HTML(template.HTMLEscapeString(s))
+	}
+	h := strings.TrimSpace(string(SanitizeHTML(markdownBlockEnd.ReplaceAllString(b.String(), "<br>"))))
+	for strings.HasSuffix(h, "<br>") {
+		h = strings.TrimSpace(strings.TrimSuffix(h, "<br>"))
+	}
+	return template.HTML(h)
+}
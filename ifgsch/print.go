@@ -0,0 +1,16 @@
+package ifgsch
+
+import (
+	_ "embed"
+	"html/template"
+)
+
+// printScript expands collapsed details while printing, for browsers which
+// don't support styling ::details-content.
+//
+//go:embed print.js
+var printScript string
+
+func printJS() template.JS {
+	return template.JS(printScript)
+}
@@ -0,0 +1,15 @@
+(function () {
+	"use strict";
+	var opened = [];
+	window.addEventListener("beforeprint", function () {
+		document.querySelectorAll("details:not([open])").forEach(function (el) {
+			el.open = true;
+			opened.push(el);
+		});
+	});
+	window.addEventListener("afterprint", function () {
+		opened.splice(0).forEach(function (el) {
+			el.open = false;
+		});
+	});
+})();
@@ -0,0 +1,56 @@
+package ifgsch
+
+import (
+	"fmt"
+	"html/template"
+	"strconv"
+	"strings"
+
+	"github.com/pgaskin/innosoftfusiongo-schedule/internal/lru"
+	"rsc.io/qr"
+)
+
+// qrSVG caches the generated QR codes by URL. Like the palette caches, it is
+// bounded since ifgsch can be used with arbitrary options.
+var qrSVG = lru.Cache[string, template.HTML]{Size: 64}
+
+// qrCodeSVG generates an inline SVG QR code for the provided URL. The result
+// is cached.
+func qrCodeSVG(url string) (template.HTML, error) {
+	if v, ok := qrSVG.Get(url); ok {
+		return v, nil
+	}
+	c, err := qr.Encode(url, qr.M)
+	if err != nil {
+		return "", fmt.Errorf("generate qr code for %q: %w", url, err)
+	}
+	const quiet = 4
+	var b strings.Builder
+	b.WriteString(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 `)
+	b.WriteString(strconv.Itoa(c.Size + quiet*2))
+	b.WriteByte(' ')
+	b.WriteString(strconv.Itoa(c.Size + quiet*2))
+	b.WriteString(`" shape-rendering="crispEdges" role="img" aria-label="QR code"><path fill="#fff" d="M0 0h`)
+	b.WriteString(strconv.Itoa(c.Size + quiet*2))
+	b.WriteString(`v`)
+	b.WriteString(strconv.Itoa(c.Size + quiet*2))
+	b.WriteString(`H0z"/><path fill="#000" d="`)
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; {
+			if !c.Black(x, y) {
+				x++
+				continue
+			}
+			n := 1
+			for c.Black(x+n, y) {
+				n++
+			}
+			b.WriteString("M" + strconv.Itoa(x+quiet) + " " + strconv.Itoa(y+quiet) + "h" + strconv.Itoa(n) + "v1h-" + strconv.Itoa(n) + "z")
+			x += n
+		}
+	}
+	b.WriteString(`"/></svg>`)
+	v := template.HTML(b.String())
+	qrSVG.Add(url, v)
+	return v, nil
+}
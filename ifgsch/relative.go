@@ -0,0 +1,13 @@
+package ifgsch
+
+import (
+	_ "embed"
+	"html/template"
+)
+
+//go:embed relative.js
+var relativeTimeScript string
+
+func relativeTimeJS() template.JS {
+	return template.JS(relativeTimeScript)
+}
@@ -0,0 +1,24 @@
+(function () {
+	"use strict";
+	var fmt = new Intl.RelativeTimeFormat(undefined, { numeric: "auto" });
+	var units = [["day", 86400], ["hour", 3600], ["minute", 60], ["second", 1]];
+	function update() {
+		document.querySelectorAll("time[data-relative]").forEach(function (el) {
+			var s = (Date.parse(el.dateTime) - Date.now()) / 1000;
+			if (isNaN(s)) {
+				return;
+			}
+			if (!el.title) {
+				el.title = el.textContent;
+			}
+			for (var i = 0; i < units.length; i++) {
+				if (Math.abs(s) >= units[i][1] || i == units.length - 1) {
+					el.textContent = fmt.format(Math.round(s / units[i][1]), units[i][0]);
+					break;
+				}
+			}
+		});
+	}
+	update();
+	setInterval(update, 30000);
+})();
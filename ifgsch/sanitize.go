@@ -0,0 +1,171 @@
+package ifgsch
+
+import (
+	"html/template"
+	"net/url"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// sanitizeAllow contains the allowed elements and their allowed attributes.
+var sanitizeAllow = map[atom.Atom][]string{
+	atom.A:      {"href", "title"},
+	atom.Abbr:   {"title"},
+	atom.B:      nil,
+	atom.Br:     nil,
+	atom.Code:   nil,
+	atom.Em:     nil,
+	atom.I:      nil,
+	atom.S:      nil,
+	atom.Small:  nil,
+	atom.Span:   {"style", "title"},
+	atom.Strong: nil,
+	atom.Time:   {"datetime"},
+	atom.U:      nil,
+}
+
+// sanitizeStyle contains the allowed CSS properties for style attributes.
+var sanitizeStyle = []string{
+	"color",
+	"background-color",
+	"font-style",
+	"font-weight",
+	"text-decoration",
+	"white-space",
+}
+
+// SanitizeHTML sanitizes an HTML fragment, only keeping basic inline
+// formatting elements and links. Disallowed elements are removed (but their
+// text is kept, except for the contents of script and style elements), and
+// disallowed attributes are dropped. Unclosed elements are closed at the end.
+func SanitizeHTML(s string) template.HTML {
+	var (
+		b     strings.Builder
+		open  []atom.Atom
+		skip  atom.Atom
+		token = html.NewTokenizerFragment(strings.NewReader(s), "p")
+	)
+	for {
+		tt := token.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+		t := token.Token()
+		if skip != 0 {
+			if tt == html.EndTagToken && t.DataAtom == skip {
+				skip = 0
+			}
+			continue
+		}
+		switch tt {
+		case html.TextToken:
+			b.WriteString(html.EscapeString(t.Data))
+		case html.StartTagToken, html.SelfClosingTagToken:
+			if t.DataAtom == atom.Script || t.DataAtom == atom.Style {
+				if tt == html.StartTagToken {
+					skip = t.DataAtom
+				}
+				continue
+			}
+			attrs, ok := sanitizeAllow[t.DataAtom]
+			if !ok {
+				continue
+			}
+			b.WriteByte('<')
+			b.WriteString(t.DataAtom.String())
+			for _, a := range t.Attr {
+				if a.Namespace != "" || !slices.Contains(attrs, a.Key) {
+					continue
+				}
+				v, ok := sanitizeAttr(a.Key, a.Val)
+				if !ok {
+					continue
+				}
+				b.WriteByte(' ')
+				b.WriteString(a.Key)
+				b.WriteString(`="`)
+				b.WriteString(html.EscapeString(v))
+				b.WriteByte('"')
+			}
+			b.WriteByte('>')
+			if t.DataAtom != atom.Br && tt == html.StartTagToken {
+				open = append(open, t.DataAtom)
+			}
+		case html.EndTagToken:
+			for i := len(open) - 1; i >= 0; i-- {
+				if open[i] == t.DataAtom {
+					for j := len(open) - 1; j >= i; j-- {
+						b.WriteString("</" + open[j].String() + ">")
+					}
+					open = open[:i]
+					break
+				}
+			}
+		}
+	}
+	for i := len(open) - 1; i >= 0; i-- {
+		b.WriteString("</" + open[i].String() + ">")
+	}
+	return template.HTML(b.String())
+}
+
+// sanitizeAttr checks and cleans up an allowed attribute value.
+func sanitizeAttr(key, val string) (string, bool) {
+	switch key {
+	case "href":
+		u, err := url.Parse(strings.TrimSpace(val))
+		if err != nil {
+			return "", false
+		}
+		switch strings.ToLower(u.Scheme) {
+		case "", "http", "https", "mailto", "tel":
+			return u.String(), true
+		}
+		return "", false
+	case "style":
+		var decls []string
+		for _, decl := range strings.Split(val, ";") {
+			k, v, ok := strings.Cut(decl, ":")
+			if !ok {
+				continue
+			}
+			k, v = strings.ToLower(strings.TrimSpace(k)), strings.TrimSpace(v)
+			if !slices.Contains(sanitizeStyle, k) || !sanitizeStyleValue(v) {
+				continue
+			}
+			decls = append(decls, k+":"+v)
+		}
+		return strings.Join(decls, ";"), len(decls) != 0
+	}
+	return val, true
+}
+
+// sanitizeStyleValue checks whether v is a simple CSS value (keywords, colors,
+// numbers, and var/rgb/hsl functions).
+func sanitizeStyleValue(v string) bool {
+	if v == "" {
+		return false
+	}
+	for _, c := range v {
+		switch {
+		case 'a' <= c && c <= 'z':
+		case 'A' <= c && c <= 'Z':
+		case '0' <= c && c <= '9':
+		case strings.ContainsRune(" #%.,-()", c):
+		default:
+			return false
+		}
+	}
+	for i := strings.IndexByte(v, '('); i != -1; i = strings.IndexByte(v, '(') {
+		switch fn := strings.ToLower(v[strings.LastIndexAny(v[:i], " ,(")+1 : i]); fn {
+		case "var", "rgb", "rgba", "hsl", "hsla":
+		default:
+			return false
+		}
+		v = v[i+1:]
+	}
+	return true
+}
@@ -0,0 +1,94 @@
+package ifgsch
+
+import (
+	"time"
+
+	"github.com/pgaskin/innosoftfusiongo-ical/fusiongo"
+)
+
+// Stats contains aggregate numbers about the events in a schedule.
+type Stats struct {
+	Weekly      int // number of weekly recurring time slots
+	Occurrences int // number of events in the schedule range, excluding cancelled ones
+	Cancelled   int // number of cancelled events in the schedule range
+	Excluded    int // number of weekly events which don't occur on a date in the schedule range
+	Weekdays    [7]int
+	Busiest     time.Weekday // weekday with the most occurrences
+	Activities  []ActivityStats
+	Locations   []LocationStats
+}
+
+// ActivityStats contains aggregate numbers about the events for an activity.
+type ActivityStats struct {
+	Name        string
+	CategoryID  string // if set in the schedule
+	Occurrences int
+	Cancelled   int
+}
+
+// LocationStats contains aggregate numbers about the events at a location.
+type LocationStats struct {
+	Name        string
+	Occurrences int
+	Minutes     int // total duration of the occurrences
+}
+
+// ComputeStats computes statistics for the events in s.
+func ComputeStats(s *Schedule) *Stats {
+	var (
+		st  Stats
+		loc = map[string]int{}
+	)
+	for _, activity := range s.Activities {
+		as := ActivityStats{Name: activity.Name, CategoryID: activity.CategoryID}
+		for _, location := range activity.Locations {
+			li, ok := loc[location.Name]
+			if !ok {
+				li = len(st.Locations)
+				loc[location.Name] = li
+				st.Locations = append(st.Locations, LocationStats{Name: location.Name})
+			}
+			for _, instance := range location.Instances {
+				for _, b := range instance.Days {
+					if b {
+						st.Weekly++
+					}
+				}
+				for _, x := range instance.Exceptions {
+					if x.Excluded {
+						st.Excluded++
+					}
+				}
+				Expand(s, instance, func(t fusiongo.DateTimeRange, cancelled, _ bool) {
+					if cancelled {
+						st.Cancelled++
+						as.Cancelled++
+						return
+					}
+					st.Occurrences++
+					st.Weekdays[t.Date.Weekday()]++
+					as.Occurrences++
+					st.Locations[li].Occurrences++
+					st.Locations[li].Minutes += minutes(t.TimeRange)
+				})
+			}
+		}
+		st.Activities = append(st.Activities, as)
+	}
+	for wd, n := range st.Weekdays {
+		if n > st.Weekdays[st.Busiest] {
+			st.Busiest = time.Weekday(wd)
+		}
+	}
+	return &st
+}
+
+// minutes returns the duration of t in minutes, assuming it ends on the next
+// day if it ends before it starts.
+func minutes(t fusiongo.TimeRange) int {
+	m := (t.End.Hour*60 + t.End.Minute) - (t.Start.Hour*60 + t.Start.Minute)
+	if m < 0 {
+		m += 24 * 60
+	}
+	return m
+}
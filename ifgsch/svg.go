@@ -0,0 +1,188 @@
+package ifgsch
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"io"
+	"strings"
+	"time"
+)
+
+// svg layout, in px
+const (
+	svgMargin     = 16
+	svgLabelWidth = 180
+	svgColWidth   = 112
+	svgLine       = 16
+	svgPad        = 6
+	svgHeader     = 28
+)
+
+// RenderSVG renders the schedule grid as a standalone SVG image with the
+// provided options. Only the title, date range, weekly instances, and update
+// time are included.
+func RenderSVG(w io.Writer, o *Options, s *Schedule) error {
+	if o == nil {
+		return fmt.Errorf("no options provided")
+	}
+	if s == nil {
+		return fmt.Errorf("no schedule provided")
+	}
+	pal, err := palette(o)
+	if err != nil {
+		return err
+	}
+	if o.ZeroDuration == ZeroDurationHide {
+		s = withoutZeroDuration(s)
+	}
+	if s, err = LimitActivities(o, s); err != nil {
+		return err
+	}
+	var (
+		b     = bufio.NewWriter(w)
+		sep   = o.TimeSeparator
+		dtFmt = o.DateTimeFormat
+		width = svgMargin*2 + svgLabelWidth + svgColWidth*7
+		y     = svgMargin
+		body  strings.Builder
+	)
+	if sep == "" {
+		sep = " - "
+	}
+	if dtFmt == "" {
+		dtFmt = "2006-01-02 15:04:05 MST"
+	}
+	text := func(x, y int, class, s string) {
+		fmt.Fprintf(&body, `<text x="%d" y="%d" class="%s">%s</text>`, x, y, class, html.EscapeString(s))
+	}
+	rect := func(x, y, w, h int, class string) {
+		fmt.Fprintf(&body, `<rect x="%d" y="%d" width="%d" height="%d" class="%s"/>`, x, y, w, h, class)
+	}
+
+	title := o.Title
+	if title == "" {
+		title = "Schedule"
+	}
+	y += 24
+	text(svgMargin, y, "title", title)
+	y += svgLine + svgPad
+	text(svgMargin, y, "range", formatRange(o, s.Start, s.End))
+	y += svgPad * 2
+
+	rect(svgMargin, y, width-svgMargin*2, svgHeader, "header")
+	for wd := 0; wd < 7; wd++ {
+		text(svgMargin+svgLabelWidth+svgColWidth*wd+svgPad, y+svgHeader-svgPad*3/2, "weekday", time.Weekday(wd).String())
+	}
+	y += svgHeader
+
+	for _, a := range s.Activities {
+		name := a.Name
+		if o.Categories && a.Category != "" {
+			name += " (" + a.Category + ")"
+		}
+		rect(svgMargin, y, width-svgMargin*2, svgHeader, "activity")
+		text(svgMargin+svgPad, y+svgHeader-svgPad*3/2, "activity", name)
+		y += svgHeader
+
+		for _, c := range locationGroups(a, o.Compact) {
+			top := y
+			for _, row := range c.Rows {
+				var (
+					cells [7][][2]string // class, text
+					lines = 1
+				)
+				for wd, x := range row {
+					if x == nil {
+						if o.EmptyCells {
+							cells[wd] = append(cells[wd], [2]string{"placeholder", "—"})
+						}
+						continue
+					}
+					if c.Other {
+						cells[wd] = append(cells[wd], [2]string{"location", x.Location.Name})
+					}
+					if pointTime(o.ZeroDuration, x.Time) {
+						cells[wd] = append(cells[wd], [2]string{"time", formatTime(o.Seconds, x.Time.Start)})
+					} else {
+						cells[wd] = append(cells[wd], [2]string{"time", formatTime(o.Seconds, x.Time.Start) + sep + formatTime(o.Seconds, x.Time.End)})
+					}
+					if o.ExceptionDetail != ExceptionDetailNone {
+						if es := weekdayExceptions(x.Instance, time.Weekday(wd)); len(es) == 1 {
+							cells[wd] = append(cells[wd], [2]string{"exception", "1 exception"})
+						} else if len(es) != 0 {
+							cells[wd] = append(cells[wd], [2]string{"exception", fmt.Sprintf("%d exceptions", len(es))})
+						}
+					}
+					lines = max(lines, len(cells[wd]))
+				}
+				for wd, ls := range cells {
+					for i, l := range ls {
+						text(svgMargin+svgLabelWidth+svgColWidth*wd+svgPad, y+svgPad+svgLine*(i+1)-4, l[0], l[1])
+					}
+				}
+				y += svgPad*2 + svgLine*lines
+			}
+			label := c.Name
+			if c.Other {
+				label = "Other times"
+			} else if c.Virtual {
+				label += " (online)"
+			}
+			text(svgMargin+svgPad, top+svgPad+svgLine-4, "location", label)
+			fmt.Fprintf(&body, `<path d="M%d %dH%d" class="line"/>`, svgMargin, y, width-svgMargin)
+		}
+	}
+	if len(s.Activities) == 0 {
+		y += svgLine + svgPad
+		text(svgMargin+svgPad, y, "range", "No scheduled events.")
+	}
+
+	y += svgLine + svgPad*2
+	text(svgMargin, y, "footer", "Updated "+s.Updated.Local().Format(dtFmt)+".")
+	y += svgMargin
+
+	fmt.Fprintf(b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`, width, y, width, y)
+	fmt.Fprintf(b, `<style>`)
+	fmt.Fprintf(b, `text{font-family:system-ui,-apple-system,'Segoe UI',Roboto,sans-serif;font-size:13px;fill:%s}`, pal["neutral10"])
+	fmt.Fprintf(b, `.title{font-size:24px;font-weight:bold}`)
+	fmt.Fprintf(b, `.range,.footer{fill:%s}`, pal["neutral-variant30"])
+	fmt.Fprintf(b, `.footer{font-size:11px}`)
+	fmt.Fprintf(b, `rect.header{fill:%s}`, pal["primary40"])
+	fmt.Fprintf(b, `text.weekday{font-weight:bold;fill:%s}`, pal["primary100"])
+	fmt.Fprintf(b, `rect.activity{fill:%s}`, pal["primary90"])
+	fmt.Fprintf(b, `text.activity{font-weight:bold;fill:%s}`, pal["primary10"])
+	fmt.Fprintf(b, `text.location{fill:%s}`, pal["neutral-variant30"])
+	fmt.Fprintf(b, `text.exception{font-size:11px;fill:%s}`, pal["tertiary40"])
+	fmt.Fprintf(b, `text.placeholder{fill:%s}`, pal["neutral-variant70"])
+	fmt.Fprintf(b, `.line{stroke:%s;stroke-width:1}`, pal["neutral-variant80"])
+	fmt.Fprintf(b, `</style>`)
+	fmt.Fprintf(b, `<rect width="100%%" height="100%%" fill="%s"/>`, pal["neutral98"])
+	b.WriteString(body.String())
+	fmt.Fprintf(b, `</svg>`)
+	return b.Flush()
+}
+
+// palette gets the MD3 palette tones (e.g., "primary40") for the schedule
+// color from the generated palette CSS custom properties.
+func palette(o *Options) (map[string]string, error) {
+	css := string(o.Palette)
+	if css == "" {
+		v, err := md3PaletteCSS(o.Color)
+		if err != nil {
+			return nil, err
+		}
+		css = v
+	}
+	p := map[string]string{}
+	for _, decl := range strings.FieldsFunc(css, func(r rune) bool {
+		return r == ';' || r == '{' || r == '}'
+	}) {
+		if k, v, ok := strings.Cut(decl, ":"); ok {
+			if k, ok := strings.CutPrefix(strings.TrimSpace(k), "--md-ref-palette-"); ok {
+				p[k] = strings.TrimSpace(v)
+			}
+		}
+	}
+	return p, nil
+}
@@ -0,0 +1,170 @@
+package ifgsch
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pgaskin/innosoftfusiongo-ical/fusiongo"
+)
+
+// RenderText renders a schedule as human-readable plain text with the
+// provided options.
+func RenderText(w io.Writer, o *Options, s *Schedule) error {
+	if o == nil {
+		return fmt.Errorf("no options provided")
+	}
+	if s == nil {
+		return fmt.Errorf("no schedule provided")
+	}
+	var (
+		b       = bufio.NewWriter(w)
+		sep     = o.TimeSeparator
+		dateFmt = o.DateFormat
+		dtFmt   = o.DateTimeFormat
+	)
+	if sep == "" {
+		sep = " - "
+	}
+	if dtFmt == "" {
+		dtFmt = "2006-01-02 15:04:05 MST"
+	}
+	timeRange := func(t fusiongo.TimeRange) string {
+		if pointTime(o.ZeroDuration, t) {
+			return formatTime(o.Seconds, t.Start)
+		}
+		return formatTime(o.Seconds, t.Start) + sep + formatTime(o.Seconds, t.End)
+	}
+	if o.ZeroDuration == ZeroDurationHide {
+		s = withoutZeroDuration(s)
+	}
+	s, err := LimitActivities(o, s)
+	if err != nil {
+		return err
+	}
+
+	title := o.Title
+	if title == "" {
+		title = "Schedule"
+	}
+	fmt.Fprintf(b, "%s\n", title)
+	fmt.Fprintf(b, "%s\n", formatRange(o, s.Start, s.End))
+
+	if len(s.Activities) == 0 {
+		fmt.Fprintf(b, "\nNo scheduled events.\n")
+	}
+	for _, a := range s.Activities {
+		fmt.Fprintf(b, "\n%s", a.Name)
+		if o.Categories && a.Category != "" {
+			fmt.Fprintf(b, " (%s)", a.Category)
+		}
+		fmt.Fprintf(b, "\n")
+		for _, l := range a.Locations {
+			fmt.Fprintf(b, "  %s", l.Name)
+			if l.Virtual {
+				fmt.Fprintf(b, " (online)")
+			}
+			fmt.Fprintf(b, "\n")
+			for _, i := range l.Instances {
+				var (
+					wd []string
+					es []exceptionRun
+				)
+				for d, ok := range i.Days {
+					if ok {
+						wd = append(wd, time.Weekday(d).String()[:3])
+						es = append(es, weekdayExceptions(&i, time.Weekday(d))...)
+					}
+				}
+				slices.SortStableFunc(es, func(a, b exceptionRun) int {
+					return a.Date.Compare(b.Date)
+				})
+				fmt.Fprintf(b, "    %-27s %s\n", strings.Join(wd, " "), timeRange(i.Time))
+				switch o.ExceptionDetail {
+				case ExceptionDetailNone:
+				case ExceptionDetailSummary:
+					if len(es) == 1 {
+						fmt.Fprintf(b, "      1 exception\n")
+					} else if len(es) != 0 {
+						fmt.Fprintf(b, "      %d exceptions\n", len(es))
+					}
+				default:
+					for _, e := range es {
+						fmt.Fprintf(b, "      %s %s\n", e.Date.Weekday().String()[:3], e.Format(dateFmt, sep, o.Seconds))
+					}
+				}
+			}
+		}
+	}
+
+	if s.MoreActivities == 1 {
+		fmt.Fprintf(b, "\n1 more activity not shown.\n")
+	} else if s.MoreActivities != 0 {
+		fmt.Fprintf(b, "\n%d more activities not shown.\n", s.MoreActivities)
+	}
+
+	if len(s.Notifications) != 0 && !o.NoNotifications {
+		fmt.Fprintf(b, "\nNotifications\n")
+		for _, n := range s.Notifications {
+			if n.Sent == (fusiongo.DateTime{}) {
+				fmt.Fprintf(b, "  (no date)\n")
+			} else {
+				fmt.Fprintf(b, "  %s %s\n", n.Sent.Date, n.Sent.Time)
+			}
+			for _, line := range strings.Split(strings.TrimSpace(truncate(n.Text, o.NotificationMax)), "\n") {
+				fmt.Fprintf(b, "    %s\n", strings.TrimSpace(line))
+			}
+		}
+	}
+
+	if o.UpcomingDays > 0 {
+		days := upcoming(s, today(o, s), o.UpcomingDays, 0, nil)
+		if o.UpcomingSkip {
+			days = nonEmptyDays(days)
+		}
+		if len(days) != 0 {
+			fmt.Fprintf(b, "\nUpcoming\n")
+			for _, d := range days {
+				fmt.Fprintf(b, "  %s %s", d.Date.Weekday().String()[:3], formatShortDate(dateFmt, d.Date))
+				if c := dayCount(o.DayCounts, d); c != "" {
+					fmt.Fprintf(b, " (%s)", c)
+				}
+				fmt.Fprintf(b, "\n")
+				if o.UpcomingGroupBy == UpcomingGroupByActivity {
+					for _, g := range upcomingGroups(d.Events) {
+						fmt.Fprintf(b, "    %s\n", g.Activity)
+						for _, e := range g.Events {
+							fmt.Fprintf(b, "      %s  %s", timeRange(e.Time), e.Location)
+							if e.Cancelled {
+								fmt.Fprintf(b, " (cancelled)")
+							}
+							fmt.Fprintf(b, "\n")
+						}
+					}
+					continue
+				}
+				for _, e := range d.Events {
+					fmt.Fprintf(b, "    %s  %s, %s", timeRange(e.Time), e.Activity, e.Location)
+					if e.Cancelled {
+						fmt.Fprintf(b, " (cancelled)")
+					}
+					fmt.Fprintf(b, "\n")
+				}
+			}
+		}
+	}
+
+	fmt.Fprintf(b, "\nUpdated %s.\n", s.Updated.Local().Format(dtFmt))
+	fmt.Fprintf(b, "Modified %s.\n", s.Modified.Local().Format(dtFmt))
+	if o.Source.Name != "" {
+		if o.Source.URL != "" {
+			fmt.Fprintf(b, "Data from %s <%s>.\n", o.Source.Name, o.Source.URL)
+		} else {
+			fmt.Fprintf(b, "Data from %s.\n", o.Source.Name)
+		}
+	}
+	return b.Flush()
+}
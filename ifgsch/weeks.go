@@ -0,0 +1,97 @@
+package ifgsch
+
+import (
+	"slices"
+	"time"
+
+	"github.com/pgaskin/innosoftfusiongo-ical/fusiongo"
+)
+
+// week is a grid of the literal occurrences in a calendar week.
+type week struct {
+	Start      fusiongo.Date // Sunday
+	End        fusiongo.Date // Saturday
+	Dates      [7]fusiongo.Date
+	Activities []weekActivity
+}
+
+type weekActivity struct {
+	*Activity
+	Locations []weekLocation
+}
+
+type weekLocation struct {
+	*Location
+	Rows [][7]*weekOccurrence // by weekday, sorted by time
+}
+
+type weekOccurrence struct {
+	Time      fusiongo.TimeRange
+	Cancelled bool
+}
+
+// weeks expands the schedule into the calendar weeks (starting on Sunday)
+// containing occurrences.
+func weeks(s *Schedule) []week {
+	var (
+		ws  []week
+		idx = map[fusiongo.Date]int{} // by week start
+	)
+	for start := s.Start.AddDays(-int(s.Start.Weekday())); !s.End.Less(start); start = start.AddDays(7) {
+		w := week{
+			Start: start,
+			End:   start.AddDays(6),
+		}
+		for i := range w.Dates {
+			w.Dates[i] = start.AddDays(i)
+		}
+		idx[start] = len(ws)
+		ws = append(ws, w)
+	}
+	for ai := range s.Activities {
+		was := make([]weekActivity, len(ws))
+		for li := range s.Activities[ai].Locations {
+			var (
+				l  = &s.Activities[ai].Locations[li]
+				ds = make([][7][]*weekOccurrence, len(ws)) // by week, then weekday
+			)
+			for _, x := range l.Instances {
+				Expand(s, x, func(t fusiongo.DateTimeRange, cancelled, _ bool) {
+					wd := t.Date.Weekday()
+					if wi, ok := idx[t.Date.AddDays(-int(wd))]; ok {
+						ds[wi][wd] = append(ds[wi][wd], &weekOccurrence{
+							Time:      t.TimeRange,
+							Cancelled: cancelled,
+						})
+					}
+				})
+			}
+			for wi := range ds {
+				wl := weekLocation{Location: l}
+				for wd, os := range ds[wi] {
+					slices.SortStableFunc(os, func(a, b *weekOccurrence) int {
+						return a.Time.Compare(b.Time)
+					})
+					for i, o := range os {
+						if i == len(wl.Rows) {
+							wl.Rows = append(wl.Rows, [7]*weekOccurrence{})
+						}
+						wl.Rows[i][time.Weekday(wd)] = o
+					}
+				}
+				if len(wl.Rows) != 0 {
+					was[wi].Locations = append(was[wi].Locations, wl)
+				}
+			}
+		}
+		for wi, wa := range was {
+			if len(wa.Locations) != 0 {
+				wa.Activity = &s.Activities[ai]
+				ws[wi].Activities = append(ws[wi].Activities, wa)
+			}
+		}
+	}
+	return slices.DeleteFunc(ws, func(w week) bool {
+		return len(w.Activities) == 0
+	})
+}
@@ -0,0 +1,67 @@
+// Package lru implements a least recently used cache.
+package lru
+
+import (
+	"container/list"
+	"sync"
+)
+
+// Cache is a fixed-size cache which evicts the least recently used value. It is
+// safe for concurrent use. The zero value is usable once Size is set.
+type Cache[K comparable, V any] struct {
+	Size int // maximum number of values (if <= 0, nothing is cached)
+
+	mu sync.Mutex
+	ll list.List // of *entry, most recent first
+	m  map[K]*list.Element
+}
+
+type entry[K comparable, V any] struct {
+	k K
+	v V
+}
+
+// Get gets the value for k, marking it as recently used.
+func (c *Cache[K, V]) Get(k K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.m[k]; ok {
+		c.ll.MoveToFront(e)
+		return e.Value.(*entry[K, V]).v, true
+	}
+	var v V
+	return v, false
+}
+
+// Add sets the value for k, evicting the least recently used values if the
+// cache is full.
+func (c *Cache[K, V]) Add(k K, v V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.m[k]; ok {
+		e.Value.(*entry[K, V]).v = v
+		c.ll.MoveToFront(e)
+		return
+	}
+	if c.Size <= 0 {
+		return
+	}
+	if c.m == nil {
+		c.m = map[K]*list.Element{}
+	}
+	for c.ll.Len() >= c.Size {
+		e := c.ll.Back()
+		delete(c.m, e.Value.(*entry[K, V]).k)
+		c.ll.Remove(e)
+	}
+	c.m[k] = c.ll.PushFront(&entry[K, V]{k, v})
+}
+
+// Len returns the number of cached values.
+func (c *Cache[K, V]) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
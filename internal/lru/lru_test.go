@@ -0,0 +1,38 @@
+package lru
+
+import (
+	"testing"
+)
+
+func TestCache(t *testing.T) {
+	c := Cache[string, int]{Size: 2}
+	c.Add("a", 1)
+	c.Add("b", 2)
+	if v, ok := c.Get("a"); !ok || v != 1 {
+		t.Errorf("expected a=1, got %d (%t)", v, ok)
+	}
+	c.Add("c", 3) // evicts b since a was used more recently
+	for _, tc := range []struct {
+		Key string
+		Val int
+		OK  bool
+	}{
+		{"a", 1, true},
+		{"b", 0, false},
+		{"c", 3, true},
+	} {
+		if v, ok := c.Get(tc.Key); ok != tc.OK || v != tc.Val {
+			t.Errorf("%s: expected %d (%t), got %d (%t)", tc.Key, tc.Val, tc.OK, v, ok)
+		}
+	}
+	c.Add("c", 4)
+	if v, _ := c.Get("c"); v != 4 || c.Len() != 2 {
+		t.Errorf("expected replacing a value to not evict anything")
+	}
+
+	var z Cache[string, int]
+	z.Add("a", 1)
+	if _, ok := z.Get("a"); ok || z.Len() != 0 {
+		t.Errorf("expected nothing to be cached without a size")
+	}
+}
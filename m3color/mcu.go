@@ -72,3 +72,20 @@ func PaletteCSS(c string) (string, error) {
 		return ":root{--md-source:" + hexFromArgb(a) + ";" + v.flatMap(([x,y]) => n.map(n=>"--md-ref-palette-"+y+n+":"+hexFromArgb(t.palettes[x].tone(n)))).join(";") + "}"
 	`, c)
 }
+
+// AccentCSS generates n tonal palettes with hues evenly spaced around the
+// color wheel starting from c, as --md-ref-palette-accent{i}-{tone} CSS custom
+// properties.
+func AccentCSS(c string, n int) (string, error) {
+	if c == "" {
+		c = "6750A4" // M3 baseline color
+	}
+	return eval[string](`c, k`, `
+		const h = Hct.fromInt(argbFromHex(c))
+		const n = [10,20,30,40,80,90,95]
+		return ":root{" + Array.from({length: k}, (_, i) => {
+			const p = TonalPalette.fromHueAndChroma((h.hue + 360*i/k) % 360, Math.max(h.chroma, 36))
+			return n.map(n=>"--md-ref-palette-accent"+i+"-"+n+":"+hexFromArgb(p.tone(n))).join(";")
+		}).join(";") + "}"
+	`, c, n)
+}
@@ -1,6 +1,7 @@
 package m3color
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -41,3 +42,16 @@ func BenchmarkPaletteCSS(b *testing.B) {
 		}
 	}
 }
+
+func TestAccentCSS(t *testing.T) {
+	act, err := AccentCSS("0074a4", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(act, "--md-ref-palette-accent"); n != 3*7 {
+		t.Errorf("expected 21 properties, got %d: %s", n, act)
+	}
+	if !strings.Contains(act, "--md-ref-palette-accent2-95:#") {
+		t.Errorf("missing last accent palette: %s", act)
+	}
+}
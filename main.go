@@ -1,53 +1,107 @@
 package main
 
 import (
+	"archive/tar"
+	"archive/zip"
 	"bufio"
 	"bytes"
 	"cmp"
 	"compress/gzip"
 	"context"
 	"crypto/sha1"
+	"crypto/subtle"
+	"crypto/tls"
 	_ "embed"
 	"encoding/base64"
+	"encoding/binary"
 	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"flag"
 	"fmt"
 	"html"
 	"html/template"
 	"io"
+	"io/fs"
 	"log/slog"
+	"maps"
 	"net"
 	"net/http"
 	"net/netip"
 	"net/textproto"
+	"net/url"
 	"os"
 	"os/signal"
+	"path"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime/debug"
 	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/pgaskin/innosoftfusiongo-ical/fusiongo"
 	"github.com/pgaskin/innosoftfusiongo-schedule/ifgsch"
+	"github.com/pgaskin/innosoftfusiongo-schedule/internal/lru"
+	"github.com/pgaskin/innosoftfusiongo-schedule/m3color"
 	"github.com/pgaskin/innosoftfusiongo-schedule/memcache"
+	"golang.org/x/crypto/acme/autocert"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/text/language"
 )
 
 const EnvPrefix = "IFGSCH"
 
 var (
-	Addr        = flag.String("addr", ":8080", "Listen address")
-	LogLevel    = flag_Level("log-level", 0, "Log level (debug/info/warn/error)")
-	LogJSON     = flag.Bool("log-json", false, "Output logs as JSON")
-	CacheTime   = flag.Duration("cache-time", time.Minute*5, "Time to cache Innosoft Fusion Go data for")
-	StaleTime   = flag.Duration("stale-time", time.Hour*6, "Amount of time after cache-time to continue using stale data for if the update fails")
-	Timeout     = flag.Duration("timeout", time.Second*7, "Timeout for fetching Innosoft Fusion Go data")
-	ProxyHeader = flag.String("proxy-header", "", "Trusted header containing the remote address (e.g., X-Forwarded-For)")
-	Testdata    = flag.String("testdata", "", "Path to directory containing school%d/*.json files to test with")
-	NoGzip      = flag.Bool("no-gzip", false, "Disable automatic gzip response compression")
-	NoCache     = flag.Bool("no-cache", false, "Disable cache headers for schedule")
-	NoHome      = flag.Bool("no-home", false, "Disable the schedule list")
-	NoUpcoming  = flag.Bool("no-upcoming", false, "Don't show upcoming events")
-	Canonical   = flag.String("canonical", "", "URL base to use for generating link[rel=canonical]")
+	Addr           = flag_Listen("addr", ":8080", "Comma-separated listen addresses, optionally prefixed with tcp/, tcp4/, or tcp6/ to select the network (inferred from ip addresses if not set)")
+	LogLevel       = flag_Level("log-level", 0, "Log level (debug/info/warn/error)")
+	LogJSON        = flag.Bool("log-json", false, "Output logs as JSON")
+	CacheTime      = flag.Duration("cache-time", time.Minute*5, "Time to cache Innosoft Fusion Go data for")
+	RefreshAt      = flag_TimesOfDay("refresh-at", "Comma-separated local times of day (HH:MM) to update cached Innosoft Fusion Go data after instead of using cache-time (e.g., shortly after the data is known to be updated)")
+	StaleTime      = flag.Duration("stale-time", time.Hour*6, "Amount of time after cache-time (or refresh-at) to continue using stale data for if the update fails")
+	Timeout        = flag.Duration("timeout", time.Second*7, "Timeout for fetching Innosoft Fusion Go data")
+	FetchRetries   = flag.Int("fetch-retries", 0, "Number of times to immediately retry fetching Innosoft Fusion Go data if it fails, within the same timeout and before applying the backoff")
+	FetchRetryWait = flag.Duration("fetch-retry-delay", time.Millisecond*500, "Amount of time to wait before each fetch retry")
+	Warm           = flag.Duration("warm", 0, "Update cached Innosoft Fusion Go data in the background this long before cache-time expires so requests don't have to wait for it (0 to disable)")
+	Prefetch       = flag.Int("prefetch", 0, "Fetch and render this many schedules at a time in the background on startup so the first requests don't have to wait (0 to disable)")
+	MaxFetchSize   = flag.Int64("max-fetch-size", 0, "Maximum size in bytes of each Innosoft Fusion Go response body, failing the fetch (and continuing to use the old data) if exceeded (0 for no limit)")
+	UserAgent      = flag.String("user-agent", "", "User-Agent header to send when fetching Innosoft Fusion Go data (defaults to the Go HTTP client's)")
+	ProbeInterval  = flag.Duration("probe-interval", 0, "Maximum amount of time to wait between retries after failing to fetch Innosoft Fusion Go data, overriding the backoff (0 to always use the backoff)")
+	ProxyHeader    = flag.String("proxy-header", "", "Trusted header containing the remote address (e.g., X-Forwarded-For)")
+	ProxyIndex     = flag_ProxyIndex("proxy-index", 0, "Which value to use if the proxy header contains multiple comma-separated addresses (leftmost, rightmost, or N for the Nth from the right)")
+	ProxyTrusted   = flag_Prefixes("proxy-trusted", "Comma-separated IPs or CIDRs of trusted proxies; if set, the proxy header is only used for connections from them, and trusted addresses are skipped from the right before applying proxy-index")
+	Testdata       = flag.String("testdata", "", "Path to directory or tar/tar.gz/zip archive containing school%d/*.json files to test with")
+	NoGzip         = flag.Bool("no-gzip", false, "Disable automatic gzip response compression")
+	NoCache        = flag.Bool("no-cache", false, "Disable cache headers for schedule")
+	NoHome         = flag.Bool("no-home", false, "Disable the schedule list")
+	NoUpcoming     = flag.Bool("no-upcoming", false, "Don't show upcoming events")
+	Canonical      = flag.String("canonical", "", "URL base to use for generating link[rel=canonical]")
+	HostFallback   = flag.String("host-fallback", "", "Hostname to serve the schedules of for requests to hosts not named by any schedule host property (if not set, only schedules without one are served for them)")
+	SanitizeFooter = flag.Bool("sanitize-footer", false, "Only allow basic formatting and links in footer HTML")
+	TLSCert        = flag.String("tls-cert", "", "Path to a PEM-encoded TLS certificate to serve HTTPS with (requires tls-key)")
+	TLSKey         = flag.String("tls-key", "", "Path to a PEM-encoded TLS private key to serve HTTPS with (requires tls-cert)")
+	Autocert       = flag.String("autocert", "", "Comma-separated hostnames to serve HTTPS for using Let's Encrypt certificates (addr should be :443)")
+	AutocertCache  = flag.String("autocert-cache", "", "Directory to cache Let's Encrypt certificates in (strongly recommended with autocert)")
+	Webhook        = flag.String("webhook", "", "URL to POST JSON-encoded schedule changes to")
+	WebhookDelay   = flag.Duration("webhook-debounce", time.Minute*5, "Amount of time to wait for further schedule changes before calling the webhook")
+	History        = flag.Int("history", 0, "Keep this many of the most recent changes detected to each schedule, serving them at /path/changes and /path/changes.json (0 to disable)")
+	HistoryDir     = flag.String("history-dir", "", "Directory to save the change history in so it is kept across restarts (requires history)")
+	ColorPreview   = flag.Bool("color-preview", false, "Allow previewing schedules with a different color using ?color=RRGGBB")
+	PalettePreview = flag.Bool("palette-preview", false, "Serve the MD3 palette tones generated for a color as labeled swatches at /palette?color=RRGGBB to help choose schedule colors")
+	MaxAge         = flag.Duration("max-age", 0, "Allow clients to cache schedules for this long without revalidating (0 to always revalidate)")
+	MaxAgeStale    = flag.Duration("stale-while-revalidate", 0, "Allow clients to use a cached schedule for this long after max-age while revalidating in the background")
+	ScheduleHash   = flag.Bool("schedule-hash", false, "Send a hash of the prepared schedule data (which only changes when the events or notifications do) in the X-Schedule-Hash header")
+	DumpSchema     = flag.Bool("dump-config-schema", false, "Print the supported schedule config properties, filter keys, and filter actions as JSON, then exit")
+	ExposeVersion  = flag.Bool("expose-version", false, "Serve the build info as JSON at /version and include the version in the generator meta tag of schedule pages")
+	Robots         = flag.Bool("robots", false, "Serve a robots.txt disallowing crawling of unlisted schedules")
+	RobotsDisallow = flag.String("robots-disallow", "", "Comma-separated additional path prefixes to disallow in robots.txt (use / to disallow everything)")
+	MaxIconSize    = flag.Int("max-icon-size", 64*1024, "Maximum size in bytes of schedule icons, which are inlined into every page (0 to disable)")
 )
 
 func flag_Level(name string, value slog.Level, usage string) *slog.Level {
@@ -56,6 +110,78 @@ func flag_Level(name string, value slog.Level, usage string) *slog.Level {
 	return v
 }
 
+// flag_ProxyIndex parses leftmost (0), rightmost (1), or a positive integer
+// for the Nth value from the right.
+func flag_ProxyIndex(name string, value int, usage string) *int {
+	v := new(int)
+	*v = value
+	flag.Func(name, usage, func(s string) error {
+		switch s {
+		case "leftmost":
+			*v = 0
+		case "rightmost":
+			*v = 1
+		default:
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 1 {
+				return fmt.Errorf("expected leftmost, rightmost, or a positive integer")
+			}
+			*v = n
+		}
+		return nil
+	})
+	return v
+}
+
+func flag_Listen(name string, value string, usage string) *listenAddrs {
+	v := new(listenAddrs)
+	if err := v.Set(value); err != nil {
+		panic(err)
+	}
+	flag.Var(v, name, usage)
+	return v
+}
+
+func flag_TimesOfDay(name string, usage string) *[]timeOfDay {
+	v := new([]timeOfDay)
+	flag.Func(name, usage, func(s string) error {
+		*v = nil
+		for _, x := range strings.Split(s, ",") {
+			if x = strings.TrimSpace(x); x == "" {
+				continue
+			}
+			t, err := time.Parse("15:04", x)
+			if err != nil {
+				return fmt.Errorf("invalid time of day %q", x)
+			}
+			*v = append(*v, timeOfDay{t.Hour(), t.Minute()})
+		}
+		return nil
+	})
+	return v
+}
+
+func flag_Prefixes(name string, usage string) *[]netip.Prefix {
+	v := new([]netip.Prefix)
+	flag.Func(name, usage, func(s string) error {
+		*v = nil
+		for _, x := range strings.Split(s, ",") {
+			if x = strings.TrimSpace(x); x == "" {
+				continue
+			}
+			if p, err := netip.ParsePrefix(x); err == nil {
+				*v = append(*v, p.Masked())
+			} else if a, err := netip.ParseAddr(x); err == nil {
+				*v = append(*v, netip.PrefixFrom(a, a.BitLen()))
+			} else {
+				return fmt.Errorf("invalid ip or cidr %q", x)
+			}
+		}
+		return nil
+	})
+	return v
+}
+
 func main() {
 	// parse config
 	flag.CommandLine.Usage = func() {
@@ -80,31 +206,135 @@ func main() {
 		flag.CommandLine.Usage()
 		os.Exit(2)
 	}
+	if *DumpSchema {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(configSchema); err != nil {
+			panic(err)
+		}
+		return
+	}
+	if (*TLSCert == "") != (*TLSKey == "") {
+		fmt.Fprintf(flag.CommandLine.Output(), "tls-cert and tls-key must be specified together\n")
+		flag.CommandLine.Usage()
+		os.Exit(2)
+	}
+	if *Warm < 0 || (*Warm != 0 && *Warm >= *CacheTime) {
+		fmt.Fprintf(flag.CommandLine.Output(), "warm must be between zero and cache-time\n")
+		flag.CommandLine.Usage()
+		os.Exit(2)
+	}
+	if *FetchRetries < 0 || *FetchRetryWait < 0 {
+		fmt.Fprintf(flag.CommandLine.Output(), "fetch-retries and fetch-retry-delay must not be negative\n")
+		flag.CommandLine.Usage()
+		os.Exit(2)
+	}
+	if *History < 0 {
+		fmt.Fprintf(flag.CommandLine.Output(), "history must not be negative\n")
+		flag.CommandLine.Usage()
+		os.Exit(2)
+	}
+	if *HistoryDir != "" && *History == 0 {
+		fmt.Fprintf(flag.CommandLine.Output(), "history-dir requires history\n")
+		flag.CommandLine.Usage()
+		os.Exit(2)
+	}
+	if *RobotsDisallow != "" && !*Robots {
+		fmt.Fprintf(flag.CommandLine.Output(), "robots-disallow requires robots\n")
+		flag.CommandLine.Usage()
+		os.Exit(2)
+	}
+	if *TLSCert != "" && *Autocert != "" {
+		fmt.Fprintf(flag.CommandLine.Output(), "tls-cert and autocert are mutually exclusive\n")
+		flag.CommandLine.Usage()
+		os.Exit(2)
+	}
+	var autocertHosts []string
+	if *Autocert != "" {
+		hosts, err := parseAutocertHosts(*Autocert)
+		if err != nil {
+			fmt.Fprintf(flag.CommandLine.Output(), "invalid autocert: %v\n", err)
+			flag.CommandLine.Usage()
+			os.Exit(2)
+		}
+		autocertHosts = hosts
+	}
 
 	// setup slog if required
-	var logOptions *slog.HandlerOptions
-	if *LogLevel != 0 {
-		logOptions = &slog.HandlerOptions{
-			Level: *LogLevel,
-		}
+	logLevel := new(slog.LevelVar) // lowered if schedules override it
+	logLevel.Set(*LogLevel)
+	logOptions := &slog.HandlerOptions{
+		Level: logLevel,
 	}
+	var logHandler slog.Handler // not filtered by the global log level
 	if *LogJSON {
-		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, logOptions)))
-	} else if logOptions != nil {
-		slog.SetDefault(slog.New(slog.NewTextHandler(os.Stdout, logOptions)))
+		logHandler = slog.NewJSONHandler(os.Stdout, logOptions)
+	} else if *LogLevel != 0 {
+		logHandler = slog.NewTextHandler(os.Stdout, logOptions)
+	}
+	if logHandler != nil {
+		slog.SetDefault(slog.New(logHandler))
 	}
 
+	// log build info
+	build := getBuildInfo()
+	slog.Info("build info", "version", build.Version, "revision", build.Revision, "time", build.Time, "modified", build.Modified, "go", build.GoVersion)
+
 	// setup testdata
 	if *Testdata != "" {
-		fusiongo.DefaultCMS = fusiongo.MockCMS(os.DirFS(*Testdata))
+		if fsys, err := testdataFS(*Testdata); err != nil {
+			slog.Error("failed to open testdata", "error", err)
+			os.Exit(1)
+		} else {
+			fusiongo.DefaultCMS = fusiongo.MockCMS(fsys)
+		}
+	}
+
+	// setup http client
+	if *UserAgent != "" || *MaxFetchSize > 0 {
+		if *Testdata != "" {
+			slog.Warn("user-agent and max-fetch-size have no effect with testdata")
+		} else {
+			var (
+				cl  = http.DefaultClient
+				hdr http.Header
+			)
+			if *MaxFetchSize > 0 {
+				cl = &http.Client{
+					Transport: limitTransport{http.DefaultTransport, *MaxFetchSize},
+				}
+			}
+			if *UserAgent != "" {
+				hdr = http.Header{
+					"User-Agent": {*UserAgent},
+				}
+			}
+			fusiongo.DefaultCMS = fusiongo.ProductionCMS.With(cl, hdr)
+		}
 	}
 
 	// cache
+	var refreshAt func(time.Time) time.Time
+	if len(*RefreshAt) != 0 {
+		refreshAt = func(t time.Time) time.Time {
+			return nextTimeOfDay(t, *RefreshAt)
+		}
+	}
+	fusionLogger := map[int]*slog.Logger{} // for schools with a schedule log level override, set after parsing the config
+	fusionUpdate := map[int][]func(){}     // called after the data for a school is updated, set after parsing the config
 	fusion := memcache.MultiCache(func(schoolID int) memcache.Cache[fusionResult] {
+		logger, ok := fusionLogger[schoolID]
+		if !ok {
+			logger = slog.Default()
+		}
 		return fusionFetcher(schoolID, memcache.CacheConfig{
-			Timeout:   *Timeout,
-			CacheTime: *CacheTime,
-			StaleTime: *StaleTime,
+			Timeout:    *Timeout,
+			CacheTime:  *CacheTime,
+			RefreshAt:  refreshAt,
+			StaleTime:  *StaleTime,
+			Retries:    *FetchRetries,
+			RetryDelay: *FetchRetryWait,
 			Backoff: memcache.BackoffFunc(func(t time.Time, _ error, n int) time.Time {
 				if n <= 0 {
 					return t
@@ -120,10 +350,20 @@ func main() {
 					return t.Add(time.Minute * 15)
 				}
 			}),
-			Logger: slog.Default(),
+			ProbeInterval: *ProbeInterval,
+			OnUpdate: func() {
+				for _, fn := range fusionUpdate[schoolID] {
+					fn()
+				}
+			},
+			Logger: logger,
 		})
 	})
 
+	// background tasks; stopped on shutdown
+	bg, stop := context.WithCancel(context.Background())
+	defer stop()
+
 	// parse schedules
 	var schedulesFile string
 	if flag.NArg() == 0 {
@@ -132,11 +372,14 @@ func main() {
 		schedulesFile = flag.Arg(0)
 	}
 	slog.Info("parsing schedule config", "file", schedulesFile)
-	var scheduleHandlers map[string]http.Handler
+	var (
+		scheduleHandlers map[string]http.Handler
+		notFound         http.Handler
+	)
 	if buf, err := os.ReadFile(schedulesFile); err != nil {
 		slog.Error("failed to parse schedule config", "error", err)
 		os.Exit(1)
-	} else if cfg, err := parseSchedules(bytes.NewReader(buf)); err != nil {
+	} else if cfg, err := parseSchedules(bytes.NewReader(buf), schedulesFile); err != nil {
 		slog.Error("failed to parse schedule config", "error", err)
 		os.Exit(1)
 	} else {
@@ -144,27 +387,218 @@ func main() {
 			slog.Error("no schedules defined in schedule config")
 			os.Exit(1)
 		}
+		if lvl, ok := cfg.LogLevel(); ok && lvl < logLevel.Level() {
+			if logHandler == nil {
+				logHandler = slog.NewTextHandler(os.Stdout, logOptions)
+			}
+			logLevel.Set(lvl)
+			slog.SetDefault(slog.New(levelHandler{logHandler, *LogLevel}))
+		}
+		if logHandler == nil {
+			logHandler = slog.Default().Handler()
+		}
+		for schoolID, lvl := range cfg.SchoolLogLevels(*LogLevel) {
+			if lvl != *LogLevel {
+				fusionLogger[schoolID] = slog.New(levelHandler{logHandler, lvl})
+			}
+		}
+		scheduleLogger := func(x *schedule) *slog.Logger {
+			if x.LogLevel == nil {
+				return slog.Default()
+			}
+			return slog.New(levelHandler{logHandler, *x.LogLevel})
+		}
+		baseLogLevels := cfg.BaseLogLevels(*LogLevel)
+		baseLogger := func(base string) *slog.Logger {
+			if lvl := baseLogLevels[base]; lvl != *LogLevel {
+				return slog.New(levelHandler{logHandler, lvl})
+			}
+			return slog.Default()
+		}
 		if *NoUpcoming {
 			for x := range cfg {
 				cfg[x].Options.UpcomingDays = 0
 			}
 		}
+		if *ExposeVersion {
+			for x := range cfg {
+				cfg[x].Options.Version = build.String()
+			}
+		}
+		if *SanitizeFooter {
+			for x := range cfg {
+				for i, v := range cfg[x].Options.Footer {
+					cfg[x].Options.Footer[i] = ifgsch.SanitizeHTML(string(v))
+				}
+			}
+		}
 		if *Canonical != "" {
 			for x := range cfg {
 				cfg[x].Options.Canonical = strings.TrimRight(*Canonical, "/") + "/" + x
 			}
 		}
+		for x := range cfg {
+			cfg[x].Options.Path = "/" + x
+		}
+		if *HostFallback != "" && !slices.Contains(cfg.Hosts(), canonicalHost(*HostFallback)) {
+			slog.Warn("host fallback is not used by any schedule", "host", *HostFallback)
+		}
+		for x := range cfg {
+			if cfg[x].Alternates && len(cfg[x].Combine) == 0 {
+				cfg[x].Options.Alternates = scheduleAlternates(&cfg[x].Options)
+			}
+		}
+		hosts := cfg.Hosts()
+		host := func(r *http.Request) string {
+			return requestHost(r, hosts, canonicalHost(*HostFallback))
+		}
 		scheduleHandlers = make(map[string]http.Handler, len(cfg))
+		renderers := map[string]memcache.Cache[scheduleResult]{}
+		prepared := map[string]memcache.Cache[preparedSchedule]{}
+		histories := map[string]*changeHistory{}
 		for _, path := range cfg.Paths() {
 			x := cfg[path]
-			scheduleHandlers[path] = scheduleHandler(!*NoCache, !*NoGzip, scheduleRenderer(
-				x.Filter,
+			if len(x.Combine) != 0 {
+				continue
+			}
+			base := path
+			if x.Variant != "" {
+				base = x.Variant
+			}
+			if _, ok := prepared[base]; !ok {
+				y := cfg[base]
+				var notify func(*ifgsch.Schedule)
+				if *Webhook != "" {
+					notify = (&changeNotifier{
+						URL:      *Webhook,
+						Path:     base,
+						Title:    y.Options.Title,
+						Debounce: *WebhookDelay,
+						Logger:   baseLogger(base).With("webhook", base),
+					}).Update
+				}
+				if *History > 0 {
+					h := &changeHistory{
+						Max:    *History,
+						Logger: baseLogger(base).With("history", base),
+					}
+					if *HistoryDir != "" {
+						h.File = filepath.Join(*HistoryDir, url.PathEscape(base)+".json")
+						if err := h.Load(); err != nil {
+							slog.Error("failed to load change history", "schedule", base, "error", err)
+							os.Exit(1)
+						}
+					}
+					histories[base] = h
+					if next := notify; next != nil {
+						notify = func(s *ifgsch.Schedule) {
+							next(s)
+							h.Update(s)
+						}
+					} else {
+						notify = h.Update
+					}
+				}
+				prepared[base] = schedulePreparer(
+					y.Filter,
+					y.Prepare,
+					fusion(y.SchoolID),
+					memcache.CachedTransformConfig{
+						Logger: baseLogger(base).With("schedule", base),
+					},
+				)
+				if notify != nil {
+					fusionUpdate[y.SchoolID] = append(fusionUpdate[y.SchoolID], scheduleUpdated(prepared[base], notify))
+				}
+			}
+			gzip := !*NoGzip && !x.NoGzip
+			var full memcache.Cache[scheduleResult]
+			if x.Private.Any() {
+				full = scheduleRenderer(
+					x.Options,
+					prepared[base],
+					gzip,
+					memcache.CachedTransformConfig{
+						MaxAge: renderMaxAge(&x.Options),
+						Logger: scheduleLogger(x).With("variant", "full"),
+					},
+				)
+			}
+			renderer := scheduleRenderer(
+				x.Private.Public(x.Options),
+				prepared[base],
+				gzip,
+				memcache.CachedTransformConfig{
+					MaxAge: renderMaxAge(&x.Options),
+					Logger: scheduleLogger(x),
+				},
+			)
+			renderers[path] = renderer
+			cache := cacheConfig{
+				Enabled:         !*NoCache,
+				MaxAge:          *MaxAge,
+				StaleRevalidate: *MaxAgeStale,
+				Hash:            *ScheduleHash,
+			}
+			if x.Cache != nil {
+				cache.MaxAge, cache.StaleRevalidate = x.Cache.MaxAge, x.Cache.StaleRevalidate
+			}
+			scheduleHandlers[path] = scheduleHandler(cache, gzip, *ColorPreview, renderer)
+			scheduleHandlers[path+"/"] = scheduleDayHandler(cache, gzip, renderer)
+			scheduleHandlers[path+".txt"] = scheduleTextHandler(cache, gzip, renderer)
+			scheduleHandlers[path+".svg"] = scheduleSVGHandler(cache, gzip, renderer)
+			scheduleHandlers[path+"/stats.json"] = scheduleStatsHandler(cache, gzip, renderer)
+			if h := histories[base]; h != nil {
+				scheduleHandlers[path+"/changes"] = scheduleChangesHandler(h, &x.Options, false)
+				scheduleHandlers[path+"/changes.json"] = scheduleChangesHandler(h, &x.Options, true)
+			}
+			if x.Private.Any() {
+				scheduleHandlers[path+"/full"] = scheduleHandler(cache, gzip, false, full)
+				if x.Private.Upcoming {
+					scheduleHandlers[path+"/"] = scheduleDayHandler(cache, gzip, full)
+				}
+			}
+			public, private := scheduleKeys(path, x, histories[base] != nil)
+			protectScheduleHandlers(scheduleHandlers, path, x, public, private, &notFound, host)
+			slog.Info("schedule registered", "url", "/"+path, "hosts", x.Hosts)
+		}
+		for _, path := range cfg.Paths() {
+			x := cfg[path]
+			if len(x.Combine) == 0 {
+				continue
+			}
+			var sources []combinedSource
+			for _, c := range x.Combine {
+				name := cfg[c].Options.Title
+				if name == "" {
+					name = c
+				}
+				var link string
+				if !cfg[c].Unlisted && (len(cfg[c].Auth) == 0 || cfg[c].Private.Any()) {
+					link = cfg[c].Options.Path
+				}
+				sources = append(sources, combinedSource{name, link, renderers[c]})
+			}
+			gzip := !*NoGzip && !x.NoGzip
+			renderer := combinedRenderer(
 				x.Options,
-				fusion(x.SchoolID),
+				sources,
+				gzip,
 				memcache.CachedTransformConfig{
-					Logger: slog.Default(),
+					MaxAge: renderMaxAge(&x.Options),
+					Logger: scheduleLogger(x),
 				},
-			))
+			)
+			cache := cacheConfig{
+				Enabled:         !*NoCache,
+				MaxAge:          *MaxAge,
+				StaleRevalidate: *MaxAgeStale,
+				Hash:            *ScheduleHash,
+			}
+			if x.Cache != nil {
+				cache.MaxAge, cache.StaleRevalidate = x.Cache.MaxAge, x.Cache.StaleRevalidate
+			}
+			scheduleHandlers[path] = scheduleHandler(cache, gzip, false, renderer)
 			if x.Unlisted {
 				next := scheduleHandlers[path]
 				scheduleHandlers[path] = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -172,34 +606,70 @@ func main() {
 					next.ServeHTTP(w, r)
 				})
 			}
-			slog.Info("schedule registered", "url", "/"+path)
+			if len(x.Auth) != 0 {
+				scheduleHandlers[path] = basicAuth(scheduleHandlers[path], "/"+path, x.Auth)
+			}
+			scheduleHandlers[path] = availableHandler(x, scheduleHandlers[path], &notFound)
+			scheduleHandlers[path] = hostHandler(x, scheduleHandlers[path], &notFound, host)
+			slog.Info("combined schedule registered", "url", "/"+path, "schedules", x.Combine, "hosts", x.Hosts)
+		}
+		if *Prefetch > 0 {
+			go prefetch(cfg, renderers, *Prefetch)
+		}
+		if *Warm > 0 {
+			warm(bg, cfg, fusion, *Warm)
 		}
 		if !*NoHome {
 			var canonical string
 			if *Canonical != "" {
 				canonical = strings.TrimRight(*Canonical, "/") + "/"
 			}
-			scheduleHandlers[""] = scheduleListHandler(cfg, canonical)
+			scheduleHandlers[""] = hostRouter(cfg, host, func(cfg schedules) http.Handler {
+				return scheduleListHandler(cfg, canonical, !*NoGzip)
+			})
+		}
+		if *PalettePreview {
+			if _, ok := scheduleHandlers["palette"]; ok {
+				slog.Warn("not serving palette preview since there is already a schedule at /palette")
+			} else {
+				scheduleHandlers["palette"] = paletteHandler()
+			}
+		}
+		if *ExposeVersion {
+			if _, ok := scheduleHandlers["version"]; ok {
+				slog.Warn("not serving build info since there is already a schedule at /version")
+			} else {
+				scheduleHandlers["version"] = versionHandler(build)
+			}
+		}
+		if *Robots {
+			if _, ok := scheduleHandlers["robots.txt"]; ok {
+				slog.Warn("not serving robots.txt since there is already a schedule at /robots.txt")
+			} else {
+				var disallow []string
+				for _, x := range strings.Split(*RobotsDisallow, ",") {
+					if x = strings.TrimSpace(x); x != "" {
+						disallow = append(disallow, x)
+					}
+				}
+				scheduleHandlers["robots.txt"] = hostRouter(cfg, host, func(cfg schedules) http.Handler {
+					return robotsHandler(cfg, disallow)
+				})
+			}
 		}
+		notFound = hostRouter(cfg, host, func(cfg schedules) http.Handler {
+			return notFoundHandler(cfg, !*NoHome, !*NoGzip)
+		})
 	}
 
 	// setup http server
 	srv := &http.Server{
-		Addr: *Addr,
-		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if n, ok := strings.CutPrefix(r.URL.Path, "/"); ok {
-				if h, ok := scheduleHandlers[n]; ok {
-					h.ServeHTTP(w, r)
-					return
-				}
-			}
-			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
-		}),
+		Handler: scheduleRouter(scheduleHandlers, notFound),
 	}
 	if *ProxyHeader != "" {
 		next := srv.Handler
 		srv.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if x, _, _ := strings.Cut(r.Header.Get(*ProxyHeader), ","); x != "" {
+			if x := proxyRemoteAddr(r.RemoteAddr, r.Header.Values(*ProxyHeader), *ProxyIndex, *ProxyTrusted); x != "" {
 				r1 := *r
 				r = &r1
 				if xap, err := netip.ParseAddrPort(x); err == nil {
@@ -217,15 +687,46 @@ func main() {
 			next.ServeHTTP(w, r)
 		})
 	}
-	if l, err := net.Listen("tcp", srv.Addr); err != nil {
-		slog.Error("listen", "error", err)
-		os.Exit(1)
-	} else {
-		go srv.Serve(l)
+	switch {
+	case *TLSCert != "":
+		cert, err := tls.LoadX509KeyPair(*TLSCert, *TLSKey)
+		if err != nil {
+			slog.Error("failed to load tls certificate", "error", err)
+			os.Exit(1)
+		}
+		srv.TLSConfig = &tls.Config{
+			Certificates: []tls.Certificate{cert},
+		}
+	case autocertHosts != nil:
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(autocertHosts...),
+		}
+		if *AutocertCache != "" {
+			m.Cache = autocert.DirCache(*AutocertCache)
+		}
+		srv.TLSConfig = m.TLSConfig()
+	}
+	var ls []net.Listener
+	for _, a := range *Addr {
+		l, err := net.Listen(a.Network, a.Address)
+		if err != nil {
+			slog.Error("listen", "network", a.Network, "addr", a.Address, "error", err)
+			os.Exit(1)
+		}
+		slog.Info("listening", "network", a.Network, "addr", a.Address, "resolved", l.Addr().String())
+		ls = append(ls, l)
+	}
+	for _, l := range ls {
+		if srv.TLSConfig != nil {
+			go srv.ServeTLS(l, "", "")
+		} else {
+			go srv.Serve(l)
+		}
 	}
 
 	// ready; stop on ^C
-	slog.Info("started server", "addr", srv.Addr)
+	slog.Info("started server", "listeners", len(ls), "tls", srv.TLSConfig != nil)
 
 	ctx, done := signal.NotifyContext(context.Background(), os.Interrupt)
 	defer done()
@@ -233,6 +734,7 @@ func main() {
 
 	// stop; force-stop on ^C
 	slog.Info("stopping")
+	stop()
 
 	ctx, done = signal.NotifyContext(context.Background(), os.Interrupt)
 	defer done()
@@ -242,23 +744,239 @@ func main() {
 	}
 }
 
+// prefetch gets each renderer with at most n at a time, logging the result.
+func prefetch(cfg schedules, renderers map[string]memcache.Cache[scheduleResult], n int) {
+	var (
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, n)
+	)
+	slog.Info("prefetching schedules", "count", len(renderers), "concurrency", n)
+	for _, path := range cfg.Paths() {
+		renderer, ok := renderers[path]
+		if !ok {
+			continue
+		}
+		wg.Add(1)
+		go func(path string, renderer memcache.Cache[scheduleResult]) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			start := time.Now()
+			if _, err := renderer.Get(); err != nil {
+				slog.Warn("failed to prefetch schedule", "schedule", path, "school", cfg[path].SchoolID, "duration", time.Since(start).Seconds(), "error", err)
+			} else {
+				slog.Info("prefetched schedule", "schedule", path, "school", cfg[path].SchoolID, "duration", time.Since(start).Seconds())
+			}
+		}(path, renderer)
+	}
+	wg.Wait()
+	slog.Info("finished prefetching schedules")
+}
+
+// warm keeps the data for each school used by cfg updated in the background
+// until ctx is cancelled.
+func warm(ctx context.Context, cfg schedules, fusion func(int) memcache.Cache[fusionResult], lead time.Duration) {
+	seen := map[int]bool{}
+	for _, path := range cfg.Paths() {
+		if x := cfg[path]; len(x.Combine) == 0 && !seen[x.SchoolID] {
+			seen[x.SchoolID] = true
+			if w, ok := fusion(x.SchoolID).(memcache.Warmer); ok {
+				slog.Info("warming cache", "school", x.SchoolID, "lead", lead.Seconds())
+				go w.Warm(ctx, lead)
+			}
+		}
+	}
+}
+
 type schedules map[string]*schedule
 
 type schedule struct {
 	Index    int
 	SchoolID int
 	Options  ifgsch.Options
+	Prepare  ifgsch.PrepareOptions
 	Filter   ifgsch.Filter
+	Filters  []string // the filter properties which built Filter, for comparing variants
 	Unlisted bool
+	Auth     map[string][]byte // username to bcrypt hash
+	Private  privateSections   // if set, Auth only protects these sections, which are shown at /path/full
+	Cache    *cacheConfig      // overrides the max-age flags if set
+	Combine  []string          // if set, only show the upcoming events of these schedules
+	Variant  string            // if set, the prepared schedule is shared with this schedule, so only the Options may differ
+
+	Alternates bool // advertise the other formats of the schedule in the page head
+
+	AvailableFrom  fusiongo.Date // if set, the schedule is not found before this date
+	AvailableUntil fusiongo.Date // if set, the schedule is not found after this date
+
+	NoGzip bool // don't compress responses (e.g., if a CDN already does it)
+
+	Hosts []string // if set, the schedule is only served for requests to these hostnames (the path must still be unique)
+
+	LogLevel *slog.Level // overrides the log level for the schedule's caches if set
+}
+
+// clone makes a deep copy of x.
+func (x *schedule) clone() *schedule {
+	dup := *x
+	dup.Options.Footer = slices.Clone(dup.Options.Footer)
+	dup.Options.ActivityIcons = maps.Clone(dup.Options.ActivityIcons)
+	dup.Options.CategoryIcons = maps.Clone(dup.Options.CategoryIcons)
+	dup.Prepare.CategoryAliases = maps.Clone(dup.Prepare.CategoryAliases)
+	dup.Prepare.VirtualLocations = slices.Clone(dup.Prepare.VirtualLocations)
+	dup.Prepare.MergePriority = slices.Clone(dup.Prepare.MergePriority)
+	dup.Prepare.ExcludeDates = slices.Clone(dup.Prepare.ExcludeDates)
+	dup.Auth = maps.Clone(dup.Auth)
+	dup.Combine = slices.Clone(dup.Combine)
+	dup.Hosts = slices.Clone(dup.Hosts)
+	if f, ok := dup.Filter.(ifgsch.Filters); ok {
+		dup.Filter = slices.Clone(f)
+	}
+	dup.Filters = slices.Clone(dup.Filters)
+	return &dup
+}
+
+// privateSections are the schedule page sections which are only shown to
+// authenticated users.
+type privateSections struct {
+	Notifications bool
+	Upcoming      bool // also includes day pages
+}
+
+// Any checks if any sections are private.
+func (p privateSections) Any() bool {
+	return p != privateSections{}
+}
+
+// Public returns the options for the public variant of the schedule.
+func (p privateSections) Public(o ifgsch.Options) ifgsch.Options {
+	if p.Notifications {
+		o.NoNotifications = true
+	}
+	if p.Upcoming {
+		o.UpcomingDays = 0
+	}
+	return o
+}
+
+// ServedFor checks whether the schedule is served for requests to the
+// specified canonical hostname, which is empty for unknown hosts.
+func (x *schedule) ServedFor(host string) bool {
+	return len(x.Hosts) == 0 || slices.Contains(x.Hosts, host)
 }
 
-func parseSchedules(r io.Reader) (schedules, error) {
+// Available checks whether the schedule is within its availability dates at
+// the specified time, in the schedule's timezone if it has one.
+func (x *schedule) Available(now time.Time) bool {
+	if x.AvailableFrom == (fusiongo.Date{}) && x.AvailableUntil == (fusiongo.Date{}) {
+		return true
+	}
+	if x.Options.Timezone != nil {
+		now = now.In(x.Options.Timezone)
+	}
+	d := fusiongo.GoDateTime(now).Date
+	if x.AvailableFrom != (fusiongo.Date{}) && d.Less(x.AvailableFrom) {
+		return false
+	}
+	if x.AvailableUntil != (fusiongo.Date{}) && x.AvailableUntil.Less(d) {
+		return false
+	}
+	return true
+}
+
+// availableWithin checks whether the availability dates of x are within those
+// of y.
+func (x *schedule) availableWithin(y *schedule) bool {
+	if y.AvailableFrom != (fusiongo.Date{}) && (x.AvailableFrom == (fusiongo.Date{}) || x.AvailableFrom.Less(y.AvailableFrom)) {
+		return false
+	}
+	if y.AvailableUntil != (fusiongo.Date{}) && (x.AvailableUntil == (fusiongo.Date{}) || y.AvailableUntil.Less(x.AvailableUntil)) {
+		return false
+	}
+	return true
+}
+
+// parseSchedules parses a schedule config. The name is used to resolve
+// included files.
+func parseSchedules(r io.Reader, name string) (schedules, error) {
+	cfg := schedules{}
+	if err := cfg.parse(r, name, nil); err != nil {
+		return nil, err
+	}
+	for path, x := range cfg {
+		for _, c := range x.Combine {
+			if y, ok := cfg[c]; !ok {
+				return nil, fmt.Errorf("combined schedule %q: unknown schedule %q", path, c)
+			} else if len(y.Combine) != 0 {
+				return nil, fmt.Errorf("combined schedule %q: cannot include combined schedule %q", path, c)
+			} else if len(y.Auth) != 0 && !y.Private.Any() && !maps.EqualFunc(x.Auth, y.Auth, bytes.Equal) {
+				return nil, fmt.Errorf("combined schedule %q: cannot include schedule %q without requiring the same auth", path, c)
+			} else if !x.availableWithin(y) {
+				return nil, fmt.Errorf("combined schedule %q: cannot include schedule %q without being available within the same dates", path, c)
+			}
+		}
+		if x.Variant != "" {
+			y := cfg[x.Variant]
+			if !slices.Equal(x.Filters, y.Filters) || !reflect.DeepEqual(x.Prepare, y.Prepare) {
+				return nil, fmt.Errorf("schedule %q: variant of %q cannot change filters or prepare options", path, x.Variant)
+			}
+		}
+		if x.Private.Any() && len(x.Auth) == 0 {
+			return nil, fmt.Errorf("schedule %q: private sections require auth", path)
+		}
+		if x.Private.Any() && len(x.Combine) != 0 {
+			return nil, fmt.Errorf("combined schedule %q: cannot have private sections", path)
+		}
+		if x.AvailableFrom != (fusiongo.Date{}) && x.AvailableUntil != (fusiongo.Date{}) && x.AvailableUntil.Less(x.AvailableFrom) {
+			return nil, fmt.Errorf("schedule %q: available-until %s is before available-from %s", path, x.AvailableUntil, x.AvailableFrom)
+		}
+	}
+	keys := map[string]string{}
+	for _, path := range cfg.Paths() {
+		ks := []string{path}
+		if x := cfg[path]; len(x.Combine) == 0 {
+			// assume history is enabled since it's set by a flag, not the config
+			public, full := scheduleKeys(path, x, true)
+			ks = append(public, full...)
+		}
+		for _, k := range ks {
+			if other, ok := keys[k]; ok {
+				return nil, fmt.Errorf("schedule %q: /%s is already used by schedule %q", path, k, other)
+			}
+			keys[k] = path
+		}
+	}
+	return cfg, nil
+}
+
+// parse parses a schedule config into cfg. The stack contains the absolute
+// paths of the files currently being parsed.
+func (cfg schedules) parse(r io.Reader, name string, stack []string) error {
+	if abs, err := filepath.Abs(name); err == nil {
+		name = abs
+	}
+	if slices.Contains(stack, name) {
+		return fmt.Errorf("include cycle (%s)", strings.Join(append(stack, name), " -> "))
+	}
+	stack = append(stack, name)
+
 	var (
-		sc   = bufio.NewScanner(r)
-		cfg  = schedules{}
-		cur  = ""
-		line = 0
+		sc     = bufio.NewScanner(r)
+		cur    = ""
+		line   = 0
+		groups []filterGroup // open filter groups for cur
 	)
+	addFilter := func(f ifgsch.Filter) {
+		if n := len(groups); n != 0 {
+			groups[n-1].Filters = append(groups[n-1].Filters, f)
+			return
+		}
+		if cfg[cur].Filter == nil {
+			cfg[cur].Filter = ifgsch.Filters{}
+		}
+		cfg[cur].Filter = append(cfg[cur].Filter.(ifgsch.Filters), f)
+	}
 	for sc.Scan() {
 		line++
 		key, value := strings.TrimSpace(sc.Text()), ""
@@ -272,6 +990,30 @@ func parseSchedules(r io.Reader) (schedules, error) {
 				break
 			}
 		}
+		switch key {
+		case "include", "schedule", "variant", "combine":
+			if n := len(groups); n != 0 {
+				return fmt.Errorf("line %d: filter group from line %d not closed with filter.end", line, groups[n-1].Line)
+			}
+		}
+		if key == "include" {
+			if value == "" {
+				return fmt.Errorf("line %d: expected %q", line, "include <path>")
+			}
+			path := value
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(filepath.Dir(name), path)
+			}
+			buf, err := os.ReadFile(path)
+			if err != nil {
+				return fmt.Errorf("line %d: include: %w", line, err)
+			}
+			if err := cfg.parse(bytes.NewReader(buf), path, slices.Clip(stack)); err != nil {
+				return fmt.Errorf("line %d: include %q: %w", line, value, err)
+			}
+			cur = ""
+			continue
+		}
 		if key == "schedule" {
 			var a1, a2 string
 			for i, c := range value {
@@ -281,10 +1023,10 @@ func parseSchedules(r io.Reader) (schedules, error) {
 				}
 			}
 			if a2 == "" {
-				return nil, fmt.Errorf("line %d: expected %q, missing school_id", line, "schedule <path> <school_id|path_to_extend>")
+				return fmt.Errorf("line %d: expected %q, missing school_id", line, "schedule <path> <school_id|path_to_extend>")
 			}
 			if _, ok := cfg[a1]; ok {
-				return nil, fmt.Errorf("line %d: schedule path %q already used", line, a1)
+				return fmt.Errorf("line %d: schedule path %q already used", line, a1)
 			}
 			if schoolID, err := strconv.ParseInt(a2, 10, 64); err == nil {
 				cur = a1
@@ -293,21 +1035,63 @@ func parseSchedules(r io.Reader) (schedules, error) {
 			}
 			if x, ok := cfg[a2]; ok {
 				cur = a1
-				dup := *x
-				dup.Options.Footer = slices.Clone(dup.Options.Footer)
-				dup.Filter = slices.Clone(dup.Filter.(ifgsch.Filters))
-				cfg[cur] = &dup
+				dup := x.clone()
+				dup.Index = len(cfg)
+				dup.Variant = ""
+				cfg[cur] = dup
 				continue
 			}
-			return nil, fmt.Errorf("line %d: %q is not a valid school ID or path of schedule to extend", line, a2)
+			return fmt.Errorf("line %d: %q is not a valid school ID or path of schedule to extend", line, a2)
+		}
+		if key == "variant" {
+			arg, err := splitQuoted(value)
+			if err != nil {
+				return fmt.Errorf("line %d: parse whitespace-delimited optionally-quoted fields: %w", line, err)
+			}
+			if len(arg) != 2 {
+				return fmt.Errorf("line %d: expected %q", line, "variant <path> <schedule_path>")
+			}
+			if _, ok := cfg[arg[0]]; ok {
+				return fmt.Errorf("line %d: schedule path %q already used", line, arg[0])
+			}
+			x, ok := cfg[arg[1]]
+			if !ok {
+				return fmt.Errorf("line %d: unknown schedule %q", line, arg[1])
+			}
+			if len(x.Combine) != 0 {
+				return fmt.Errorf("line %d: cannot make a variant of combined schedule %q", line, arg[1])
+			}
+			dup := x.clone()
+			dup.Index = len(cfg)
+			if dup.Variant == "" {
+				dup.Variant = arg[1]
+			}
+			cur = arg[0]
+			cfg[cur] = dup
+			continue
+		}
+		if key == "combine" {
+			arg, err := splitQuoted(value)
+			if err != nil {
+				return fmt.Errorf("line %d: parse whitespace-delimited optionally-quoted fields: %w", line, err)
+			}
+			if len(arg) < 2 {
+				return fmt.Errorf("line %d: expected %q", line, "combine <path> <schedule_path...>")
+			}
+			if _, ok := cfg[arg[0]]; ok {
+				return fmt.Errorf("line %d: schedule path %q already used", line, arg[0])
+			}
+			cur = arg[0]
+			cfg[cur] = &schedule{Index: len(cfg), Combine: arg[1:]}
+			continue
 		}
 		if cur == "" {
-			return nil, fmt.Errorf("line %d: expected %q line before properties, got %q", line, "schedule <path>", key)
+			return fmt.Errorf("line %d: expected %q line before properties, got %q", line, "schedule <path>", key)
 		}
 		switch key {
 		case "color":
 			if len(value) != 3 && len(value) != 6 {
-				return nil, fmt.Errorf("line %d: invalid hex color %q", line, value)
+				return fmt.Errorf("line %d: invalid hex color %q", line, value)
 			}
 			for _, c := range value {
 				switch {
@@ -315,17 +1099,42 @@ func parseSchedules(r io.Reader) (schedules, error) {
 				case 'a' <= c && c <= 'f':
 				case 'A' <= c && c <= 'F':
 				default:
-					return nil, fmt.Errorf("line %d: invalid hex color %q", line, value)
+					return fmt.Errorf("line %d: invalid hex color %q", line, value)
 				}
 			}
 			cfg[cur].Options.Color = value
+		case "palette":
+			path := value
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(filepath.Dir(name), path)
+			}
+			buf, err := os.ReadFile(path)
+			if err != nil {
+				return fmt.Errorf("line %d: read palette: %w", line, err)
+			}
+			css := strings.TrimSpace(string(buf))
+			if strings.Contains(css, "</") {
+				return fmt.Errorf("line %d: palette css must not contain %q", line, "</")
+			}
+			for _, x := range []string{"primary", "tertiary", "neutral", "neutral-variant", "error"} {
+				if !strings.Contains(css, "--md-ref-palette-"+x) {
+					return fmt.Errorf("line %d: palette css does not define --md-ref-palette-%s*", line, x)
+				}
+			}
+			cfg[cur].Options.Palette = template.CSS(css)
 		case "icon":
 			b, err := base64.StdEncoding.DecodeString(value)
 			if err != nil {
-				return nil, fmt.Errorf("line %d: invalid base64: %w", line, err)
+				return fmt.Errorf("line %d: invalid base64: %w", line, err)
 			}
 			if !bytes.HasPrefix(b, []byte{0, 0, 1, 0}) {
-				return nil, fmt.Errorf("line %d: not a base64-encoded ico", line)
+				return fmt.Errorf("line %d: not a base64-encoded ico", line)
+			}
+			if err := checkICO(b); err != nil {
+				return fmt.Errorf("line %d: invalid ico: %w", line, err)
+			}
+			if *MaxIconSize > 0 && len(b) > *MaxIconSize {
+				return fmt.Errorf("line %d: icon too large (%d bytes, max %d)", line, len(b), *MaxIconSize)
 			}
 			cfg[cur].Options.Icon = b
 		case "title":
@@ -333,6 +1142,33 @@ func parseSchedules(r io.Reader) (schedules, error) {
 		case "desc":
 			cfg[cur].Options.Description = value
 		case "footer":
+			if term, ok := strings.CutPrefix(value, "<<"); ok {
+				term = strings.TrimSpace(term)
+				if term == "" || strings.ContainsAny(term, " \t") {
+					return fmt.Errorf("line %d: expected %q", line, "footer <<TERMINATOR")
+				}
+				var (
+					start = line
+					lines []string
+					done  bool
+				)
+				for sc.Scan() {
+					line++
+					x := strings.TrimSpace(sc.Text())
+					if x == term {
+						done = true
+						break
+					}
+					lines = append(lines, x)
+				}
+				if err := sc.Err(); err != nil {
+					return err
+				}
+				if !done {
+					return fmt.Errorf("line %d: unterminated footer block (expected %q)", start, term)
+				}
+				value = strings.TrimSpace(strings.Join(lines, "\n"))
+			}
 			if value == "" {
 				cfg[cur].Options.Footer = nil
 			}
@@ -340,34 +1176,622 @@ func parseSchedules(r io.Reader) (schedules, error) {
 		case "upcoming":
 			n, err := strconv.ParseInt(value, 10, 64)
 			if err != nil {
-				return nil, fmt.Errorf("line %d: invalid number %q: %w", line, value, err)
+				return fmt.Errorf("line %d: invalid number %q: %w", line, value, err)
 			}
 			if n < 1 || n > 90 {
-				return nil, fmt.Errorf("line %d: upcoming days must be greater than zero if specified, and lower than 90, got %d", line, n)
+				return fmt.Errorf("line %d: upcoming days must be greater than zero if specified, and lower than 90, got %d", line, n)
 			}
 			cfg[cur].Options.UpcomingDays = int(n)
+		case "upcoming-layout":
+			switch x := ifgsch.UpcomingLayout(value); x {
+			case ifgsch.UpcomingLayoutStack:
+				cfg[cur].Options.UpcomingLayout = x
+			case "scroll":
+				cfg[cur].Options.UpcomingLayout = ifgsch.UpcomingLayoutScroll
+			default:
+				return fmt.Errorf("line %d: invalid upcoming layout %q (expected scroll or stack)", line, value)
+			}
+		case "upcoming-skip-empty":
+			if value != "" {
+				return fmt.Errorf("line %d: does not take a value, got %q", line, value)
+			}
+			cfg[cur].Options.UpcomingSkip = true
+		case "upcoming-nav":
+			if value != "" {
+				return fmt.Errorf("line %d: does not take a value, got %q", line, value)
+			}
+			cfg[cur].Options.UpcomingNav = true
+		case "calendar-links":
+			if value != "" {
+				return fmt.Errorf("line %d: does not take a value, got %q", line, value)
+			}
+			cfg[cur].Options.CalendarLinks = true
+		case "upcoming-group-by":
+			switch x := ifgsch.UpcomingGroupBy(value); x {
+			case ifgsch.UpcomingGroupByActivity:
+				cfg[cur].Options.UpcomingGroupBy = x
+			case "time":
+				cfg[cur].Options.UpcomingGroupBy = ifgsch.UpcomingGroupByTime
+			default:
+				return fmt.Errorf("line %d: invalid upcoming group by %q (expected time or activity)", line, value)
+			}
+		case "event-colors":
+			switch x := ifgsch.EventColors(value); x {
+			case ifgsch.EventColorsActivity, ifgsch.EventColorsCategory:
+				cfg[cur].Options.EventColors = x
+			case "none":
+				cfg[cur].Options.EventColors = ifgsch.EventColorsNone
+			default:
+				return fmt.Errorf("line %d: invalid event colors %q (expected none, activity, or category)", line, value)
+			}
+		case "upcoming-max":
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return fmt.Errorf("line %d: invalid number %q: %w", line, value, err)
+			}
+			if n < 1 || n > 100 {
+				return fmt.Errorf("line %d: upcoming max events must be greater than zero if specified, and lower than 100, got %d", line, n)
+			}
+			cfg[cur].Options.UpcomingMax = int(n)
+		case "host":
+			arg, err := splitQuoted(value)
+			if err != nil {
+				return fmt.Errorf("line %d: parse whitespace-delimited optionally-quoted fields: %w", line, err)
+			}
+			if len(arg) == 0 {
+				return fmt.Errorf("line %d: expected %q", line, "host <hostname...>")
+			}
+			for _, h := range arg {
+				h = canonicalHost(h)
+				if h == "" || strings.ContainsAny(h, ":/@") {
+					return fmt.Errorf("line %d: invalid hostname %q", line, h)
+				}
+				if !slices.Contains(cfg[cur].Hosts, h) {
+					cfg[cur].Hosts = append(cfg[cur].Hosts, h)
+				}
+			}
+		case "log-level":
+			var lvl slog.Level
+			if err := lvl.UnmarshalText([]byte(value)); err != nil {
+				return fmt.Errorf("line %d: invalid log level: %w", line, err)
+			}
+			cfg[cur].LogLevel = &lvl
+		case "no-gzip":
+			if value != "" {
+				return fmt.Errorf("line %d: does not take a value, got %q", line, value)
+			}
+			cfg[cur].NoGzip = true
 		case "unlisted":
 			if value != "" {
-				return nil, fmt.Errorf("line %d: does not take a value, got %q", line, value)
+				return fmt.Errorf("line %d: does not take a value, got %q", line, value)
 			}
 			cfg[cur].Unlisted = true
+		case "max-age":
+			arg, err := splitQuoted(value)
+			if err != nil {
+				return fmt.Errorf("line %d: parse whitespace-delimited optionally-quoted fields: %w", line, err)
+			}
+			if len(arg) != 1 && len(arg) != 2 {
+				return fmt.Errorf("line %d: expected %q", line, "max-age <duration> [stale_while_revalidate_duration]")
+			}
+			var c cacheConfig
+			for i, a := range arg {
+				d, err := time.ParseDuration(a)
+				if err != nil {
+					return fmt.Errorf("line %d: invalid duration: %w", line, err)
+				}
+				if d < 0 {
+					return fmt.Errorf("line %d: duration must not be negative", line)
+				}
+				if i == 0 {
+					c.MaxAge = d
+				} else {
+					c.StaleRevalidate = d
+				}
+			}
+			cfg[cur].Cache = &c
+		case "auth":
+			arg, err := splitQuoted(value)
+			if err != nil {
+				return fmt.Errorf("line %d: parse whitespace-delimited optionally-quoted fields: %w", line, err)
+			}
+			if len(arg) != 2 {
+				return fmt.Errorf("line %d: expected %q", line, "auth <user> <bcrypt-hash>")
+			}
+			if arg[0] == "" || strings.ContainsRune(arg[0], ':') {
+				return fmt.Errorf("line %d: invalid username %q", line, arg[0])
+			}
+			if _, err := bcrypt.Cost([]byte(arg[1])); err != nil {
+				return fmt.Errorf("line %d: invalid bcrypt hash: %w", line, err)
+			}
+			if cfg[cur].Auth == nil {
+				cfg[cur].Auth = map[string][]byte{}
+			}
+			cfg[cur].Auth[arg[0]] = []byte(arg[1])
+		case "private":
+			arg, err := splitQuoted(value)
+			if err != nil {
+				return fmt.Errorf("line %d: parse whitespace-delimited optionally-quoted fields: %w", line, err)
+			}
+			if len(arg) == 0 {
+				return fmt.Errorf("line %d: expected %q", line, "private <notifications|upcoming...>")
+			}
+			var p privateSections
+			for _, x := range arg {
+				switch x {
+				case "notifications":
+					p.Notifications = true
+				case "upcoming":
+					p.Upcoming = true
+				default:
+					return fmt.Errorf("line %d: invalid private section %q (expected notifications or upcoming)", line, x)
+				}
+			}
+			cfg[cur].Private = p
+		case "time-separator":
+			arg, err := splitQuoted(value)
+			if err != nil {
+				return fmt.Errorf("line %d: parse whitespace-delimited optionally-quoted fields: %w", line, err)
+			}
+			if len(arg) != 1 {
+				return fmt.Errorf("line %d: expected %q", line, "time-separator <separator>")
+			}
+			if n := utf8.RuneCountInString(arg[0]); n == 0 || n > 8 {
+				return fmt.Errorf("line %d: time separator must be between 1 and 8 characters, got %q", line, arg[0])
+			}
+			if strings.ContainsFunc(arg[0], unicode.IsControl) {
+				return fmt.Errorf("line %d: time separator must not contain control characters, got %q", line, arg[0])
+			}
+			cfg[cur].Options.TimeSeparator = arg[0]
+		case "activity-sort":
+			switch x := ifgsch.ActivitySort(value); x {
+			case ifgsch.ActivitySortTime:
+				cfg[cur].Prepare.ActivitySort = x
+			case "name":
+				cfg[cur].Prepare.ActivitySort = ifgsch.ActivitySortName
+			default:
+				return fmt.Errorf("line %d: invalid activity sort %q (expected name or time)", line, value)
+			}
+		case "max-activities":
+			arg, err := splitQuoted(value)
+			if err != nil {
+				return fmt.Errorf("line %d: parse whitespace-delimited optionally-quoted fields: %w", line, err)
+			}
+			if len(arg) != 1 && len(arg) != 2 {
+				return fmt.Errorf("line %d: expected %q", line, "max-activities <n> [name|count]")
+			}
+			n, err := strconv.ParseInt(arg[0], 10, 64)
+			if err != nil || n < 1 {
+				return fmt.Errorf("line %d: invalid max activities %q (expected a positive integer)", line, arg[0])
+			}
+			cfg[cur].Options.MaxActivities = int(n)
+			cfg[cur].Options.MaxActivitiesBy = ifgsch.ActivityLimitName
+			if len(arg) == 2 {
+				switch x := ifgsch.ActivityLimit(arg[1]); x {
+				case ifgsch.ActivityLimitCount:
+					cfg[cur].Options.MaxActivitiesBy = x
+				case "name":
+				default:
+					return fmt.Errorf("line %d: invalid activity limit %q (expected name or count)", line, arg[1])
+				}
+			}
+		case "empty-activities":
+			arg, err := splitQuoted(value)
+			if err != nil {
+				return fmt.Errorf("line %d: parse whitespace-delimited optionally-quoted fields: %w", line, err)
+			}
+			if len(arg) == 0 {
+				return fmt.Errorf("line %d: expected %q", line, "empty-activities <rename [name]|drop>")
+			}
+			switch arg[0] {
+			case "rename":
+				if len(arg) > 2 {
+					return fmt.Errorf("line %d: expected %q", line, "empty-activities rename [name]")
+				}
+				cfg[cur].Prepare.EmptyActivities = ifgsch.EmptyActivityRename
+				cfg[cur].Prepare.UntitledActivity = ""
+				if len(arg) == 2 {
+					cfg[cur].Prepare.UntitledActivity = strings.TrimSpace(arg[1])
+				}
+			case string(ifgsch.EmptyActivityDrop):
+				if len(arg) != 1 {
+					return fmt.Errorf("line %d: expected %q", line, "empty-activities drop")
+				}
+				cfg[cur].Prepare.EmptyActivities = ifgsch.EmptyActivityDrop
+			default:
+				return fmt.Errorf("line %d: invalid empty activity handling %q (expected rename or drop)", line, arg[0])
+			}
+		case "merge-priority":
+			arg, err := splitQuoted(value)
+			if err != nil {
+				return fmt.Errorf("line %d: parse whitespace-delimited optionally-quoted fields: %w", line, err)
+			}
+			if len(arg) == 0 {
+				return fmt.Errorf("line %d: expected %q", line, "merge-priority <exclusion|exception|duration...>")
+			}
+			cfg[cur].Prepare.MergePriority = nil
+			for _, x := range arg {
+				switch p := ifgsch.MergePenalty(x); p {
+				case ifgsch.MergePenaltyExclusion, ifgsch.MergePenaltyException, ifgsch.MergePenaltyDuration:
+					if slices.Contains(cfg[cur].Prepare.MergePriority, p) {
+						return fmt.Errorf("line %d: duplicate merge penalty %q", line, x)
+					}
+					cfg[cur].Prepare.MergePriority = append(cfg[cur].Prepare.MergePriority, p)
+				default:
+					return fmt.Errorf("line %d: invalid merge penalty %q (expected exclusion, exception, or duration)", line, x)
+				}
+			}
+		case "split-threshold":
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return fmt.Errorf("line %d: invalid duration: %w", line, err)
+			}
+			if d <= 0 {
+				return fmt.Errorf("line %d: duration must be positive", line)
+			}
+			cfg[cur].Prepare.SplitThreshold = d
+		case "exclude-date":
+			arg, err := splitQuoted(value)
+			if err != nil {
+				return fmt.Errorf("line %d: parse whitespace-delimited optionally-quoted fields: %w", line, err)
+			}
+			if len(arg) == 0 {
+				return fmt.Errorf("line %d: expected %q", line, "exclude-date <yyyy-mm-dd...>")
+			}
+			for _, x := range arg {
+				t, err := time.Parse("2006-01-02", x)
+				if err != nil {
+					return fmt.Errorf("line %d: invalid date %q: %w", line, x, err)
+				}
+				if d := fusiongo.GoDateTime(t).Date; !slices.Contains(cfg[cur].Prepare.ExcludeDates, d) {
+					cfg[cur].Prepare.ExcludeDates = append(cfg[cur].Prepare.ExcludeDates, d)
+				}
+			}
+		case "snap-times":
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return fmt.Errorf("line %d: invalid duration: %w", line, err)
+			}
+			if d <= 0 || d > time.Hour || d%time.Minute != 0 {
+				return fmt.Errorf("line %d: duration must be a whole number of minutes up to an hour", line)
+			}
+			cfg[cur].Prepare.SnapTimes = d
+		case "subscribe":
+			if u, err := url.Parse(value); err != nil {
+				return fmt.Errorf("line %d: invalid subscribe url: %w", line, err)
+			} else if u.Scheme != "" && u.Scheme != "http" && u.Scheme != "https" {
+				return fmt.Errorf("line %d: invalid subscribe url: unsupported scheme %q", line, u.Scheme)
+			}
+			cfg[cur].Options.Subscribe = value
+		case "source":
+			arg, err := splitQuoted(value)
+			if err != nil {
+				return fmt.Errorf("line %d: parse whitespace-delimited optionally-quoted fields: %w", line, err)
+			}
+			if len(arg) != 1 && len(arg) != 2 {
+				return fmt.Errorf("line %d: expected %q", line, "source <name> [url]")
+			}
+			cfg[cur].Options.Source = ifgsch.Source{Name: arg[0]}
+			if len(arg) == 2 {
+				if u, err := url.Parse(arg[1]); err != nil {
+					return fmt.Errorf("line %d: invalid source url: %w", line, err)
+				} else if u.Scheme != "http" && u.Scheme != "https" {
+					return fmt.Errorf("line %d: invalid source url: expected absolute http or https url", line)
+				} else if u.Host == "" {
+					return fmt.Errorf("line %d: invalid source url: missing host", line)
+				}
+				cfg[cur].Options.Source.URL = arg[1]
+			}
+		case "exception-detail":
+			switch x := ifgsch.ExceptionDetail(value); x {
+			case ifgsch.ExceptionDetailSummary, ifgsch.ExceptionDetailCollapsed, ifgsch.ExceptionDetailNone:
+				cfg[cur].Options.ExceptionDetail = x
+			case "full":
+				cfg[cur].Options.ExceptionDetail = ifgsch.ExceptionDetailFull
+			default:
+				return fmt.Errorf("line %d: invalid exception detail %q (expected full, summary, collapsed, or none)", line, value)
+			}
+		case "first-day-exclusion":
+			switch x := ifgsch.FirstDayExclusion(value); x {
+			case ifgsch.FirstDayExclusionShow, ifgsch.FirstDayExclusionExclude:
+				cfg[cur].Prepare.FirstDayExclusion = x
+			case "ignore":
+				cfg[cur].Prepare.FirstDayExclusion = ifgsch.FirstDayExclusionIgnore
+			default:
+				return fmt.Errorf("line %d: invalid first day exclusion mode %q (expected ignore, show, or exclude)", line, value)
+			}
+		case "align":
+			switch x := ifgsch.Align(value); x {
+			case ifgsch.AlignStart, ifgsch.AlignEnd:
+				cfg[cur].Options.Align = x
+			case "center":
+				cfg[cur].Options.Align = ifgsch.AlignCenter
+			default:
+				return fmt.Errorf("line %d: invalid alignment %q (expected center, start, or end)", line, value)
+			}
+		case "max-width":
+			if !cssLength.MatchString(value) {
+				return fmt.Errorf("line %d: invalid max width %q (expected a number with a px, em, rem, ch, vw, or %% unit)", line, value)
+			}
+			cfg[cur].Options.MaxWidth = value
+		case "sticky-header":
+			if value != "" {
+				return fmt.Errorf("line %d: does not take a value, got %q", line, value)
+			}
+			cfg[cur].Options.StickyHeader = true
+		case "day-counts":
+			switch x := ifgsch.DayCounts(value); x {
+			case ifgsch.DayCountsAll, ifgsch.DayCountsScheduled:
+				cfg[cur].Options.DayCounts = x
+			case "none":
+				cfg[cur].Options.DayCounts = ifgsch.DayCountsNone
+			default:
+				return fmt.Errorf("line %d: invalid day counts %q (expected all, scheduled, or none)", line, value)
+			}
+		case "notification-banner":
+			if value != "" {
+				return fmt.Errorf("line %d: does not take a value, got %q", line, value)
+			}
+			cfg[cur].Options.NotifyBanner = true
+		case "subtotals":
+			if value != "" {
+				return fmt.Errorf("line %d: does not take a value, got %q", line, value)
+			}
+			cfg[cur].Options.Subtotals = true
+		case "seconds":
+			if value != "" {
+				return fmt.Errorf("line %d: does not take a value, got %q", line, value)
+			}
+			cfg[cur].Options.Seconds = true
+		case "zero-duration":
+			switch x := ifgsch.ZeroDuration(value); x {
+			case ifgsch.ZeroDurationPoint, ifgsch.ZeroDurationHide:
+				cfg[cur].Options.ZeroDuration = x
+			case "keep":
+				cfg[cur].Options.ZeroDuration = ifgsch.ZeroDurationKeep
+			default:
+				return fmt.Errorf("line %d: invalid zero duration handling %q (expected keep, point, or hide)", line, value)
+			}
+		case "durations":
+			switch x := ifgsch.Durations(value); x {
+			case ifgsch.DurationsBeside, ifgsch.DurationsReplace:
+				cfg[cur].Options.Durations = x
+			case "none":
+				cfg[cur].Options.Durations = ifgsch.DurationsNone
+			default:
+				return fmt.Errorf("line %d: invalid duration display %q (expected none, beside, or replace)", line, value)
+			}
+		case "range-format":
+			switch x := ifgsch.RangeFormat(value); x {
+			case ifgsch.RangeFormatWeekOf:
+				cfg[cur].Options.RangeFormat = x
+			case "dates":
+				cfg[cur].Options.RangeFormat = ifgsch.RangeFormatDates
+			default:
+				return fmt.Errorf("line %d: invalid range format %q (expected dates or week-of)", line, value)
+			}
+		case "lang":
+			tag, err := language.Parse(value)
+			if err != nil {
+				return fmt.Errorf("line %d: invalid language tag %q: %w", line, value, err)
+			}
+			cfg[cur].Options.Language = tag.String()
+		case "direction":
+			switch value {
+			case "ltr", "rtl":
+				cfg[cur].Options.Direction = value
+			default:
+				return fmt.Errorf("line %d: invalid direction %q (expected ltr or rtl)", line, value)
+			}
+		case "show-categories":
+			if value != "" {
+				return fmt.Errorf("line %d: does not take a value, got %q", line, value)
+			}
+			cfg[cur].Options.Categories = true
+		case "show-live":
+			if value != "" {
+				return fmt.Errorf("line %d: does not take a value, got %q", line, value)
+			}
+			cfg[cur].Options.Live = true
+		case "virtual-location":
+			arg, err := splitQuoted(value)
+			if err != nil {
+				return fmt.Errorf("line %d: parse whitespace-delimited optionally-quoted fields: %w", line, err)
+			}
+			if len(arg) != 1 {
+				return fmt.Errorf("line %d: expected %q", line, "virtual-location <name>")
+			}
+			cfg[cur].Prepare.VirtualLocations = append(cfg[cur].Prepare.VirtualLocations, arg[0])
+		case "date-format", "datetime-format":
+			arg, err := splitQuoted(value)
+			if err != nil {
+				return fmt.Errorf("line %d: parse whitespace-delimited optionally-quoted fields: %w", line, err)
+			}
+			if len(arg) != 1 {
+				return fmt.Errorf("line %d: expected %q", line, key+" <go-time-layout>")
+			}
+			if sample := time.Date(2009, 11, 17, 20, 34, 58, 0, time.UTC).Format(arg[0]); sample == arg[0] || !strings.Contains(sample, "17") {
+				return fmt.Errorf("line %d: invalid time layout %q (must contain at least the day of the month)", line, arg[0])
+			}
+			if key == "date-format" {
+				cfg[cur].Options.DateFormat = arg[0]
+			} else {
+				cfg[cur].Options.DateTimeFormat = arg[0]
+			}
+		case "timezone":
+			arg, err := splitQuoted(value)
+			if err != nil {
+				return fmt.Errorf("line %d: parse whitespace-delimited optionally-quoted fields: %w", line, err)
+			}
+			if len(arg) != 1 {
+				return fmt.Errorf("line %d: expected %q", line, "timezone <iana-name>")
+			}
+			loc, err := time.LoadLocation(arg[0])
+			if err != nil {
+				return fmt.Errorf("line %d: invalid timezone %q: %w", line, arg[0], err)
+			}
+			cfg[cur].Options.Timezone = loc
+		case "available-from", "available-until":
+			arg, err := splitQuoted(value)
+			if err != nil {
+				return fmt.Errorf("line %d: parse whitespace-delimited optionally-quoted fields: %w", line, err)
+			}
+			if len(arg) != 1 {
+				return fmt.Errorf("line %d: expected %q", line, key+" <yyyy-mm-dd>")
+			}
+			t, err := time.Parse("2006-01-02", arg[0])
+			if err != nil {
+				return fmt.Errorf("line %d: invalid date %q: %w", line, arg[0], err)
+			}
+			if key == "available-from" {
+				cfg[cur].AvailableFrom = fusiongo.GoDateTime(t).Date
+			} else {
+				cfg[cur].AvailableUntil = fusiongo.GoDateTime(t).Date
+			}
+		case "compact":
+			if value != "" {
+				return fmt.Errorf("line %d: does not take a value, got %q", line, value)
+			}
+			cfg[cur].Options.Compact = true
+		case "relative-time":
+			if value != "" {
+				return fmt.Errorf("line %d: does not take a value, got %q", line, value)
+			}
+			cfg[cur].Options.RelativeTime = true
+		case "weekly":
+			if value != "" {
+				return fmt.Errorf("line %d: does not take a value, got %q", line, value)
+			}
+			cfg[cur].Options.Weekly = true
+		case "show-empty":
+			if value != "" {
+				return fmt.Errorf("line %d: does not take a value, got %q", line, value)
+			}
+			cfg[cur].Options.EmptyCells = true
+		case "qr-code":
+			if value != "" {
+				return fmt.Errorf("line %d: does not take a value, got %q", line, value)
+			}
+			cfg[cur].Options.QRCode = true
+		case "show-legend":
+			if value != "" {
+				return fmt.Errorf("line %d: does not take a value, got %q", line, value)
+			}
+			cfg[cur].Options.Legend = true
+		case "hide-cancelled":
+			if value != "" {
+				return fmt.Errorf("line %d: does not take a value, got %q", line, value)
+			}
+			cfg[cur].Prepare.HideCancelled = true
+		case "alternate-links":
+			if value != "" {
+				return fmt.Errorf("line %d: does not take a value, got %q", line, value)
+			}
+			cfg[cur].Alternates = true
+		case "notifications-markdown":
+			if value != "" {
+				return fmt.Errorf("line %d: does not take a value, got %q", line, value)
+			}
+			cfg[cur].Options.Markdown = true
+		case "category-ids":
+			if value != "" {
+				return fmt.Errorf("line %d: does not take a value, got %q", line, value)
+			}
+			cfg[cur].Prepare.CategoryIDs = true
+		case "notification-max":
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return fmt.Errorf("line %d: invalid number %q: %w", line, value, err)
+			}
+			if n < 2 {
+				return fmt.Errorf("line %d: notification max length must be at least 2, got %d", line, n)
+			}
+			cfg[cur].Options.NotificationMax = int(n)
+		case "dedupe-notifications":
+			if value != "" {
+				return fmt.Errorf("line %d: does not take a value, got %q", line, value)
+			}
+			cfg[cur].Prepare.DedupeNotifications = true
+		case "icon.activity", "icon.category":
+			arg, err := splitQuoted(value)
+			if err != nil {
+				return fmt.Errorf("line %d: parse whitespace-delimited optionally-quoted fields: %w", line, err)
+			}
+			if len(arg) != 2 {
+				return fmt.Errorf("line %d: expected %q", line, key+" <name> <svg_path>")
+			}
+			path := arg[1]
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(filepath.Dir(name), path)
+			}
+			icon, err := readIconSVG(path)
+			if err != nil {
+				return fmt.Errorf("line %d: %w", line, err)
+			}
+			m := &cfg[cur].Options.ActivityIcons
+			if key == "icon.category" {
+				m = &cfg[cur].Options.CategoryIcons
+			}
+			if *m == nil {
+				*m = map[string]template.HTML{}
+			}
+			(*m)[arg[0]] = icon
+		case "category-alias":
+			arg, err := splitQuoted(value)
+			if err != nil {
+				return fmt.Errorf("line %d: parse whitespace-delimited optionally-quoted fields: %w", line, err)
+			}
+			if len(arg) != 2 {
+				return fmt.Errorf("line %d: expected %q", line, "category-alias <from> <to>")
+			}
+			if cfg[cur].Prepare.CategoryAliases == nil {
+				cfg[cur].Prepare.CategoryAliases = map[string]string{}
+			}
+			cfg[cur].Prepare.CategoryAliases[arg[0]] = arg[1]
 		default:
 			key, ok := strings.CutPrefix(key, "filter.")
 			if !ok {
-				return nil, fmt.Errorf("line %d: unknown property %q", line, key)
+				return fmt.Errorf("line %d: unknown property %q", line, key)
+			}
+			cfg[cur].Filters = append(cfg[cur].Filters, "filter."+key+" "+value)
+			switch key {
+			case "any", "all":
+				if value != "" {
+					return fmt.Errorf("line %d: does not take a value, got %q", line, value)
+				}
+				groups = append(groups, filterGroup{Any: key != "all", Line: line})
+				continue
+			case "end":
+				if value != "" {
+					return fmt.Errorf("line %d: does not take a value, got %q", line, value)
+				}
+				n := len(groups)
+				if n == 0 {
+					return fmt.Errorf("line %d: filter.end without filter.any or filter.all", line)
+				}
+				g := groups[n-1]
+				if groups = groups[:n-1]; len(g.Filters) == 0 {
+					return fmt.Errorf("line %d: empty filter group", line)
+				}
+				if g.Any {
+					addFilter(ifgsch.AnyFilter(g.Filters))
+				} else {
+					addFilter(ifgsch.AllFilter(g.Filters))
+				}
+				continue
 			}
 			arg, err := splitQuoted(value)
 			if err != nil {
-				return nil, fmt.Errorf("line %d: parse whitespace-delimited optionally-quoted fields: %w", line, err)
+				return fmt.Errorf("line %d: parse whitespace-delimited optionally-quoted fields: %w", line, err)
 			}
 			if len(arg) == 0 {
-				return nil, fmt.Errorf("line %d: missing filter action", line)
+				return fmt.Errorf("line %d: missing filter action", line)
 			}
 			var flt func(s ...string) ([]string, bool)
 			switch act, arg := arg[0], arg[1:]; act {
 			case "in", "notIn":
 				if len(arg) < 1 {
-					return nil, fmt.Errorf("line %d: expected at least 1 argument for filter action %q", line, act)
+					return fmt.Errorf("line %d: expected at least 1 argument for filter action %q", line, act)
 				}
 				flt = func(s ...string) ([]string, bool) {
 					ok := slices.ContainsFunc(s, func(s string) bool {
@@ -380,29 +1804,51 @@ func parseSchedules(r io.Reader) (schedules, error) {
 				}
 			case "trimPrefix", "trimSuffix", "contains", "notContains":
 				if len(arg) != 1 {
-					return nil, fmt.Errorf("line %d: expected exactly 1 argument for filter action %q", line, act)
+					return fmt.Errorf("line %d: expected exactly 1 argument for filter action %q", line, act)
 				}
 				flt = func(s ...string) ([]string, bool) {
-					var m, mm bool
-					for i, x := range s {
-						switch act {
-						case "trimPrefix":
+					switch act {
+					case "trimPrefix":
+						for i, x := range s {
 							s[i] = strings.TrimPrefix(x, arg[0])
-						case "trimSuffix":
+						}
+						return s, true
+					case "trimSuffix":
+						for i, x := range s {
 							s[i] = strings.TrimSuffix(x, arg[0])
-						case "contains":
-							m, mm = true, mm && strings.Contains(x, arg[0])
-						case "notContains":
-							m, mm = true, mm && !strings.Contains(x, arg[0])
-						default:
-							panic("wtf")
 						}
+						return s, true
+					case "contains", "notContains":
+						// like in/notIn, match if any of the values do
+						ok := slices.ContainsFunc(s, func(s string) bool {
+							return strings.Contains(s, arg[0])
+						})
+						if act == "notContains" {
+							ok = !ok
+						}
+						return s, ok
+					default:
+						panic("wtf")
+					}
+				}
+			case "matches", "notMatches":
+				if len(arg) != 1 {
+					return fmt.Errorf("line %d: expected exactly 1 argument for filter action %q", line, act)
+				}
+				re, err := regexp.Compile(arg[0])
+				if err != nil {
+					return fmt.Errorf("line %d: invalid regexp for filter action %q: %w", line, act, err)
+				}
+				flt = func(s ...string) ([]string, bool) {
+					ok := slices.ContainsFunc(s, re.MatchString)
+					if act == "notMatches" {
+						ok = !ok
 					}
-					return s, !m || mm
+					return s, ok
 				}
 			case "replace", "map":
 				if len(arg) != 2 {
-					return nil, fmt.Errorf("line %d: expected exactly 2 arguments for filter action %q", line, act)
+					return fmt.Errorf("line %d: expected exactly 2 arguments for filter action %q", line, act)
 				}
 				flt = func(s ...string) ([]string, bool) {
 					for i, x := range s {
@@ -417,14 +1863,11 @@ func parseSchedules(r io.Reader) (schedules, error) {
 					return s, true
 				}
 			default:
-				return nil, fmt.Errorf("line %d: unknown filter action %q", line, act)
-			}
-			if cfg[cur].Filter == nil {
-				cfg[cur].Filter = ifgsch.Filters{}
+				return fmt.Errorf("line %d: unknown filter action %q", line, act)
 			}
 			switch key {
 			case "category":
-				cfg[cur].Filter = append(cfg[cur].Filter.(ifgsch.Filters), ifgsch.FilterFunc(func(ai *fusiongo.ActivityInstance) (ok bool) {
+				addFilter(ifgsch.FilterFunc(func(ai *fusiongo.ActivityInstance) (ok bool) {
 					v, ok := flt(ai.CategoryNames()...)
 					for i, x := range v {
 						ai.Category[i].Name = x
@@ -432,7 +1875,7 @@ func parseSchedules(r io.Reader) (schedules, error) {
 					return ok
 				}))
 			case "category_id":
-				cfg[cur].Filter = append(cfg[cur].Filter.(ifgsch.Filters), ifgsch.FilterFunc(func(ai *fusiongo.ActivityInstance) (ok bool) {
+				addFilter(ifgsch.FilterFunc(func(ai *fusiongo.ActivityInstance) (ok bool) {
 					v, ok := flt(ai.CategoryIDs()...)
 					for i, x := range v {
 						ai.Category[i].ID = x
@@ -440,26 +1883,176 @@ func parseSchedules(r io.Reader) (schedules, error) {
 					return ok
 				}))
 			case "location":
-				cfg[cur].Filter = append(cfg[cur].Filter.(ifgsch.Filters), ifgsch.FilterFunc(func(ai *fusiongo.ActivityInstance) (ok bool) {
+				addFilter(ifgsch.FilterFunc(func(ai *fusiongo.ActivityInstance) (ok bool) {
 					v, ok := flt(ai.Location)
 					ai.Location = v[0]
 					return ok
 				}))
 			case "activity":
-				cfg[cur].Filter = append(cfg[cur].Filter.(ifgsch.Filters), ifgsch.FilterFunc(func(ai *fusiongo.ActivityInstance) (ok bool) {
+				addFilter(ifgsch.FilterFunc(func(ai *fusiongo.ActivityInstance) (ok bool) {
 					v, ok := flt(ai.Activity)
 					ai.Activity = v[0]
 					return ok
 				}))
+			case "description":
+				addFilter(ifgsch.FilterFunc(func(ai *fusiongo.ActivityInstance) (ok bool) {
+					v, ok := flt(ai.Description)
+					ai.Description = v[0]
+					return ok
+				}))
 			default:
-				return nil, fmt.Errorf("line %d: unknown filter key %q", line, key)
+				return fmt.Errorf("line %d: unknown filter key %q", line, key)
 			}
 		}
 	}
 	if err := sc.Err(); err != nil {
-		return nil, err
+		return err
 	}
-	return cfg, nil
+	if n := len(groups); n != 0 {
+		return fmt.Errorf("line %d: filter group from line %d not closed with filter.end", line, groups[n-1].Line)
+	}
+	return nil
+}
+
+// filterGroup is a filter.any or filter.all group being parsed.
+type filterGroup struct {
+	Any     bool
+	Line    int
+	Filters []ifgsch.Filter
+}
+
+// configSchema documents the schedule config syntax accepted by
+// [schedules.parse]. It must be kept in sync with the parser.
+var configSchema = struct {
+	Properties    []configSchemaItem `json:"properties"`
+	FilterKeys    []configSchemaItem `json:"filter_keys"`
+	FilterActions []configSchemaItem `json:"filter_actions"`
+}{
+	Properties: []configSchemaItem{
+		{Name: "schedule", Usage: "schedule <path> <school_id|path_to_extend>", Description: "start a new schedule served at /path, optionally copying the properties of an earlier schedule"},
+		{Name: "combine", Usage: "combine <path> <schedule_path...>", Description: "start a new page at /path showing the upcoming events of other schedules together (only display, auth, and availability properties apply, and they must be at least as restrictive as those of the schedules)"},
+		{Name: "variant", Usage: "variant <path> <schedule_path>", Description: "start a new schedule served at /path, copying the properties of an earlier schedule and sharing its prepared data (only display properties may be changed)"},
+		{Name: "include", Usage: "include <path>", Description: "include another config file (relative to the current one)"},
+		{Name: "color", Usage: "color <hex>", Description: "theme color as 3 or 6 hex digits"},
+		{Name: "palette", Usage: "palette <css_path>", Description: "use a pre-generated palette instead of generating one from the color"},
+		{Name: "icon", Usage: "icon <base64_ico>", Description: "favicon"},
+		{Name: "title", Usage: "title <text>", Description: "page title"},
+		{Name: "desc", Usage: "desc <text>", Description: "page description"},
+		{Name: "footer", Usage: "footer <html> | footer <<TERMINATOR", Description: "add a footer paragraph, optionally spanning multiple lines until the terminator"},
+		{Name: "upcoming", Usage: "upcoming <days>", Description: "show events for the next 1-90 days"},
+		{Name: "upcoming-layout", Usage: "upcoming-layout <scroll|stack>", Description: "how to lay out upcoming days"},
+		{Name: "upcoming-skip-empty", Usage: "upcoming-skip-empty", Description: "don't show upcoming days without any events"},
+		{Name: "upcoming-nav", Usage: "upcoming-nav", Description: "show links above the upcoming days to jump to each day"},
+		{Name: "calendar-links", Usage: "calendar-links", Description: "show links to add each upcoming event to Google Calendar (in the timezone if set)"},
+		{Name: "upcoming-group-by", Usage: "upcoming-group-by <time|activity>", Description: "list the events in each upcoming day by time, or grouped by activity"},
+		{Name: "event-colors", Usage: "event-colors <none|activity|category>", Description: "color upcoming event cards with a distinct accent palette for each activity or category"},
+		{Name: "upcoming-max", Usage: "upcoming-max <n>", Description: "show at most 1-100 events per upcoming day, linking to the rest"},
+		{Name: "host", Usage: "host <hostname...>", Description: "only serve the schedule (and list it) for requests to these hostnames instead of all of them (can be specified multiple times)"},
+		{Name: "log-level", Usage: "log-level <debug|info|warn|error>", Description: "override the log level for messages about the schedule (e.g., to debug how it is merged)"},
+		{Name: "no-gzip", Usage: "no-gzip", Description: "don't compress responses for the schedule (e.g., if it is served behind a CDN which already does)"},
+		{Name: "unlisted", Usage: "unlisted", Description: "don't show the schedule in the list, and ask search engines not to index it"},
+		{Name: "max-age", Usage: "max-age <duration> [stale_while_revalidate_duration]", Description: "allow clients to cache the schedule without revalidating"},
+		{Name: "auth", Usage: "auth <user> <bcrypt-hash>", Description: "require http basic authentication (can be specified multiple times)"},
+		{Name: "private", Usage: "private <notifications|upcoming...>", Description: "only require auth for these sections, showing the rest of the schedule publicly and the full schedule at /path/full"},
+		{Name: "time-separator", Usage: "time-separator <separator>", Description: "text between start and end times"},
+		{Name: "activity-sort", Usage: "activity-sort <name|time>", Description: "order of activities and locations"},
+		{Name: "max-activities", Usage: "max-activities <n> [name|count]", Description: "only show n activities, keeping the alphabetically first ones or the ones with the most events, with a note that there are more"},
+		{Name: "empty-activities", Usage: "empty-activities <rename [name]|drop>", Description: "name activities which are empty after trimming whitespace Untitled (or the specified name), or remove them"},
+		{Name: "merge-priority", Usage: "merge-priority <exclusion|exception|duration...>", Description: "order of penalties to minimize when merging events at different times into weekly instances"},
+		{Name: "split-threshold", Usage: "split-threshold <duration>", Description: "show events starting or ending further than this from the rest of their weekly instance separately"},
+		{Name: "exclude-date", Usage: "exclude-date <yyyy-mm-dd...>", Description: "ignore the events on these dates (e.g., if the data for them is wrong), as if there wasn't any data for them (can be specified multiple times)"},
+		{Name: "snap-times", Usage: "snap-times <duration>", Description: "round event start and end times to the nearest multiple of this (e.g., 5m) before merging them into weekly instances, to ignore small inconsistencies in the data"},
+		{Name: "subscribe", Usage: "subscribe <url>", Description: "show calendar subscription links for an iCalendar feed"},
+		{Name: "alternate-links", Usage: "alternate-links", Description: "advertise the text, svg, stats, and subscription formats of the schedule with link[rel=alternate]"},
+		{Name: "source", Usage: "source <name> [url]", Description: "credit the facility the schedule data is from"},
+		{Name: "exception-detail", Usage: "exception-detail <full|summary|collapsed|none>", Description: "how much detail to show for exceptions"},
+		{Name: "first-day-exclusion", Usage: "first-day-exclusion <ignore|show|exclude>", Description: "how to handle exclusions on the first day of the schedule before it was updated, which are usually just events left out of the data since they already happened"},
+		{Name: "align", Usage: "align <center|start|end>", Description: "horizontal alignment of the page content"},
+		{Name: "max-width", Usage: "max-width <css-length>", Description: "limit the width of the page content (e.g., 60em), scrolling the grid if it is wider"},
+		{Name: "sticky-header", Usage: "sticky-header", Description: "keep the grid weekday header and location column visible while scrolling, limiting the grid to the screen height"},
+		{Name: "day-counts", Usage: "day-counts <all|scheduled|none>", Description: "show the number of events in each upcoming day, including or excluding cancelled ones"},
+		{Name: "notification-banner", Usage: "notification-banner", Description: "show the notifications in a single collapsed banner with the number of them and the most recent one"},
+		{Name: "subtotals", Usage: "subtotals", Description: "show the average weekly number and duration of the events in the schedule range after each location in the grid"},
+		{Name: "seconds", Usage: "seconds", Description: "show non-zero seconds in times instead of truncating them to the minute"},
+		{Name: "zero-duration", Usage: "zero-duration <keep|point|hide>", Description: "show instances which start and end at the same time as usual, as only the start time, or not at all"},
+		{Name: "durations", Usage: "durations <none|beside|replace>", Description: "show the duration of events (e.g., 90 min) after their time range, or instead of it in the grid"},
+		{Name: "range-format", Usage: "range-format <dates|week-of>", Description: "show the grid date range as the start and end dates, or as the week of the start date if it is a single week"},
+		{Name: "lang", Usage: "lang <bcp47-tag>", Description: "language of the page content (default en)"},
+		{Name: "direction", Usage: "direction <ltr|rtl>", Description: "text direction"},
+		{Name: "show-categories", Usage: "show-categories", Description: "show activity categories"},
+		{Name: "show-live", Usage: "show-live", Description: "highlight events happening now or next (in the timezone if set, otherwise the server's; the schedule is rendered again every minute)"},
+		{Name: "virtual-location", Usage: "virtual-location <name>", Description: "mark a location as online"},
+		{Name: "date-format", Usage: "date-format <go-time-layout>", Description: "layout for short dates"},
+		{Name: "datetime-format", Usage: "datetime-format <go-time-layout>", Description: "layout for the updated and modified times"},
+		{Name: "timezone", Usage: "timezone <iana-name>", Description: "start upcoming days from the current date in this timezone instead of the date of the last update"},
+		{Name: "available-from", Usage: "available-from <yyyy-mm-dd>", Description: "return not found for the schedule before this date (in the schedule timezone if set, otherwise the server's)"},
+		{Name: "available-until", Usage: "available-until <yyyy-mm-dd>", Description: "return not found for the schedule after this date (in the schedule timezone if set, otherwise the server's)"},
+		{Name: "compact", Usage: "compact", Description: "group locations with a single instance on a single weekday"},
+		{Name: "relative-time", Usage: "relative-time", Description: "show the updated and modified times relative to now using a small inline script"},
+		{Name: "weekly", Usage: "weekly", Description: "show the events in each week separately instead of merging them into weekly instances"},
+		{Name: "show-empty", Usage: "show-empty", Description: "show a muted dash in empty grid cells"},
+		{Name: "qr-code", Usage: "qr-code", Description: "show a qr code linking to the canonical url when printed"},
+		{Name: "show-legend", Usage: "show-legend", Description: "explain the exception markers"},
+		{Name: "hide-cancelled", Usage: "hide-cancelled", Description: "treat cancelled events as if they were never scheduled"},
+		{Name: "notifications-markdown", Usage: "notifications-markdown", Description: "render notifications as sanitized markdown"},
+		{Name: "category-ids", Usage: "category-ids", Description: "include the fusion go category id of each activity in stats.json"},
+		{Name: "notification-max", Usage: "notification-max <n>", Description: "truncate notification text to n characters on the schedule page and text output"},
+		{Name: "dedupe-notifications", Usage: "dedupe-notifications", Description: "only show the most recent notification with the same text"},
+		{Name: "icon.activity", Usage: "icon.activity <name> <svg_path>", Description: "show an icon beside an activity"},
+		{Name: "icon.category", Usage: "icon.category <name> <svg_path>", Description: "show an icon beside activities in a category"},
+		{Name: "category-alias", Usage: "category-alias <from> <to>", Description: "rename a category after filtering"},
+		{Name: "filter.*", Usage: "filter.<key> <action> <args...>", Description: "filter or transform activities (applied in order, optionally grouped with filter.any or filter.all)"},
+	},
+	FilterKeys: []configSchemaItem{
+		{Name: "category", Description: "category names"},
+		{Name: "category_id", Description: "category ids"},
+		{Name: "location", Description: "location name"},
+		{Name: "activity", Description: "activity name"},
+		{Name: "description", Description: "activity description"},
+		{Name: "any", Usage: "filter.any", Description: "start a group of filters keeping activities matching any of them, with only the transformations of the first match applied"},
+		{Name: "all", Usage: "filter.all", Description: "start a group of filters keeping activities matching all of them (for nesting inside filter.any)"},
+		{Name: "end", Usage: "filter.end", Description: "end the innermost filter group"},
+	},
+	FilterActions: []configSchemaItem{
+		{Name: "in", Usage: "in <value...>", MinArgs: 1, MaxArgs: -1, Description: "keep if any value matches exactly"},
+		{Name: "notIn", Usage: "notIn <value...>", MinArgs: 1, MaxArgs: -1, Description: "keep if no value matches exactly"},
+		{Name: "trimPrefix", Usage: "trimPrefix <prefix>", MinArgs: 1, MaxArgs: 1, Description: "remove a prefix"},
+		{Name: "trimSuffix", Usage: "trimSuffix <suffix>", MinArgs: 1, MaxArgs: 1, Description: "remove a suffix"},
+		{Name: "contains", Usage: "contains <substring>", MinArgs: 1, MaxArgs: 1, Description: "keep if any value (e.g., any category) contains the substring (case-sensitive)"},
+		{Name: "notContains", Usage: "notContains <substring>", MinArgs: 1, MaxArgs: 1, Description: "keep unless any value (e.g., any category) contains the substring (case-sensitive)"},
+		{Name: "matches", Usage: "matches <regexp>", MinArgs: 1, MaxArgs: 1, Description: "keep if any value matches the regexp"},
+		{Name: "notMatches", Usage: "notMatches <regexp>", MinArgs: 1, MaxArgs: 1, Description: "keep if no value matches the regexp"},
+		{Name: "replace", Usage: "replace <old> <new>", MinArgs: 2, MaxArgs: 2, Description: "replace all occurrences of a substring"},
+		{Name: "map", Usage: "map <from> <to>", MinArgs: 2, MaxArgs: 2, Description: "replace values matching exactly"},
+	},
+}
+
+type configSchemaItem struct {
+	Name        string `json:"name"`
+	Usage       string `json:"usage,omitempty"`
+	MinArgs     int    `json:"min_args,omitempty"`
+	MaxArgs     int    `json:"max_args,omitempty"` // -1 for no limit
+	Description string `json:"description"`
+}
+
+// Language gets the language of the schedules if they're all the same, or en
+// otherwise.
+func (s schedules) Language() string {
+	var lang string
+	for _, x := range s {
+		l := x.Options.Language
+		if l == "" {
+			l = "en"
+		}
+		if lang != "" && l != lang {
+			return "en"
+		}
+		lang = l
+	}
+	if lang == "" {
+		return "en"
+	}
+	return lang
 }
 
 func (s schedules) Paths() []string {
@@ -473,155 +2066,1740 @@ func (s schedules) Paths() []string {
 	return paths
 }
 
+// Hosts returns the hostnames schedules are restricted to, sorted.
+func (s schedules) Hosts() []string {
+	var hosts []string
+	for _, x := range s {
+		for _, h := range x.Hosts {
+			if !slices.Contains(hosts, h) {
+				hosts = append(hosts, h)
+			}
+		}
+	}
+	slices.Sort(hosts)
+	return hosts
+}
+
+// LogLevel returns the most verbose log level override of the schedules, if
+// any.
+func (s schedules) LogLevel() (slog.Level, bool) {
+	var (
+		lvl slog.Level
+		ok  bool
+	)
+	for _, x := range s {
+		if x.LogLevel != nil && (!ok || *x.LogLevel < lvl) {
+			lvl, ok = *x.LogLevel, true
+		}
+	}
+	return lvl, ok
+}
+
+// SchoolLogLevels returns the most verbose log level of the schedules using
+// each school, using def for schedules without an override. Since the fetched
+// data is shared between schedules, a school is only logged less verbosely if
+// all schedules using it are.
+func (s schedules) SchoolLogLevels(def slog.Level) map[int]slog.Level {
+	r := map[int]slog.Level{}
+	for _, x := range s {
+		if len(x.Combine) != 0 {
+			continue
+		}
+		lvl := def
+		if x.LogLevel != nil {
+			lvl = *x.LogLevel
+		}
+		if cur, ok := r[x.SchoolID]; !ok || lvl < cur {
+			r[x.SchoolID] = lvl
+		}
+	}
+	return r
+}
+
+// BaseLogLevels is like SchoolLogLevels, but for the base schedules of each
+// variant, since the prepared data (and the webhook and history which depend
+// on it) is shared between them.
+func (s schedules) BaseLogLevels(def slog.Level) map[string]slog.Level {
+	r := map[string]slog.Level{}
+	for path, x := range s {
+		if len(x.Combine) != 0 {
+			continue
+		}
+		base := path
+		if x.Variant != "" {
+			base = x.Variant
+		}
+		lvl := def
+		if x.LogLevel != nil {
+			lvl = *x.LogLevel
+		}
+		if cur, ok := r[base]; !ok || lvl < cur {
+			r[base] = lvl
+		}
+	}
+	return r
+}
+
+// ForHost returns the schedules served for the specified canonical hostname.
+func (s schedules) ForHost(host string) schedules {
+	r := schedules{}
+	for path, x := range s {
+		if x.ServedFor(host) {
+			r[path] = x
+		}
+	}
+	return r
+}
+
 type fusionResult struct {
 	Schedule      *fusiongo.Schedule
 	Notifications *fusiongo.Notifications
 }
 
-func fusionFetcher(schoolID int, cfg memcache.CacheConfig) memcache.Cache[fusionResult] {
-	if cfg.Logger != nil {
-		cfg.Logger = cfg.Logger.With("cache", "fusion", "school", schoolID)
+func fusionFetcher(schoolID int, cfg memcache.CacheConfig) memcache.Cache[fusionResult] {
+	if cfg.Logger != nil {
+		cfg.Logger = cfg.Logger.With("cache", "fusion", "school", schoolID)
+	}
+	logger := cfg.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return memcache.Cached(cfg, func(ctx context.Context) (res fusionResult, err error) {
+		if v, err := fusiongo.FetchSchedule(ctx, schoolID); err != nil {
+			return res, err
+		} else if err := checkFusionSchedule(v, logger); err != nil {
+			return res, fmt.Errorf("implausible schedule data (the format may have changed): %w", err)
+		} else {
+			res.Schedule = v
+		}
+		if v, err := fusiongo.FetchNotifications(ctx, schoolID); err != nil {
+			return res, err
+		} else {
+			res.Notifications = v
+		}
+		return res, nil
+	})
+}
+
+// timeOfDay is a local wall-clock time.
+type timeOfDay struct {
+	Hour, Minute int
+}
+
+// nextTimeOfDay returns the earliest of tods after t, in the location of t.
+func nextTimeOfDay(t time.Time, tods []timeOfDay) time.Time {
+	var next time.Time
+	for d := 0; d <= 1; d++ {
+		for _, x := range tods {
+			c := time.Date(t.Year(), t.Month(), t.Day()+d, x.Hour, x.Minute, 0, 0, t.Location())
+			if c.After(t) && (next.IsZero() || c.Before(next)) {
+				next = c
+			}
+		}
+	}
+	return next
+}
+
+// levelHandler wraps a Handler, only enabling records at or above Level. The
+// Handler is still checked, so it must be enabled for at least as many levels.
+type levelHandler struct {
+	slog.Handler
+	Level slog.Leveler
+}
+
+func (h levelHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.Level.Level() && h.Handler.Enabled(ctx, level)
+}
+
+func (h levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return levelHandler{h.Handler.WithAttrs(attrs), h.Level}
+}
+
+func (h levelHandler) WithGroup(name string) slog.Handler {
+	return levelHandler{h.Handler.WithGroup(name), h.Level}
+}
+
+// limitTransport wraps a RoundTripper, failing responses with bodies larger
+// than N bytes.
+type limitTransport struct {
+	http.RoundTripper
+	N int64
+}
+
+func (t limitTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	resp, err := t.RoundTripper.RoundTrip(r)
+	if err != nil {
+		return nil, err
+	}
+	if resp.ContentLength > t.N {
+		resp.Body.Close()
+		return nil, fmt.Errorf("response body too large (limit %d bytes)", t.N)
+	}
+	resp.Body = &limitBody{Closer: resp.Body, r: io.LimitReader(resp.Body, t.N+1), N: t.N}
+	return resp, nil
+}
+
+// limitBody wraps a response body, failing once more than N bytes are read.
+type limitBody struct {
+	io.Closer
+	r io.Reader
+	n int64
+	N int64
+}
+
+func (b *limitBody) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	if b.n += int64(n); b.n > b.N {
+		return n, fmt.Errorf("response body too large (limit %d bytes)", b.N)
+	}
+	return n, err
+}
+
+// maxImplausible is the maximum fraction of activities which can be dropped by
+// checkFusionSchedule before the entire schedule is rejected.
+const maxImplausible = 0.1
+
+// checkFusionSchedule checks that the schedule data looks plausible, to
+// prevent rendering garbage if the data format changes. Implausible activities
+// are removed, but if there are too many of them, an error is returned so the
+// previous data continues to be used.
+func checkFusionSchedule(s *fusiongo.Schedule, logger *slog.Logger) error {
+	if s.Updated.IsZero() {
+		return fmt.Errorf("missing update time")
+	}
+	if s.Updated.After(time.Now().Add(time.Hour * 24)) {
+		return fmt.Errorf("update time %s is in the future", s.Updated)
+	}
+	validTime := func(t fusiongo.Time) bool {
+		return t.Hour >= 0 && t.Minute >= 0 && t.Second >= 0 && t.Minute < 60 && t.Second < 60 && (t.Hour < 24 || t == fusiongo.Time{Hour: 24})
+	}
+	var bad []string
+	n := len(s.Activities)
+	s.Activities = slices.DeleteFunc(s.Activities, func(ai fusiongo.ActivityInstance) bool {
+		var why string
+		switch d := ai.Time.Date; {
+		case strings.TrimSpace(ai.Activity) == "":
+			why = "missing activity name"
+		case d.Month < time.January || d.Month > time.December || d.Day < 1 || d.Day > 31:
+			why = "invalid date"
+		case d.Year < s.Updated.Year()-1 || d.Year > s.Updated.Year()+1:
+			why = "date too far from update time"
+		case !validTime(ai.Time.TimeRange.Start) || !validTime(ai.Time.TimeRange.End):
+			why = "invalid time"
+		}
+		if why != "" {
+			bad = append(bad, why)
+			logger.Warn("dropping implausible activity", "reason", why, slog.Group("activity", "time", ai.Time, "activity", ai.Activity, "location", ai.Location))
+		}
+		return why != ""
+	})
+	if len(bad) != 0 && float64(len(bad)) > float64(n)*maxImplausible {
+		return fmt.Errorf("%d/%d activities are implausible (e.g., %s)", len(bad), n, bad[0])
+	}
+	return nil
+}
+
+type scheduleResult struct {
+	Error    error // if set, old (non-stale) data is being used for the schedule
+	Schedule *ifgsch.Schedule
+	Options  ifgsch.Options // used to render the schedule
+	Hash     string         // of the prepared schedule, if not combined
+
+	HTML    encodedBody
+	Text    encodedBody
+	SVG     encodedBody
+	Stats   encodedBody
+	Preview *previewCache
+	Days    *dayCache
+}
+
+// dayCache caches the upcoming day pages of a schedule. It doesn't need to be
+// bounded since only days within the schedule range are rendered.
+type dayCache struct {
+	Gzip bool // whether to compress the rendered days
+
+	m sync.Map // fusiongo.Date -> *encodedBody
+}
+
+// Render gets the page for the specified day.
+func (c *dayCache) Render(res *scheduleResult, day fusiongo.Date) (*encodedBody, error) {
+	if b, ok := c.m.Load(day); ok {
+		return b.(*encodedBody), nil
+	}
+
+	var buf bytes.Buffer
+	if err := ifgsch.RenderDay(&buf, &res.Options, res.Schedule, day); err != nil {
+		return nil, fmt.Errorf("render schedule: %w", err)
+	}
+	b, err := encodeBody(buf.Bytes(), c.Gzip)
+	if err != nil {
+		return nil, fmt.Errorf("compress schedule: %w", err)
+	}
+	v, _ := c.m.LoadOrStore(day, &b)
+	return v.(*encodedBody), nil
+}
+
+// previewCache caches schedules rendered with a different color.
+type previewCache struct {
+	Gzip bool // whether to compress the rendered schedules
+
+	cache lru.Cache[string, *encodedBody]
+}
+
+// newPreviewCache creates a new previewCache caching up to maxPreviewColors
+// renders.
+func newPreviewCache(gzip bool) *previewCache {
+	p := &previewCache{Gzip: gzip}
+	p.cache.Size = maxPreviewColors
+	return p
+}
+
+// maxPreviewColors is the maximum number of preview colors to cache for each
+// version of a schedule.
+const maxPreviewColors = 16
+
+// Render gets the schedule rendered with the specified color. Concurrent
+// renders of different colors don't block each other.
+func (p *previewCache) Render(res *scheduleResult, color string) (*encodedBody, error) {
+	if b, ok := p.cache.Get(color); ok {
+		return b, nil
+	}
+
+	opt := res.Options // copy
+	opt.Color = color
+	opt.Palette = ""
+
+	var buf bytes.Buffer
+	if err := ifgsch.Render(&buf, &opt, res.Schedule); err != nil {
+		return nil, fmt.Errorf("render schedule: %w", err)
+	}
+	b, err := encodeBody(buf.Bytes(), p.Gzip)
+	if err != nil {
+		return nil, fmt.Errorf("compress schedule: %w", err)
+	}
+	p.cache.Add(color, &b)
+	return &b, nil
+}
+
+// preparedSchedule is a prepared schedule which may be shared between multiple
+// renderers.
+type preparedSchedule struct {
+	Error    error // if set, old (non-stale) data is being used for the schedule
+	Schedule *ifgsch.Schedule
+	Hash     string // of the schedule, excluding the modification time
+}
+
+func schedulePreparer(filter ifgsch.Filter, prep ifgsch.PrepareOptions, fusion memcache.Cache[fusionResult], cfg memcache.CachedTransformConfig) memcache.Cache[preparedSchedule] {
+	if cfg.Logger != nil {
+		cfg.Logger = cfg.Logger.With("cache", "prepare")
+	}
+	prep.Logger = cfg.Logger
+	return memcache.CachedTransform(fusion, cfg, func(fusion fusionResult, fusionErr error) (res preparedSchedule, err error) {
+		res.Error = fusionErr
+		if schedule, err := ifgsch.Prepare(fusion.Schedule, fusion.Notifications, filter, &prep); err != nil {
+			return res, fmt.Errorf("prepare schedule: %w", err)
+		} else {
+			res.Schedule = schedule
+			res.Hash = scheduleHash(schedule)
+		}
+		return res, nil
+	})
+}
+
+// scheduleUpdated returns a callback for updates to the data of a prepared
+// schedule which calls notify with the updated schedule. This is done when the
+// data is updated rather than when the schedule is requested so changes are
+// detected even if it isn't.
+func scheduleUpdated(prepared memcache.Cache[preparedSchedule], notify func(*ifgsch.Schedule)) func() {
+	return func() {
+		if res, err := prepared.Get(); err == nil && res.Error == nil {
+			notify(res.Schedule)
+		}
+	}
+}
+
+// scheduleHash hashes the dump of s, which is deterministic. The modification
+// time is excluded since it may change without the events changing.
+func scheduleHash(s *ifgsch.Schedule) string {
+	x := *s // copy
+	x.Modified = time.Time{}
+	hash := sha1.Sum(ifgsch.Dump(&x))
+	return hex.EncodeToString(hash[:])
+}
+
+// renderMaxAge gets the maximum amount of time to use a render of a schedule
+// with the provided options for, since the live status of events depends on
+// the current time.
+func renderMaxAge(o *ifgsch.Options) time.Duration {
+	if o.Live {
+		return time.Minute
+	}
+	return 0
+}
+
+func scheduleRenderer(opt ifgsch.Options, prepared memcache.Cache[preparedSchedule], gzip bool, cfg memcache.CachedTransformConfig) memcache.Cache[scheduleResult] {
+	if cfg.Logger != nil {
+		cfg.Logger = cfg.Logger.With("cache", "schedule", "title", opt.Title)
+	}
+	return memcache.CachedTransform(prepared, cfg, func(prepared preparedSchedule, preparedErr error) (res scheduleResult, err error) {
+		if preparedErr != nil {
+			return res, preparedErr // the transform failed, so there isn't a schedule
+		}
+		opt := opt // copy
+		if prepared.Error != nil {
+			res.Error = prepared.Error
+			opt.Footer = append(opt.Footer, template.HTML(`<span style="color:var(--md-ref-palette-error50)">Warning: schedule update failed (using cached schedule data): `+html.EscapeString(prepared.Error.Error())+`.</span>`))
+		}
+		if res.Schedule, err = ifgsch.LimitActivities(&opt, prepared.Schedule); err != nil {
+			return res, fmt.Errorf("limit activities: %w", err)
+		}
+		res.Hash = prepared.Hash
+		res.Options = opt
+		res.Preview = newPreviewCache(gzip)
+		res.Days = &dayCache{Gzip: gzip}
+		{
+			var buf bytes.Buffer
+			if err := ifgsch.Render(&buf, &opt, res.Schedule); err != nil {
+				return res, fmt.Errorf("render schedule: %w", err)
+			}
+			if v, err := encodeBody(buf.Bytes(), gzip); err != nil {
+				return res, fmt.Errorf("compress schedule: %w", err)
+			} else {
+				res.HTML = v
+			}
+		}
+		{
+			var buf bytes.Buffer
+			if err := ifgsch.RenderText(&buf, &opt, res.Schedule); err != nil {
+				return res, fmt.Errorf("render schedule text: %w", err)
+			}
+			if v, err := encodeBody(buf.Bytes(), gzip); err != nil {
+				return res, fmt.Errorf("compress schedule text: %w", err)
+			} else {
+				res.Text = v
+			}
+		}
+		{
+			var buf bytes.Buffer
+			if err := ifgsch.RenderSVG(&buf, &opt, res.Schedule); err != nil {
+				return res, fmt.Errorf("render schedule svg: %w", err)
+			}
+			if v, err := encodeBody(buf.Bytes(), gzip); err != nil {
+				return res, fmt.Errorf("compress schedule svg: %w", err)
+			} else {
+				res.SVG = v
+			}
+		}
+		{
+			buf, err := scheduleStatsJSON(res.Schedule)
+			if err != nil {
+				return res, fmt.Errorf("encode schedule stats: %w", err)
+			}
+			if v, err := encodeBody(buf, gzip); err != nil {
+				return res, fmt.Errorf("compress schedule stats: %w", err)
+			} else {
+				res.Stats = v
+			}
+		}
+		return res, nil
+	})
+}
+
+// scheduleStatsJSON computes and encodes the statistics for a schedule.
+func scheduleStatsJSON(s *ifgsch.Schedule) ([]byte, error) {
+	st := ifgsch.ComputeStats(s)
+
+	type activity struct {
+		Name        string `json:"name"`
+		CategoryID  string `json:"category_id,omitempty"`
+		Occurrences int    `json:"occurrences"`
+		Cancelled   int    `json:"cancelled"`
+	}
+	type location struct {
+		Name        string `json:"name"`
+		Occurrences int    `json:"occurrences"`
+		Minutes     int    `json:"minutes"`
+	}
+	var payload struct {
+		Start       string         `json:"start"`
+		End         string         `json:"end"`
+		Updated     time.Time      `json:"updated"`
+		Weekly      int            `json:"weekly"`
+		Occurrences int            `json:"occurrences"`
+		Cancelled   int            `json:"cancelled"`
+		Excluded    int            `json:"excluded"`
+		Weekdays    map[string]int `json:"weekdays"`
+		Busiest     string         `json:"busiest_weekday"`
+		Activities  []activity     `json:"activities"`
+		Locations   []location     `json:"locations"`
+	}
+	payload.Start = s.Start.String()
+	payload.End = s.End.String()
+	payload.Updated = s.Updated.UTC()
+	payload.Weekly = st.Weekly
+	payload.Occurrences = st.Occurrences
+	payload.Cancelled = st.Cancelled
+	payload.Excluded = st.Excluded
+	payload.Weekdays = map[string]int{}
+	for wd, n := range st.Weekdays {
+		payload.Weekdays[strings.ToLower(time.Weekday(wd).String())] = n
+	}
+	payload.Busiest = strings.ToLower(st.Busiest.String())
+	payload.Activities = []activity{}
+	for _, a := range st.Activities {
+		payload.Activities = append(payload.Activities, activity(a))
+	}
+	payload.Locations = []location{}
+	for _, l := range st.Locations {
+		payload.Locations = append(payload.Locations, location(l))
+	}
+	return json.Marshal(payload)
+}
+
+// combinedSource is a schedule included in a combined schedule.
+type combinedSource struct {
+	Name     string
+	Path     string // for linking to the source schedule, if not empty
+	Renderer memcache.Cache[scheduleResult]
+}
+
+// combinedRenderer renders the upcoming events from multiple schedules,
+// updating it whenever any of the source schedules are updated. Only HTML is
+// rendered, and the result schedule only contains the update times.
+func combinedRenderer(opt ifgsch.Options, sources []combinedSource, gzip bool, cfg memcache.CachedTransformConfig) memcache.Cache[scheduleResult] {
+	if cfg.Logger != nil {
+		cfg.Logger = cfg.Logger.With("cache", "combined", "title", opt.Title)
+	}
+	caches := make([]memcache.Cache[scheduleResult], len(sources))
+	for i, src := range sources {
+		caches[i] = src.Renderer
+	}
+	return memcache.CachedTransform(memcache.Combine(caches...), cfg, func(results []*scheduleResult, resultsErr error) (res scheduleResult, err error) {
+		opt := opt // copy
+		var srcs []ifgsch.CombinedSource
+		for i, x := range results {
+			if x == nil {
+				opt.Footer = append(opt.Footer, template.HTML(`<span style="color:var(--md-ref-palette-error50)">Warning: failed to get schedule for `+html.EscapeString(sources[i].Name)+`.</span>`))
+				continue
+			}
+			if x.Error != nil {
+				opt.Footer = append(opt.Footer, template.HTML(`<span style="color:var(--md-ref-palette-error50)">Warning: schedule update failed for `+html.EscapeString(sources[i].Name)+` (using cached schedule data).</span>`))
+			}
+			srcs = append(srcs, ifgsch.CombinedSource{
+				Name:     sources[i].Name,
+				Path:     sources[i].Path,
+				Schedule: x.Schedule,
+			})
+		}
+		res.Error = resultsErr
+		res.Options = opt
+		res.Schedule = new(ifgsch.Schedule)
+		for _, src := range srcs {
+			if src.Schedule.Updated.After(res.Schedule.Updated) {
+				res.Schedule.Updated = src.Schedule.Updated
+			}
+			if src.Schedule.Modified.After(res.Schedule.Modified) {
+				res.Schedule.Modified = src.Schedule.Modified
+			}
+		}
+		var buf bytes.Buffer
+		if err := ifgsch.RenderCombined(&buf, &opt, srcs); err != nil {
+			return res, fmt.Errorf("render combined schedule: %w", err)
+		}
+		if v, err := encodeBody(buf.Bytes(), gzip); err != nil {
+			return res, fmt.Errorf("compress combined schedule: %w", err)
+		} else {
+			res.HTML = v
+		}
+		return res, nil
+	})
+}
+
+// changeNotifier calls a webhook when a schedule changes.
+type changeNotifier struct {
+	URL      string
+	Path     string
+	Title    string
+	Debounce time.Duration
+	Logger   *slog.Logger
+
+	mu      sync.Mutex
+	base    *ifgsch.Schedule
+	pending *ifgsch.Schedule
+	timer   *time.Timer
+
+	flushMu sync.Mutex // held while flushing so a timer reset during a webhook call doesn't start another one
+}
+
+// Update sets the latest version of the schedule. The webhook is called with
+// the changes since the last call once no updates have been received for the
+// debounce interval.
+func (n *changeNotifier) Update(s *ifgsch.Schedule) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.base == nil {
+		n.base = s
+		return
+	}
+	n.pending = s
+	if n.timer == nil {
+		n.timer = time.AfterFunc(n.Debounce, n.flush)
+	} else {
+		n.timer.Reset(n.Debounce)
+	}
+}
+
+func (n *changeNotifier) flush() {
+	n.flushMu.Lock()
+	defer n.flushMu.Unlock()
+
+	n.mu.Lock()
+	base, pending := n.base, n.pending
+	n.pending = nil
+	n.mu.Unlock()
+
+	if pending == nil {
+		return
+	}
+
+	changes := ifgsch.Diff(base, pending)
+	if len(changes) == 0 {
+		n.Logger.Debug("no schedule changes")
+		n.advance(pending)
+		return
+	}
+
+	var payload struct {
+		Schedule string           `json:"schedule"`
+		Title    string           `json:"title,omitempty"`
+		Updated  time.Time        `json:"updated"`
+		Changes  []scheduleChange `json:"changes"`
+	}
+	payload.Schedule = n.Path
+	payload.Title = n.Title
+	payload.Updated = pending.Updated.UTC()
+	for _, c := range changes {
+		payload.Changes = append(payload.Changes, newScheduleChange(c))
+	}
+
+	buf, err := json.Marshal(payload)
+	if err != nil {
+		n.Logger.Error("failed to encode webhook payload", "error", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*15)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.URL, bytes.NewReader(buf))
+	if err != nil {
+		n.Logger.Error("failed to create webhook request", "error", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		n.Logger.Warn("failed to call webhook", "changes", len(changes), "error", err)
+		return
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		n.Logger.Warn("failed to call webhook", "changes", len(changes), "error", fmt.Errorf("response status %s", resp.Status))
+		return
+	}
+	n.Logger.Info("called webhook", "changes", len(changes))
+	n.advance(pending)
+}
+
+// advance sets the schedule future changes are compared against. It is only
+// called once changes are sent so they are included in the next update if the
+// webhook fails.
+func (n *changeNotifier) advance(s *ifgsch.Schedule) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.base = s
+}
+
+// scheduleChange is the JSON encoding of an [ifgsch.Change].
+type scheduleChange struct {
+	Kind     ifgsch.ChangeKind `json:"kind"`
+	Activity string            `json:"activity"`
+	Location string            `json:"location"`
+	Date     string            `json:"date"`
+	Start    string            `json:"start"`
+	End      string            `json:"end"`
+	OldStart string            `json:"old_start,omitempty"`
+	OldEnd   string            `json:"old_end,omitempty"`
+}
+
+func newScheduleChange(c ifgsch.Change) scheduleChange {
+	x := scheduleChange{
+		Kind:     c.Kind,
+		Activity: c.Activity,
+		Location: c.Location,
+		Date:     c.Date.String(),
+		Start:    c.Time.Start.String(),
+		End:      c.Time.End.String(),
+	}
+	if c.Kind == ifgsch.ChangeTime {
+		x.OldStart = c.OldTime.Start.String()
+		x.OldEnd = c.OldTime.End.String()
+	}
+	return x
+}
+
+// historyEntry is a change detected by a [changeHistory].
+type historyEntry struct {
+	Detected time.Time `json:"detected"` // same as Updated since changes are detected when the data is updated
+	Updated  time.Time `json:"updated"`  // of the schedule data the change was detected in
+	scheduleChange
+}
+
+// changeHistory keeps the most recent changes to a schedule across updates.
+type changeHistory struct {
+	Max    int
+	File   string // if set, the entries are saved as JSON to this file after every change
+	Logger *slog.Logger
+
+	mu      sync.Mutex
+	base    *ifgsch.Schedule
+	entries []historyEntry // oldest first
+}
+
+// Load reads the entries from File, if it exists. The first update after
+// loading is only used as the base for detecting later changes.
+func (h *changeHistory) Load() error {
+	buf, err := os.ReadFile(h.File)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	var entries []historyEntry
+	if err := json.Unmarshal(buf, &entries); err != nil {
+		return fmt.Errorf("parse %q: %w", h.File, err)
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.entries = entries[max(len(entries)-h.Max, 0):]
+	return nil
+}
+
+// Update sets the latest version of the schedule, recording the changes since
+// the previous one.
+func (h *changeHistory) Update(s *ifgsch.Schedule) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	base := h.base
+	h.base = s
+	if base == nil {
+		return
+	}
+
+	changes := ifgsch.Diff(base, s)
+	if len(changes) == 0 {
+		return
+	}
+	for _, c := range changes {
+		h.entries = append(h.entries, historyEntry{
+			Detected:       s.Updated.UTC(),
+			Updated:        s.Updated.UTC(),
+			scheduleChange: newScheduleChange(c),
+		})
+	}
+	if n := len(h.entries) - h.Max; n > 0 {
+		h.entries = slices.Delete(h.entries, 0, n)
+	}
+	h.Logger.Info("recorded schedule changes", "changes", len(changes))
+
+	if h.File != "" {
+		if err := h.save(); err != nil {
+			h.Logger.Error("failed to save change history", "error", err)
+		}
+	}
+}
+
+// Entries returns the recorded changes, most recent first.
+func (h *changeHistory) Entries() []historyEntry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	entries := slices.Clone(h.entries)
+	slices.Reverse(entries)
+	return entries
+}
+
+func (h *changeHistory) save() error {
+	buf, err := json.Marshal(h.entries)
+	if err != nil {
+		return err
+	}
+	tmp := h.File + ".tmp"
+	if err := os.WriteFile(tmp, buf, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, h.File)
+}
+
+// listenAddr is an address to listen on.
+type listenAddr struct {
+	Network string // tcp, tcp4, or tcp6
+	Address string
+}
+
+// listenAddrs is a [flag.Value] for comma-separated listen addresses.
+type listenAddrs []listenAddr
+
+func (v listenAddrs) String() string {
+	var b strings.Builder
+	for i, a := range v {
+		if i != 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(a.Network)
+		b.WriteByte('/')
+		b.WriteString(a.Address)
+	}
+	return b.String()
+}
+
+func (v *listenAddrs) Set(s string) error {
+	x, err := parseListenAddrs(s)
+	if err == nil {
+		*v = x
+	}
+	return err
+}
+
+// parseListenAddrs parses comma-separated listen addresses. If an address isn't
+// prefixed with the network (e.g., tcp4/:8080), tcp4 or tcp6 is used if the
+// host is an ip address, and tcp (i.e., whatever the system does by default,
+// which is usually dual-stack) otherwise.
+func parseListenAddrs(s string) ([]listenAddr, error) {
+	var as []listenAddr
+	for _, x := range strings.Split(s, ",") {
+		if x = strings.TrimSpace(x); x == "" {
+			continue
+		}
+		var a listenAddr
+		if n, addr, ok := strings.Cut(x, "/"); ok {
+			switch n {
+			case "tcp", "tcp4", "tcp6":
+				a.Network, a.Address = n, addr
+			default:
+				return nil, fmt.Errorf("invalid listen address %q: unsupported network %q", x, n)
+			}
+		} else {
+			a.Address = x
+		}
+		host, _, err := net.SplitHostPort(a.Address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid listen address %q: %w", x, err)
+		}
+		if ip, err := netip.ParseAddr(host); err == nil {
+			v6 := ip.Is6() && !ip.Is4In6()
+			switch a.Network {
+			case "":
+				if v6 {
+					a.Network = "tcp6"
+				} else {
+					a.Network = "tcp4"
+				}
+			case "tcp4":
+				if v6 {
+					return nil, fmt.Errorf("invalid listen address %q: ipv6 address for tcp4", x)
+				}
+			case "tcp6":
+				if !v6 {
+					return nil, fmt.Errorf("invalid listen address %q: ipv4 address for tcp6", x)
+				}
+			}
+		} else if a.Network == "" {
+			a.Network = "tcp"
+		}
+		as = append(as, a)
+	}
+	if len(as) == 0 {
+		return nil, fmt.Errorf("no listen addresses")
+	}
+	return as, nil
+}
+
+// parseAutocertHosts parses comma-separated hostnames, ignoring whitespace and
+// empty entries.
+func parseAutocertHosts(s string) ([]string, error) {
+	var hosts []string
+	for _, x := range strings.Split(s, ",") {
+		if x = strings.TrimSpace(x); x != "" {
+			hosts = append(hosts, x)
+		}
+	}
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("no hostnames")
+	}
+	return hosts, nil
+}
+
+// proxyRemoteAddr selects the client address from the values of a proxy
+// header. If trusted is not empty, the header is ignored unless remoteAddr is
+// trusted, and trusted addresses are removed from the right. Then, if idx is
+// zero, the leftmost value is returned, otherwise the idx-th value from the
+// right is returned. If there isn't a matching value, an empty string is
+// returned.
+func proxyRemoteAddr(remoteAddr string, header []string, idx int, trusted []netip.Prefix) string {
+	isTrusted := func(x string) bool {
+		a, err := netip.ParseAddr(x)
+		if err != nil {
+			ap, err := netip.ParseAddrPort(x)
+			if err != nil {
+				return false
+			}
+			a = ap.Addr()
+		}
+		a = a.Unmap()
+		for _, p := range trusted {
+			if p.Contains(a) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(trusted) != 0 && !isTrusted(remoteAddr) {
+		return ""
+	}
+	var vs []string
+	for _, h := range header {
+		for _, x := range strings.Split(h, ",") {
+			vs = append(vs, strings.TrimSpace(x))
+		}
+	}
+	if len(trusted) != 0 {
+		for len(vs) != 0 && isTrusted(vs[len(vs)-1]) {
+			vs = vs[:len(vs)-1]
+		}
+	}
+	if len(vs) == 0 {
+		return ""
+	}
+	if idx == 0 {
+		return vs[0]
+	}
+	if idx > len(vs) {
+		return ""
+	}
+	return vs[len(vs)-idx]
+}
+
+// basicAuthDummy is used to compare passwords against if the username does not
+// exist so the response time doesn't reveal which usernames are valid.
+var basicAuthDummy, _ = bcrypt.GenerateFromPassword([]byte("dummy"), bcrypt.DefaultCost)
+
+// basicAuth wraps next with HTTP basic authentication. The users map usernames
+// to bcrypt password hashes.
+func basicAuth(next http.Handler, realm string, users map[string][]byte) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); ok {
+			hash, found := basicAuthDummy, false
+			for u, h := range users {
+				if subtle.ConstantTimeCompare([]byte(u), []byte(user)) == 1 {
+					hash, found = h, true
+				}
+			}
+			if err := bcrypt.CompareHashAndPassword(hash, []byte(pass)); err == nil && found {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="`+strings.NewReplacer(`"`, "", `\`, "").Replace(realm)+`", charset="UTF-8"`)
+		w.Header().Set("Cache-Control", "private, no-store")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	})
+}
+
+// hostHandler wraps next to serve notFound if the schedule isn't served for the
+// request host. The notFound handler is dereferenced at request time.
+func hostHandler(x *schedule, next http.Handler, notFound *http.Handler, host func(*http.Request) string) http.Handler {
+	if len(x.Hosts) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !x.ServedFor(host(r)) {
+			(*notFound).ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// hostRouter calls fn with the schedules served for each host, returning a
+// handler which dispatches on the request host.
+func hostRouter(cfg schedules, host func(*http.Request) string, fn func(cfg schedules) http.Handler) http.Handler {
+	hosts := cfg.Hosts()
+	if len(hosts) == 0 {
+		return fn(cfg)
+	}
+	hs := make(map[string]http.Handler, len(hosts)+1)
+	hs[""] = fn(cfg.ForHost(""))
+	for _, h := range hosts {
+		hs[h] = fn(cfg.ForHost(h))
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hs[host(r)].ServeHTTP(w, r)
+	})
+}
+
+// requestHost gets the canonical hostname of r, replacing it with fallback
+// (which may be empty) if it isn't one of hosts.
+func requestHost(r *http.Request, hosts []string, fallback string) string {
+	h := r.Host
+	if v, _, err := net.SplitHostPort(h); err == nil {
+		h = v
+	}
+	if h = canonicalHost(h); !slices.Contains(hosts, h) {
+		return fallback
+	}
+	return h
+}
+
+// canonicalHost lowercases h and removes the trailing dot if present.
+func canonicalHost(h string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(h)), ".")
+}
+
+// availableHandler wraps next to serve notFound if the schedule isn't currently
+// available. The notFound handler is dereferenced at request time.
+func availableHandler(x *schedule, next http.Handler, notFound *http.Handler) http.Handler {
+	if x.AvailableFrom == (fusiongo.Date{}) && x.AvailableUntil == (fusiongo.Date{}) {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !x.Available(time.Now()) {
+			(*notFound).ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// scheduleRouter dispatches requests to the handler for the path without the
+// leading slash, or the handler for its parent with a trailing slash, falling
+// back to notFound. A path with a trailing slash is redirected to the path
+// without it if there is a handler for that.
+func scheduleRouter(handlers map[string]http.Handler, notFound http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if n, ok := strings.CutPrefix(r.URL.Path, "/"); ok {
+			if x, ok := strings.CutSuffix(n, "/"); ok && x != "" {
+				if _, ok := handlers[x]; ok {
+					u := *r.URL
+					u.Path = "/" + x
+					http.Redirect(w, r, u.RequestURI(), http.StatusMovedPermanently)
+					return
+				}
+			}
+			if h, ok := handlers[n]; ok {
+				h.ServeHTTP(w, r)
+				return
+			}
+			if i := strings.LastIndexByte(n, '/'); i != -1 {
+				if h, ok := handlers[n[:i+1]]; ok {
+					h.ServeHTTP(w, r)
+					return
+				}
+			}
+		}
+		notFound.ServeHTTP(w, r)
+	})
+}
+
+func scheduleHandler(cache cacheConfig, gzip, preview bool, schedule memcache.Cache[scheduleResult]) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		schedule, ok := getSchedule(w, r, cache, schedule)
+		if !ok {
+			return
+		}
+
+		body := &schedule.HTML
+		if color := r.URL.Query().Get("color"); preview && color != "" {
+			if !isHexColor(color) {
+				scheduleError(w, http.StatusText(http.StatusBadRequest)+": invalid color (expected RRGGBB)", http.StatusBadRequest)
+				return
+			}
+			var err error
+			if body, err = schedule.Preview.Render(schedule, strings.ToLower(color)); err != nil {
+				scheduleError(w, http.StatusText(http.StatusInternalServerError)+": "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("X-Robots-Tag", "noindex")
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+		serveScheduleBody(w, r, cache, gzip, body, schedule.Schedule.Modified)
+	})
+}
+
+// cssLength matches a positive CSS length in the units supported for
+// max-width.
+var cssLength = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?(px|em|rem|ch|vw|%)$`)
+
+// isHexColor checks if s is a six-digit hex color without the leading #.
+func isHexColor(s string) bool {
+	if len(s) != 6 {
+		return false
+	}
+	for _, c := range s {
+		switch {
+		case '0' <= c && c <= '9':
+		case 'a' <= c && c <= 'f':
+		case 'A' <= c && c <= 'F':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// scheduleDayHandler serves the events for a single day of the schedule at
+// YYYY-MM-DD under the schedule path.
+func scheduleDayHandler(cache cacheConfig, gzip bool, schedule memcache.Cache[scheduleResult]) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var day fusiongo.Date
+		if t, err := time.Parse("2006-01-02", r.URL.Path[strings.LastIndexByte(r.URL.Path, '/')+1:]); err != nil {
+			scheduleError(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		} else {
+			day = fusiongo.Date{Year: t.Year(), Month: t.Month(), Day: t.Day()}
+		}
+
+		schedule, ok := getSchedule(w, r, cache, schedule)
+		if !ok {
+			return
+		}
+
+		if day.Less(schedule.Schedule.Start) || schedule.Schedule.End.Less(day) {
+			scheduleError(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+
+		body, err := schedule.Days.Render(schedule, day)
+		if err != nil {
+			scheduleError(w, http.StatusText(http.StatusInternalServerError)+": "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+		serveScheduleBody(w, r, cache, gzip, body, schedule.Schedule.Modified)
+	})
+}
+
+// scheduleTextHandler serves the plain-text version of the schedule.
+func scheduleTextHandler(cache cacheConfig, gzip bool, schedule memcache.Cache[scheduleResult]) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		schedule, ok := getSchedule(w, r, cache, schedule)
+		if !ok {
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+		serveScheduleBody(w, r, cache, gzip, &schedule.Text, schedule.Schedule.Modified)
+	})
+}
+
+// scheduleKeys returns the handler keys for a schedule, split by whether they
+// show the full schedule when only some sections are private. The change
+// history is recorded from the full schedule, so it is never public.
+func scheduleKeys(path string, x *schedule, history bool) (public, full []string) {
+	public = []string{path, path + "/", path + ".txt", path + ".svg", path + "/stats.json"}
+	if history {
+		full = append(full, path+"/changes", path+"/changes.json")
+	}
+	if x.Private.Any() {
+		full = append(full, path+"/full")
+		if x.Private.Upcoming {
+			public = slices.DeleteFunc(public, func(k string) bool { return k == path+"/" })
+			full = append(full, path+"/")
+		}
+	}
+	return public, full
+}
+
+// protectScheduleHandlers wraps the handlers for the keys of a schedule. If
+// only some sections are private, auth is only required for the full keys.
+func protectScheduleHandlers(handlers map[string]http.Handler, path string, x *schedule, public, full []string, notFound *http.Handler, host func(*http.Request) string) {
+	for _, k := range append(slices.Clip(public), full...) {
+		if x.Unlisted {
+			next := handlers[k]
+			handlers[k] = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("X-Robots-Tag", "noindex")
+				next.ServeHTTP(w, r)
+			})
+		}
+		if len(x.Auth) != 0 && (!x.Private.Any() || slices.Contains(full, k)) {
+			handlers[k] = basicAuth(handlers[k], "/"+path, x.Auth)
+		}
+		handlers[k] = availableHandler(x, handlers[k], notFound)
+		handlers[k] = hostHandler(x, handlers[k], notFound, host)
+	}
+}
+
+// scheduleSVGHandler serves the schedule grid as a standalone SVG image.
+func scheduleSVGHandler(cache cacheConfig, gzip bool, schedule memcache.Cache[scheduleResult]) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		schedule, ok := getSchedule(w, r, cache, schedule)
+		if !ok {
+			return
+		}
+
+		w.Header().Set("Content-Type", "image/svg+xml; charset=utf-8")
+
+		serveScheduleBody(w, r, cache, gzip, &schedule.SVG, schedule.Schedule.Modified)
+	})
+}
+
+// scheduleStatsHandler serves the JSON-encoded schedule statistics.
+func scheduleStatsHandler(cache cacheConfig, gzip bool, schedule memcache.Cache[scheduleResult]) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		schedule, ok := getSchedule(w, r, cache, schedule)
+		if !ok {
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		serveScheduleBody(w, r, cache, gzip, &schedule.Stats, schedule.Schedule.Modified)
+	})
+}
+
+// scheduleChangesHandler serves the recorded schedule changes as HTML or JSON.
+func scheduleChangesHandler(h *changeHistory, opt *ifgsch.Options, asJSON bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		var buf []byte
+		if asJSON {
+			v, err := json.Marshal(struct {
+				Changes []historyEntry `json:"changes"`
+			}{
+				Changes: append([]historyEntry{}, h.Entries()...),
+			})
+			if err != nil {
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			buf = v
+			w.Header().Set("Content-Type", "application/json")
+		} else {
+			buf = scheduleChangesPage(opt, h.Entries())
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		}
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Content-Length", strconv.Itoa(len(buf)))
+		w.WriteHeader(http.StatusOK)
+		if r.Method != http.MethodHead {
+			w.Write(buf)
+		}
+	})
+}
+
+func scheduleChangesPage(opt *ifgsch.Options, entries []historyEntry) []byte {
+	title := opt.Title
+	if title == "" {
+		title = "Schedule"
+	}
+	lang := opt.Language
+	if lang == "" {
+		lang = "en"
+	}
+	var buf bytes.Buffer
+	buf.WriteString(`<!DOCTYPE html><html lang="` + html.EscapeString(lang) + `"><head>`)
+	buf.WriteString(`<meta charset="utf-8">`)
+	buf.WriteString(`<meta name="viewport" content="width=device-width, initial-scale=1.0">`)
+	buf.WriteString(`<meta name="generator" content="ifgsch">`)
+	buf.WriteString(`<meta name="color-scheme" content="light dark">`)
+	buf.WriteString(`<title>Changes - ` + html.EscapeString(title) + `</title>`)
+	buf.WriteString(`<style>`)
+	buf.WriteString(` html { color-scheme: light dark; background: #fafafa; color: #000 }`)
+	buf.WriteString(` body { font-family: system-ui, -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, Oxygen, Ubuntu, Cantarell, 'Open Sans', 'Helvetica Neue', sans-serif }`)
+	buf.WriteString(` body { background: inherit; color: inherit; max-width: 960px; margin: 0 auto }`)
+	buf.WriteString(` a { color: #00a }`)
+	buf.WriteString(` h1.title { font-weight: bold; font-size: 1.6em; text-align: center; margin: 1em; padding: 0 }`)
+	buf.WriteString(` p.message { text-align: center; margin: 1em }`)
+	buf.WriteString(` table { border-collapse: collapse; margin: 1em; width: calc(100% - 2em) }`)
+	buf.WriteString(` th, td { text-align: start; padding: .25em .5em; border-bottom: 1px solid #bbb }`)
+	buf.WriteString(` .old { text-decoration: line-through; opacity: 0.7 }`)
+	buf.WriteString(` footer { margin: 1em; text-align: center; font-size: 0.75em; opacity: 0.7 }`)
+	buf.WriteString(` @media screen and (prefers-color-scheme: dark) {`)
+	buf.WriteString(` html { background: #111; color: #e4e4e4 }`)
+	buf.WriteString(` a { color: #aae }`)
+	buf.WriteString(` th, td { border-bottom: 1px solid #444 }`)
+	buf.WriteString(` }`)
+	buf.WriteString(`</style>`)
+	buf.WriteString(`</head><body>`)
+	buf.WriteString(`<h1 class="title">` + html.EscapeString(title) + `</h1>`)
+	buf.WriteString(`<p class="message">Recent changes to the <a href="` + html.EscapeString(opt.Path) + `">schedule</a>.</p>`)
+	if len(entries) == 0 {
+		buf.WriteString(`<p class="message">No changes have been detected yet.</p>`)
+	} else {
+		buf.WriteString(`<table><thead><tr><th scope="col">Detected</th><th scope="col">Change</th><th scope="col">Activity</th><th scope="col">Location</th><th scope="col">Date</th><th scope="col">Time</th></tr></thead><tbody>`)
+		for _, e := range entries {
+			buf.WriteString(`<tr>`)
+			buf.WriteString(`<td><time datetime="` + e.Detected.Format(time.RFC3339) + `">` + html.EscapeString(e.Detected.Local().Format("2006-01-02 15:04")) + `</time></td>`)
+			buf.WriteString(`<td>` + html.EscapeString(string(e.Kind)) + `</td>`)
+			buf.WriteString(`<td>` + html.EscapeString(e.Activity) + `</td>`)
+			buf.WriteString(`<td>` + html.EscapeString(e.Location) + `</td>`)
+			buf.WriteString(`<td>` + html.EscapeString(e.Date) + `</td>`)
+			buf.WriteString(`<td>`)
+			if e.OldStart != "" {
+				buf.WriteString(`<span class="old">` + html.EscapeString(e.OldStart+" - "+e.OldEnd) + `</span> `)
+			}
+			buf.WriteString(html.EscapeString(e.Start + " - " + e.End))
+			buf.WriteString(`</td>`)
+			buf.WriteString(`</tr>`)
+		}
+		buf.WriteString(`</tbody></table>`)
+	}
+	buf.WriteString(`<footer>`)
+	buf.WriteString(`Generated by <a href="https://github.com/pgaskin/innosoftfusiongo-schedule">innosoftfusiongo-schedule</a>.`)
+	buf.WriteString(`</footer>`)
+	buf.WriteString(`</body></html>`)
+	return buf.Bytes()
+}
+
+// cacheConfig configures client caching for schedule responses.
+type cacheConfig struct {
+	Enabled         bool          // if false, responses must not be cached
+	MaxAge          time.Duration // if zero, clients must always revalidate
+	StaleRevalidate time.Duration // only used if MaxAge is set
+	Hash            bool          // send the schedule hash in the X-Schedule-Hash header
+}
+
+// Header returns the Cache-Control header value.
+func (c cacheConfig) Header() string {
+	switch {
+	case !c.Enabled:
+		return "private, no-store, no-cache"
+	case c.MaxAge <= 0:
+		return "no-cache"
+	case c.StaleRevalidate <= 0:
+		return "max-age=" + strconv.Itoa(int(c.MaxAge.Seconds()))
+	default:
+		return "max-age=" + strconv.Itoa(int(c.MaxAge.Seconds())) + ", stale-while-revalidate=" + strconv.Itoa(int(c.StaleRevalidate.Seconds()))
+	}
+}
+
+// scheduleAlternates gets the other formats a schedule is served in.
+func scheduleAlternates(o *ifgsch.Options) []ifgsch.Alternate {
+	base := o.Path
+	if o.Canonical != "" {
+		base = o.Canonical
+	}
+	as := []ifgsch.Alternate{
+		{Type: "text/plain", Title: "Text", URL: base + ".txt"},
+		{Type: "image/svg+xml", Title: "Image", URL: base + ".svg"},
+		{Type: "application/json", Title: "Statistics", URL: base + "/stats.json"},
+	}
+	if o.Subscribe != "" {
+		as = append(as, ifgsch.Alternate{Type: "text/calendar", Title: "iCalendar", URL: o.Subscribe})
+	}
+	return as
+}
+
+// getSchedule checks the request method, gets the current schedule, and sets
+// the common headers. If it returns false, an error response has been written.
+// The Cache-Control header is only set by serveScheduleBody so errors written
+// after this aren't cached.
+func getSchedule(w http.ResponseWriter, r *http.Request, cache cacheConfig, schedule memcache.Cache[scheduleResult]) (*scheduleResult, bool) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return nil, false
+	}
+
+	res, err := schedule.Get()
+	if err != nil {
+		if errors.As(err, new(*memcache.RetryError)) {
+			// couldn't fetch the data, and there's nothing cached
+			if t, ok := memcache.RetryAt(err); ok {
+				w.Header().Set("Retry-After", strconv.Itoa(max(int((time.Until(t)+time.Second-1)/time.Second), 0)))
+			}
+			scheduleError(w, http.StatusText(http.StatusServiceUnavailable)+": "+err.Error(), http.StatusServiceUnavailable)
+			return nil, false
+		}
+		scheduleError(w, http.StatusText(http.StatusInternalServerError)+": "+err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+
+	if res.Error != nil {
+		w.Header().Set("X-Refresh-Error", res.Error.Error())
+	}
+	if cache.Hash && res.Hash != "" {
+		w.Header().Set("X-Schedule-Hash", res.Hash)
+	}
+	return res, true
+}
+
+// serveScheduleBody writes body as a successful schedule response.
+func serveScheduleBody(w http.ResponseWriter, r *http.Request, cache cacheConfig, gzip bool, body *encodedBody, modified time.Time) {
+	w.Header().Set("Cache-Control", cache.Header())
+	serveEncodedBody(w, r, cache.Enabled, gzip, body, modified)
+}
+
+// scheduleError writes an error response which must not be cached.
+func scheduleError(w http.ResponseWriter, error string, code int) {
+	w.Header().Set("Cache-Control", "no-store")
+	http.Error(w, error, code)
+}
+
+// serveEncodedBody writes the negotiated variant of body.
+func serveEncodedBody(w http.ResponseWriter, r *http.Request, cache, gzip bool, body *encodedBody, modified time.Time) {
+	resp := body.Negotiate(w, r, gzip)
+
+	if cache {
+		w.Header().Set("Etag", resp.ETag)
+		w.Header().Set("Date", time.Now().UTC().Format(http.TimeFormat))
+		// ServeContent doesn't set the Content-Length if Content-Encoding is
+		// set (unless it's a range request, in which case it will overwrite
+		// it), but we know it's correct since the body is already compressed
+		// (it will be removed for 304 and error responses)
+		w.Header().Set("Content-Length", strconv.Itoa(len(resp.Data)))
+		http.ServeContent(w, r, "", modified, bytes.NewReader(resp.Data))
+		return
+	}
+
+	w.Header().Set("Content-Length", strconv.Itoa(len(resp.Data)))
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		w.Write(resp.Data)
 	}
-	return memcache.Cached(cfg, func(ctx context.Context) (res fusionResult, err error) {
-		if v, err := fusiongo.FetchSchedule(ctx, schoolID); err != nil {
-			return res, err
-		} else {
-			res.Schedule = v
-		}
-		if v, err := fusiongo.FetchNotifications(ctx, schoolID); err != nil {
-			return res, err
-		} else {
-			res.Notifications = v
-		}
-		return res, nil
-	})
 }
 
-type scheduleResult struct {
-	Error    error // if set, old (non-stale) data is being used for the schedule
-	Schedule *ifgsch.Schedule
+type buildInfo struct {
+	Version   string `json:"version,omitempty"`
+	Revision  string `json:"revision,omitempty"`
+	Time      string `json:"time,omitempty"` // RFC 3339
+	Modified  bool   `json:"modified,omitempty"`
+	GoVersion string `json:"go_version,omitempty"`
+}
 
-	HTML struct {
-		Raw, Gzip struct {
-			Data []byte
-			ETag string
+// getBuildInfo gets the version and vcs info embedded in the binary, if
+// available.
+func getBuildInfo() (b buildInfo) {
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return b
+	}
+	b.GoVersion = bi.GoVersion
+	if bi.Main.Version != "(devel)" {
+		b.Version = bi.Main.Version
+	}
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			b.Revision = s.Value
+		case "vcs.time":
+			b.Time = s.Value
+		case "vcs.modified":
+			b.Modified = s.Value == "true"
 		}
 	}
+	return b
 }
 
-func scheduleRenderer(filter ifgsch.Filter, opt ifgsch.Options, fusion memcache.Cache[fusionResult], cfg memcache.CachedTransformConfig) memcache.Cache[scheduleResult] {
-	if cfg.Logger != nil {
-		cfg.Logger = cfg.Logger.With("cache", "schedule", "title", opt.Title)
+// String returns a short description of the build.
+func (b buildInfo) String() string {
+	switch {
+	case b.Version != "":
+		return b.Version
+	case b.Revision != "" && b.Modified:
+		return b.Revision[:min(len(b.Revision), 12)] + "-dirty"
+	case b.Revision != "":
+		return b.Revision[:min(len(b.Revision), 12)]
+	default:
+		return "devel"
 	}
-	return memcache.CachedTransform(fusion, cfg, func(fusion fusionResult, fusionErr error) (res scheduleResult, err error) {
-		opt := opt // copy
-		if fusionErr != nil {
-			res.Error = fusionErr
-			opt.Footer = append(opt.Footer, template.HTML(`<span style="color:var(--md-ref-palette-error50)">Warning: schedule update failed (using cached schedule data): `+html.EscapeString(fusionErr.Error())+`.</span>`))
-		}
-		if schedule, err := ifgsch.Prepare(fusion.Schedule, fusion.Notifications, filter); err != nil {
-			return res, fmt.Errorf("prepare schedule: %w", err)
-		} else {
-			res.Schedule = schedule
-		}
-		{
-			var buf bytes.Buffer
-			if err := ifgsch.Render(&buf, &opt, res.Schedule); err != nil {
-				return res, fmt.Errorf("render schedule: %w", err)
-			}
-			res.HTML.Raw.Data = buf.Bytes()
+}
+
+// robotsHandler serves a robots.txt disallowing the unlisted schedules in cfg
+// and the additional path prefixes.
+func robotsHandler(cfg schedules, disallow []string) http.Handler {
+	var b bytes.Buffer
+	b.WriteString("User-agent: *\n")
+	for _, x := range disallow {
+		if !strings.HasPrefix(x, "/") {
+			x = "/" + x
 		}
-		{
-			hash := sha1.Sum(res.HTML.Raw.Data)
-			res.HTML.Raw.ETag = "\"" + hex.EncodeToString(hash[:]) + "\""
+		b.WriteString("Disallow: " + x + "\n")
+	}
+	for _, path := range cfg.Paths() {
+		if cfg[path].Unlisted {
+			// $ anchors the match so other schedules with the path as a prefix aren't affected
+			b.WriteString("Disallow: /" + path + "$\n")
+			b.WriteString("Disallow: /" + path + "/\n")
+			b.WriteString("Disallow: /" + path + ".txt$\n")
+			b.WriteString("Disallow: /" + path + ".svg$\n")
 		}
-		{
-			var buf bytes.Buffer
-			if zw, err := gzip.NewWriterLevel(&buf, gzip.BestCompression); err != nil {
-				return res, fmt.Errorf("compress schedule: %w", err)
-			} else if _, err := zw.Write(res.HTML.Raw.Data); err != nil {
-				return res, fmt.Errorf("compress schedule: %w", err)
-			} else if err := zw.Close(); err != nil {
-				return res, fmt.Errorf("compress schedule: %w", err)
-			}
-			res.HTML.Gzip.Data = buf.Bytes()
+	}
+	if b.Len() == len("User-agent: *\n") {
+		b.WriteString("Disallow:\n")
+	}
+	buf := b.Bytes()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
 		}
-		{
-			hash := sha1.Sum(res.HTML.Gzip.Data)
-			res.HTML.Gzip.ETag = "\"" + hex.EncodeToString(hash[:]) + "\""
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Content-Length", strconv.Itoa(len(buf)))
+		w.WriteHeader(http.StatusOK)
+		if r.Method != http.MethodHead {
+			w.Write(buf)
 		}
-		return res, nil
 	})
 }
 
-func scheduleHandler(cache, gzip bool, schedule memcache.Cache[scheduleResult]) http.Handler {
+// paletteHandler shows the MD3 palette tones generated for the color in the
+// query string (or the default one if not set).
+func paletteHandler() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet && r.Method != http.MethodHead {
 			w.Header().Set("Allow", "GET, HEAD")
 			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
 			return
 		}
-
-		if cache {
-			w.Header().Set("Cache-Control", "no-cache")
-		} else {
-			w.Header().Set("Cache-Control", "private, no-store, no-cache")
+		color := strings.ToLower(strings.TrimPrefix(r.URL.Query().Get("color"), "#"))
+		if color != "" && !isHexColor(color) {
+			http.Error(w, http.StatusText(http.StatusBadRequest)+": invalid color (expected RRGGBB)", http.StatusBadRequest)
+			return
 		}
-
-		schedule, err := schedule.Get()
+		css, err := m3color.PaletteCSS(color)
 		if err != nil {
 			http.Error(w, http.StatusText(http.StatusInternalServerError)+": "+err.Error(), http.StatusInternalServerError)
 			return
 		}
+		buf := palettePage(color, css)
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Content-Length", strconv.Itoa(len(buf)))
+		w.Header().Set("X-Robots-Tag", "noindex")
+		w.WriteHeader(http.StatusOK)
+		if r.Method != http.MethodHead {
+			w.Write(buf)
+		}
+	})
+}
+
+func palettePage(color, css string) []byte {
+	type swatch struct {
+		Tone  int
+		Value string
+	}
+	var (
+		names    []string
+		palettes = map[string][]swatch{}
+	)
+	for _, decl := range strings.FieldsFunc(css, func(r rune) bool {
+		return r == ';' || r == '{' || r == '}'
+	}) {
+		k, v, ok := strings.Cut(decl, ":")
+		if !ok {
+			continue
+		}
+		k, ok = strings.CutPrefix(strings.TrimSpace(k), "--md-ref-palette-")
+		if !ok {
+			continue
+		}
+		name := strings.TrimRight(k, "0123456789")
+		tone, err := strconv.Atoi(k[len(name):])
+		if err != nil {
+			continue
+		}
+		if _, ok := palettes[name]; !ok {
+			names = append(names, name)
+		}
+		palettes[name] = append(palettes[name], swatch{tone, strings.TrimSpace(v)})
+	}
 
-		if schedule.Error != nil {
-			w.Header().Set("X-Refresh-Error", schedule.Error.Error())
+	var buf bytes.Buffer
+	buf.WriteString(`<!DOCTYPE html><html lang="en"><head>`)
+	buf.WriteString(`<meta charset="utf-8">`)
+	buf.WriteString(`<meta name="viewport" content="width=device-width, initial-scale=1.0">`)
+	buf.WriteString(`<meta name="generator" content="ifgsch">`)
+	buf.WriteString(`<meta name="color-scheme" content="light dark">`)
+	buf.WriteString(`<title>Palette</title>`)
+	buf.WriteString(`<style>`)
+	buf.WriteString(` html { color-scheme: light dark; background: #fafafa; color: #000 }`)
+	buf.WriteString(` body { font-family: system-ui, -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, Oxygen, Ubuntu, Cantarell, 'Open Sans', 'Helvetica Neue', sans-serif }`)
+	buf.WriteString(` body { background: inherit; color: inherit; margin: 0 1em }`)
+	buf.WriteString(` h1.title { font-weight: bold; font-size: 1.6em; text-align: center; margin: 1em; padding: 0 }`)
+	buf.WriteString(` form { text-align: center; margin: 1em }`)
+	buf.WriteString(` h2 { font-size: 1em; margin: 1em 0 .25em }`)
+	buf.WriteString(` .palette { display: flex; flex-wrap: wrap }`)
+	buf.WriteString(` .palette > div { width: 4.5em; padding: .5em .25em; font-size: .75em; text-align: center }`)
+	buf.WriteString(` .palette > div > code { display: block; opacity: 0.8 }`)
+	buf.WriteString(` footer { margin: 1em; text-align: center; font-size: 0.75em; opacity: 0.7 }`)
+	buf.WriteString(` @media screen and (prefers-color-scheme: dark) {`)
+	buf.WriteString(` html { background: #111; color: #e4e4e4 }`)
+	buf.WriteString(` }`)
+	buf.WriteString(`</style>`)
+	buf.WriteString(`</head><body>`)
+	buf.WriteString(`<h1 class="title">Palette</h1>`)
+	buf.WriteString(`<form method="get"><label>Color <input name="color" value="` + html.EscapeString(color) + `" placeholder="6750a4" pattern="#?[0-9a-fA-F]{6}" size="7"></label> <button type="submit">Preview</button></form>`)
+	for _, name := range names {
+		buf.WriteString(`<h2>` + html.EscapeString(name) + `</h2>`)
+		buf.WriteString(`<div class="palette">`)
+		for _, x := range palettes[name] {
+			fg := "#fff"
+			if x.Tone >= 50 {
+				fg = "#000"
+			}
+			fmt.Fprintf(&buf, `<div style="background:%s;color:%s" title="--md-ref-palette-%s%d">%d<code>%s</code></div>`,
+				html.EscapeString(x.Value), fg, html.EscapeString(name), x.Tone, x.Tone, html.EscapeString(x.Value))
 		}
+		buf.WriteString(`</div>`)
+	}
+	buf.WriteString(`<footer>`)
+	buf.WriteString(`Generated by <a href="https://github.com/pgaskin/innosoftfusiongo-schedule">innosoftfusiongo-schedule</a>.`)
+	buf.WriteString(`</footer>`)
+	buf.WriteString(`</body></html>`)
+	return buf.Bytes()
+}
 
-		if gzip {
-			w.Header().Set("Vary", "Accept-Encoding")
+func versionHandler(b buildInfo) http.Handler {
+	buf, err := json.Marshal(b)
+	if err != nil {
+		panic(fmt.Errorf("encode build info: %w", err))
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
 		}
-		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("Content-Length", strconv.Itoa(len(buf)))
+		w.WriteHeader(http.StatusOK)
+		if r.Method != http.MethodHead {
+			w.Write(buf)
+		}
+	})
+}
 
-		resp := schedule.HTML.Raw
+func scheduleListHandler(cfg schedules, canonical string, gzip bool) http.Handler {
+	page := scheduleListBody(cfg, func(now time.Time) []byte {
+		return scheduleListPage(cfg, now, "Schedules", "", canonical, true)
+	})
+	if _, err := page(); err != nil {
+		panic(fmt.Errorf("compress schedule list: %w", err))
+	}
 
-		if gzip {
-			for _, x := range r.Header[textproto.CanonicalMIMEHeaderKey("Accept-Encoding")] {
-				for _, x := range strings.Split(x, ",") {
-					x, _, _ = strings.Cut(x, ";")
-					x = strings.TrimSpace(x)
-					if x == "gzip" {
-						w.Header().Set("Content-Encoding", "gzip")
-						resp = schedule.HTML.Gzip
-						break
-					}
-				}
-			}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
 		}
 
-		if cache {
-			w.Header().Set("Etag", resp.ETag)
-			w.Header().Set("Date", time.Now().UTC().Format(http.TimeFormat))
-			http.ServeContent(w, r, "", schedule.Schedule.Modified, bytes.NewReader(resp.Data))
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+		body, err := page()
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 			return
 		}
+		serveEncodedBody(w, r, true, gzip, body, time.Time{})
+	})
+}
+
+// notFoundHandler serves a 404 page, listing the schedules if list is true.
+func notFoundHandler(cfg schedules, list, gzip bool) http.Handler {
+	msg := "The requested page does not exist."
+	if list {
+		msg += " Try one of the following schedules."
+	}
+	page := scheduleListBody(cfg, func(now time.Time) []byte {
+		return scheduleListPage(cfg, now, "Not Found", msg, "", list)
+	})
+	if _, err := page(); err != nil {
+		panic(fmt.Errorf("compress not found page: %w", err))
+	}
 
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := page()
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		resp := body.Negotiate(w, r, gzip)
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
 		w.Header().Set("Content-Length", strconv.Itoa(len(resp.Data)))
-		w.WriteHeader(http.StatusOK)
+		w.WriteHeader(http.StatusNotFound)
 		if r.Method != http.MethodHead {
 			w.Write(resp.Data)
 		}
 	})
 }
 
-func scheduleListHandler(cfg schedules, canonical string) http.Handler {
+// scheduleListBody returns a function which returns the encoded page generated
+// by fn, regenerating it whenever the schedules available at the current time
+// change.
+func scheduleListBody(cfg schedules, fn func(now time.Time) []byte) func() (*encodedBody, error) {
+	var (
+		mu   sync.Mutex
+		key  string
+		body *encodedBody
+	)
+	return func() (*encodedBody, error) {
+		now := time.Now()
+
+		var b strings.Builder
+		for _, path := range cfg.Paths() {
+			if cfg[path].Available(now) {
+				b.WriteString(path)
+				b.WriteByte(0)
+			}
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		if body == nil || key != b.String() {
+			v, err := newEncodedBody(fn(now))
+			if err != nil {
+				return nil, err
+			}
+			key, body = b.String(), &v
+		}
+		return body, nil
+	}
+}
+
+// scheduleListPage generates a simple page with a heading, an optional
+// message, and optionally a list of the listed schedules available at now.
+func scheduleListPage(cfg schedules, now time.Time, title, message, canonical string, list bool) []byte {
 	var buf bytes.Buffer
-	buf.WriteString(`<!DOCTYPE html><html lang="en"><head>`)
+	buf.WriteString(`<!DOCTYPE html><html lang="` + html.EscapeString(cfg.Language()) + `"><head>`)
 	buf.WriteString(`<meta charset="utf-8">`)
 	buf.WriteString(`<meta name="viewport" content="width=device-width, initial-scale=1.0">`)
 	buf.WriteString(`<meta name="generator" content="ifgsch">`)
 	buf.WriteString(`<meta name="color-scheme" content="light dark">`)
-	buf.WriteString(`<title>Schedules</title>`)
+	buf.WriteString(`<title>` + html.EscapeString(title) + `</title>`)
 	if canonical != "" {
 		buf.WriteString(`<link rel="canonical" href="` + html.EscapeString(canonical) + `">`)
 	}
@@ -631,6 +3809,7 @@ func scheduleListHandler(cfg schedules, canonical string) http.Handler {
 	buf.WriteString(` body { background: inherit; color: inherit; max-width: 720px; margin: 0 auto }`)
 	buf.WriteString(` a { color: #00a }`)
 	buf.WriteString(` h1.title { font-weight: bold; font-size: 1.6em; text-align: center; margin: 1em; padding: 0 }`)
+	buf.WriteString(` p.message { text-align: center; margin: 1em }`)
 	buf.WriteString(` .schedules > a { display: block; margin: .75em; padding: .5em; text-decoration: none; color: inherit; background: #eee; border: 1px solid #bbb }`)
 	buf.WriteString(` .schedules > a:hover { background: #ddd }`)
 	buf.WriteString(` .schedules > a > .title { font-weight: bold; color: #00a }`)
@@ -645,38 +3824,305 @@ func scheduleListHandler(cfg schedules, canonical string) http.Handler {
 	buf.WriteString(` }`)
 	buf.WriteString(`</style>`)
 	buf.WriteString(`</head><body>`)
-	buf.WriteString(`<h1 class="title">Schedules</h1>`)
-	buf.WriteString(`<nav class="schedules">`)
-	for _, path := range cfg.Paths() {
-		if !cfg[path].Unlisted {
-			fmt.Fprintf(&buf, `<a href="%s"><div class="title">%s</div><div class="desc">%s</div></a>`,
-				html.EscapeString("/"+path),
-				html.EscapeString(cfg[path].Options.Title),
-				html.EscapeString(cfg[path].Options.Description))
+	buf.WriteString(`<h1 class="title">` + html.EscapeString(title) + `</h1>`)
+	if message != "" {
+		buf.WriteString(`<p class="message">` + html.EscapeString(message) + `</p>`)
+	}
+	if list {
+		buf.WriteString(`<nav class="schedules">`)
+		for _, path := range cfg.Paths() {
+			if !cfg[path].Unlisted && cfg[path].Available(now) {
+				fmt.Fprintf(&buf, `<a href="%s"><div class="title">%s</div><div class="desc">%s</div></a>`,
+					html.EscapeString("/"+path),
+					html.EscapeString(cfg[path].Options.Title),
+					html.EscapeString(cfg[path].Options.Description))
+			}
 		}
+		buf.WriteString(`</nav>`)
 	}
-	buf.WriteString(`</nav>`)
 	buf.WriteString(`<footer>`)
 	buf.WriteString(`Generated by <a href="https://github.com/pgaskin/innosoftfusiongo-schedule">innosoftfusiongo-schedule</a>.`)
 	buf.WriteString(`</footer>`)
 	buf.WriteString(`</body></html>`)
+	return buf.Bytes()
+}
 
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodGet && r.Method != http.MethodHead {
-			w.Header().Set("Allow", "GET, HEAD")
-			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
-			return
+// encodedBody contains a response body and its precomputed gzip variant.
+type encodedBody struct {
+	Raw, Gzip encodedBodyVariant
+}
+
+type encodedBodyVariant struct {
+	Data []byte
+	ETag string
+}
+
+// newEncodedBody compresses buf and computes the ETags.
+func newEncodedBody(buf []byte) (b encodedBody, err error) {
+	return encodeBody(buf, true)
+}
+
+// encodeBody is like newEncodedBody, but only includes the gzip variant if
+// compress is true.
+func encodeBody(buf []byte, compress bool) (b encodedBody, err error) {
+	b.Raw.Data = buf
+	if compress {
+		var buf bytes.Buffer
+		if zw, err := gzip.NewWriterLevel(&buf, gzip.BestCompression); err != nil {
+			return b, err
+		} else if _, err := zw.Write(b.Raw.Data); err != nil {
+			return b, err
+		} else if err := zw.Close(); err != nil {
+			return b, err
+		}
+		b.Gzip.Data = buf.Bytes()
+	}
+	for _, v := range []*encodedBodyVariant{&b.Raw, &b.Gzip} {
+		if v.Data == nil {
+			continue
 		}
+		hash := sha1.Sum(v.Data)
+		v.ETag = "\"" + hex.EncodeToString(hash[:]) + "\""
+	}
+	return b, nil
+}
 
-		w.Header().Set("Cache-Control", "private, no-store, no-cache")
-		w.Header().Set("Content-Type", "text/html; charset=utf-8")
-		w.Header().Set("Content-Length", strconv.Itoa(len(buf.Bytes())))
+// Negotiate selects the variant to respond with based on the Accept-Encoding
+// header, setting the Vary and Content-Encoding headers as required.
+func (b *encodedBody) Negotiate(w http.ResponseWriter, r *http.Request, gzip bool) encodedBodyVariant {
+	if gzip && b.Gzip.Data != nil {
+		w.Header().Set("Vary", "Accept-Encoding")
+		for _, x := range r.Header[textproto.CanonicalMIMEHeaderKey("Accept-Encoding")] {
+			for _, x := range strings.Split(x, ",") {
+				x, _, _ = strings.Cut(x, ";")
+				x = strings.TrimSpace(x)
+				if x == "gzip" {
+					w.Header().Set("Content-Encoding", "gzip")
+					return b.Gzip
+				}
+			}
+		}
+	}
+	return b.Raw
+}
 
-		w.WriteHeader(http.StatusOK)
-		if r.Method != http.MethodHead {
-			w.Write(buf.Bytes())
+// testdataFS opens a directory, or reads a tar, gzipped tar, or zip archive
+// into memory. Tar archives are converted into an uncompressed zip archive,
+// since it can be read as a filesystem directly.
+func testdataFS(name string) (fs.FS, error) {
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		zr, err := zip.OpenReader(name)
+		if err != nil {
+			return nil, fmt.Errorf("open testdata archive: %w", err)
 		}
-	})
+		return zr, nil // kept open until exit
+	case strings.HasSuffix(name, ".tar"), strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, fmt.Errorf("open testdata archive: %w", err)
+		}
+		defer f.Close()
+
+		var r io.Reader = f
+		if !strings.HasSuffix(name, ".tar") {
+			zr, err := gzip.NewReader(f)
+			if err != nil {
+				return nil, fmt.Errorf("read testdata archive: %w", err)
+			}
+			defer zr.Close()
+			r = zr
+		}
+
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		for tr := tar.NewReader(r); ; {
+			h, err := tr.Next()
+			if err != nil {
+				if err == io.EOF {
+					break
+				}
+				return nil, fmt.Errorf("read testdata archive: %w", err)
+			}
+			if h.Typeflag != tar.TypeReg {
+				continue
+			}
+			n := path.Clean(strings.TrimPrefix(h.Name, "/"))
+			if !fs.ValidPath(n) {
+				return nil, fmt.Errorf("read testdata archive: invalid filename %q", h.Name)
+			}
+			w, err := zw.CreateHeader(&zip.FileHeader{Name: n, Method: zip.Store, Modified: h.ModTime})
+			if err != nil {
+				return nil, fmt.Errorf("read testdata archive: %w", err)
+			}
+			if _, err := io.Copy(w, tr); err != nil {
+				return nil, fmt.Errorf("read testdata archive: %w", err)
+			}
+		}
+		if err := zw.Close(); err != nil {
+			return nil, fmt.Errorf("read testdata archive: %w", err)
+		}
+		zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		if err != nil {
+			return nil, fmt.Errorf("read testdata archive: %w", err)
+		}
+		return zr, nil
+	default:
+		return os.DirFS(name), nil
+	}
+}
+
+// checkICO checks that b contains a well-formed ico directory with image
+// entries within the bounds of the file.
+func checkICO(b []byte) error {
+	if len(b) < 6 {
+		return fmt.Errorf("truncated header")
+	}
+	n := int(binary.LittleEndian.Uint16(b[4:]))
+	if n == 0 {
+		return fmt.Errorf("no images")
+	}
+	if len(b) < 6+n*16 {
+		return fmt.Errorf("truncated directory")
+	}
+	for i := 0; i < n; i++ {
+		e := b[6+i*16:][:16]
+		w, h := int(e[0]), int(e[1])
+		if w == 0 {
+			w = 256
+		}
+		if h == 0 {
+			h = 256
+		}
+		size, off := binary.LittleEndian.Uint32(e[8:]), binary.LittleEndian.Uint32(e[12:])
+		if size == 0 || uint64(off)+uint64(size) > uint64(len(b)) || off < uint32(6+n*16) {
+			return fmt.Errorf("image %d (%dx%d) out of bounds", i, w, h)
+		}
+	}
+	return nil
+}
+
+// readIconSVG reads an SVG icon to be inlined into the page. Since it is
+// inlined as-is, it is sanitized with [sanitizeSVG].
+func readIconSVG(path string) (template.HTML, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("read icon: %w", err)
+	}
+	svg, err := sanitizeSVG(buf)
+	if err != nil {
+		return "", fmt.Errorf("read icon %q: %w", path, err)
+	}
+	return template.HTML(svg), nil
+}
+
+// svgElements and svgAttributes are the SVG elements and attributes which are
+// kept by sanitizeSVG. Anything which can run scripts, load external
+// resources, or contain HTML (e.g., script, style, foreignObject, image) is
+// excluded.
+var (
+	svgElements = []string{
+		"svg", "g", "defs", "symbol", "use", "title", "desc",
+		"path", "circle", "ellipse", "line", "polyline", "polygon", "rect",
+		"text", "tspan",
+		"linearGradient", "radialGradient", "stop", "clipPath", "mask",
+	}
+	svgAttributes = []string{
+		"id", "class", "role", "aria-hidden", "aria-label",
+		"viewBox", "preserveAspectRatio", "width", "height", "transform",
+		"x", "y", "x1", "y1", "x2", "y2", "cx", "cy", "r", "rx", "ry", "d", "points",
+		"dx", "dy", "fx", "fy", "offset", "pathLength",
+		"fill", "fill-opacity", "fill-rule", "clip-rule", "clip-path", "mask", "opacity",
+		"stroke", "stroke-width", "stroke-linecap", "stroke-linejoin", "stroke-miterlimit",
+		"stroke-dasharray", "stroke-dashoffset", "stroke-opacity",
+		"stop-color", "stop-opacity", "gradientUnits", "gradientTransform", "spreadMethod",
+		"clipPathUnits", "maskUnits", "maskContentUnits",
+		"font-family", "font-size", "font-weight", "text-anchor", "dominant-baseline",
+		"href",
+	}
+)
+
+// sanitizeSVG re-serializes an SVG document, keeping only allowlisted elements
+// and attributes. References (href and url()) are only kept if they point to a
+// fragment within the document. Comments, processing instructions, and the
+// contents of removed elements are dropped.
+func sanitizeSVG(buf []byte) ([]byte, error) {
+	var (
+		dec  = xml.NewDecoder(bytes.NewReader(buf))
+		out  bytes.Buffer
+		root = true
+		skip int // depth within a removed element
+	)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parse svg: %w", err)
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			if root && tok.Name.Local != "svg" {
+				return nil, fmt.Errorf("root element is %q, not svg", tok.Name.Local)
+			}
+			root = false
+			if skip != 0 || !slices.Contains(svgElements, tok.Name.Local) || (tok.Name.Space != "" && tok.Name.Space != "http://www.w3.org/2000/svg") {
+				skip++
+				continue
+			}
+			out.WriteString("<" + tok.Name.Local)
+			for _, a := range tok.Attr {
+				switch {
+				case a.Name.Space == "http://www.w3.org/1999/xlink" && a.Name.Local == "href":
+				case a.Name.Space == "" && slices.Contains(svgAttributes, a.Name.Local):
+				default:
+					continue
+				}
+				if !svgSafeValue(a.Name.Local, a.Value) {
+					continue
+				}
+				out.WriteString(" " + a.Name.Local + `="`)
+				xml.EscapeText(&out, []byte(a.Value))
+				out.WriteString(`"`)
+			}
+			out.WriteString(">")
+		case xml.EndElement:
+			if skip != 0 {
+				skip--
+				continue
+			}
+			out.WriteString("</" + tok.Name.Local + ">")
+		case xml.CharData:
+			if skip == 0 && !root {
+				xml.EscapeText(&out, tok)
+			}
+		}
+	}
+	if root {
+		return nil, fmt.Errorf("no svg element")
+	}
+	return bytes.TrimSpace(out.Bytes()), nil
+}
+
+// svgSafeValue checks whether an attribute value only references fragments
+// within the document.
+func svgSafeValue(name, value string) bool {
+	v := strings.ToLower(strings.Join(strings.Fields(value), ""))
+	if name == "href" {
+		return strings.HasPrefix(v, "#")
+	}
+	for rest := v; ; {
+		i := strings.Index(rest, "url(")
+		if i == -1 {
+			break
+		}
+		rest = strings.TrimLeft(rest[i+len("url("):], `"'`)
+		if !strings.HasPrefix(rest, "#") {
+			return false
+		}
+	}
+	return !strings.Contains(v, "javascript:")
 }
 
 func splitQuoted(s string) ([]string, error) {
@@ -0,0 +1,1757 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"html/template"
+	"io"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pgaskin/innosoftfusiongo-ical/fusiongo"
+	"github.com/pgaskin/innosoftfusiongo-schedule/ifgsch"
+	"github.com/pgaskin/innosoftfusiongo-schedule/memcache"
+)
+
+func TestScheduleHandlerHead(t *testing.T) {
+	body, err := newEncodedBody(bytes.Repeat([]byte("<p>test</p>\n"), 100))
+	if err != nil {
+		t.Fatalf("encode body: %v", err)
+	}
+	res := &scheduleResult{
+		Schedule: &ifgsch.Schedule{
+			Modified: time.Date(2023, 10, 15, 12, 0, 0, 0, time.UTC),
+		},
+		HTML: body,
+	}
+	schedule := memcache.CacheFunc[scheduleResult](func() (*scheduleResult, error) {
+		return res, nil
+	})
+	for _, cache := range []bool{false, true} {
+		testEncodedHead(t, "cache="+strconv.FormatBool(cache), scheduleHandler(cacheConfig{Enabled: cache}, true, false, schedule), body)
+	}
+	testEncodedHead(t, "list", scheduleListHandler(schedules{}, "", true), encodedBody{})
+}
+
+func TestEncodeBodyNoGzip(t *testing.T) {
+	body, err := encodeBody(bytes.Repeat([]byte("<p>test</p>\n"), 100), false)
+	if err != nil {
+		t.Fatalf("encode body: %v", err)
+	}
+	if body.Gzip.Data != nil || body.Gzip.ETag != "" {
+		t.Errorf("expected no gzip variant")
+	}
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	serveEncodedBody(w, r, true, true, &body, time.Time{})
+	if act := w.Header().Get("Content-Encoding"); act != "" {
+		t.Errorf("expected no content-encoding, got %q", act)
+	}
+	if act := w.Header().Get("Etag"); act != body.Raw.ETag || act == "" {
+		t.Errorf("expected etag %q, got %q", body.Raw.ETag, act)
+	}
+	if !bytes.Equal(w.Body.Bytes(), body.Raw.Data) {
+		t.Errorf("incorrect body")
+	}
+}
+
+// testEncodedHead checks that HEAD requests to h have the same headers as GET
+// requests, with the Content-Length matching the encoded body. If body is
+// empty, the GET response is used as the expected body.
+func testEncodedHead(t *testing.T, name string, h http.Handler, body encodedBody) {
+	t.Run(name, func(t *testing.T) {
+		for _, gzip := range []bool{false, true} {
+			do := func(method string) *httptest.ResponseRecorder {
+				r := httptest.NewRequest(method, "/", nil)
+				if gzip {
+					r.Header.Set("Accept-Encoding", "gzip")
+				}
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, r)
+				return w
+			}
+			get, head := do(http.MethodGet), do(http.MethodHead)
+
+			exp := body.Raw
+			if gzip {
+				exp = body.Gzip
+			}
+			if exp.Data == nil {
+				exp.Data = get.Body.Bytes()
+			}
+
+			for _, w := range []*httptest.ResponseRecorder{get, head} {
+				if w.Code != http.StatusOK {
+					t.Fatalf("gzip=%t: expected status 200, got %d", gzip, w.Code)
+				}
+				if act, exp := w.Header().Get("Content-Length"), strconv.Itoa(len(exp.Data)); act != exp {
+					t.Errorf("gzip=%t: expected content-length %q, got %q", gzip, exp, act)
+				}
+				if act, exp := w.Header().Get("Content-Encoding"), map[bool]string{true: "gzip"}[gzip]; act != exp {
+					t.Errorf("gzip=%t: expected content-encoding %q, got %q", gzip, exp, act)
+				}
+			}
+			if !bytes.Equal(get.Body.Bytes(), exp.Data) {
+				t.Errorf("gzip=%t: incorrect get body", gzip)
+			}
+			if head.Body.Len() != 0 {
+				t.Errorf("gzip=%t: expected empty head body, got %d bytes", gzip, head.Body.Len())
+			}
+		}
+	})
+}
+
+func TestScheduleHandlerUnavailable(t *testing.T) {
+	fetchErr := errors.New("fetch failed")
+	fusion := memcache.Cached(memcache.CacheConfig{
+		Backoff: memcache.BackoffFunc(func(t time.Time, _ error, n int) time.Time {
+			return t.Add(time.Minute)
+		}),
+	}, func(ctx context.Context) (fusionResult, error) {
+		return fusionResult{}, fetchErr
+	})
+	schedule := scheduleRenderer(ifgsch.Options{}, schedulePreparer(nil, ifgsch.PrepareOptions{}, fusion, memcache.CachedTransformConfig{}), true, memcache.CachedTransformConfig{})
+
+	w := httptest.NewRecorder()
+	scheduleHandler(cacheConfig{Enabled: true, MaxAge: time.Hour}, true, false, schedule).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status 503, got %d", w.Code)
+	}
+	if v, err := strconv.Atoi(w.Header().Get("Retry-After")); err != nil || v < 59 || v > 60 {
+		t.Errorf("expected retry-after of about 60 seconds, got %q", w.Header().Get("Retry-After"))
+	}
+	if act := w.Header().Get("Cache-Control"); act != "no-store" {
+		t.Errorf("expected the unavailable response to not be cached, got cache-control %q", act)
+	}
+
+	broken := memcache.CacheFunc[scheduleResult](func() (*scheduleResult, error) {
+		return nil, errors.New("render failed")
+	})
+	w = httptest.NewRecorder()
+	scheduleHandler(cacheConfig{}, true, false, broken).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("expected status 500, got %d", w.Code)
+	}
+	if w.Header().Get("Retry-After") != "" {
+		t.Errorf("expected no retry-after")
+	}
+}
+
+func TestScheduleDayCache(t *testing.T) {
+	res := &scheduleResult{
+		Schedule: &ifgsch.Schedule{
+			Updated:    time.Date(2023, 10, 15, 12, 0, 0, 0, time.UTC),
+			Start:      fusiongo.Date{Year: 2023, Month: 10, Day: 15},
+			End:        fusiongo.Date{Year: 2023, Month: 10, Day: 21},
+			Activities: []ifgsch.Activity{{Name: "Swim"}},
+		},
+		Days: &dayCache{Gzip: true},
+	}
+	schedule := memcache.CacheFunc[scheduleResult](func() (*scheduleResult, error) {
+		return res, nil
+	})
+	h := scheduleDayHandler(cacheConfig{Enabled: true}, true, schedule)
+	var etag string
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/a/2023-10-16", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+		if i == 0 {
+			if etag = w.Header().Get("ETag"); etag == "" {
+				t.Errorf("expected etag")
+			}
+		} else if act := w.Header().Get("ETag"); act != etag {
+			t.Errorf("expected the same etag, got %q then %q", etag, act)
+		}
+	}
+	a, _ := res.Days.Render(res, fusiongo.Date{Year: 2023, Month: 10, Day: 16})
+	b, _ := res.Days.Render(res, fusiongo.Date{Year: 2023, Month: 10, Day: 16})
+	c, _ := res.Days.Render(res, fusiongo.Date{Year: 2023, Month: 10, Day: 17})
+	if a != b || a == c {
+		t.Errorf("expected day pages to be cached by date")
+	}
+}
+
+func TestScheduleCacheControl(t *testing.T) {
+	body, err := newEncodedBody([]byte("test"))
+	if err != nil {
+		t.Fatalf("encode body: %v", err)
+	}
+	res := &scheduleResult{
+		Schedule: &ifgsch.Schedule{
+			Start: fusiongo.Date{Year: 2023, Month: 10, Day: 15},
+			End:   fusiongo.Date{Year: 2023, Month: 10, Day: 21},
+		},
+		HTML: body,
+		Days: &dayCache{Gzip: true},
+	}
+	schedule := memcache.CacheFunc[scheduleResult](func() (*scheduleResult, error) {
+		return res, nil
+	})
+	broken := memcache.CacheFunc[scheduleResult](func() (*scheduleResult, error) {
+		return nil, errors.New("render failed")
+	})
+	cache := cacheConfig{Enabled: true, MaxAge: time.Hour}
+	for _, tc := range []struct {
+		Name    string
+		Handler http.Handler
+		Target  string
+		Status  int
+		Header  string
+	}{
+		{"ok", scheduleHandler(cache, true, true, schedule), "/a", http.StatusOK, "max-age=3600"},
+		{"color", scheduleHandler(cache, true, true, schedule), "/a?color=zzzzzz", http.StatusBadRequest, "no-store"},
+		{"error", scheduleHandler(cache, true, true, broken), "/a", http.StatusInternalServerError, "no-store"},
+		{"day", scheduleDayHandler(cache, true, schedule), "/a/2023-10-16", http.StatusOK, "max-age=3600"},
+		{"day range", scheduleDayHandler(cache, true, schedule), "/a/2023-11-01", http.StatusNotFound, "no-store"},
+		{"day invalid", scheduleDayHandler(cache, true, schedule), "/a/x", http.StatusNotFound, "no-store"},
+		{"day error", scheduleDayHandler(cache, true, broken), "/a/2023-10-16", http.StatusInternalServerError, "no-store"},
+		{"text error", scheduleTextHandler(cache, true, broken), "/a.txt", http.StatusInternalServerError, "no-store"},
+	} {
+		w := httptest.NewRecorder()
+		tc.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tc.Target, nil))
+		if w.Code != tc.Status {
+			t.Errorf("%s: expected status %d, got %d", tc.Name, tc.Status, w.Code)
+		}
+		if act := w.Header().Get("Cache-Control"); act != tc.Header {
+			t.Errorf("%s: expected cache-control %q, got %q", tc.Name, tc.Header, act)
+		}
+	}
+}
+
+func TestScheduleHash(t *testing.T) {
+	a := &ifgsch.Schedule{
+		Modified:   time.Date(2023, 10, 15, 12, 0, 0, 0, time.UTC),
+		Start:      fusiongo.Date{Year: 2023, Month: 10, Day: 15},
+		End:        fusiongo.Date{Year: 2023, Month: 10, Day: 21},
+		Activities: []ifgsch.Activity{{Name: "Swim"}},
+	}
+	b := *a
+	b.Modified = b.Modified.Add(time.Hour)
+	c := *a
+	c.Activities = []ifgsch.Activity{{Name: "Skate"}}
+	if scheduleHash(a) != scheduleHash(&b) {
+		t.Errorf("expected hash to not depend on the modification time")
+	}
+	if scheduleHash(a) == scheduleHash(&c) {
+		t.Errorf("expected hash to depend on the activities")
+	}
+	if !a.Modified.Equal(time.Date(2023, 10, 15, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("hash modified the schedule")
+	}
+
+	body, err := newEncodedBody([]byte("test"))
+	if err != nil {
+		t.Fatalf("encode body: %v", err)
+	}
+	schedule := memcache.CacheFunc[scheduleResult](func() (*scheduleResult, error) {
+		return &scheduleResult{Schedule: a, Hash: scheduleHash(a), HTML: body}, nil
+	})
+	for _, hash := range []bool{false, true} {
+		w := httptest.NewRecorder()
+		scheduleHandler(cacheConfig{Hash: hash}, true, false, schedule).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		if act, exp := w.Header().Get("X-Schedule-Hash"), map[bool]string{true: scheduleHash(a)}[hash]; act != exp {
+			t.Errorf("hash=%t: expected header %q, got %q", hash, exp, act)
+		}
+	}
+}
+
+func TestTestdataFS(t *testing.T) {
+	const (
+		name = "school110/schedule.json"
+		data = `{"updated":"2023-01-01 00:00:00"}`
+	)
+	tarball := func(name string) []byte {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: "school110/", Mode: 0755})
+		tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0644, Size: int64(len(data))})
+		tw.Write([]byte(data))
+		tw.Close()
+		return buf.Bytes()
+	}
+	dir := t.TempDir()
+	write := func(name string, b []byte) string {
+		t.Helper()
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0777); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+		if err := os.WriteFile(p, b, 0666); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+		return p
+	}
+	write(filepath.Join("dir", name), []byte(data))
+	write("a.tar", tarball(name))
+	write("b.tar", tarball("/"+name))
+	write("invalid.tar", tarball("../"+name))
+	{
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write(tarball(name))
+		zw.Close()
+		write("a.tar.gz", buf.Bytes())
+		write("a.tgz", buf.Bytes())
+	}
+	{
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		w, _ := zw.Create(name)
+		w.Write([]byte(data))
+		zw.Close()
+		write("a.zip", buf.Bytes())
+	}
+	for _, tc := range []struct {
+		Name string
+		OK   bool
+	}{
+		{"dir", true},
+		{"a.tar", true},
+		{"b.tar", true},
+		{"a.tar.gz", true},
+		{"a.tgz", true},
+		{"a.zip", true},
+		{"invalid.tar", false},
+		{"missing.tar", false},
+		{"missing.zip", false},
+	} {
+		fsys, err := testdataFS(filepath.Join(dir, tc.Name))
+		if !tc.OK {
+			if err == nil {
+				t.Errorf("%s: expected error", tc.Name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.Name, err)
+			continue
+		}
+		if buf, err := fs.ReadFile(fsys, name); err != nil || string(buf) != data {
+			t.Errorf("%s: expected %q, got %q (error: %v)", tc.Name, data, buf, err)
+		}
+		if ents, err := fs.ReadDir(fsys, "."); err != nil || len(ents) != 1 || ents[0].Name() != "school110" || !ents[0].IsDir() {
+			t.Errorf("%s: expected a single school110 directory, got %v (error: %v)", tc.Name, ents, err)
+		}
+		if c, ok := fsys.(io.Closer); ok {
+			c.Close()
+		}
+	}
+}
+
+func TestBuildInfo(t *testing.T) {
+	for _, tc := range []struct {
+		b   buildInfo
+		exp string
+	}{
+		{buildInfo{}, "devel"},
+		{buildInfo{Version: "v1.2.3", Revision: "0123456789abcdef"}, "v1.2.3"},
+		{buildInfo{Revision: "0123456789abcdef"}, "0123456789ab"},
+		{buildInfo{Revision: "0123456789abcdef", Modified: true}, "0123456789ab-dirty"},
+	} {
+		if act := tc.b.String(); act != tc.exp {
+			t.Errorf("%+v: expected %q, got %q", tc.b, tc.exp, act)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	versionHandler(buildInfo{Revision: "abc"}).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/version", nil))
+	if act, exp := w.Body.String(), `{"revision":"abc"}`; act != exp {
+		t.Errorf("expected version response %s, got %s", exp, act)
+	}
+}
+
+func TestPreviewCache(t *testing.T) {
+	res := &scheduleResult{
+		Schedule: &ifgsch.Schedule{
+			Updated: time.Date(2023, 10, 15, 12, 0, 0, 0, time.UTC),
+			Start:   fusiongo.Date{Year: 2023, Month: 10, Day: 15},
+			End:     fusiongo.Date{Year: 2023, Month: 10, Day: 21},
+		},
+	}
+	p := newPreviewCache(false)
+	a, err := p.Render(res, "ff0000")
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	if b, _ := p.Render(res, "ff0000"); a != b {
+		t.Errorf("expected preview to be cached")
+	}
+	for i := 0; i < maxPreviewColors*2; i++ {
+		if _, err := p.Render(res, fmt.Sprintf("%06x", i)); err != nil {
+			t.Fatalf("render: %v", err)
+		}
+	}
+	if n := p.cache.Len(); n != maxPreviewColors {
+		t.Errorf("expected %d cached previews, got %d", maxPreviewColors, n)
+	}
+}
+
+func TestSanitizeSVG(t *testing.T) {
+	for _, tc := range []struct {
+		In  string
+		Out string // empty for an error
+	}{
+		{`<?xml version="1.0"?><!-- x --><svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 24 24"><path d="M0 0h24v24H0z" fill="currentColor"/></svg>`, `<svg viewBox="0 0 24 24"><path d="M0 0h24v24H0z" fill="currentColor"></path></svg>`},
+		{`<svg><script>alert(1)</script><path d="M0 0"/></svg>`, `<svg><path d="M0 0"></path></svg>`},
+		{`<svg onload="alert(1)"><path onclick="alert(1)" d="M0 0"/></svg>`, `<svg><path d="M0 0"></path></svg>`},
+		{`<svg><style>path{fill:url(https://example.com/x)}</style></svg>`, `<svg></svg>`},
+		{`<svg><foreignObject><iframe src="https://example.com"></iframe></foreignObject></svg>`, `<svg></svg>`},
+		{`<svg xmlns:xlink="http://www.w3.org/1999/xlink"><a xlink:href="javascript:alert(1)"><path d="M0 0"/></a></svg>`, `<svg></svg>`},
+		{`<svg xmlns:xlink="http://www.w3.org/1999/xlink"><use xlink:href="https://example.com/x.svg#a"/><use href=" JavaScript:alert(1)"/><use xlink:href="#a"/></svg>`, `<svg><use></use><use></use><use href="#a"></use></svg>`},
+		{`<svg><path fill="url(https://example.com/x.svg#g)" stroke="url( '#g' )" d="M0 0"/></svg>`, `<svg><path stroke="url( &#39;#g&#39; )" d="M0 0"></path></svg>`},
+		{`<svg><path style="background:url(https://example.com)" d="M0 0"/></svg>`, `<svg><path d="M0 0"></path></svg>`},
+		{`<svg><image href="https://example.com/x.png"/><title>a &lt;b&gt;</title></svg>`, `<svg><title>a &lt;b&gt;</title></svg>`},
+		{`<html><svg></svg></html>`, ``},
+		{`<svg>`, ``},
+		{``, ``},
+	} {
+		act, err := sanitizeSVG([]byte(tc.In))
+		if tc.Out == "" {
+			if err == nil {
+				t.Errorf("%q: expected error, got %q", tc.In, act)
+			}
+		} else if err != nil {
+			t.Errorf("%q: unexpected error: %v", tc.In, err)
+		} else if string(act) != tc.Out {
+			t.Errorf("%q: expected %q, got %q", tc.In, tc.Out, act)
+		}
+	}
+}
+
+func TestSchedulePalette(t *testing.T) {
+	dir := t.TempDir()
+	const css = "--md-ref-palette-primary40: #000; --md-ref-palette-tertiary40: #000; --md-ref-palette-neutral40: #000; --md-ref-palette-neutral-variant40: #000; --md-ref-palette-error40: #000;"
+	if err := os.WriteFile(filepath.Join(dir, "palette.css"), []byte(css), 0666); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := parseSchedules(strings.NewReader("schedule a 110\n\tpalette palette.css\n"), filepath.Join(dir, "schedules.txt"))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if act := string(cfg["a"].Options.Palette); act != css {
+		t.Errorf("expected palette %q, got %q", css, act)
+	}
+	if _, err := parseSchedules(strings.NewReader("schedule a 110\n\tpalette palette.css\n"), filepath.Join(t.TempDir(), "schedules.txt")); err == nil {
+		t.Errorf("expected palette to be resolved relative to the schedules file")
+	}
+}
+
+func TestScheduleIcon(t *testing.T) {
+	defer func(v int) { *MaxIconSize = v }(*MaxIconSize)
+	*MaxIconSize = 1024
+
+	ico := func(n int) string {
+		b := make([]byte, 6+16+n)
+		copy(b, []byte{0, 0, 1, 0, 1, 0})
+		binary.LittleEndian.PutUint32(b[6+8:], uint32(n))
+		binary.LittleEndian.PutUint32(b[6+12:], 6+16)
+		return base64.StdEncoding.EncodeToString(b)
+	}
+	for _, tc := range []struct {
+		Icon string
+		Err  string
+	}{
+		{ico(16), ""},
+		{ico(1024 - 6 - 16), ""},
+		{ico(1024), "line 2: icon too large (1046 bytes, max 1024)"},
+		{"AAABAA==", "line 2: invalid ico: truncated header"},
+		{"!", "line 2: invalid base64"},
+	} {
+		_, err := parseSchedules(strings.NewReader("schedule a 110\n\ticon "+tc.Icon+"\n"), "schedules.txt")
+		if tc.Err == "" {
+			if err != nil {
+				t.Errorf("%.16q: unexpected error: %v", tc.Icon, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), tc.Err) {
+			t.Errorf("%.16q: expected error %q, got %v", tc.Icon, tc.Err, err)
+		}
+	}
+}
+
+func TestPaletteHandler(t *testing.T) {
+	for _, tc := range []struct {
+		Query string
+		Code  int
+	}{
+		{"", http.StatusOK},
+		{"?color=%23FF0000", http.StatusOK},
+		{"?color=f00", http.StatusBadRequest},
+		{"?color=gggggg", http.StatusBadRequest},
+		{"?color=%22%3E", http.StatusBadRequest},
+	} {
+		w := httptest.NewRecorder()
+		paletteHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/palette"+tc.Query, nil))
+		if w.Code != tc.Code {
+			t.Errorf("%q: expected status %d, got %d", tc.Query, tc.Code, w.Code)
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+		for _, x := range []string{`<h2>primary</h2>`, `<h2>neutral-variant</h2>`, `title="--md-ref-palette-primary40">40<code>#`} {
+			if !strings.Contains(w.Body.String(), x) {
+				t.Errorf("%q: expected palette page to contain %q", tc.Query, x)
+			}
+		}
+	}
+}
+
+func TestRobotsHandler(t *testing.T) {
+	cfg, err := parseSchedules(strings.NewReader("schedule a 110\nschedule b 110\n\tunlisted\n"), "schedules.txt")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	for _, tc := range []struct {
+		Disallow []string
+		Exp      string
+	}{
+		{nil, "User-agent: *\nDisallow: /b$\nDisallow: /b/\nDisallow: /b.txt$\nDisallow: /b.svg$\n"},
+		{[]string{"/", "x"}, "User-agent: *\nDisallow: /\nDisallow: /x\nDisallow: /b$\nDisallow: /b/\nDisallow: /b.txt$\nDisallow: /b.svg$\n"},
+	} {
+		w := httptest.NewRecorder()
+		robotsHandler(cfg, tc.Disallow).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/robots.txt", nil))
+		if act := w.Body.String(); act != tc.Exp {
+			t.Errorf("%q: expected robots.txt %q, got %q", tc.Disallow, tc.Exp, act)
+		}
+	}
+	delete(cfg, "b")
+	w := httptest.NewRecorder()
+	robotsHandler(cfg, nil).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/robots.txt", nil))
+	if act, exp := w.Body.String(), "User-agent: *\nDisallow:\n"; act != exp {
+		t.Errorf("expected robots.txt %q, got %q", exp, act)
+	}
+}
+
+func TestNotFoundHandler(t *testing.T) {
+	cfg := schedules{
+		"a": &schedule{Index: 0, Options: ifgsch.Options{Title: "Listed"}},
+		"b": &schedule{Index: 1, Options: ifgsch.Options{Title: "Unlisted"}, Unlisted: true},
+	}
+	for _, list := range []bool{false, true} {
+		for _, method := range []string{http.MethodGet, http.MethodHead} {
+			w := httptest.NewRecorder()
+			notFoundHandler(cfg, list, true).ServeHTTP(w, httptest.NewRequest(method, "/c", nil))
+			if w.Code != http.StatusNotFound {
+				t.Errorf("list=%t %s: expected status 404, got %d", list, method, w.Code)
+			}
+			if method == http.MethodHead {
+				if w.Body.Len() != 0 {
+					t.Errorf("list=%t %s: expected empty body", list, method)
+				}
+				continue
+			}
+			if act := bytes.Contains(w.Body.Bytes(), []byte(`href="/a"`)); act != list {
+				t.Errorf("list=%t %s: expected listed schedule link %t, got %t", list, method, list, act)
+			}
+			if bytes.Contains(w.Body.Bytes(), []byte(`href="/b"`)) {
+				t.Errorf("list=%t %s: unexpected unlisted schedule link", list, method)
+			}
+		}
+	}
+}
+
+func TestChangeNotifier(t *testing.T) {
+	schedule := func(days ...time.Weekday) *ifgsch.Schedule {
+		var i ifgsch.Instance
+		i.Time = fusiongo.TimeRange{Start: fusiongo.Time{Hour: 10}, End: fusiongo.Time{Hour: 11}}
+		for _, wd := range days {
+			i.Days[wd] = true
+		}
+		return &ifgsch.Schedule{
+			Start: fusiongo.Date{Year: 2023, Month: 10, Day: 1},
+			End:   fusiongo.Date{Year: 2023, Month: 10, Day: 7},
+			Activities: []ifgsch.Activity{{
+				Name:      "Swim",
+				Locations: []ifgsch.Location{{Name: "Pool", Instances: []ifgsch.Instance{i}}},
+			}},
+		}
+	}
+	var (
+		fail    = true
+		changes []int
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			Changes []scheduleChange `json:"changes"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("decode payload: %v", err)
+		}
+		changes = append(changes, len(payload.Changes))
+		if fail {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	n := &changeNotifier{URL: srv.URL, Debounce: time.Hour, Logger: slog.Default()}
+	defer func() {
+		if n.timer != nil {
+			n.timer.Stop()
+		}
+	}()
+	n.Update(schedule(time.Monday))
+	n.Update(schedule(time.Monday, time.Tuesday))
+	n.flush() // fails
+	n.Update(schedule(time.Monday, time.Tuesday, time.Wednesday))
+	fail = false
+	n.flush() // includes the changes from the failed call
+	n.Update(schedule(time.Monday, time.Tuesday, time.Wednesday, time.Thursday))
+	n.flush()
+	if exp := []int{1, 2, 1}; !slices.Equal(changes, exp) {
+		t.Errorf("expected webhook calls with %v changes, got %v", exp, changes)
+	}
+
+	var (
+		mu           sync.Mutex
+		active, peak int
+	)
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		active++
+		peak = max(peak, active)
+		mu.Unlock()
+
+		time.Sleep(time.Millisecond * 50)
+
+		mu.Lock()
+		active--
+		mu.Unlock()
+	}))
+	defer slow.Close()
+
+	n = &changeNotifier{URL: slow.URL, Debounce: time.Millisecond, Logger: slog.Default()}
+	n.Update(schedule(time.Monday))
+	n.Update(schedule(time.Monday, time.Tuesday))
+	time.Sleep(time.Millisecond * 20)                             // the first webhook call is in progress
+	n.Update(schedule(time.Monday, time.Tuesday, time.Wednesday)) // resets the timer
+	time.Sleep(time.Millisecond * 150)
+	mu.Lock()
+	defer mu.Unlock()
+	if peak != 1 {
+		t.Errorf("expected webhook calls to not overlap, got %d at once", peak)
+	}
+}
+
+func TestScheduleUpdated(t *testing.T) {
+	var update func()
+	fusion := memcache.Cached(memcache.CacheConfig{
+		OnUpdate: func() {
+			update()
+		},
+	}, func(ctx context.Context) (fusionResult, error) {
+		return fusionResult{
+			Schedule:      &fusiongo.Schedule{Updated: time.Date(2023, 10, 15, 12, 0, 0, 0, time.UTC)},
+			Notifications: &fusiongo.Notifications{},
+		}, nil
+	})
+	notified := make(chan *ifgsch.Schedule, 1)
+	update = scheduleUpdated(schedulePreparer(nil, ifgsch.PrepareOptions{}, fusion, memcache.CachedTransformConfig{}), func(s *ifgsch.Schedule) {
+		notified <- s
+	})
+
+	// like the cache warmer, without getting the prepared schedule
+	if _, err := fusion.Get(); err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	select {
+	case <-notified:
+	case <-time.After(time.Second):
+		t.Errorf("expected the schedule to be updated without a request")
+	}
+}
+
+func TestChangeHistory(t *testing.T) {
+	schedule := func(days ...time.Weekday) *ifgsch.Schedule {
+		var i ifgsch.Instance
+		i.Time = fusiongo.TimeRange{Start: fusiongo.Time{Hour: 10}, End: fusiongo.Time{Hour: 11}}
+		for _, wd := range days {
+			i.Days[wd] = true
+		}
+		return &ifgsch.Schedule{
+			Start: fusiongo.Date{Year: 2023, Month: 10, Day: 1},
+			End:   fusiongo.Date{Year: 2023, Month: 10, Day: 14},
+			Activities: []ifgsch.Activity{{
+				Name:      "Swim",
+				Locations: []ifgsch.Location{{Name: "Pool", Instances: []ifgsch.Instance{i}}},
+			}},
+		}
+	}
+	h := &changeHistory{Max: 3, File: filepath.Join(t.TempDir(), "a.json"), Logger: slog.Default()}
+	h.Update(schedule(time.Monday))
+	if n := len(h.Entries()); n != 0 {
+		t.Errorf("expected no changes for the initial schedule, got %d", n)
+	}
+	h.Update(schedule(time.Monday))
+	if n := len(h.Entries()); n != 0 {
+		t.Errorf("expected no changes for the same schedule, got %d", n)
+	}
+	h.Update(schedule(time.Monday, time.Tuesday))
+	h.Update(schedule(time.Wednesday))
+	entries := h.Entries()
+	if len(entries) != 3 {
+		t.Fatalf("expected history to be limited to 3 entries, got %d", len(entries))
+	}
+	for i, exp := range []string{"2023-10-11 added", "2023-10-10 removed", "2023-10-09 removed"} {
+		if act := entries[i].Date + " " + string(entries[i].Kind); act != exp {
+			t.Errorf("entry %d: expected %q, got %q", i, exp, act)
+		}
+	}
+
+	loaded := &changeHistory{Max: 2, File: h.File, Logger: slog.Default()}
+	if err := loaded.Load(); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if act := loaded.Entries(); len(act) != 2 || act[0] != entries[0] || act[1] != entries[1] {
+		t.Errorf("expected the loaded history to have the 2 most recent entries, got %+v", act)
+	}
+
+	w := httptest.NewRecorder()
+	scheduleChangesHandler(loaded, &ifgsch.Options{Path: "/a"}, true).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/a/changes.json", nil))
+	if !strings.Contains(w.Body.String(), `"kind":"added","activity":"Swim","location":"Pool","date":"2023-10-11"`) {
+		t.Errorf("unexpected json: %s", w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	scheduleChangesHandler(&changeHistory{}, &ifgsch.Options{Path: "/a"}, true).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/a/changes.json", nil))
+	if act, exp := w.Body.String(), `{"changes":[]}`; act != exp {
+		t.Errorf("expected empty history json %s, got %s", exp, act)
+	}
+	w = httptest.NewRecorder()
+	scheduleChangesHandler(loaded, &ifgsch.Options{Path: "/a"}, false).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/a/changes", nil))
+	if !strings.Contains(w.Body.String(), `<td>removed</td><td>Swim</td><td>Pool</td><td>2023-10-10</td>`) {
+		t.Errorf("unexpected html: %s", w.Body.String())
+	}
+
+	s := schedule(time.Wednesday, time.Thursday)
+	s.Updated = time.Date(2023, 10, 2, 8, 0, 0, 0, time.FixedZone("EDT", -4*60*60))
+	h.Update(s)
+	if e := h.Entries()[0]; e.Detected != time.Date(2023, 10, 2, 12, 0, 0, 0, time.UTC) {
+		t.Errorf("expected the change to be detected at the schedule update time, got %s", e.Detected)
+	}
+}
+
+func TestCheckFusionSchedule(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	ai := func(activity string, year int, hour int) fusiongo.ActivityInstance {
+		return fusiongo.ActivityInstance{
+			Activity: activity,
+			Time: fusiongo.DateTimeRange{
+				Date:      fusiongo.Date{Year: year, Month: time.October, Day: 16},
+				TimeRange: fusiongo.TimeRange{Start: fusiongo.Time{Hour: hour}, End: fusiongo.Time{Hour: hour + 1}},
+			},
+		}
+	}
+	updated := time.Date(2023, 10, 15, 12, 0, 0, 0, time.UTC)
+
+	s := &fusiongo.Schedule{Updated: updated}
+	for i := 0; i < 20; i++ {
+		s.Activities = append(s.Activities, ai("A", 2023, 8))
+	}
+	s.Activities = append(s.Activities, ai("", 2023, 8), ai("B", 1, 8))
+	if err := checkFusionSchedule(s, logger); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if len(s.Activities) != 20 {
+		t.Errorf("expected implausible activities to be removed, got %d activities", len(s.Activities))
+	}
+
+	s.Activities = append(s.Activities, ai("B", 2023, 30), ai("B", 2023, 40), ai("B", 2023, 50))
+	if err := checkFusionSchedule(s, logger); err == nil {
+		t.Errorf("expected error for mostly implausible schedule")
+	}
+
+	if err := checkFusionSchedule(&fusiongo.Schedule{}, logger); err == nil {
+		t.Errorf("expected error for missing update time")
+	}
+}
+
+func TestNextTimeOfDay(t *testing.T) {
+	tods := []timeOfDay{{17, 30}, {5, 0}}
+	for _, tc := range []struct {
+		T   time.Time
+		Exp time.Time
+	}{
+		{time.Date(2023, 10, 16, 4, 59, 0, 0, time.UTC), time.Date(2023, 10, 16, 5, 0, 0, 0, time.UTC)},
+		{time.Date(2023, 10, 16, 5, 0, 0, 0, time.UTC), time.Date(2023, 10, 16, 17, 30, 0, 0, time.UTC)},
+		{time.Date(2023, 10, 16, 12, 0, 0, 0, time.UTC), time.Date(2023, 10, 16, 17, 30, 0, 0, time.UTC)},
+		{time.Date(2023, 10, 16, 17, 30, 1, 0, time.UTC), time.Date(2023, 10, 17, 5, 0, 0, 0, time.UTC)},
+		{time.Date(2023, 12, 31, 23, 0, 0, 0, time.UTC), time.Date(2024, 1, 1, 5, 0, 0, 0, time.UTC)},
+	} {
+		if act := nextTimeOfDay(tc.T, tods); !act.Equal(tc.Exp) {
+			t.Errorf("%s: expected %s, got %s", tc.T, tc.Exp, act)
+		}
+	}
+}
+
+func TestLimitTransport(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, _ := strconv.Atoi(r.URL.Query().Get("n"))
+		if r.URL.Query().Has("chunked") {
+			w.(http.Flusher).Flush()
+		}
+		w.Write(bytes.Repeat([]byte{'x'}, n))
+	}))
+	defer srv.Close()
+
+	cl := &http.Client{Transport: limitTransport{http.DefaultTransport, 16}}
+	for _, tc := range []struct {
+		Query string
+		Err   bool
+	}{
+		{"n=16", false},
+		{"n=17", true},
+		{"n=16&chunked", false},
+		{"n=17&chunked", true},
+		{"n=4096&chunked", true},
+	} {
+		resp, err := cl.Get(srv.URL + "?" + tc.Query)
+		if err == nil {
+			_, err = io.ReadAll(resp.Body)
+			resp.Body.Close()
+		}
+		if act := err != nil; act != tc.Err {
+			t.Errorf("%s: expected error %t, got %v", tc.Query, tc.Err, err)
+		}
+	}
+}
+
+func TestScheduleAvailable(t *testing.T) {
+	cfg, err := parseSchedules(strings.NewReader("schedule a 110\n\tavailable-from 2023-10-16\n\tavailable-until 2023-10-20\n\ttimezone America/Toronto\n"), "schedules.txt")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	for _, tc := range []struct {
+		now time.Time
+		exp bool
+	}{
+		{time.Date(2023, 10, 16, 3, 0, 0, 0, time.UTC), false}, // still 2023-10-15 in Toronto
+		{time.Date(2023, 10, 16, 5, 0, 0, 0, time.UTC), true},
+		{time.Date(2023, 10, 20, 23, 0, 0, 0, time.UTC), true},
+		{time.Date(2023, 10, 21, 5, 0, 0, 0, time.UTC), false},
+	} {
+		if act := cfg["a"].Available(tc.now); act != tc.exp {
+			t.Errorf("%s: expected available %t, got %t", tc.now, tc.exp, act)
+		}
+	}
+	if !(&schedule{}).Available(time.Now()) {
+		t.Errorf("expected schedule without availability dates to be available")
+	}
+	for _, c := range []string{
+		"schedule a 110\n\tavailable-from 2023-02-30\n",
+		"schedule a 110\n\tavailable-from 2023-10-20\n\tavailable-until 2023-10-16\n",
+	} {
+		if _, err := parseSchedules(strings.NewReader(c), "schedules.txt"); err == nil {
+			t.Errorf("expected error for %q", c)
+		}
+	}
+}
+
+func TestScheduleLogLevel(t *testing.T) {
+	cfg, err := parseSchedules(strings.NewReader("schedule a 110\n\tlog-level debug\nschedule b 110\nschedule c 120\n\tlog-level warn\nschedule d 130\n"), "schedules.txt")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if lvl, ok := cfg.LogLevel(); !ok || lvl != slog.LevelDebug {
+		t.Errorf("expected most verbose log level override to be debug, got %s (%t)", lvl, ok)
+	}
+	if act, exp := cfg.SchoolLogLevels(slog.LevelInfo), map[int]slog.Level{110: slog.LevelDebug, 120: slog.LevelWarn, 130: slog.LevelInfo}; !maps.Equal(act, exp) {
+		t.Errorf("expected school log levels %v, got %v", exp, act)
+	}
+	for _, tc := range []struct {
+		Config string
+		Levels map[string]slog.Level
+	}{
+		{"schedule a 110\nvariant b a\n", map[string]slog.Level{"a": slog.LevelInfo}},
+		{"schedule a 110\n\tlog-level warn\nvariant b a\n\tlog-level debug\nvariant c a\nschedule d 120\n", map[string]slog.Level{"a": slog.LevelDebug, "d": slog.LevelInfo}},
+		{"schedule a 110\nvariant b a\n\tlog-level warn\ncombine c a\n\tlog-level debug\n", map[string]slog.Level{"a": slog.LevelInfo}},
+	} {
+		cfg, err := parseSchedules(strings.NewReader(tc.Config), "schedules.txt")
+		if err != nil {
+			t.Fatalf("parse %q: %v", tc.Config, err)
+		}
+		if act := cfg.BaseLogLevels(slog.LevelInfo); !maps.Equal(act, tc.Levels) {
+			t.Errorf("%q: expected base log levels %v, got %v", tc.Config, tc.Levels, act)
+		}
+	}
+	if _, err := parseSchedules(strings.NewReader("schedule a 110\n\tlog-level verbose\n"), "schedules.txt"); err == nil {
+		t.Errorf("expected error for invalid log level")
+	}
+
+	var buf bytes.Buffer
+	h := slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})
+	slog.New(levelHandler{h, slog.LevelInfo}).With("schedule", "a").Debug("hidden")
+	slog.New(levelHandler{h, slog.LevelInfo}).With("schedule", "a").Info("shown")
+	if act := buf.String(); strings.Contains(act, "hidden") || !strings.Contains(act, "msg=shown schedule=a") {
+		t.Errorf("expected records to be filtered by level, got %q", act)
+	}
+}
+
+func TestScheduleHosts(t *testing.T) {
+	cfg, err := parseSchedules(strings.NewReader("schedule a 110\nschedule b 110\n\thost Foo.Example.com.\nschedule c 110\n\thost bar.example.com foo.example.com\n"), "schedules.txt")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if act, exp := cfg.Hosts(), []string{"bar.example.com", "foo.example.com"}; !slices.Equal(act, exp) {
+		t.Errorf("expected hosts %q, got %q", exp, act)
+	}
+	for _, fallback := range []string{"", "bar.example.com"} {
+		host := func(r *http.Request) string {
+			return requestHost(r, cfg.Hosts(), fallback)
+		}
+		list := hostRouter(cfg, host, func(cfg schedules) http.Handler {
+			return scheduleListHandler(cfg, "", false)
+		})
+		for _, tc := range []struct {
+			Host string
+			Exp  string
+		}{
+			{"FOO.example.com:8080", "abc"},
+			{"bar.example.com", "ac"},
+			{"baz.example.com", map[string]string{"": "a", "bar.example.com": "ac"}[fallback]},
+		} {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Host = tc.Host
+			list.ServeHTTP(w, r)
+			var act string
+			for _, path := range cfg.Paths() {
+				if bytes.Contains(w.Body.Bytes(), []byte(`href="/`+path+`"`)) {
+					act += path
+				}
+				if exp := strings.Contains(tc.Exp, path); cfg[path].ServedFor(host(r)) != exp {
+					t.Errorf("fallback=%q %s: expected %s served %t", fallback, tc.Host, path, exp)
+				}
+			}
+			if act != tc.Exp {
+				t.Errorf("fallback=%q %s: expected listed schedules %q, got %q", fallback, tc.Host, tc.Exp, act)
+			}
+		}
+	}
+	if _, err := parseSchedules(strings.NewReader("schedule a 110\n\thost example.com:8080\n"), "schedules.txt"); err == nil {
+		t.Errorf("expected error for host with port")
+	}
+}
+
+// authHash is a bcrypt hash of authPassword (cost 4 to keep the tests fast),
+// and auth is a schedule directive using it for the staff user.
+const (
+	authPassword = "password"
+	authHash     = "$2a$04$GX9zbEUdzHQmhZjfoynL5uM1k6ZoZGNJGJ9K.uIBb975NrIBmY/1C"
+	auth         = "\tauth staff \"" + authHash + "\"\n"
+)
+
+func TestBasicAuth(t *testing.T) {
+	h := basicAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}), `/a "b"`, map[string][]byte{"staff": []byte(authHash)})
+	for _, tc := range []struct {
+		Name   string
+		Header string
+		Status int
+	}{
+		{"correct", "Basic " + base64.StdEncoding.EncodeToString([]byte("staff:"+authPassword)), http.StatusNoContent},
+		{"wrong password", "Basic " + base64.StdEncoding.EncodeToString([]byte("staff:wrong")), http.StatusUnauthorized},
+		{"unknown user", "Basic " + base64.StdEncoding.EncodeToString([]byte("other:"+authPassword)), http.StatusUnauthorized},
+		{"malformed", "Basic !!!", http.StatusUnauthorized},
+		{"missing", "", http.StatusUnauthorized},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/a", nil)
+		if tc.Header != "" {
+			r.Header.Set("Authorization", tc.Header)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tc.Status {
+			t.Errorf("%s: expected status %d, got %d", tc.Name, tc.Status, w.Code)
+		}
+		if tc.Status == http.StatusUnauthorized {
+			if act, exp := w.Header().Get("WWW-Authenticate"), `Basic realm="/a b", charset="UTF-8"`; act != exp {
+				t.Errorf("%s: expected challenge %q, got %q", tc.Name, exp, act)
+			}
+			if act := w.Header().Get("Cache-Control"); act != "private, no-store" {
+				t.Errorf("%s: expected unauthorized response not to be cached, got %q", tc.Name, act)
+			}
+		}
+	}
+}
+
+func TestSchedulePrivate(t *testing.T) {
+	cfg, err := parseSchedules(strings.NewReader("schedule a 110\n"+auth+"\tupcoming 7\n\tprivate notifications upcoming\n"), "schedules.txt")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if exp := (privateSections{Notifications: true, Upcoming: true}); cfg["a"].Private != exp {
+		t.Errorf("expected private sections %+v, got %+v", exp, cfg["a"].Private)
+	}
+	if o := cfg["a"].Private.Public(cfg["a"].Options); o.UpcomingDays != 0 || !o.NoNotifications {
+		t.Errorf("expected public options to hide upcoming and notifications")
+	}
+	if cfg["a"].Options.UpcomingDays != 7 || cfg["a"].Options.NoNotifications {
+		t.Errorf("expected full options to be unchanged")
+	}
+	for _, c := range []string{
+		"schedule a 110\n\tprivate upcoming\n",
+		"schedule a 110\n" + auth + "\tprivate\n",
+		"schedule a 110\n" + auth + "\tprivate grid\n",
+	} {
+		if _, err := parseSchedules(strings.NewReader(c), "schedules.txt"); err == nil {
+			t.Errorf("expected error for %q", c)
+		}
+	}
+}
+
+func TestCombinedRestrictions(t *testing.T) {
+	const auth2 = "\tauth other \"" + authHash + "\"\n"
+	for _, tc := range []struct {
+		Config string
+		OK     bool
+	}{
+		{"schedule a 110\nschedule b 111\ncombine c a b\n", true},
+		{"schedule a 110\n" + auth + "schedule b 111\ncombine c a b\n", false},
+		{"schedule a 110\n" + auth + "schedule b 111\ncombine c a b\n" + auth, true},
+		{"schedule a 110\n" + auth + "schedule b 111\ncombine c a b\n" + auth2, false},
+		{"schedule a 110\n" + auth + "\tprivate notifications\nschedule b 111\ncombine c a b\n", true},
+		{"schedule a 110\n\tavailable-from 2023-10-16\nschedule b 111\ncombine c a b\n", false},
+		{"schedule a 110\n\tavailable-from 2023-10-16\nschedule b 111\ncombine c a b\n\tavailable-from 2023-10-17\n", true},
+		{"schedule a 110\n\tavailable-until 2023-10-20\nschedule b 111\ncombine c a b\n\tavailable-until 2023-10-21\n", false},
+		{"schedule a 110\n\tavailable-until 2023-10-20\nschedule b 111\ncombine c a b\n\tavailable-until 2023-10-20\n", true},
+	} {
+		if _, err := parseSchedules(strings.NewReader(tc.Config), "schedules.txt"); (err == nil) != tc.OK {
+			t.Errorf("%q: expected ok=%t, got error %v", tc.Config, tc.OK, err)
+		}
+	}
+}
+
+func TestSchedulePrivateChanges(t *testing.T) {
+	cfg, err := parseSchedules(strings.NewReader("schedule a 110\n"+auth+"\tprivate notifications\n"), "schedules.txt")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	public, full := scheduleKeys("a", cfg["a"], true)
+	handlers := map[string]http.Handler{}
+	for _, k := range append(slices.Clip(public), full...) {
+		handlers[k] = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	}
+	var notFound http.Handler = http.NotFoundHandler()
+	protectScheduleHandlers(handlers, "a", cfg["a"], public, full, &notFound, func(r *http.Request) string { return "" })
+	for _, tc := range []struct {
+		Key    string
+		Status int
+	}{
+		{"a", http.StatusOK},
+		{"a/full", http.StatusUnauthorized},
+		{"a/changes", http.StatusUnauthorized},
+		{"a/changes.json", http.StatusUnauthorized},
+	} {
+		w := httptest.NewRecorder()
+		handlers[tc.Key].ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/"+tc.Key, nil))
+		if w.Code != tc.Status {
+			t.Errorf("%s: expected status %d without credentials, got %d", tc.Key, tc.Status, w.Code)
+		}
+	}
+}
+
+func TestScheduleKeyConflicts(t *testing.T) {
+	for _, tc := range []struct {
+		Config string
+		OK     bool
+	}{
+		{"schedule a 110\nschedule ab 111\nschedule a.b 111\n", true},
+		{"schedule a 110\nschedule a.txt 111\n", false},
+		{"schedule a.txt 111\nschedule a 110\n", false},
+		{"schedule a 110\nschedule a.svg 111\n", false},
+		{"schedule a 110\nschedule a/stats.json 111\n", false},
+		{"schedule a 110\nschedule a/changes 111\n", false},
+		{"schedule a 110\nschedule a/changes.json 111\n", false},
+		{"schedule a 110\nschedule a/full 111\n", true},
+		{"schedule a 110\n" + auth + "\tprivate notifications\nschedule a/full 111\n", false},
+		{"schedule a 110\nschedule b 111\ncombine a.txt a b\n", false},
+		{"schedule a 110\nschedule b 111\ncombine c a b\nschedule c.txt 112\n", true},
+	} {
+		if _, err := parseSchedules(strings.NewReader(tc.Config), "schedules.txt"); (err == nil) != tc.OK {
+			t.Errorf("%q: expected ok=%t, got error %v", tc.Config, tc.OK, err)
+		}
+	}
+}
+
+func TestScheduleVariant(t *testing.T) {
+	cfg, err := parseSchedules(strings.NewReader("schedule a 110\n\tfilter.category_id in 1\n\ttitle A\nvariant b a\n\ttitle B\nvariant c b\nschedule d b\n"), "schedules.txt")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	for path, exp := range map[string]string{"a": "", "b": "a", "c": "a", "d": ""} {
+		if act := cfg[path].Variant; act != exp {
+			t.Errorf("%s: expected variant of %q, got %q", path, exp, act)
+		}
+	}
+	if cfg["a"].Options.Title != "A" || cfg["c"].Options.Title != "B" {
+		t.Errorf("expected variants to copy and override options")
+	}
+	for _, c := range []string{
+		"schedule a 110\nvariant b x\n",
+		"schedule a 110\nvariant b a\n\tfilter.category_id in 1\n",
+		"schedule a 110\n\tfilter.category_id in 1\nvariant b a\n\tfilter.all\n\tfilter.location in X\n\tfilter.end\n",
+		"schedule a 110\nvariant b a\n\thide-cancelled\n",
+		"schedule a 110\ncombine b a\nvariant c b\n",
+	} {
+		if _, err := parseSchedules(strings.NewReader(c), "schedules.txt"); err == nil {
+			t.Errorf("expected error for %q", c)
+		}
+	}
+}
+
+func TestFilterGroups(t *testing.T) {
+	cfg, err := parseSchedules(strings.NewReader(`schedule a 110
+	filter.any
+		filter.activity in "Lane Swim"
+		filter.all
+			filter.category in Swim
+			filter.location notIn Tank
+			filter.activity trimPrefix "Swim: "
+		filter.end
+	filter.end
+	filter.activity notIn Closed
+`), "schedules.txt")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	ai := func(activity, location, category string) *fusiongo.ActivityInstance {
+		return &fusiongo.ActivityInstance{Activity: activity, Location: location, Category: []fusiongo.ActivityCategory{{Name: category}}}
+	}
+	for _, tc := range []struct {
+		Instance *fusiongo.ActivityInstance
+		Keep     bool
+		Activity string
+	}{
+		{ai("Lane Swim", "Tank", "Other"), true, "Lane Swim"},
+		{ai("Swim: Aquafit", "Pool", "Swim"), true, "Aquafit"},
+		{ai("Swim: Aquafit", "Tank", "Swim"), false, ""},
+		{ai("Closed", "Pool", "Swim"), false, ""},
+		{ai("Yoga", "Studio", "Fitness"), false, ""},
+	} {
+		if act := cfg["a"].Filter.Filter(tc.Instance); act != tc.Keep {
+			t.Errorf("%q: expected keep %t, got %t", tc.Instance.Activity, tc.Keep, act)
+		} else if act && tc.Instance.Activity != tc.Activity {
+			t.Errorf("%q: expected activity to be transformed to %q", tc.Instance.Activity, tc.Activity)
+		}
+	}
+	for _, c := range []string{
+		"schedule a 110\n\tfilter.any\n\tfilter.activity in A\n",
+		"schedule a 110\n\tfilter.any\n\tfilter.activity in A\nschedule b 110\n",
+		"schedule a 110\n\tfilter.end\n",
+		"schedule a 110\n\tfilter.any\n\tfilter.end\n",
+		"schedule a 110\n\tfilter.all x\n",
+	} {
+		if _, err := parseSchedules(strings.NewReader(c), "schedules.txt"); err == nil {
+			t.Errorf("expected error for %q", c)
+		}
+	}
+}
+
+func TestProxyRemoteAddr(t *testing.T) {
+	trusted := []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("192.0.2.1/32"),
+	}
+	for _, tc := range []struct {
+		remote  string
+		header  []string
+		idx     int
+		trusted []netip.Prefix
+		exp     string
+	}{
+		{"127.0.0.1:1234", nil, 0, nil, ""},
+		{"127.0.0.1:1234", []string{"1.1.1.1"}, 0, nil, "1.1.1.1"},
+		{"127.0.0.1:1234", []string{"1.1.1.1, 2.2.2.2, 3.3.3.3"}, 0, nil, "1.1.1.1"},
+		{"127.0.0.1:1234", []string{"1.1.1.1, 2.2.2.2, 3.3.3.3"}, 1, nil, "3.3.3.3"},
+		{"127.0.0.1:1234", []string{"1.1.1.1, 2.2.2.2", "3.3.3.3"}, 2, nil, "2.2.2.2"},
+		{"127.0.0.1:1234", []string{"1.1.1.1, 2.2.2.2, 3.3.3.3"}, 4, nil, ""},
+		{"10.1.2.3:1234", []string{"1.1.1.1, 2.2.2.2, 10.0.0.1, 192.0.2.1"}, 1, trusted, "2.2.2.2"},
+		{"10.1.2.3:1234", []string{"1.1.1.1, 2.2.2.2, 10.0.0.1, 192.0.2.1"}, 2, trusted, "1.1.1.1"},
+		{"10.1.2.3:1234", []string{"1.1.1.1, 2.2.2.2, 10.0.0.1, 192.0.2.1"}, 0, trusted, "1.1.1.1"},
+		{"[::ffff:10.1.2.3]:1234", []string{"2.2.2.2"}, 1, trusted, "2.2.2.2"},
+		{"10.1.2.3:1234", []string{"10.0.0.1, 192.0.2.1"}, 1, trusted, ""},
+		{"127.0.0.1:1234", []string{"1.1.1.1, 2.2.2.2"}, 1, trusted, ""},
+	} {
+		if act := proxyRemoteAddr(tc.remote, tc.header, tc.idx, tc.trusted); act != tc.exp {
+			t.Errorf("remote=%s header=%q idx=%d trusted=%t: expected %q, got %q", tc.remote, tc.header, tc.idx, tc.trusted != nil, tc.exp, act)
+		}
+	}
+}
+
+func TestParseListenAddrs(t *testing.T) {
+	for _, tc := range []struct {
+		in  string
+		exp string
+	}{
+		{":8080", "tcp/:8080"},
+		{"localhost:8080", "tcp/localhost:8080"},
+		{"0.0.0.0:8080", "tcp4/0.0.0.0:8080"},
+		{"[::]:8080", "tcp6/[::]:8080"},
+		{"[::ffff:127.0.0.1]:8080", "tcp4/[::ffff:127.0.0.1]:8080"},
+		{"tcp4/:8080", "tcp4/:8080"},
+		{"tcp6/:8080, 127.0.0.1:8081", "tcp6/:8080,tcp4/127.0.0.1:8081"},
+		{"tcp/0.0.0.0:8080", "tcp/0.0.0.0:8080"},
+		{"tcp4/[::1]:8080", ""},
+		{"tcp6/127.0.0.1:8080", ""},
+		{"udp/:8080", ""},
+		{"8080", ""},
+		{" , ", ""},
+	} {
+		as, err := parseListenAddrs(tc.in)
+		act := listenAddrs(as).String()
+		if tc.exp == "" {
+			if err == nil {
+				t.Errorf("%q: expected error, got %q", tc.in, act)
+			}
+		} else if err != nil {
+			t.Errorf("%q: unexpected error: %v", tc.in, err)
+		} else if act != tc.exp {
+			t.Errorf("%q: expected %q, got %q", tc.in, tc.exp, act)
+		} else if as, err := parseListenAddrs(act); err != nil || listenAddrs(as).String() != act {
+			t.Errorf("%q: expected %q to round-trip, got %q (error: %v)", tc.in, act, listenAddrs(as).String(), err)
+		}
+	}
+}
+
+// TestConfigSchema checks that configSchema is in sync with the cases handled
+// by the config parser.
+func TestParseAutocertHosts(t *testing.T) {
+	for _, tc := range []struct {
+		in  string
+		exp string
+	}{
+		{"example.com", "example.com"},
+		{"example.com, www.example.com", "example.com,www.example.com"},
+		{" example.com,,www.example.com, ", "example.com,www.example.com"},
+		{"", ""},
+		{" , ", ""},
+	} {
+		hosts, err := parseAutocertHosts(tc.in)
+		act := strings.Join(hosts, ",")
+		if tc.exp == "" {
+			if err == nil {
+				t.Errorf("%q: expected error, got %q", tc.in, act)
+			}
+		} else if err != nil {
+			t.Errorf("%q: unexpected error: %v", tc.in, err)
+		} else if act != tc.exp {
+			t.Errorf("%q: expected %q, got %q", tc.in, tc.exp, act)
+		}
+	}
+}
+
+func TestConfigSchema(t *testing.T) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "main.go", nil, 0)
+	if err != nil {
+		t.Fatalf("parse main.go: %v", err)
+	}
+	var fn *ast.FuncDecl
+	for _, d := range f.Decls {
+		if d, ok := d.(*ast.FuncDecl); ok && d.Name.Name == "parse" && d.Recv != nil {
+			fn = d
+		}
+	}
+	if fn == nil {
+		t.Fatalf("parse method not found")
+	}
+
+	var (
+		props   = map[string]bool{"filter.*": true}
+		keys    = map[string]bool{}
+		actions = map[string]bool{}
+		depth   int
+	)
+	str := func(e ast.Expr) (string, bool) {
+		if l, ok := e.(*ast.BasicLit); ok && l.Kind == token.STRING {
+			s, err := strconv.Unquote(l.Value)
+			return s, err == nil
+		}
+		return "", false
+	}
+	var visit func(n ast.Node) bool
+	visit = func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.BinaryExpr:
+			if id, ok := n.X.(*ast.Ident); ok && id.Name == "key" && n.Op == token.EQL {
+				if s, ok := str(n.Y); ok {
+					props[s] = true
+				}
+			}
+		case *ast.SwitchStmt:
+			id, ok := n.Tag.(*ast.Ident)
+			if !ok || (id.Name != "key" && id.Name != "act") {
+				break
+			}
+			m := actions
+			if id.Name == "key" {
+				if m = props; depth != 0 {
+					m = keys
+				}
+			}
+			for _, c := range n.Body.List {
+				for _, e := range c.(*ast.CaseClause).List {
+					if s, ok := str(e); ok {
+						m[s] = true
+					}
+				}
+			}
+			if id.Name == "key" {
+				depth++
+				ast.Inspect(n.Body, visit)
+				depth--
+				return false
+			}
+		}
+		return true
+	}
+	ast.Inspect(fn.Body, visit)
+
+	for _, x := range []struct {
+		What   string
+		Parsed map[string]bool
+		Schema []configSchemaItem
+	}{
+		{"properties", props, configSchema.Properties},
+		{"filter keys", keys, configSchema.FilterKeys},
+		{"filter actions", actions, configSchema.FilterActions},
+	} {
+		var (
+			documented   = map[string]bool{}
+			duplicate    []string
+			unhandled    []string
+			undocumented []string
+		)
+		for _, it := range x.Schema {
+			if documented[it.Name] {
+				duplicate = append(duplicate, it.Name)
+			}
+			documented[it.Name] = true
+			if !x.Parsed[it.Name] {
+				unhandled = append(unhandled, it.Name)
+			}
+		}
+		for k := range x.Parsed {
+			if !documented[k] {
+				undocumented = append(undocumented, k)
+			}
+		}
+		slices.Sort(undocumented)
+		if len(duplicate) != 0 {
+			t.Errorf("%s documented more than once: %q", x.What, duplicate)
+		}
+		if len(unhandled) != 0 {
+			t.Errorf("%s documented but not handled by the parser: %q", x.What, unhandled)
+		}
+		if len(undocumented) != 0 {
+			t.Errorf("%s handled by the parser but not documented: %q", x.What, undocumented)
+		}
+	}
+}
+
+func TestScheduleActivitySort(t *testing.T) {
+	for _, tc := range []struct {
+		Value string
+		Sort  ifgsch.ActivitySort
+		OK    bool
+	}{
+		{"name", ifgsch.ActivitySortName, true},
+		{"time", ifgsch.ActivitySortTime, true},
+		{"", "", false},
+		{"Time", "", false},
+		{"earliest", "", false},
+	} {
+		cfg, err := parseSchedules(strings.NewReader("schedule a 110\n\tactivity-sort "+tc.Value+"\n"), "schedules.txt")
+		if !tc.OK {
+			if err == nil {
+				t.Errorf("%q: expected error", tc.Value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tc.Value, err)
+		} else if act := cfg["a"].Prepare.ActivitySort; act != tc.Sort {
+			t.Errorf("%q: expected sort %q, got %q", tc.Value, tc.Sort, act)
+		}
+	}
+}
+
+func TestFilterDescription(t *testing.T) {
+	ai := func(description string) *fusiongo.ActivityInstance {
+		return &fusiongo.ActivityInstance{Activity: "A", Description: description}
+	}
+	for _, tc := range []struct {
+		Filter string
+		Keep   map[string]bool // by description, nil for a parse error
+	}{
+		{`filter.description contains staff`, map[string]bool{"staff only": true, "Staff only": false, "": false}},
+		{`filter.description notContains staff`, map[string]bool{"staff only": false, "Staff only": true, "": true}},
+		{`filter.description matches "(?i)^staff"`, map[string]bool{"staff only": true, "Staff only": true, "for staff": false}},
+		{`filter.description notMatches "(?i)staff"`, map[string]bool{"staff only": false, "for Staff": false, "open": true}},
+		{`filter.description matches "("`, nil},
+		{`filter.description contains`, nil},
+		{`filter.description matches a b`, nil},
+	} {
+		cfg, err := parseSchedules(strings.NewReader("schedule a 110\n\t"+tc.Filter+"\n"), "schedules.txt")
+		if tc.Keep == nil {
+			if err == nil {
+				t.Errorf("%s: expected error", tc.Filter)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.Filter, err)
+			continue
+		}
+		for description, keep := range tc.Keep {
+			if act := cfg["a"].Filter.Filter(ai(description)); act != keep {
+				t.Errorf("%s: %q: expected keep %t, got %t", tc.Filter, description, keep, act)
+			}
+		}
+	}
+}
+
+func TestFilterNotContains(t *testing.T) {
+	cfg, err := parseSchedules(strings.NewReader("schedule a 110\n\tfilter.category notContains Staff\n\tfilter.activity notContains \"(Women)\"\n"), "schedules.txt")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	for _, tc := range []struct {
+		Activity   string
+		Categories []string
+		Keep       bool
+	}{
+		{"Lane Swim", []string{"Aquatics"}, true},
+		{"Lane Swim", nil, true},
+		{"Lane Swim", []string{"Aquatics", "Staff Only"}, false},
+		{"Lane Swim", []string{"Staff"}, false},
+		{"Lane Swim", []string{"staff"}, true},
+		{"Lane Swim (Women)", []string{"Aquatics"}, false},
+	} {
+		ai := &fusiongo.ActivityInstance{Activity: tc.Activity}
+		for _, c := range tc.Categories {
+			ai.Category = append(ai.Category, fusiongo.ActivityCategory{Name: c})
+		}
+		if act := cfg["a"].Filter.Filter(ai); act != tc.Keep {
+			t.Errorf("%q %q: expected keep %t, got %t", tc.Activity, tc.Categories, tc.Keep, act)
+		}
+	}
+}
+
+func TestScheduleFooter(t *testing.T) {
+	for _, tc := range []struct {
+		Config string
+		Footer []template.HTML
+		Title  string
+		Err    string
+	}{
+		{"\tfooter <b>a</b>\n", []template.HTML{"<b>a</b>"}, "", ""},
+		{"\tfooter a\n\tfooter b\n", []template.HTML{"a", "b"}, "", ""},
+		{"\tfooter <<END\n\t\t<p>\n\t\t\ta\n\t\t</p>\n\tEND\n\ttitle T\n", []template.HTML{"<p>\na\n</p>"}, "T", ""},
+		{"\tfooter a\n\tfooter <<END\n\nb\n\nEND\n", []template.HTML{"a", "b"}, "", ""},
+		{"\tfooter <<END\n\ttitle T\n", nil, "", "line 2: unterminated footer block"},
+		{"\tfooter <<\n", nil, "", "line 2: expected"},
+		{"\tfooter <<A B\nA B\n", nil, "", "line 2: expected"},
+	} {
+		cfg, err := parseSchedules(strings.NewReader("schedule a 110\n"+tc.Config), "schedules.txt")
+		if tc.Err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.Err) {
+				t.Errorf("%q: expected error %q, got %v", tc.Config, tc.Err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tc.Config, err)
+			continue
+		}
+		if act := cfg["a"].Options.Footer; !slices.Equal(act, tc.Footer) {
+			t.Errorf("%q: expected footer %q, got %q", tc.Config, tc.Footer, act)
+		}
+		if act := cfg["a"].Options.Title; act != tc.Title {
+			t.Errorf("%q: expected title %q after the footer, got %q", tc.Config, tc.Title, act)
+		}
+		var buf bytes.Buffer
+		if err := ifgsch.Render(&buf, &cfg["a"].Options, &ifgsch.Schedule{}); err != nil {
+			t.Errorf("%q: render: %v", tc.Config, err)
+		}
+		for _, x := range tc.Footer {
+			if !strings.Contains(buf.String(), string(x)) {
+				t.Errorf("%q: expected rendered footer to contain %q", tc.Config, x)
+			}
+		}
+	}
+}
+
+func TestScheduleInclude(t *testing.T) {
+	for _, tc := range []struct {
+		Name  string
+		Files map[string]string
+		Paths []string
+		Err   string
+	}{
+		{"relative", map[string]string{
+			"schedules.txt":  "schedule a 110\n\ttitle A\n\tfilter.activity notIn X\ninclude sub/b.txt\nschedule d 110\n",
+			"sub/b.txt":      "schedule b a\ninclude c.txt\n",
+			"sub/c.txt":      "schedule c 120\n",
+			"c.txt":          "schedule x 130\n",
+			"sub/unused.txt": "schedule y 130\n",
+		}, []string{"a", "b", "c", "d"}, ""},
+		{"duplicate", map[string]string{
+			"schedules.txt": "schedule a 110\ninclude b.txt\n",
+			"b.txt":         "schedule a 120\n",
+		}, nil, `line 2: include "b.txt": line 1: schedule path "a" already used`},
+		{"cycle", map[string]string{
+			"schedules.txt": "include b.txt\n",
+			"b.txt":         "include sub/../schedules.txt\n",
+		}, nil, "include cycle"},
+		{"self", map[string]string{
+			"schedules.txt": "include schedules.txt\n",
+		}, nil, "include cycle"},
+		{"repeated", map[string]string{
+			"schedules.txt": "include b.txt\ninclude c.txt\n",
+			"b.txt":         "include c.txt\n",
+			"c.txt":         "",
+		}, nil, ""},
+		{"missing", map[string]string{
+			"schedules.txt": "include b.txt\n",
+		}, nil, "line 1: include:"},
+		{"empty", map[string]string{
+			"schedules.txt": "include\n",
+		}, nil, "line 1: expected"},
+		{"properties", map[string]string{
+			"schedules.txt": "schedule a 110\ninclude b.txt\n\ttitle A\n",
+			"b.txt":         "",
+		}, nil, "line 3: expected"},
+	} {
+		dir := t.TempDir()
+		for name, data := range tc.Files {
+			p := filepath.Join(dir, filepath.FromSlash(name))
+			if err := os.MkdirAll(filepath.Dir(p), 0777); err != nil {
+				t.Fatalf("%s: write %s: %v", tc.Name, name, err)
+			}
+			if err := os.WriteFile(p, []byte(data), 0666); err != nil {
+				t.Fatalf("%s: write %s: %v", tc.Name, name, err)
+			}
+		}
+		name := filepath.Join(dir, "schedules.txt")
+		cfg, err := parseSchedules(strings.NewReader(tc.Files["schedules.txt"]), name)
+		if tc.Err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.Err) {
+				t.Errorf("%s: expected error %q, got %v", tc.Name, tc.Err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.Name, err)
+			continue
+		}
+		if act := cfg.Paths(); !slices.Equal(act, tc.Paths) {
+			t.Errorf("%s: expected paths %q, got %q", tc.Name, tc.Paths, act)
+		}
+		if x := cfg["b"]; x != nil && x.Options.Title != "A" {
+			t.Errorf("%s: expected included schedule to extend an earlier one", tc.Name)
+		}
+	}
+}
+
+func TestScheduleCompact(t *testing.T) {
+	for _, tc := range []struct {
+		Config  string
+		Compact bool
+		OK      bool
+	}{
+		{"schedule a 110\n", false, true},
+		{"schedule a 110\n\tcompact\n", true, true},
+		{"schedule a 110\n\tcompact\n\tfilter.activity notIn X\nschedule b a\n", true, true},
+		{"schedule a 110\n\tcompact yes\n", false, false},
+	} {
+		cfg, err := parseSchedules(strings.NewReader(tc.Config), "schedules.txt")
+		if !tc.OK {
+			if err == nil {
+				t.Errorf("%q: expected error", tc.Config)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tc.Config, err)
+			continue
+		}
+		for path, x := range cfg {
+			if x.Options.Compact != tc.Compact {
+				t.Errorf("%q: %s: expected compact %t, got %t", tc.Config, path, tc.Compact, x.Options.Compact)
+			}
+		}
+	}
+}
+
+func TestScheduleRouter(t *testing.T) {
+	named := func(name string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, name)
+		})
+	}
+	h := scheduleRouter(map[string]http.Handler{
+		"a":       named("a"),
+		"a/":      named("a day"),
+		"a.json":  named("a json"),
+		"a/stats": named("a stats"),
+		"b/c":     named("b/c"),
+		"b/c/":    named("b/c day"),
+	}, named("not found"))
+	for _, tc := range []struct {
+		Path     string
+		Body     string
+		Location string
+	}{
+		{"/a", "a", ""},
+		{"/a/", "", "/a"},
+		{"/a/?x=1", "", "/a?x=1"},
+		{"/a/2023-01-01", "a day", ""},
+		{"/a.json", "a json", ""},
+		{"/a.json/", "", "/a.json"},
+		{"/a/stats", "a stats", ""},
+		{"/a/stats/", "", "/a/stats"},
+		{"/a//", "", "/a/"},
+		{"/b/c/", "", "/b/c"},
+		{"/b/c/2023-01-01", "b/c day", ""},
+		{"/", "not found", ""},
+		{"//", "not found", ""},
+		{"/c/", "not found", ""},
+		{"/b/", "not found", ""},
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tc.Path, nil))
+		if tc.Location != "" {
+			if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != tc.Location {
+				t.Errorf("%s: expected redirect to %s, got %d %q", tc.Path, tc.Location, w.Code, w.Header().Get("Location"))
+			}
+		} else if act := w.Body.String(); act != tc.Body {
+			t.Errorf("%s: expected %q, got %q", tc.Path, tc.Body, act)
+		}
+	}
+}
+
+func TestPrefetch(t *testing.T) {
+	cfg, err := parseSchedules(strings.NewReader("schedule a 110\nschedule b 110\nschedule c 120\nschedule d 130\ncombine e a b\n"), "schedules.txt")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	for _, n := range []int{1, 2, 10} {
+		var (
+			mu     sync.Mutex
+			calls  = map[string]int{}
+			active int
+			peak   int
+		)
+		renderers := map[string]memcache.Cache[scheduleResult]{}
+		for _, path := range []string{"a", "b", "c", "d"} {
+			path := path
+			renderers[path] = memcache.CacheFunc[scheduleResult](func() (*scheduleResult, error) {
+				mu.Lock()
+				calls[path]++
+				active++
+				peak = max(peak, active)
+				mu.Unlock()
+
+				time.Sleep(time.Millisecond)
+
+				mu.Lock()
+				active--
+				mu.Unlock()
+				if path == "c" {
+					return nil, errors.New("fetch failed")
+				}
+				return &scheduleResult{}, nil
+			})
+		}
+		prefetch(cfg, renderers, n)
+		if exp := map[string]int{"a": 1, "b": 1, "c": 1, "d": 1}; !maps.Equal(calls, exp) {
+			t.Errorf("%d: expected each renderer to be prefetched once, got %v", n, calls)
+		}
+		if peak > n {
+			t.Errorf("%d: expected at most %d concurrent prefetches, got %d", n, n, peak)
+		}
+	}
+}
+
+func TestScheduleAlternates(t *testing.T) {
+	for _, tc := range []struct {
+		Config    string
+		Canonical string
+		Links     []string // nil for a parse error
+	}{
+		{"", "", []string{}},
+		{"\talternate-links\n", "", []string{
+			`<link rel="alternate" type="text/plain" href="/a.txt" title="Text">`,
+			`<link rel="alternate" type="image/svg&#43;xml" href="/a.svg" title="Image">`,
+			`<link rel="alternate" type="application/json" href="/a/stats.json" title="Statistics">`,
+		}},
+		{"\talternate-links\n\tsubscribe https://example.com/a.ics\n", "", []string{
+			`<link rel="alternate" type="text/plain" href="/a.txt" title="Text">`,
+			`<link rel="alternate" type="text/calendar" href="https://example.com/a.ics" title="iCalendar">`,
+		}},
+		{"\talternate-links\n", "https://example.com/a", []string{
+			`<link rel="alternate" type="text/plain" href="https://example.com/a.txt" title="Text">`,
+			`<link rel="alternate" type="application/json" href="https://example.com/a/stats.json" title="Statistics">`,
+		}},
+		{"\talternate-links yes\n", "", nil},
+	} {
+		cfg, err := parseSchedules(strings.NewReader("schedule a 110\n"+tc.Config), "schedules.txt")
+		if tc.Links == nil {
+			if err == nil {
+				t.Errorf("%q: expected error", tc.Config)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tc.Config, err)
+			continue
+		}
+		x := cfg["a"]
+		if x.Alternates != (len(tc.Links) != 0) {
+			t.Errorf("%q: expected alternates %t, got %t", tc.Config, len(tc.Links) != 0, x.Alternates)
+		}
+		x.Options.Path, x.Options.Canonical = "/a", tc.Canonical
+		if x.Alternates {
+			x.Options.Alternates = scheduleAlternates(&x.Options)
+		}
+		var buf bytes.Buffer
+		if err := ifgsch.Render(&buf, &x.Options, &ifgsch.Schedule{}); err != nil {
+			t.Fatalf("%q: render: %v", tc.Config, err)
+		}
+		if n, exp := strings.Count(buf.String(), `<link rel="alternate"`), len(x.Options.Alternates); n != exp {
+			t.Errorf("%q: expected %d alternate links, got %d", tc.Config, exp, n)
+		}
+		for _, l := range tc.Links {
+			if !strings.Contains(buf.String(), l) {
+				t.Errorf("%q: expected %s", tc.Config, l)
+			}
+		}
+	}
+}
@@ -4,6 +4,7 @@ package memcache
 import (
 	"cmp"
 	"context"
+	"errors"
 	"log/slog"
 	"sync"
 	"time"
@@ -31,6 +32,15 @@ func (fn CacheFunc[T]) Get() (*T, error) {
 	return v, err
 }
 
+// Warmer is optionally implemented by caches which can be updated in the
+// background.
+type Warmer interface {
+	// Warm updates the cache in the background lead before the cached data
+	// expires, or when the backoff ends after a failed update, until ctx is
+	// cancelled. It blocks until ctx is cancelled.
+	Warm(ctx context.Context, lead time.Duration)
+}
+
 // MultiCache dynamically initializes caches.
 func MultiCache[K comparable, T any](init func(K) Cache[T]) func(K) Cache[T] {
 	var (
@@ -53,6 +63,46 @@ func MultiCache[K comparable, T any](init func(K) Cache[T]) func(K) Cache[T] {
 	}
 }
 
+// Combine combines the values of multiple caches. The value is only updated if
+// any of the sources are updated. If a source fails without old cached data,
+// its value will be nil and the errors are joined. If all sources fail, only
+// an error is returned.
+func Combine[T any](sources ...Cache[T]) Cache[[]*T] {
+	var cache struct {
+		mu   sync.Mutex
+		src  []*T
+		errs []error
+		res  *[]*T
+		err  error
+	}
+	return CacheFunc[[]*T](func() (*[]*T, error) {
+		cache.mu.Lock()
+		defer cache.mu.Unlock()
+
+		var (
+			src     = make([]*T, len(sources))
+			errs    = make([]error, len(sources))
+			changed = cache.res == nil
+			ok      bool
+		)
+		for i, c := range sources {
+			src[i], errs[i] = c.Get()
+			if !changed && (src[i] != cache.src[i] || errs[i] != cache.errs[i]) {
+				changed = true
+			}
+			ok = ok || src[i] != nil
+		}
+		if changed {
+			cache.src, cache.errs = src, errs
+			cache.res, cache.err = &src, errors.Join(errs...)
+		}
+		if !ok {
+			return nil, cache.err
+		}
+		return cache.res, cache.err
+	})
+}
+
 // Backoff implements a backoff strategy.
 type Backoff interface {
 
@@ -77,24 +127,79 @@ type CacheConfig struct {
 
 	// CacheTime is the maximum amount of time data is cached for before
 	// attempting to update it. If negative, an update is attempted every time.
-	// If zero, the default value is used.
+	// If zero, the default value is used. It is ignored if RefreshAt is set.
 	CacheTime time.Duration
 
-	// StaleTime is the maximum amount of time to return old data (along with
-	// the update error) while updates fail. If negative, old data will never be
-	// returned. If zero, the default value is used.
+	// RefreshAt, if not nil, returns the time data updated at t expires,
+	// replacing CacheTime. This allows updating data soon after it is known to
+	// change. StaleTime is relative to the returned time, and Backoff still
+	// applies to updates which fail after the data expires.
+	RefreshAt func(t time.Time) time.Time
+
+	// StaleTime is the maximum amount of time after the data expires to return
+	// old data (along with the update error) while updates fail. If negative,
+	// old data will never be returned. If zero, the default value is used.
 	StaleTime time.Duration
 
 	// Backoff is used to delay update retries on error. If nil, no backoff is
 	// used.
 	Backoff Backoff
 
+	// Retries is the number of times to immediately retry a failed update
+	// within the same attempt, waiting RetryDelay between them. All retries
+	// share the Timeout, and Backoff only applies once all of them fail (they
+	// count as a single failed attempt).
+	Retries int
+
+	// RetryDelay is the amount of time to wait before each of the Retries.
+	RetryDelay time.Duration
+
+	// ProbeInterval, if positive, is the maximum amount of time to wait after
+	// a failed update before trying again, even if Backoff would wait longer.
+	// This allows recovering sooner after a long outage without making the
+	// backoff less aggressive. A failed probe counts as another attempt for
+	// Backoff.
+	ProbeInterval time.Duration
+
+	// OnUpdate, if not nil, is called in a new goroutine after the data is
+	// successfully updated, including by [Warmer]. This allows reacting to
+	// changes without waiting for the next Get.
+	OnUpdate func()
+
 	// Logger is used to write informational logs about cache updates. If nil,
 	// no logger is used.
 	Logger *slog.Logger
 }
 
-// Cached wraps the provided fetch function in a cache.
+// RetryError is returned by [Cached] when the data couldn't be updated.
+type RetryError struct {
+	Err error
+
+	// RetryAt is the time the next update will be attempted, or zero if
+	// there is no backoff.
+	RetryAt time.Time
+}
+
+func (e *RetryError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RetryError) Unwrap() error {
+	return e.Err
+}
+
+// RetryAt gets the time the cache will next attempt an update if err contains
+// a [RetryError] with a backoff.
+func RetryAt(err error) (time.Time, bool) {
+	var re *RetryError
+	if errors.As(err, &re) && !re.RetryAt.IsZero() {
+		return re.RetryAt, true
+	}
+	return time.Time{}, false
+}
+
+// Cached wraps the provided fetch function in a cache. Update errors are
+// wrapped in a [RetryError]. The returned cache implements [Warmer].
 func Cached[T any](cfg CacheConfig, fetch func(ctx context.Context) (T, error)) Cache[T] {
 	cfg.Timeout = negZeroDef(cfg.Timeout, time.Second*7)
 	cfg.CacheTime = negZeroDef(cfg.CacheTime, time.Minute*15)
@@ -109,11 +214,26 @@ func Cached[T any](cfg CacheConfig, fetch func(ctx context.Context) (T, error))
 
 		success  time.Time
 		successV *T
+
+		warming bool // if an update is being done without holding mu
 	}
 	if cfg.Logger != nil {
-		cfg.Logger.Info("cache created", slog.Group("config", "timeout", cfg.Timeout.Seconds(), "cache_time", cfg.CacheTime.Seconds(), "stale_time", cfg.StaleTime.Seconds(), "backoff", cfg.Backoff != nil))
+		cfg.Logger.Info("cache created", slog.Group("config", "timeout", cfg.Timeout.Seconds(), "cache_time", cfg.CacheTime.Seconds(), "refresh_at", cfg.RefreshAt != nil, "stale_time", cfg.StaleTime.Seconds(), "backoff", cfg.Backoff != nil, "retries", cfg.Retries, "retry_delay", cfg.RetryDelay.Seconds(), "probe_interval", cfg.ProbeInterval.Seconds(), "on_update", cfg.OnUpdate != nil))
 	}
-	return CacheFunc[T](func() (*T, error) {
+	expiry := func() time.Time {
+		if cfg.RefreshAt != nil {
+			return cfg.RefreshAt(cache.success)
+		}
+		return cache.success.Add(cfg.CacheTime)
+	}
+	retryAt := func() time.Time {
+		t := cfg.Backoff.Backoff(cache.failure, cache.failureV, cache.failureN)
+		if p := cache.failure.Add(cfg.ProbeInterval); cfg.ProbeInterval > 0 && !t.IsZero() && p.Before(t) {
+			t = p
+		}
+		return t
+	}
+	get := func(lead time.Duration) (*T, error) {
 		cache.mu.Lock()
 		defer cache.mu.Unlock()
 
@@ -128,14 +248,14 @@ func Cached[T any](cfg CacheConfig, fetch func(ctx context.Context) (T, error))
 		now := time.Now()
 
 		if !cache.success.IsZero() {
-			age := time.Since(cache.success)
-			if age <= cfg.CacheTime {
+			age, exp := time.Since(cache.success), expiry()
+			if !now.After(exp.Add(-lead)) {
 				if cfg.Logger != nil {
 					cfg.Logger.Debug("using cached data", "age", age.Truncate(time.Millisecond).Seconds())
 				}
 				return cache.successV, nil
 			}
-			if age > cfg.CacheTime+cfg.StaleTime {
+			if now.After(exp.Add(cfg.StaleTime)) {
 				if cfg.Logger != nil {
 					cfg.Logger.Debug("clearing stale cached data", "age", age.Truncate(time.Millisecond).Seconds())
 				}
@@ -146,7 +266,7 @@ func Cached[T any](cfg CacheConfig, fetch func(ctx context.Context) (T, error))
 
 		if cfg.Backoff != nil {
 			if cache.failureN != 0 {
-				if t := cfg.Backoff.Backoff(cache.failure, cache.failureV, cache.failureN); !t.IsZero() && now.Before(t) {
+				if t := retryAt(); !t.IsZero() && now.Before(t) {
 					if cfg.Logger != nil {
 						if cache.success.IsZero() {
 							cfg.Logger.Debug("no cached data to use")
@@ -160,25 +280,65 @@ func Cached[T any](cfg CacheConfig, fetch func(ctx context.Context) (T, error))
 			}
 		}
 
+		attempt := cache.failureN
+
+		// if the data hasn't expired yet (i.e., it's being warmed), update it
+		// without holding the lock so other callers can continue using it
+		warm := !cache.success.IsZero() && !now.After(expiry())
+		if warm {
+			if cache.warming {
+				return cache.successV, nil
+			}
+			cache.warming = true
+			cache.mu.Unlock()
+		}
+
 		if cfg.Logger != nil {
-			cfg.Logger.Info("updating cached data", "attempt", cache.failureN)
+			cfg.Logger.Info("updating cached data", "attempt", attempt, "warm", warm)
+		}
+
+		v, err := forceContextCancel1(ctx, fetch)
+		for retry := 1; err != nil && retry <= cfg.Retries; retry++ {
+			if !sleepContext(ctx, cfg.RetryDelay) {
+				break
+			}
+			if cfg.Logger != nil {
+				cfg.Logger.Info("retrying cached data update", "attempt", attempt, "retry", retry, "error", err)
+			}
+			v, err = forceContextCancel1(ctx, fetch)
 		}
 
-		if v, err := forceContextCancel1(ctx, fetch); err != nil {
+		if warm {
+			cache.mu.Lock()
+			cache.warming = false
+			if cache.success.After(now) {
+				// it was updated by another caller after the data expired
+				return cache.successV, cache.failureV
+			}
+		}
+		if err != nil {
 			cache.failure = now
 			cache.failureV = err
 			cache.failureN++
+			re := &RetryError{Err: err}
+			if cfg.Backoff != nil {
+				re.RetryAt = retryAt()
+			}
+			cache.failureV = re // note: this must only be created once per failure since CachedTransform compares errors
 		} else {
 			cache.failure = time.Time{}
 			cache.failureV = nil
 			cache.failureN = 0
 			cache.success = now
 			cache.successV = &v
+			if cfg.OnUpdate != nil {
+				go cfg.OnUpdate()
+			}
 		}
 		if cfg.Logger != nil {
 			if !cache.failure.IsZero() {
 				if cfg.Backoff != nil {
-					cfg.Logger.Warn("failed to update cached data", "attempt", cache.failureN, "duration", time.Since(now).Truncate(time.Millisecond).Seconds(), "error", cache.failureV, "backoff", cfg.Backoff.Backoff(cache.failure, cache.failureV, cache.failureN), "using_old_data", !cache.success.IsZero())
+					cfg.Logger.Warn("failed to update cached data", "attempt", cache.failureN, "duration", time.Since(now).Truncate(time.Millisecond).Seconds(), "error", cache.failureV, "backoff", retryAt(), "using_old_data", !cache.success.IsZero())
 				} else {
 					cfg.Logger.Warn("failed to update cached data", "attempt", cache.failureN, "duration", time.Since(now).Truncate(time.Millisecond).Seconds(), "error", cache.failureV, "using_old_data", !cache.success.IsZero())
 				}
@@ -192,19 +352,71 @@ func Cached[T any](cfg CacheConfig, fetch func(ctx context.Context) (T, error))
 			}
 		}
 		return cache.successV, cache.failureV
-	})
+	}
+	next := func(lead time.Duration) time.Time {
+		cache.mu.Lock()
+		defer cache.mu.Unlock()
+
+		if cache.failureN != 0 {
+			if cfg.Backoff != nil {
+				if t := retryAt(); !t.IsZero() {
+					return t
+				}
+			}
+			return cache.failure.Add(lead)
+		}
+		if cache.success.IsZero() {
+			return time.Now()
+		}
+		return expiry().Add(-lead)
+	}
+	return &cached[T]{get: get, next: next}
+}
+
+// cached implements [Cache] and [Warmer] for [Cached].
+type cached[T any] struct {
+	get  func(lead time.Duration) (*T, error)
+	next func(lead time.Duration) time.Time
+}
+
+func (c *cached[T]) Get() (*T, error) {
+	return CacheFunc[T](func() (*T, error) {
+		return c.get(0)
+	}).Get()
+}
+
+func (c *cached[T]) Warm(ctx context.Context, lead time.Duration) {
+	for {
+		// don't spin if the lead is longer than the cache time or the
+		// backoff doesn't delay retries
+		d := max(time.Until(c.next(lead)), time.Second)
+		t := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-t.C:
+		}
+		c.get(lead)
+	}
 }
 
 // CachedTransformConfig configures [CachedTransform].
 type CachedTransformConfig struct {
 
+	// MaxAge, if positive, is the maximum amount of time to use the result
+	// for before running the transform again, even if the source hasn't
+	// changed. This is for transforms which depend on the current time.
+	MaxAge time.Duration
+
 	// Logger is used to write informational logs about cache updates. If nil,
 	// no logger is used.
 	Logger *slog.Logger
 }
 
 // CachedTransform transforms the value from a cache, updating it only when it
-// changes, or if the source returns an update error. Note that unlike [Cached],
+// changes, if the source returns an update error, or once the result is older
+// than the configured MaxAge. Note that unlike [Cached],
 // if the function errors, only an error is returned, and otherwise, only a
 // value is returned.
 func CachedTransform[T, U any](source Cache[T], cfg CachedTransformConfig, transform func(v T, err error) (U, error)) Cache[U] {
@@ -214,9 +426,10 @@ func CachedTransform[T, U any](source Cache[T], cfg CachedTransformConfig, trans
 		srcErr error
 		res    *U
 		resErr error
+		resAt  time.Time
 	}
 	if cfg.Logger != nil {
-		cfg.Logger.Info("cache transform created")
+		cfg.Logger.Info("cache transform created", slog.Group("config", "max_age", cfg.MaxAge.Seconds()))
 	}
 	return CacheFunc[U](func() (*U, error) {
 		cache.mu.Lock()
@@ -236,7 +449,7 @@ func CachedTransform[T, U any](source Cache[T], cfg CachedTransformConfig, trans
 			panic("cache must return a non-nil pointer if err is nil")
 		}
 
-		if cache.src != src || cache.srcErr != srcErr {
+		if cache.src != src || cache.srcErr != srcErr || (cfg.MaxAge > 0 && time.Since(cache.resAt) >= cfg.MaxAge) {
 			now := time.Now()
 
 			if cfg.Logger != nil {
@@ -245,7 +458,7 @@ func CachedTransform[T, U any](source Cache[T], cfg CachedTransformConfig, trans
 
 			res, resErr := transform(*src, srcErr)
 			cache.src, cache.srcErr = src, srcErr // note: it's important that this is after transform so if it panics, it will try again
-			cache.res, cache.resErr = &res, resErr
+			cache.res, cache.resErr, cache.resAt = &res, resErr, now
 
 			if cfg.Logger != nil {
 				if cache.resErr != nil {
@@ -278,6 +491,18 @@ func forceContextCancel(ctx context.Context, fn func(context.Context) error) err
 	}
 }
 
+// sleepContext waits for d, returning false if ctx is done first.
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
 // forceContextCancel1 wraps forceContextCancel for a single return value.
 func forceContextCancel1[T any](ctx context.Context, fn func(context.Context) (T, error)) (T, error) {
 	var ret1 T
@@ -0,0 +1,208 @@
+package memcache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCachedWarmNonBlocking(t *testing.T) {
+	var (
+		n       int
+		started = make(chan struct{})
+		release = make(chan struct{})
+	)
+	c := Cached(CacheConfig{CacheTime: time.Hour, Timeout: -1}, func(ctx context.Context) (int, error) {
+		if n++; n == 2 {
+			close(started)
+			<-release
+		}
+		return n, nil
+	})
+	if v, err := c.Get(); err != nil || *v != 1 {
+		t.Fatalf("expected initial value 1, got %v (%v)", v, err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.(*cached[int]).get(2 * time.Hour) // a lead longer than the cache time always updates
+	}()
+	<-started
+
+	got := make(chan int, 1)
+	go func() {
+		v, _ := c.Get()
+		got <- *v
+	}()
+	select {
+	case v := <-got:
+		if v != 1 {
+			t.Errorf("expected old value 1 while warming, got %d", v)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("get blocked while warming")
+	}
+
+	close(release)
+	<-done
+	if v, _ := c.Get(); *v != 2 {
+		t.Errorf("expected warmed value 2, got %d", *v)
+	}
+}
+
+func TestCachedRetries(t *testing.T) {
+	for _, tc := range []struct {
+		Retries int
+		Delay   time.Duration
+		Timeout time.Duration
+		Calls   int
+		OK      bool
+	}{
+		{0, 0, 0, 1, false},
+		{1, time.Millisecond, 0, 2, false},
+		{2, time.Millisecond, 0, 3, true},
+		{5, time.Millisecond, 0, 3, true},
+		{2, time.Hour, time.Millisecond, 1, false}, // the retry delay is longer than the timeout
+	} {
+		var calls int
+		c := Cached(CacheConfig{
+			Timeout:    tc.Timeout,
+			Retries:    tc.Retries,
+			RetryDelay: tc.Delay,
+		}, func(ctx context.Context) (int, error) {
+			if calls++; calls < 3 {
+				return 0, errors.New("fetch failed")
+			}
+			return calls, nil
+		})
+		v, err := c.Get()
+		if calls != tc.Calls {
+			t.Errorf("%+v: expected %d calls, got %d", tc, tc.Calls, calls)
+		}
+		if tc.OK {
+			if err != nil || v == nil || *v != tc.Calls {
+				t.Errorf("%+v: expected success, got %v", tc, err)
+			}
+		} else if !errors.As(err, new(*RetryError)) {
+			t.Errorf("%+v: expected retry error, got %v", tc, err)
+		}
+	}
+}
+
+func TestCachedProbeInterval(t *testing.T) {
+	for _, tc := range []struct {
+		Backoff time.Duration // zero for no backoff
+		Probe   time.Duration
+		RetryIn time.Duration // zero for no retry time
+		Calls   int           // after two gets
+	}{
+		{time.Hour, 0, time.Hour, 1},
+		{time.Hour, -time.Minute, time.Hour, 1},
+		{time.Hour, time.Minute, time.Minute, 1},
+		{time.Hour, time.Hour * 2, time.Hour, 1},
+		{0, time.Minute, 0, 2},
+	} {
+		var calls int
+		c := Cached(CacheConfig{
+			Backoff: BackoffFunc(func(t time.Time, _ error, _ int) time.Time {
+				if tc.Backoff == 0 {
+					return time.Time{}
+				}
+				return t.Add(tc.Backoff)
+			}),
+			ProbeInterval: tc.Probe,
+		}, func(ctx context.Context) (int, error) {
+			calls++
+			return 0, errors.New("fetch failed")
+		})
+		start := time.Now()
+		_, err := c.Get()
+		if at, ok := RetryAt(err); ok != (tc.RetryIn != 0) {
+			t.Errorf("%+v: expected retry time = %t, got %t", tc, tc.RetryIn != 0, ok)
+		} else if ok && (at.Before(start.Add(tc.RetryIn)) || at.After(time.Now().Add(tc.RetryIn))) {
+			t.Errorf("%+v: expected retry in %s, got %s", tc, tc.RetryIn, at.Sub(start))
+		}
+		c.Get()
+		if calls != tc.Calls {
+			t.Errorf("%+v: expected %d calls before the retry time, got %d", tc, tc.Calls, calls)
+		}
+	}
+
+	var calls int
+	c := Cached(CacheConfig{
+		Backoff: BackoffFunc(func(t time.Time, _ error, _ int) time.Time {
+			return t.Add(time.Hour)
+		}),
+		ProbeInterval: time.Millisecond,
+	}, func(ctx context.Context) (int, error) {
+		if calls++; calls < 3 {
+			return 0, errors.New("fetch failed")
+		}
+		return calls, nil
+	})
+	for i := 1; i <= 3; i++ {
+		time.Sleep(time.Millisecond * 2)
+		v, err := c.Get()
+		if calls != i {
+			t.Errorf("expected the probe to retry the update, got %d calls after %d gets", calls, i)
+		}
+		if (err == nil) != (i == 3) || (i == 3 && *v != 3) {
+			t.Errorf("get %d: unexpected result %v (%v)", i, v, err)
+		}
+	}
+}
+
+func TestCachedTransformMaxAge(t *testing.T) {
+	v := 1
+	src := CacheFunc[int](func() (*int, error) {
+		return &v, nil
+	})
+	var calls int
+	c := CachedTransform[int, int](src, CachedTransformConfig{MaxAge: time.Millisecond * 20}, func(v int, err error) (int, error) {
+		calls++
+		return v, err
+	})
+	c.Get()
+	c.Get()
+	if calls != 1 {
+		t.Errorf("expected the result to be reused before the max age, got %d calls", calls)
+	}
+	time.Sleep(time.Millisecond * 30)
+	c.Get()
+	if calls != 2 {
+		t.Errorf("expected the transform to run again after the max age, got %d calls", calls)
+	}
+}
+
+func TestCachedOnUpdate(t *testing.T) {
+	var (
+		fail    bool
+		updated = make(chan struct{}, 2)
+	)
+	c := Cached(CacheConfig{
+		CacheTime: -1,
+		OnUpdate: func() {
+			updated <- struct{}{}
+		},
+	}, func(ctx context.Context) (int, error) {
+		if fail {
+			return 0, errors.New("fetch failed")
+		}
+		return 1, nil
+	})
+	c.Get()
+	select {
+	case <-updated:
+	case <-time.After(time.Second):
+		t.Fatalf("expected a callback after the update")
+	}
+	fail = true
+	c.Get()
+	select {
+	case <-updated:
+		t.Errorf("expected no callback after a failed update")
+	case <-time.After(time.Millisecond * 50):
+	}
+}